@@ -8,6 +8,7 @@ package aiot
 import (
 	"encoding/json"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
@@ -22,6 +23,12 @@ const (
 	DefaultCacheCleanupInterval = time.Second * 30
 )
 
+// 子设备自动重新登陆默认值
+const (
+	DefaultReloginInterval = time.Second * 10 // 同一子设备两次自动重新登陆的最小间隔
+	DefaultReloginTimeout  = time.Second * 10 // 重新登陆时拓扑添加及登陆请求的超时时间
+)
+
 // DefaultVersion 平台通信版本
 const DefaultVersion = "1.0"
 
@@ -82,13 +89,37 @@ type Client struct {
 	mode    Mode
 	version string
 	// 选项功能
-	isGateway   bool
-	hasDiag     bool
-	hasNTP      bool
-	hasRawModel bool
-	hasDesired  bool
-	hasExtRRPC  bool
-	hasOTA      bool
+	isGateway     bool
+	hasDiag       bool
+	hasNTP        bool
+	hasRawModel   bool
+	hasDesired    bool
+	hasExtRRPC    bool
+	hasOTA        bool
+	hasFileUpload bool
+	// 自定义模块服务调用处理
+	functionBlocks map[string]FunctionBlockServiceHandler
+	// 透传数据编解码器
+	codec Codec
+	// OTA升级处理器
+	otaUpgrader OtaUpgrader
+	// OTA模块注册表
+	otaModules otaModules
+	// 子设备会话错误时自动重新登陆
+	reloginInterval time.Duration
+	reloginTimeout  time.Duration
+	reloginReplay   bool
+	reloginMu       sync.Mutex
+	relogins        map[string]*relogin
+	replayCache     *cache.Cache
+	// 子设备持久化存储
+	devStore DevStore
+	// 子设备认证签名器, hasSigner 表示设置了外部签名器
+	signer    infra.Signer
+	hasSigner bool
+	// 子设备产品的ProductSecret, 用于子设备一型一密动态注册
+	psMu           sync.RWMutex
+	productSecrets map[string]string
 
 	*DevMgr
 	msgCache *cache.Cache
@@ -110,6 +141,10 @@ func New(triad infra.MetaTriad, conn Conn, opts ...Option) *Client {
 		cacheExpiration:      DefaultCacheExpiration,
 		cacheCleanupInterval: DefaultCacheCleanupInterval,
 
+		reloginInterval: DefaultReloginInterval,
+		reloginTimeout:  DefaultReloginTimeout,
+		relogins:        make(map[string]*relogin),
+
 		DevMgr: NewDevMgr(triad),
 		Conn:   conn,
 		cb:     NopCb{},
@@ -119,18 +154,55 @@ func New(triad infra.MetaTriad, conn Conn, opts ...Option) *Client {
 	for _, opt := range opts {
 		opt(c)
 	}
+	if c.signer == nil {
+		c.signer = infra.SecretSigner(func(pair infra.MetaPair) (string, error) {
+			return c.DeviceSecret(pair.ProductKey, pair.DeviceName)
+		})
+	}
+	if c.devStore != nil {
+		if err := c.LoadStore(c.devStore); err != nil {
+			c.Log.Errorf("load sub-devices from store failed, not persisted until LoadDevStore succeeds, %+v", err)
+		}
+	}
 	if c.mode != ModeHTTP {
 		c.msgCache = cache.New(c.cacheExpiration, c.cacheCleanupInterval)
+		if c.reloginReplay {
+			c.replayCache = cache.New(c.cacheExpiration, c.cacheCleanupInterval)
+		}
 	}
 	return c
 }
 
-// Connect 将订阅所有相关主题,主题有config配置
+// LoadDevStore 从 WithDevStore 设置的存储加载子设备,返回加载错误.
+// 创建时加载失败的,子设备不持久化,直到调用本函数加载成功
+func (sf *Client) LoadDevStore() error {
+	if sf.devStore == nil {
+		return nil
+	}
+	return sf.LoadStore(sf.devStore)
+}
+
+// SetCallback 设置事件接口,需在Connect之前设置
+func (sf *Client) SetCallback(cb Callback) {
+	sf.cb = cb
+}
+
+// SetGwCallback 设置网关事件接口,需在Connect之前设置
+func (sf *Client) SetGwCallback(cb GwCallback) {
+	sf.gwCb = cb
+}
+
+// Connect 将订阅所有相关主题,主题有config配置,并上报已注册的OTA模块的固件版本
 func (sf *Client) Connect() error {
 	if sf.mode != ModeMQTT {
 		return nil
 	}
-	return sf.SubscribeAllTopic(sf.tetrad.ProductKey, sf.tetrad.DeviceName, false)
+	err := sf.SubscribeAllTopic(sf.tetrad.ProductKey, sf.tetrad.DeviceName, false)
+	if err != nil {
+		return err
+	}
+	sf.otaInformModulesOnline(sf.tetrad.ProductKey, sf.tetrad.DeviceName)
+	return nil
 }
 
 // AddSubDevice 增加一个一个子设备
@@ -141,9 +213,49 @@ func (sf *Client) AddSubDevice(meta infra.MetaTriad) error {
 	return ErrNotSupportFeature
 }
 
+// SubDeviceRegister 子设备动态注册,获得设备证书.
+// 设置了子设备产品的ProductSecret时使用一型一密动态注册,设备名称无需在平台预注册,
+// 否则使用子设备动态注册,设备名称需在平台预注册.
+// 应答中没有该子设备的设备证书时返回 ErrNotRegistered
+func (sf *Client) SubDeviceRegister(pk, dn string, timeout time.Duration) error {
+	var regs []SubRegisterData
+	var err error
+	if _, e := sf.ProductSecret(pk); e == nil {
+		regs, err = sf.LinkThingProxyProductRegister(pk, dn, timeout)
+	} else {
+		regs, err = sf.LinkThingSubRegister(pk, dn, timeout)
+	}
+	if err != nil {
+		return err
+	}
+	for _, v := range regs {
+		if v.ProductKey == pk && v.DeviceName == dn {
+			return nil
+		}
+	}
+	return ErrNotRegistered
+}
+
+// HasDeviceSecret 是否持有子设备的设备证书, 设置了外部签名器时设备证书由签名器持有, 总是返回true
+func (sf *Client) HasDeviceSecret(pk, dn string) bool {
+	if sf.hasSigner {
+		return true
+	}
+	ds, err := sf.DeviceSecret(pk, dn)
+	return err == nil && ds != ""
+}
+
+// calcSign 使用签名器计算子设备认证的签名, 返回clientID和加签后的值
+func (sf *Client) calcSign(pk, dn string, timestamp int64) (string, string, error) {
+	return infra.CalcSignWith(sf.signer, "hmacsha256",
+		infra.MetaPair{ProductKey: pk, DeviceName: dn}, timestamp)
+}
+
 // SubDeviceConnect 子设备连接注册并添加到网关拓扑关系
 // 子设备上线流程: (确保网关已接入物联网平台)
-//      1. 子设备发起动态注册，返回成功注册的子设备的设备证书(当平台使能动态注册子设备时)
+//      1. 子设备发起动态注册，返回成功注册的子设备的设备证书(当平台使能动态注册子设备时),
+//         设置了子设备产品的ProductSecret时使用一型一密动态注册,设备名称无需在平台预注册,
+//         设置了外部签名器时设备证书由签名器持有,不进行动态注册
 //      2. 子设备身份注册后,通过网关向平台上报网关与子设备的拓扑关系
 //      3. 子设备进行上线(此时平台会校验子设备的身份和与网关的拓扑关系。所有校验通过，才会建立并绑定子设备逻辑通道至网关物理通道上)
 //      4. 子设备与物联网平台的数据上下行通信与直连设备的通信协议一致，协议上不需要露出网关信息
@@ -153,11 +265,13 @@ func (sf *Client) SubDeviceConnect(pk, dn string, cleanSession bool, timeout tim
 	if err != nil {
 		return err
 	}
-	if node.Status() < DevStatusRegistered || node.DeviceSecret() == "" { // 需要注册
+	if !sf.hasSigner && (node.Status() < DevStatusRegistered || node.DeviceSecret() == "") { // 需要注册
 		// 子设备注册
-		if _, err := sf.LinkThingSubRegister(pk, dn, timeout); err != nil {
+		if err := sf.SubDeviceRegister(pk, dn, timeout); err != nil {
 			return err
 		}
+	} else if node.Status() < DevStatusRegistered {
+		sf.UpdateDeviceStatus(pk, dn, DevStatusRegistered, StatusCauseRegister) // nolint: errcheck
 	}
 	// 子设备添加到拓扑
 	err = sf.LinkThingTopoAdd(pk, dn, timeout)
@@ -174,6 +288,7 @@ func (sf *Client) SubDeviceConnect(pk, dn string, cleanSession bool, timeout tim
 	if err != nil {
 		return err
 	}
-	sf.SetDeviceStatus(pk, dn, DevStatusOnline) // nolint: errcheck
+	sf.UpdateDeviceStatus(pk, dn, DevStatusOnline, StatusCauseSubscribe) // nolint: errcheck
+	sf.otaInformModulesOnline(pk, dn)
 	return nil
 }
@@ -48,6 +48,28 @@ func (sf *Client) LinkThingEventPost(pk, dn, eventID string, params interface{},
 	return err
 }
 
+// LinkThingFunctionBlockPropertyPost 自定义模块上报属性数据,同步
+func (sf *Client) LinkThingFunctionBlockPropertyPost(pk, dn, functionBlockID string,
+	params map[string]interface{}, timeout time.Duration) error {
+	token, err := sf.ThingFunctionBlockPropertyPost(pk, dn, functionBlockID, params)
+	if err != nil {
+		return err
+	}
+	_, err = token.Wait(timeout)
+	return err
+}
+
+// LinkThingFunctionBlockEventPost 自定义模块事件上报,同步
+func (sf *Client) LinkThingFunctionBlockEventPost(pk, dn, functionBlockID, eventID string,
+	params interface{}, timeout time.Duration) error {
+	token, err := sf.ThingFunctionBlockEventPost(pk, dn, functionBlockID, eventID, params)
+	if err != nil {
+		return err
+	}
+	_, err = token.Wait(timeout)
+	return err
+}
+
 // LinkThingEventPropertyPackPost 网关批量上报数据,同步
 func (sf *Client) LinkThingEventPropertyPackPost(params interface{}, timeout time.Duration) error {
 	token, err := sf.ThingEventPropertyPackPost(params)
@@ -172,7 +194,12 @@ func (sf *Client) LinkThingLogPost(pk, dn string, lp []LogParam, timeout time.Du
 
 // LinkThingSubRegister 同步子设备注册,
 func (sf *Client) LinkThingSubRegister(pk, dn string, timeout time.Duration) ([]SubRegisterData, error) {
-	token, err := sf.thingSubRegister(pk, dn)
+	return sf.LinkThingSubBatchRegister([]infra.MetaPair{{ProductKey: pk, DeviceName: dn}}, timeout)
+}
+
+// LinkThingSubBatchRegister 同步子设备批量注册
+func (sf *Client) LinkThingSubBatchRegister(pairs []infra.MetaPair, timeout time.Duration) ([]SubRegisterData, error) {
+	token, err := sf.thingSubBatchRegister(pairs)
 	if err != nil {
 		return nil, err
 	}
@@ -181,18 +208,51 @@ func (sf *Client) LinkThingSubRegister(pk, dn string, timeout time.Duration) ([]
 		return nil, err
 	}
 	data := msg.Data.([]SubRegisterData)
-	for _, v := range msg.Data.([]SubRegisterData) {
-		sf.SetDeviceSecret(v.ProductKey, v.DeviceName, v.DeviceSecret)      // nolint: errcheck
-		sf.SetDeviceStatus(v.ProductKey, v.DeviceName, DevStatusRegistered) // nolint: errcheck
+	for _, v := range data {
+		sf.SetDeviceSecret(v.ProductKey, v.DeviceName, v.DeviceSecret)                              // nolint: errcheck
+		sf.UpdateDeviceStatus(v.ProductKey, v.DeviceName, DevStatusRegistered, StatusCauseRegister) // nolint: errcheck
 	}
 	return data, nil
 }
 
+// LinkThingProxyProductRegister 同步子设备一型一密动态注册,需已设置子设备产品的ProductSecret
+func (sf *Client) LinkThingProxyProductRegister(pk, dn string, timeout time.Duration) ([]SubRegisterData, error) {
+	return sf.LinkThingProxyBatchProductRegister([]infra.MetaPair{{ProductKey: pk, DeviceName: dn}}, timeout)
+}
+
+// LinkThingProxyBatchProductRegister 同步子设备一型一密批量动态注册,
+// 返回成功注册的子设备, 有子设备注册失败时同时返回第一个失败的错误
+func (sf *Client) LinkThingProxyBatchProductRegister(pairs []infra.MetaPair, timeout time.Duration) ([]SubRegisterData, error) {
+	token, err := sf.thingProxyBatchProductRegister(pairs)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := token.Wait(timeout)
+	if err != nil {
+		return nil, err
+	}
+	data := msg.Data.(ProductRegisterData)
+	for _, v := range data.Successes {
+		sf.SetDeviceSecret(v.ProductKey, v.DeviceName, v.DeviceSecret)                              // nolint: errcheck
+		sf.UpdateDeviceStatus(v.ProductKey, v.DeviceName, DevStatusRegistered, StatusCauseRegister) // nolint: errcheck
+	}
+	if len(data.ErrorInfos) > 0 {
+		e := data.ErrorInfos[0]
+		return data.Successes, infra.NewCodeError(e.Code, e.ProductKey+"."+e.DeviceName+": "+e.Message)
+	}
+	return data.Successes, nil
+}
+
 /**************************************** network *****************************/
 
 // LinkThingTopoAdd 添加设备拓扑关系,同步
 func (sf *Client) LinkThingTopoAdd(pk, dn string, timeout time.Duration) error {
-	token, err := sf.thingTopoAdd(pk, dn)
+	return sf.LinkThingTopoBatchAdd([]infra.MetaPair{{ProductKey: pk, DeviceName: dn}}, timeout)
+}
+
+// LinkThingTopoBatchAdd 批量添加设备拓扑关系,同步
+func (sf *Client) LinkThingTopoBatchAdd(pairs []infra.MetaPair, timeout time.Duration) error {
+	token, err := sf.thingTopoBatchAdd(pairs)
 	if err != nil {
 		return err
 	}
@@ -201,14 +261,19 @@ func (sf *Client) LinkThingTopoAdd(pk, dn string, timeout time.Duration) error {
 		return err
 	}
 	for _, pair := range msg.Data.([]infra.MetaPair) {
-		sf.SetDeviceStatus(pair.ProductKey, pair.DeviceName, DevStatusAttached) // nolint: errcheck
+		sf.UpdateDeviceStatus(pair.ProductKey, pair.DeviceName, DevStatusAttached, StatusCauseTopoAdd) // nolint: errcheck
 	}
 	return nil
 }
 
 // LinkThingTopoDelete 删除网关与子设备的拓扑关系
 func (sf *Client) LinkThingTopoDelete(pk, dn string, timeout time.Duration) error {
-	token, err := sf.thingTopoDelete(pk, dn)
+	return sf.LinkThingTopoBatchDelete([]infra.MetaPair{{ProductKey: pk, DeviceName: dn}}, timeout)
+}
+
+// LinkThingTopoBatchDelete 批量删除网关与子设备的拓扑关系,同步
+func (sf *Client) LinkThingTopoBatchDelete(pairs []infra.MetaPair, timeout time.Duration) error {
+	token, err := sf.thingTopoBatchDelete(pairs)
 	if err != nil {
 		return err
 	}
@@ -217,7 +282,7 @@ func (sf *Client) LinkThingTopoDelete(pk, dn string, timeout time.Duration) erro
 		return err
 	}
 	for _, pair := range msg.Data.([]infra.MetaPair) {
-		sf.SetDeviceStatus(pair.ProductKey, pair.DeviceName, DevStatusRegistered) // nolint: errcheck
+		sf.UpdateDeviceStatus(pair.ProductKey, pair.DeviceName, DevStatusRegistered, StatusCauseTopoDelete) // nolint: errcheck
 	}
 	return nil
 }
@@ -257,7 +322,7 @@ func (sf *Client) LinkExtCombineLogin(cp CombinePair, timeout time.Duration) err
 	if err != nil {
 		return err
 	}
-	sf.SetDeviceStatus(cp.ProductKey, cp.DeviceName, DevStatusLogined) // nolint: errcheck
+	sf.UpdateDeviceStatus(cp.ProductKey, cp.DeviceName, DevStatusLogined, StatusCauseLogin) // nolint: errcheck
 	return nil
 }
 
@@ -273,7 +338,7 @@ func (sf *Client) LinkExtCombineBatchLogin(pairs []CombinePair, timeout time.Dur
 	}
 
 	for _, cp := range pairs {
-		sf.SetDeviceStatus(cp.ProductKey, cp.DeviceName, DevStatusLogined) // nolint: errcheck
+		sf.UpdateDeviceStatus(cp.ProductKey, cp.DeviceName, DevStatusLogined, StatusCauseLogin) // nolint: errcheck
 	}
 	return nil
 }
@@ -288,7 +353,7 @@ func (sf *Client) LinkExtCombineLogout(pk, dn string, timeout time.Duration) err
 	if err != nil {
 		return err
 	}
-	sf.SetDeviceStatus(pk, dn, DevStatusAttached) // nolint: errcheck
+	sf.UpdateDeviceStatus(pk, dn, DevStatusAttached, StatusCauseLogout) // nolint: errcheck
 	return nil
 }
 
@@ -303,7 +368,7 @@ func (sf *Client) LinkExtCombineBatchLogout(pairs []infra.MetaPair, timeout time
 		return err
 	}
 	for _, cp := range pairs {
-		sf.SetDeviceStatus(cp.ProductKey, cp.DeviceName, DevStatusAttached) // nolint: errcheck
+		sf.UpdateDeviceStatus(cp.ProductKey, cp.DeviceName, DevStatusAttached, StatusCauseLogout) // nolint: errcheck
 	}
 	return nil
 }
@@ -324,6 +389,60 @@ func (sf *Client) LinkThingOtaFirmwareGet(pk, dn string,
 	return msg.Data.(OtaFirmwareData), nil
 }
 
+// LinkThingFileDownload 请求下载文件分片,同步
+func (sf *Client) LinkThingFileDownload(pk, dn string,
+	params FileDownloadParams, timeout time.Duration) (*FileDownloadReply, error) {
+	token, err := sf.ThingFileDownload(pk, dn, params)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := token.Wait(timeout)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Data.(*FileDownloadReply), nil
+}
+
+/**************************************** file upload *****************************/
+
+// LinkThingFileUploadInit 初始化文件上传,同步
+func (sf *Client) LinkThingFileUploadInit(pk, dn string,
+	params FileUploadInitParams, timeout time.Duration) (*FileUploadInitData, error) {
+	token, err := sf.ThingFileUploadInit(pk, dn, params)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := token.Wait(timeout)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Data.(*FileUploadInitData), nil
+}
+
+// LinkThingFileUploadSend 上传文件分片,同步
+func (sf *Client) LinkThingFileUploadSend(pk, dn string,
+	params FileUploadSendParams, block []byte, timeout time.Duration) (*FileUploadSendData, error) {
+	token, err := sf.ThingFileUploadSend(pk, dn, params, block)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := token.Wait(timeout)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Data.(*FileUploadSendData), nil
+}
+
+// LinkThingFileUploadCancel 取消文件上传,同步
+func (sf *Client) LinkThingFileUploadCancel(pk, dn, uploadID string, timeout time.Duration) error {
+	token, err := sf.ThingFileUploadCancel(pk, dn, uploadID)
+	if err != nil {
+		return err
+	}
+	_, err = token.Wait(timeout)
+	return err
+}
+
 /**************************************** diag *****************************/
 
 // LinkThingDiagPost 设备主动上报当前网络状态,同步
@@ -7,6 +7,7 @@ package aiot
 import (
 	"time"
 
+	"github.com/things-go/aliyun-iot/infra"
 	"github.com/things-go/aliyun-iot/logger"
 )
 
@@ -49,6 +50,15 @@ func WithEnableModelRaw() Option {
 	}
 }
 
+// WithCodec 设置透传数据编解码器,同时使能透传
+// 物模型的上下行数据将透明地经编解码器在Alink格式与透传数据间转换
+func WithCodec(codec Codec) Option {
+	return func(c *Client) {
+		c.codec = codec
+		c.hasRawModel = codec != nil || c.hasRawModel
+	}
+}
+
 // WithEnableDesired 使能期望属性
 func WithEnableDesired() Option {
 	return func(c *Client) {
@@ -70,6 +80,55 @@ func WithEnableGateway() Option {
 	}
 }
 
+// WithSubDevRelogin 设置同一子设备两次自动重新登陆的最小间隔,默认 DefaultReloginInterval, 0 禁用自动重新登陆.
+// 网关收到子设备会话错误(520)或拓扑关系不存在(6401)时,将自动重新登陆该子设备并重新订阅其主题
+func WithSubDevRelogin(interval time.Duration) Option {
+	return func(c *Client) {
+		c.reloginInterval = interval
+	}
+}
+
+// WithSubDevReloginTimeout 设置自动重新登陆时拓扑添加及登陆请求的超时时间,默认 DefaultReloginTimeout
+func WithSubDevReloginTimeout(t time.Duration) Option {
+	return func(c *Client) {
+		if t > 0 {
+			c.reloginTimeout = t
+		}
+	}
+}
+
+// WithSubDevReplay 使能子设备请求重放, 子设备会话错误导致失败的子设备请求,
+// 将在子设备重新登陆后重新发送, 等待该请求应答的调用者将收到重放请求的应答
+func WithSubDevReplay() Option {
+	return func(c *Client) {
+		c.reloginReplay = true
+	}
+}
+
+// WithDevStore 设置子设备持久化存储,创建时将从存储中加载子设备,加载失败见 Client.LoadDevStore
+func WithDevStore(store DevStore) Option {
+	return func(c *Client) {
+		c.devStore = store
+	}
+}
+
+// WithSigner 设置子设备拓扑添加及上线认证的签名器, 子设备的DeviceSecret由签名器持有,
+// 不再保存在设备管理中, 子设备需已在平台注册, SubDeviceConnect 不再进行动态注册.
+// 默认使用设备管理中的DeviceSecret进行HMAC签名
+func WithSigner(s infra.Signer) Option {
+	return func(c *Client) {
+		c.signer = s
+		c.hasSigner = s != nil
+	}
+}
+
+// WithProductSecret 设置子设备产品的ProductSecret,该产品的子设备将使用一型一密动态注册
+func WithProductSecret(pk, ps string) Option {
+	return func(c *Client) {
+		c.SetProductSecret(pk, ps)
+	}
+}
+
 // WithEnableOTA 使能ota功能
 func WithEnableOTA() Option {
 	return func(c *Client) {
@@ -77,6 +136,29 @@ func WithEnableOTA() Option {
 	}
 }
 
+// WithOtaUpgrader 设置OTA升级处理器,同时使能ota功能
+func WithOtaUpgrader(u OtaUpgrader) Option {
+	return func(c *Client) {
+		c.hasOTA = true
+		c.otaUpgrader = u
+	}
+}
+
+// WithOtaModule 注册OTA模块及其版本获取函数,同时使能ota功能,
+// 设备上线及升级成功后将上报所有模块的固件版本
+func WithOtaModule(module string, version OtaVersionFunc) Option {
+	return func(c *Client) {
+		c.AddOtaModule(module, version)
+	}
+}
+
+// WithEnableFileUpload 使能MQTT文件上传功能
+func WithEnableFileUpload() Option {
+	return func(c *Client) {
+		c.hasFileUpload = true
+	}
+}
+
 // WithEnableDiag 使能diag功能
 func WithEnableDiag() Option {
 	return func(c *Client) {
@@ -84,6 +166,19 @@ func WithEnableDiag() Option {
 	}
 }
 
+// WithFunctionBlockHandler 设置自定义模块(功能块)的服务调用处理函数
+func WithFunctionBlockHandler(functionBlockID string, h FunctionBlockServiceHandler) Option {
+	return func(c *Client) {
+		if functionBlockID == "" || h == nil {
+			return
+		}
+		if c.functionBlocks == nil {
+			c.functionBlocks = make(map[string]FunctionBlockServiceHandler)
+		}
+		c.functionBlocks[functionBlockID] = h
+	}
+}
+
 // WithCallback 设置事件处理接口
 func WithCallback(cb Callback) Option {
 	return func(c *Client) {
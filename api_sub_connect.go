@@ -0,0 +1,199 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package aiot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// 子设备批量操作的单个批次数量.
+// CombineBatchMax 为平台对批量上下线的限制, 见 https://help.aliyun.com/document_detail/89300.html .
+// 子设备注册(89298)及添加拓扑(89299)的文档未给出单个批次的数量,
+// SubDevBatchMax 为本库的取值而非平台限制: 取 CombineBatchMax,
+// 使注册及添加拓扑的批次与批量上线的批次一致, 批次失败时逐个重试的请求数也有限
+const (
+	CombineBatchMax        = 5               // 单个批次上下线的子设备数量不超过5个
+	SubDevBatchMax         = CombineBatchMax // 单个批次注册及添加拓扑的子设备数量上限
+	DefaultSubDevBatchSize = SubDevBatchMax  // 批量注册及添加拓扑的默认批次数量
+)
+
+// SubConnectStage 子设备连接的阶段
+type SubConnectStage byte
+
+// 子设备连接的阶段
+const (
+	SubConnectStageRegister  SubConnectStage = iota // 注册(含查找子设备)
+	SubConnectStageTopoAdd                          // 添加拓扑
+	SubConnectStageLogin                            // 上线
+	SubConnectStageSubscribe                        // 订阅
+)
+
+// String 实现 fmt.Stringer 接口
+func (sf SubConnectStage) String() string {
+	switch sf {
+	case SubConnectStageRegister:
+		return "register"
+	case SubConnectStageTopoAdd:
+		return "topo add"
+	case SubConnectStageLogin:
+		return "login"
+	case SubConnectStageSubscribe:
+		return "subscribe"
+	}
+	return "unknown"
+}
+
+// SubConnectError 子设备连接失败,Stage为失败的阶段
+type SubConnectError struct {
+	Stage SubConnectStage
+	Err   error
+}
+
+// Error 实现error接口
+func (sf *SubConnectError) Error() string {
+	return fmt.Sprintf("sub-device %s failed, %v", sf.Stage, sf.Err)
+}
+
+// Unwrap 返回原始错误
+func (sf *SubConnectError) Unwrap() error { return sf.Err }
+
+// SubConnectOptions 子设备批量连接的选项
+type SubConnectOptions struct {
+	Timeout   time.Duration // 每个请求的超时时间
+	BatchSize int           // 批量注册及添加拓扑的单个批次数量,默认 DefaultSubDevBatchSize,最大 SubDevBatchMax
+}
+
+// SubDevicesConnect 子设备批量连接,流程同 SubDeviceConnect,设置了外部签名器时不进行动态注册,
+// 子设备按批次注册,添加拓扑,并以 CombineBatchMax 为一组批量上线.
+// 批量请求失败时,该批次的子设备将逐个重试,以隔离失败的子设备.
+// 返回每个子设备的结果, key为 FormatKey(pk, dn), 成功为nil, 失败为 *SubConnectError
+func (sf *Client) SubDevicesConnect(pairs []CombinePair, opts SubConnectOptions) (map[string]error, error) {
+	if !sf.isGateway {
+		return nil, ErrNotSupportFeature
+	}
+	if len(pairs) == 0 || opts.Timeout <= 0 {
+		return nil, ErrInvalidParameter
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultSubDevBatchSize
+	} else if opts.BatchSize > SubDevBatchMax {
+		opts.BatchSize = SubDevBatchMax
+	}
+
+	result := make(map[string]error, len(pairs))
+	failed := func(pk, dn string, stage SubConnectStage, err error) {
+		result[FormatKey(pk, dn)] = &SubConnectError{stage, err}
+	}
+
+	// 注册
+	var registers []infra.MetaPair
+	for _, cp := range pairs {
+		node, err := sf.SearchAvail(cp.ProductKey, cp.DeviceName)
+		if err != nil {
+			failed(cp.ProductKey, cp.DeviceName, SubConnectStageRegister, err)
+			continue
+		}
+		result[FormatKey(cp.ProductKey, cp.DeviceName)] = nil
+		if !sf.hasSigner && (node.Status() < DevStatusRegistered || !sf.HasDeviceSecret(cp.ProductKey, cp.DeviceName)) {
+			registers = append(registers, infra.MetaPair{ProductKey: cp.ProductKey, DeviceName: cp.DeviceName})
+		} else if node.Status() < DevStatusRegistered { // 设备证书由签名器持有
+			sf.UpdateDeviceStatus(cp.ProductKey, cp.DeviceName, DevStatusRegistered, StatusCauseRegister) // nolint: errcheck
+		}
+	}
+	sf.subDevicesBatch(registers, opts.BatchSize, func(batch []infra.MetaPair) error {
+		_, err := sf.LinkThingSubBatchRegister(batch, opts.Timeout)
+		return err
+	}, func(pair infra.MetaPair, err error) {
+		failed(pair.ProductKey, pair.DeviceName, SubConnectStageRegister, err)
+	})
+	// 批量注册成功时应答可能缺少部分子设备,未获得设备证书的视为注册失败
+	for _, pair := range registers {
+		if result[FormatKey(pair.ProductKey, pair.DeviceName)] != nil {
+			continue
+		}
+		node, err := sf.SearchAvail(pair.ProductKey, pair.DeviceName)
+		if err == nil && (node.Status() < DevStatusRegistered || !sf.HasDeviceSecret(pair.ProductKey, pair.DeviceName)) {
+			err = ErrNotRegistered
+		}
+		if err != nil {
+			failed(pair.ProductKey, pair.DeviceName, SubConnectStageRegister, err)
+		}
+	}
+
+	// 添加拓扑
+	var topos []infra.MetaPair
+	for _, cp := range pairs {
+		if result[FormatKey(cp.ProductKey, cp.DeviceName)] == nil {
+			topos = append(topos, infra.MetaPair{ProductKey: cp.ProductKey, DeviceName: cp.DeviceName})
+		}
+	}
+	sf.subDevicesBatch(topos, opts.BatchSize, func(batch []infra.MetaPair) error {
+		return sf.LinkThingTopoBatchAdd(batch, opts.Timeout)
+	}, func(pair infra.MetaPair, err error) {
+		failed(pair.ProductKey, pair.DeviceName, SubConnectStageTopoAdd, err)
+	})
+
+	// 上线,批量上线为原子接口
+	var logins []CombinePair
+	for _, cp := range pairs {
+		if result[FormatKey(cp.ProductKey, cp.DeviceName)] == nil {
+			logins = append(logins, cp)
+		}
+	}
+	for len(logins) > 0 {
+		n := CombineBatchMax
+		if len(logins) < n {
+			n = len(logins)
+		}
+		if err := sf.LinkExtCombineBatchLogin(logins[:n], opts.Timeout); err != nil {
+			for _, cp := range logins[:n] {
+				if n == 1 {
+					failed(cp.ProductKey, cp.DeviceName, SubConnectStageLogin, err)
+				} else if err := sf.LinkExtCombineLogin(cp, opts.Timeout); err != nil {
+					failed(cp.ProductKey, cp.DeviceName, SubConnectStageLogin, err)
+				}
+			}
+		}
+		logins = logins[n:]
+	}
+
+	// 订阅
+	for _, cp := range pairs {
+		if result[FormatKey(cp.ProductKey, cp.DeviceName)] != nil {
+			continue
+		}
+		if err := sf.SubscribeAllTopic(cp.ProductKey, cp.DeviceName, true); err != nil {
+			failed(cp.ProductKey, cp.DeviceName, SubConnectStageSubscribe, err)
+			continue
+		}
+		sf.UpdateDeviceStatus(cp.ProductKey, cp.DeviceName, DevStatusOnline, StatusCauseSubscribe) // nolint: errcheck
+		sf.otaInformModulesOnline(cp.ProductKey, cp.DeviceName)
+	}
+	return result, nil
+}
+
+// subDevicesBatch 按批次执行请求,批次失败时逐个重试,仍失败的调用failed
+func (sf *Client) subDevicesBatch(pairs []infra.MetaPair, size int,
+	do func(batch []infra.MetaPair) error, failed func(pair infra.MetaPair, err error)) {
+	for len(pairs) > 0 {
+		n := size
+		if len(pairs) < n {
+			n = len(pairs)
+		}
+		if err := do(pairs[:n]); err != nil {
+			for _, pair := range pairs[:n] {
+				if n == 1 {
+					failed(pair, err)
+				} else if err := do([]infra.MetaPair{pair}); err != nil {
+					failed(pair, err)
+				}
+			}
+		}
+		pairs = pairs[n:]
+	}
+}
@@ -0,0 +1,119 @@
+package aiot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+func subPairs(n int) []CombinePair {
+	pairs := make([]CombinePair, 0, n)
+	for i := 0; i < n; i++ {
+		pairs = append(pairs, CombinePair{ProductKey: "pk", DeviceName: string(rune('a' + i))})
+	}
+	return pairs
+}
+
+func addSubDevices(t *testing.T, c *Client, pairs []CombinePair) {
+	for _, cp := range pairs {
+		require.NoError(t, c.AddSubDevice(infra.MetaTriad{ProductKey: cp.ProductKey, DeviceName: cp.DeviceName}))
+	}
+}
+
+// stageOf 子设备连接失败的阶段
+func stageOf(t *testing.T, err error) SubConnectStage {
+	var e *SubConnectError
+	require.True(t, errors.As(err, &e), "%v", err)
+	return e.Stage
+}
+
+func TestSubDevicesConnect(t *testing.T) {
+	c, cloud := newGateway()
+	pairs := subPairs(7)
+	addSubDevices(t, c, pairs)
+
+	_, err := c.SubDevicesConnect(pairs, SubConnectOptions{})
+	require.Equal(t, ErrInvalidParameter, err)
+
+	result, err := c.SubDevicesConnect(append(pairs, CombinePair{ProductKey: "pk", DeviceName: "missing"}),
+		SubConnectOptions{Timeout: time.Second, BatchSize: 100})
+	require.NoError(t, err)
+	require.Len(t, result, 8)
+	for _, cp := range pairs {
+		require.NoError(t, result[FormatKey(cp.ProductKey, cp.DeviceName)])
+		require.True(t, c.IsActive(cp.ProductKey, cp.DeviceName))
+	}
+	require.Equal(t, SubConnectStageRegister, stageOf(t, result[FormatKey("pk", "missing")]))
+	// 批次数量不超过 SubDevBatchMax
+	require.Equal(t, 2, cloud.count("/thing/sub/register"))
+	require.Equal(t, 2, cloud.count("/thing/topo/add"))
+	require.Equal(t, 2, cloud.count("/combine/batch_login"))
+}
+
+func TestSubDevicesConnectStages(t *testing.T) {
+	c, cloud := newGateway()
+	pairs := subPairs(5)
+	addSubDevices(t, c, pairs)
+
+	// a: 注册应答中缺失, b: 注册失败, c: 添加拓扑失败, d: 上线失败, e: 成功
+	cloud.omit = func(p infra.MetaPair) bool { return p.DeviceName == "a" }
+	cloud.fail = func(topic string, pairs []infra.MetaPair) int {
+		for _, p := range pairs {
+			switch {
+			case p.DeviceName == "b" && strings.HasSuffix(topic, "/thing/sub/register"),
+				p.DeviceName == "c" && strings.HasSuffix(topic, "/thing/topo/add"),
+				p.DeviceName == "d" && strings.Contains(topic, "/combine/"):
+				return infra.CodeRequestError
+			}
+		}
+		return 0
+	}
+	result, err := c.SubDevicesConnect(pairs, SubConnectOptions{Timeout: time.Second})
+	require.NoError(t, err)
+
+	require.Equal(t, SubConnectStageRegister, stageOf(t, result[FormatKey("pk", "a")]))
+	require.True(t, errors.Is(result[FormatKey("pk", "a")], ErrNotRegistered))
+	require.Equal(t, SubConnectStageRegister, stageOf(t, result[FormatKey("pk", "b")]))
+	require.Equal(t, SubConnectStageTopoAdd, stageOf(t, result[FormatKey("pk", "c")]))
+	require.Equal(t, SubConnectStageLogin, stageOf(t, result[FormatKey("pk", "d")]))
+	require.NoError(t, result[FormatKey("pk", "e")])
+
+	for dn, want := range map[string]DevStatus{
+		"a": DevStatusUnauthorized,
+		"b": DevStatusUnauthorized,
+		"c": DevStatusRegistered,
+		"d": DevStatusAttached,
+		"e": DevStatusOnline,
+	} {
+		st, err := c.DeviceStatus("pk", dn)
+		require.NoError(t, err)
+		require.Equal(t, want, st, dn)
+	}
+}
+
+func TestSubDevicesConnectSigner(t *testing.T) {
+	signer := infra.SignerFunc(func(method string, _ infra.MetaPair, content string) (string, error) {
+		return infra.Hmac(method, "external", content), nil
+	})
+	c, cloud := newGateway(WithSigner(signer))
+	pairs := subPairs(3)
+	addSubDevices(t, c, pairs)
+
+	result, err := c.SubDevicesConnect(pairs, SubConnectOptions{Timeout: time.Second})
+	require.NoError(t, err)
+	for _, cp := range pairs {
+		require.NoError(t, result[FormatKey(cp.ProductKey, cp.DeviceName)])
+		require.True(t, c.IsActive(cp.ProductKey, cp.DeviceName))
+		// 设备证书不写回设备管理
+		ds, err := c.DeviceSecret(cp.ProductKey, cp.DeviceName)
+		require.NoError(t, err)
+		require.Empty(t, ds)
+	}
+	require.Equal(t, 0, cloud.count("/thing/sub/register"))
+	require.Equal(t, 1, cloud.count("/thing/topo/add"))
+}
@@ -0,0 +1,164 @@
+package aiot
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+var testGateway = infra.MetaTriad{ProductKey: "gwpk", DeviceName: "gwdn", DeviceSecret: "gwds"}
+
+// testProductSecret 模拟云端子设备产品的ProductSecret
+const testProductSecret = "ps"
+
+// fakeCloud 模拟云端,按主题应答网关的请求
+type fakeCloud struct {
+	c *Client
+
+	mu       sync.Mutex
+	topics   []string
+	payloads map[string][][]byte
+	// fail 返回非零时,该主题的请求应答对应的错误码
+	fail func(topic string, pairs []infra.MetaPair) int
+	// omit 返回true时,注册应答中不包含该子设备
+	omit func(pair infra.MetaPair) bool
+	// topo 云端的拓扑关系
+	topo []infra.MetaPair
+}
+
+func (sf *fakeCloud) Publish(topic string, _ byte, payload interface{}) error {
+	b, _ := payload.([]byte)
+	sf.mu.Lock()
+	sf.topics = append(sf.topics, topic)
+	if sf.payloads == nil {
+		sf.payloads = make(map[string][][]byte)
+	}
+	sf.payloads[topic] = append(sf.payloads[topic], b)
+	sf.mu.Unlock()
+
+	var req struct {
+		ID     uint            `json:"id,string"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(b, &req); err != nil {
+		return nil
+	}
+	var pairs []infra.MetaPair
+	var proc ProcDownStream
+	var data interface{}
+	switch {
+	case strings.HasSuffix(topic, "/thing/sub/register"):
+		json.Unmarshal(req.Params, &pairs) // nolint: errcheck
+		regs := make([]SubRegisterData, 0, len(pairs))
+		for _, p := range pairs {
+			if sf.omit != nil && sf.omit(p) {
+				continue
+			}
+			regs = append(regs, SubRegisterData{ProductKey: p.ProductKey, DeviceName: p.DeviceName, DeviceSecret: "ds"})
+		}
+		proc, data = ProcThingSubRegisterReply, regs
+	case strings.HasSuffix(topic, "/thing/proxy/provisioning/product_register"):
+		var params ProductRegisterParams
+		json.Unmarshal(req.Params, &params) // nolint: errcheck
+		var prd ProductRegisterData
+		for _, p := range params.Proxieds {
+			pair := infra.MetaPair{ProductKey: p.ProductKey, DeviceName: p.DeviceName}
+			pairs = append(pairs, pair)
+			source := "deviceName" + p.DeviceName + "productKey" + p.ProductKey + "random" + p.Random
+			if infra.Hmac(p.SignMethod, testProductSecret, source) != p.Sign {
+				prd.ErrorInfos = append(prd.ErrorInfos, ProductRegisterError{
+					ProductKey: p.ProductKey, DeviceName: p.DeviceName, Code: infra.CodeRequestError, Message: "sign mismatch",
+				})
+				continue
+			}
+			if sf.omit != nil && sf.omit(pair) {
+				continue
+			}
+			prd.Successes = append(prd.Successes, SubRegisterData{ProductKey: p.ProductKey, DeviceName: p.DeviceName, DeviceSecret: "ds"})
+		}
+		proc, data = ProcThingProxyProductRegisterReply, prd
+	case strings.HasSuffix(topic, "/thing/topo/add"):
+		json.Unmarshal(req.Params, &pairs) // nolint: errcheck
+		proc, data = ProcThingTopoAddReply, pairs
+	case strings.HasSuffix(topic, "/thing/topo/delete"):
+		json.Unmarshal(req.Params, &pairs) // nolint: errcheck
+		proc, data = ProcThingTopoDeleteReply, pairs
+	case strings.HasSuffix(topic, "/thing/topo/get"):
+		sf.mu.Lock()
+		topo := append([]infra.MetaPair{}, sf.topo...)
+		sf.mu.Unlock()
+		proc, data = ProcThingTopoGetReply, topo
+	case strings.HasSuffix(topic, "/combine/batch_login"):
+		var params CombineBatchLoginParams
+		json.Unmarshal(req.Params, &params) // nolint: errcheck
+		for _, p := range params.DeviceList {
+			pairs = append(pairs, infra.MetaPair{ProductKey: p.ProductKey, DeviceName: p.DeviceName})
+		}
+		proc = ProcExtCombineBatchLoginReply
+	case strings.HasSuffix(topic, "/combine/login"):
+		var p infra.MetaPair
+		json.Unmarshal(req.Params, &p) // nolint: errcheck
+		pairs = append(pairs, p)
+		proc = ProcExtCombineLoginReply
+	default:
+		return nil
+	}
+	rsp := Response{ID: req.ID, Code: infra.CodeSuccess, Data: data}
+	if sf.fail != nil {
+		if code := sf.fail(topic, pairs); code != 0 {
+			rsp.Code, rsp.Data = code, nil
+		}
+	}
+	out, _ := json.Marshal(rsp)
+	go func() {
+		time.Sleep(time.Millisecond * 5)
+		proc(sf.c, topic+"_reply", out) // nolint: errcheck
+	}()
+	return nil
+}
+
+func (sf *fakeCloud) Subscribe(string, ProcDownStream) error { return nil }
+func (sf *fakeCloud) UnSubscribe(...string) error            { return nil }
+func (sf *fakeCloud) Close() error                           { return nil }
+
+// count 主题后缀为suffix的请求数量
+func (sf *fakeCloud) count(suffix string) int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	n := 0
+	for _, topic := range sf.topics {
+		if strings.HasSuffix(topic, suffix) {
+			n++
+		}
+	}
+	return n
+}
+
+// published 发往主题topic的消息
+func (sf *fakeCloud) published(topic string) [][]byte {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.payloads[topic]
+}
+
+func newGateway(opts ...Option) (*Client, *fakeCloud) {
+	cloud := &fakeCloud{}
+	cloud.c = New(testGateway, cloud, append([]Option{WithEnableGateway()}, opts...)...)
+	return cloud.c, cloud
+}
+
+// testUpgrader 记录升级请求的 OtaUpgrader
+type testUpgrader struct {
+	mu   sync.Mutex
+	data []OtaFirmwareData
+}
+
+func (sf *testUpgrader) OtaUpgrade(_ *Client, _, _ string, data OtaFirmwareData) error {
+	sf.mu.Lock()
+	sf.data = append(sf.data, data)
+	sf.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package aiot
+
+import (
+	"time"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// TopoPolicy 拓扑关系不一致时的处理策略
+type TopoPolicy byte
+
+// 拓扑关系不一致时的处理策略
+const (
+	// TopoPolicyReport 仅报告差异, 不修改本地设备状态
+	TopoPolicyReport TopoPolicy = iota
+	// TopoPolicyAddLocal 云端拓扑中存在而本地缺失的子设备,添加到本地
+	TopoPolicyAddLocal
+	// TopoPolicyDeleteRemote 云端拓扑中存在而本地缺失的子设备,删除其云端拓扑关系
+	TopoPolicyDeleteRemote
+)
+
+// TopoReport 拓扑关系比对结果
+type TopoReport struct {
+	Confirmed []infra.MetaPair // 本地与云端拓扑中均存在的子设备
+	CloudOnly []infra.MetaPair // 仅云端拓扑中存在的子设备
+	LocalOnly []infra.MetaPair // 仅本地存在的子设备,云端已无拓扑关系
+	Added     []infra.MetaPair // 已添加到本地的子设备
+	Deleted   []infra.MetaPair // 已删除云端拓扑关系的子设备
+	Errors    map[string]error // 处理失败的子设备, key为 FormatKey(pk, dn)
+}
+
+// ReconcileTopology 比对云端拓扑关系与本地设备管理中的子设备,并按策略处理差异.
+// 除 TopoPolicyReport 外, 云端拓扑中存在的本地子设备状态置为 DevStatusAttached(已上线的子设备保持不变),
+// 云端已无拓扑关系的本地子设备状态回退为 DevStatusRegistered, 需重新添加拓扑后才能上线.
+func (sf *Client) ReconcileTopology(policy TopoPolicy, timeout time.Duration) (*TopoReport, error) {
+	if !sf.isGateway {
+		return nil, ErrNotSupportFeature
+	}
+	cloud, err := sf.LinkThingTopoGet(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	update := policy != TopoPolicyReport
+	report := &TopoReport{Errors: make(map[string]error)}
+	remote := make(map[string]struct{}, len(cloud))
+	for _, pair := range cloud {
+		remote[FormatKey(pair.ProductKey, pair.DeviceName)] = struct{}{}
+		node, err := sf.Search(pair.ProductKey, pair.DeviceName)
+		if err != nil {
+			report.CloudOnly = append(report.CloudOnly, pair)
+			continue
+		}
+		report.Confirmed = append(report.Confirmed, pair)
+		if update && node.Status() < DevStatusAttached {
+			sf.UpdateDeviceStatus(pair.ProductKey, pair.DeviceName, DevStatusAttached, StatusCauseTopology) // nolint: errcheck
+		}
+	}
+	for _, node := range sf.List() {
+		pair := infra.MetaPair{ProductKey: node.ProductKey(), DeviceName: node.DeviceName()}
+		if _, ok := remote[FormatKey(pair.ProductKey, pair.DeviceName)]; ok {
+			continue
+		}
+		report.LocalOnly = append(report.LocalOnly, pair)
+		if update && node.Status() >= DevStatusAttached {
+			sf.UpdateDeviceStatus(pair.ProductKey, pair.DeviceName, DevStatusRegistered, StatusCauseTopology) // nolint: errcheck
+		}
+	}
+
+	switch policy {
+	case TopoPolicyAddLocal:
+		for _, pair := range report.CloudOnly {
+			err := sf.Add(infra.MetaTriad{ProductKey: pair.ProductKey, DeviceName: pair.DeviceName})
+			if err != nil {
+				report.Errors[FormatKey(pair.ProductKey, pair.DeviceName)] = err
+				continue
+			}
+			sf.UpdateDeviceStatus(pair.ProductKey, pair.DeviceName, DevStatusAttached, StatusCauseTopology) // nolint: errcheck
+			report.Added = append(report.Added, pair)
+		}
+	case TopoPolicyDeleteRemote:
+		failed := make(map[string]struct{})
+		sf.subDevicesBatch(report.CloudOnly, DefaultSubDevBatchSize, func(batch []infra.MetaPair) error {
+			return sf.LinkThingTopoBatchDelete(batch, timeout)
+		}, func(pair infra.MetaPair, err error) {
+			failed[FormatKey(pair.ProductKey, pair.DeviceName)] = struct{}{}
+			report.Errors[FormatKey(pair.ProductKey, pair.DeviceName)] = err
+		})
+		for _, pair := range report.CloudOnly {
+			if _, ok := failed[FormatKey(pair.ProductKey, pair.DeviceName)]; !ok {
+				report.Deleted = append(report.Deleted, pair)
+			}
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,122 @@
+package aiot
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/uri"
+)
+
+var (
+	topoConfirmed = infra.MetaPair{ProductKey: "pk", DeviceName: "confirmed"}
+	topoLocal     = infra.MetaPair{ProductKey: "pk", DeviceName: "local"}
+	topoCloud     = infra.MetaPair{ProductKey: "pk", DeviceName: "cloud"}
+)
+
+// topoGateway 本地有confirmed(未上线)和local(在线), 云端拓扑有confirmed和cloud
+func topoGateway(t *testing.T) (*Client, *fakeCloud) {
+	c, cloud := newGateway()
+	cloud.topo = []infra.MetaPair{topoConfirmed, topoCloud}
+	for _, pair := range []infra.MetaPair{topoConfirmed, topoLocal} {
+		require.NoError(t, c.AddSubDevice(infra.MetaTriad{ProductKey: pair.ProductKey, DeviceName: pair.DeviceName}))
+	}
+	require.NoError(t, c.SubDeviceConnect(topoLocal.ProductKey, topoLocal.DeviceName, false, time.Second))
+	return c, cloud
+}
+
+func requireTopoStatus(t *testing.T, c *Client, pair infra.MetaPair, want DevStatus) {
+	st, err := c.DeviceStatus(pair.ProductKey, pair.DeviceName)
+	require.NoError(t, err)
+	require.Equal(t, want, st)
+}
+
+// requireTopoDiff 所有策略下相同的比对结果
+func requireTopoDiff(t *testing.T, report *TopoReport) {
+	require.Equal(t, []infra.MetaPair{topoConfirmed}, report.Confirmed)
+	require.Equal(t, []infra.MetaPair{topoCloud}, report.CloudOnly)
+	require.Equal(t, []infra.MetaPair{topoLocal}, report.LocalOnly)
+}
+
+// requireTopoSynced 除仅报告外的策略同步本地状态
+func requireTopoSynced(t *testing.T, c *Client) {
+	requireTopoStatus(t, c, topoConfirmed, DevStatusAttached)
+	requireTopoStatus(t, c, topoLocal, DevStatusRegistered)
+}
+
+func TestReconcileTopology(t *testing.T) {
+	t.Run("not gateway", func(t *testing.T) {
+		c := New(testGateway, &fakeCloud{})
+		_, err := c.ReconcileTopology(TopoPolicyReport, time.Second)
+		require.Equal(t, ErrNotSupportFeature, err)
+	})
+
+	t.Run("report", func(t *testing.T) {
+		c, cloud := topoGateway(t)
+		report, err := c.ReconcileTopology(TopoPolicyReport, time.Second)
+		require.NoError(t, err)
+		requireTopoDiff(t, report)
+		// 仅报告, 不修改本地状态
+		requireTopoStatus(t, c, topoConfirmed, DevStatusUnauthorized)
+		requireTopoStatus(t, c, topoLocal, DevStatusOnline)
+		require.Empty(t, report.Added)
+		require.Empty(t, report.Deleted)
+		require.Empty(t, report.Errors)
+		_, err = c.Search(topoCloud.ProductKey, topoCloud.DeviceName)
+		require.Error(t, err)
+		require.Zero(t, cloud.count("/thing/topo/delete"))
+	})
+
+	t.Run("add local", func(t *testing.T) {
+		c, cloud := topoGateway(t)
+		report, err := c.ReconcileTopology(TopoPolicyAddLocal, time.Second)
+		require.NoError(t, err)
+		requireTopoDiff(t, report)
+		requireTopoSynced(t, c)
+		require.Equal(t, []infra.MetaPair{topoCloud}, report.Added)
+		require.Empty(t, report.Deleted)
+		require.Empty(t, report.Errors)
+		requireTopoStatus(t, c, topoCloud, DevStatusAttached)
+		require.Zero(t, cloud.count("/thing/topo/delete"))
+	})
+
+	t.Run("delete remote", func(t *testing.T) {
+		c, cloud := topoGateway(t)
+		report, err := c.ReconcileTopology(TopoPolicyDeleteRemote, time.Second)
+		require.NoError(t, err)
+		requireTopoDiff(t, report)
+		requireTopoSynced(t, c)
+		require.Empty(t, report.Added)
+		require.Equal(t, []infra.MetaPair{topoCloud}, report.Deleted)
+		require.Empty(t, report.Errors)
+		_, err = c.Search(topoCloud.ProductKey, topoCloud.DeviceName)
+		require.Error(t, err)
+
+		payloads := cloud.published(c.URIGateway(uri.SysPrefix, uri.ThingTopoDelete))
+		require.Len(t, payloads, 1)
+		var req struct {
+			Params []infra.MetaPair `json:"params"`
+		}
+		require.NoError(t, json.Unmarshal(payloads[0], &req))
+		require.Equal(t, []infra.MetaPair{topoCloud}, req.Params)
+	})
+
+	t.Run("delete remote failed", func(t *testing.T) {
+		c, cloud := topoGateway(t)
+		cloud.fail = func(topic string, _ []infra.MetaPair) int {
+			if c.URIGateway(uri.SysPrefix, uri.ThingTopoDelete) == topic {
+				return infra.CodeRequestError
+			}
+			return 0
+		}
+		report, err := c.ReconcileTopology(TopoPolicyDeleteRemote, time.Second)
+		require.NoError(t, err)
+		requireTopoDiff(t, report)
+		requireTopoSynced(t, c)
+		require.Empty(t, report.Deleted)
+		require.Contains(t, report.Errors, FormatKey(topoCloud.ProductKey, topoCloud.DeviceName))
+	})
+}
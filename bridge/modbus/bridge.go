@@ -0,0 +1,361 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package modbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// DefaultInterval 默认轮询间隔
+const DefaultInterval = 10 * time.Second
+
+// 错误定义
+var (
+	ErrUnknownDevice   = errors.New("modbus: unknown device")
+	ErrUnknownProperty = errors.New("modbus: unknown property")
+	ErrNotWritable     = errors.New("modbus: property not writable")
+)
+
+// block 一次请求读取的连续区间
+type block struct {
+	area     Area
+	address  uint16
+	quantity uint16
+	points   []*Point
+}
+
+type device struct {
+	Device
+	points map[string]*Point
+	blocks []*block
+}
+
+func newDevice(dev Device) *device {
+	d := &device{
+		Device: dev,
+		points: make(map[string]*Point, len(dev.Points)),
+	}
+	if d.Interval == 0 {
+		d.Interval = Duration(DefaultInterval)
+	}
+	ps := make([]*Point, 0, len(dev.Points))
+	for i := range d.Points {
+		p := &d.Points[i]
+		d.points[p.Identifier] = p
+		ps = append(ps, p)
+	}
+	d.blocks = mergeBlocks(ps)
+	return d
+}
+
+// mergeBlocks 将同一寄存器区内地址连续或重叠的点合并为一次读取
+func mergeBlocks(ps []*Point) []*block {
+	sort.SliceStable(ps, func(i, j int) bool {
+		if ps[i].Area != ps[j].Area {
+			return ps[i].Area < ps[j].Area
+		}
+		return ps[i].Address < ps[j].Address
+	})
+	var blocks []*block
+	var cur *block
+	for _, p := range ps {
+		max := MaxReadRegisters
+		if p.Area.isBit() {
+			max = MaxReadBits
+		}
+		end := int(p.Address) + int(p.quantity())
+		if cur != nil && cur.area == p.Area && int(p.Address) <= int(cur.address)+int(cur.quantity) &&
+			end-int(cur.address) <= max {
+			if n := end - int(cur.address); n > int(cur.quantity) {
+				cur.quantity = uint16(n)
+			}
+			cur.points = append(cur.points, p)
+			continue
+		}
+		cur = &block{p.Area, p.Address, p.quantity(), []*Point{p}}
+		blocks = append(blocks, cur)
+	}
+	return blocks
+}
+
+// Bridge Modbus南向桥接, 按映射配置轮询子设备并通过 aiot.Client.ThingEventPropertyPost 上报属性,
+// 云端的属性设置经 Callback 转换为寄存器写入并回复
+type Bridge struct {
+	c       *aiot.Client
+	m       *Master
+	devices map[string]*device
+	order   []*device
+
+	mu      sync.Mutex
+	started bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// New 创建Modbus南向桥接, 同一传输层(总线)上的子设备共用一个桥接
+func New(c *aiot.Client, t Transporter, cfg *Config) (*Bridge, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bridge{
+		c:       c,
+		m:       NewMaster(t),
+		devices: make(map[string]*device, len(cfg.Devices)),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for _, dev := range cfg.Devices {
+		d := newDevice(dev)
+		b.devices[aiot.FormatKey(dev.ProductKey, dev.DeviceName)] = d
+		b.order = append(b.order, d)
+	}
+	return b, nil
+}
+
+// Start 启动轮询, 每个子设备按各自的间隔轮询, 子设备未在线时跳过
+func (sf *Bridge) Start() {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.started {
+		return
+	}
+	sf.started = true
+	for _, d := range sf.order {
+		sf.wg.Add(1)
+		go sf.run(d)
+	}
+}
+
+// Close 停止轮询
+func (sf *Bridge) Close() error {
+	sf.cancel()
+	sf.wg.Wait()
+	return nil
+}
+
+func (sf *Bridge) run(d *device) {
+	defer sf.wg.Done()
+	tk := time.NewTicker(time.Duration(d.Interval))
+	defer tk.Stop()
+	for {
+		if sf.c.IsActive(d.ProductKey, d.DeviceName) {
+			if err := sf.poll(d); err != nil {
+				sf.c.Log.Warnf("modbus: poll %s failed, %+v", aiot.FormatKey(d.ProductKey, d.DeviceName), err)
+			}
+		}
+		select {
+		case <-sf.ctx.Done():
+			return
+		case <-tk.C:
+		}
+	}
+}
+
+func (sf *Bridge) device(pk, dn string) (*device, error) {
+	d, ok := sf.devices[aiot.FormatKey(pk, dn)]
+	if !ok {
+		return nil, ErrUnknownDevice
+	}
+	return d, nil
+}
+
+// Read 读取子设备所有映射的属性值, 部分读取失败时返回已读取的属性值及首个错误
+func (sf *Bridge) Read(pk, dn string) (map[string]interface{}, error) {
+	d, err := sf.device(pk, dn)
+	if err != nil {
+		return nil, err
+	}
+	return sf.read(d, d.blocks)
+}
+
+// Poll 立即轮询子设备并上报属性
+func (sf *Bridge) Poll(pk, dn string) error {
+	d, err := sf.device(pk, dn)
+	if err != nil {
+		return err
+	}
+	return sf.poll(d)
+}
+
+func (sf *Bridge) poll(d *device) error {
+	values, err := sf.read(d, d.blocks)
+	if len(values) > 0 {
+		if _, e := sf.c.ThingEventPropertyPost(d.ProductKey, d.DeviceName, values); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (sf *Bridge) read(d *device, blocks []*block) (map[string]interface{}, error) {
+	var firstErr error
+	values := make(map[string]interface{}, len(d.points))
+	for _, blk := range blocks {
+		regs, err := sf.readBlock(d.SlaveID, blk)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, p := range blk.points {
+			off := p.Address - blk.address
+			values[p.Identifier] = p.decode(regs[off : off+p.quantity()])
+		}
+	}
+	return values, firstErr
+}
+
+// readBlock 读取连续区间, 线圈及离散输入转换为0,1
+func (sf *Bridge) readBlock(slaveID byte, blk *block) ([]uint16, error) {
+	var bits []bool
+	var err error
+	switch blk.area {
+	case AreaHolding:
+		return sf.m.ReadHoldingRegisters(slaveID, blk.address, blk.quantity)
+	case AreaInput:
+		return sf.m.ReadInputRegisters(slaveID, blk.address, blk.quantity)
+	case AreaCoil:
+		bits, err = sf.m.ReadCoils(slaveID, blk.address, blk.quantity)
+	default:
+		bits, err = sf.m.ReadDiscreteInputs(slaveID, blk.address, blk.quantity)
+	}
+	if err != nil {
+		return nil, err
+	}
+	regs := make([]uint16, len(bits))
+	for i, b := range bits {
+		if b {
+			regs[i] = 1
+		}
+	}
+	return regs, nil
+}
+
+// Write 将属性值写入子设备的寄存器, 所有属性校验通过后才开始写入, 按标识符顺序写入
+func (sf *Bridge) Write(pk, dn string, params map[string]json.RawMessage) error {
+	d, err := sf.device(pk, dn)
+	if err != nil {
+		return err
+	}
+	ps, values, err := d.prepare(params)
+	if err != nil {
+		return err
+	}
+	_, err = sf.write(d, ps, values)
+	return err
+}
+
+// prepare 校验并编码属性值, 按标识符排序
+func (sf *device) prepare(params map[string]json.RawMessage) ([]*Point, [][]uint16, error) {
+	ids := make([]string, 0, len(params))
+	for id := range params {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	ps := make([]*Point, 0, len(ids))
+	values := make([][]uint16, 0, len(ids))
+	for _, id := range ids {
+		p, ok := sf.points[id]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownProperty, id)
+		}
+		if !p.Writable {
+			return nil, nil, fmt.Errorf("%w: %s", ErrNotWritable, id)
+		}
+		v, err := p.encode(params[id])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", id, err)
+		}
+		ps = append(ps, p)
+		values = append(values, v)
+	}
+	return ps, values, nil
+}
+
+// write 依次写入, 返回已写入成功的点
+func (sf *Bridge) write(d *device, ps []*Point, values [][]uint16) ([]*Point, error) {
+	for i, p := range ps {
+		var err error
+		switch {
+		case p.Area == AreaCoil:
+			err = sf.m.WriteSingleCoil(d.SlaveID, p.Address, values[i][0] != 0)
+		case len(values[i]) == 1:
+			err = sf.m.WriteSingleRegister(d.SlaveID, p.Address, values[i][0])
+		default:
+			err = sf.m.WriteMultipleRegisters(d.SlaveID, p.Address, values[i])
+		}
+		if err != nil {
+			return ps[:i], err
+		}
+	}
+	return ps, nil
+}
+
+// propertySet 处理云端的属性设置并回复, 写入成功后回读并上报已写入的属性
+func (sf *Bridge) propertySet(d *device, payload []byte) error {
+	req := &aiot.RequestRawData{}
+	if err := json.Unmarshal(payload, req); err != nil {
+		return err
+	}
+
+	rsp := aiot.Response{ID: req.ID, Code: infra.CodeSuccess, Data: "{}"}
+	var written []*Point
+	params := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		rsp.Code, rsp.Message = infra.CodeRequestParamsError, err.Error()
+	} else if ps, values, err := d.prepare(params); err != nil {
+		rsp.Code, rsp.Message = infra.CodeRequestParamsError, err.Error()
+	} else if written, err = sf.write(d, ps, values); err != nil {
+		rsp.Code, rsp.Message = infra.CodeRequestError, err.Error()
+	}
+	if err := sf.c.ThingServicePropertySetResponse(d.ProductKey, d.DeviceName, rsp); err != nil {
+		return err
+	}
+	if len(written) == 0 {
+		return nil
+	}
+
+	values, err := sf.read(d, mergeBlocks(written))
+	if len(values) > 0 {
+		sf.c.ThingEventPropertyPost(d.ProductKey, d.DeviceName, values) // nolint: errcheck
+	}
+	return err
+}
+
+// Callback 包装事件接口, 映射的子设备的属性设置由桥接处理并回复,
+// 其它设备的事件交由next处理, 使用 aiot.Client.SetCallback 或 aiot.WithCallback 设置
+func (sf *Bridge) Callback(next aiot.Callback) aiot.Callback {
+	if next == nil {
+		next = aiot.NopCb{}
+	}
+	return &bridgeCallback{next, sf}
+}
+
+type bridgeCallback struct {
+	aiot.Callback
+	b *Bridge
+}
+
+// ThingServicePropertySet 实现 aiot.Callback 接口
+func (sf *bridgeCallback) ThingServicePropertySet(c *aiot.Client, pk, dn string, payload []byte) error {
+	d, err := sf.b.device(pk, dn)
+	if err != nil {
+		return sf.Callback.ThingServicePropertySet(c, pk, dn, payload)
+	}
+	return sf.b.propertySet(d, payload)
+}
@@ -0,0 +1,167 @@
+package modbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// fakeConn 记录网关发布的消息
+type fakeConn struct {
+	mu       sync.Mutex
+	messages []message
+}
+
+func (sf *fakeConn) Publish(topic string, _ byte, payload interface{}) error {
+	b, _ := payload.([]byte)
+	sf.mu.Lock()
+	sf.messages = append(sf.messages, message{topic, b})
+	sf.mu.Unlock()
+	return nil
+}
+
+func (sf *fakeConn) Subscribe(string, aiot.ProcDownStream) error { return nil }
+func (sf *fakeConn) UnSubscribe(...string) error                 { return nil }
+func (sf *fakeConn) Close() error                                { return nil }
+
+// last 最后一条以suffix结尾的主题的消息
+func (sf *fakeConn) last(t *testing.T, suffix string, v interface{}) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for i := len(sf.messages) - 1; i >= 0; i-- {
+		if strings.HasSuffix(sf.messages[i].topic, suffix) {
+			require.NoError(t, json.Unmarshal(sf.messages[i].payload, v))
+			return
+		}
+	}
+	t.Fatalf("no message on %s", suffix)
+}
+
+const testConfig = `{"devices":[{"productKey":"pk","deviceName":"meter","slaveId":1,"interval":"20ms","points":[
+	{"identifier":"voltage","area":"input","address":0,"type":"uint16","scale":0.1},
+	{"identifier":"current","area":"input","address":1,"type":"uint16","scale":0.01},
+	{"identifier":"energy","area":"input","address":2,"type":"uint32"},
+	{"identifier":"alarm","area":"discrete","address":0},
+	{"identifier":"relay","area":"coil","address":0,"writable":true},
+	{"identifier":"limit","area":"holding","address":10,"type":"float32","writable":true},
+	{"identifier":"mode","area":"holding","address":12,"type":"int16"}]}]}`
+
+func newBridge(t *testing.T) (*aiot.Client, *fakeConn, *Simulator, *Bridge) {
+	sim := NewSimulator()
+	sim.SetInputRegisters(1, 0, 2205, 150, 0x0001, 0x0000)
+	sim.SetDiscreteInputs(1, 0, true)
+	sim.SetCoils(1, 0, false)
+	sim.SetHoldingRegisters(1, 10, 0, 0, 3)
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() }) // nolint: errcheck
+	go sim.ServeRTU(server)              // nolint: errcheck
+
+	conn := &fakeConn{}
+	c := aiot.New(infra.MetaTriad{ProductKey: "gpk", DeviceName: "gdn", DeviceSecret: "gds"}, conn, aiot.WithEnableGateway())
+	require.NoError(t, c.AddSubDevice(infra.MetaTriad{ProductKey: "pk", DeviceName: "meter", DeviceSecret: "ds"}))
+	require.NoError(t, c.SetDeviceStatus("pk", "meter", aiot.DevStatusOnline))
+
+	cfg, err := ParseConfig([]byte(testConfig))
+	require.NoError(t, err)
+	b, err := New(c, NewRTU(client, time.Second), cfg)
+	require.NoError(t, err)
+	return c, conn, sim, b
+}
+
+func TestBridgeBlocks(t *testing.T) {
+	cfg, err := ParseConfig([]byte(testConfig))
+	require.NoError(t, err)
+	d := newDevice(cfg.Devices[0])
+	require.Len(t, d.blocks, 4) // coil, discrete, holding, input
+	for _, blk := range d.blocks {
+		if blk.area == AreaInput {
+			require.Equal(t, uint16(4), blk.quantity)
+			require.Len(t, blk.points, 3)
+		}
+		if blk.area == AreaHolding {
+			require.Equal(t, uint16(3), blk.quantity)
+		}
+	}
+}
+
+func TestBridgePoll(t *testing.T) {
+	_, conn, _, b := newBridge(t)
+	values, err := b.Read("pk", "meter")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"voltage": 220.5,
+		"current": 1.5,
+		"energy":  int64(0x10000),
+		"alarm":   int64(1),
+		"relay":   int64(0),
+		"limit":   float64(0),
+		"mode":    int64(3),
+	}, values)
+
+	b.Start()
+	time.Sleep(time.Millisecond * 50)
+	require.NoError(t, b.Close())
+
+	var req struct {
+		Method string                 `json:"method"`
+		Params map[string]interface{} `json:"params"`
+	}
+	conn.last(t, "/sys/pk/meter/thing/event/property/post", &req)
+	require.Equal(t, infra.MethodEventPropertyPost, req.Method)
+	require.Equal(t, 220.5, req.Params["voltage"])
+
+	_, err = b.Read("pk", "none")
+	require.Equal(t, ErrUnknownDevice, err)
+}
+
+func TestBridgePropertySet(t *testing.T) {
+	c, conn, sim, b := newBridge(t)
+	c.SetCallback(b.Callback(nil))
+
+	var rsp aiot.ResponseRawData
+	var post struct {
+		Params map[string]interface{} `json:"params"`
+	}
+	set := func(id uint, params string) {
+		payload := fmt.Sprintf(`{"id":"%d","version":"1.0","method":"thing.service.property.set","params":%s}`, id, params)
+		require.NoError(t, aiot.ProcThingServiceRequest(c, "/sys/pk/meter/thing/service/property/set", []byte(payload)))
+		conn.last(t, "/sys/pk/meter/thing/service/property/set_reply", &rsp)
+		require.Equal(t, id, uint(rsp.ID))
+	}
+
+	set(1, `{"relay":1,"limit":12.5}`)
+	require.Equal(t, infra.CodeSuccess, rsp.Code)
+	require.True(t, sim.Coil(1, 0))
+	require.Equal(t, []uint16{0x4148, 0x0000}, sim.HoldingRegisters(1, 10, 2))
+	conn.last(t, "/sys/pk/meter/thing/event/property/post", &post)
+	require.Equal(t, map[string]interface{}{"relay": float64(1), "limit": 12.5}, post.Params)
+
+	// 只读属性, 所有属性均不写入
+	set(2, `{"relay":0,"mode":1}`)
+	require.Equal(t, infra.CodeRequestParamsError, rsp.Code)
+	require.True(t, sim.Coil(1, 0))
+
+	set(3, `{"unknown":1}`)
+	require.Equal(t, infra.CodeRequestParamsError, rsp.Code)
+
+	// 从站异常
+	sim.SetHoldingRegisters(2, 0, 0)
+	b.devices[aiot.FormatKey("pk", "meter")].SlaveID = 2
+	set(4, `{"relay":0}`)
+	require.Equal(t, infra.CodeRequestError, rsp.Code)
+}
@@ -0,0 +1,290 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package modbus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"time"
+
+	aiot "github.com/things-go/aliyun-iot"
+)
+
+// Area 寄存器区
+type Area string
+
+// 寄存器区
+const (
+	AreaCoil     Area = "coil"     // 线圈, 可读写
+	AreaDiscrete Area = "discrete" // 离散输入, 只读
+	AreaInput    Area = "input"    // 输入寄存器, 只读
+	AreaHolding  Area = "holding"  // 保持寄存器, 可读写
+)
+
+func (sf Area) isBit() bool { return sf == AreaCoil || sf == AreaDiscrete }
+
+// Type 数据类型
+type Type string
+
+// 数据类型, 32位类型占两个连续的寄存器, 默认高字在前
+const (
+	TypeBool    Type = "bool"
+	TypeInt16   Type = "int16"
+	TypeUint16  Type = "uint16"
+	TypeInt32   Type = "int32"
+	TypeUint32  Type = "uint32"
+	TypeFloat32 Type = "float32"
+)
+
+// ErrValueRange 属性值超出数据类型的范围
+var ErrValueRange = errors.New("modbus: value out of range")
+
+// Duration json中以字符串表示的时间间隔, 如 "10s", 数字表示秒
+type Duration time.Duration
+
+// MarshalJSON 实现 json.Marshaler 接口
+func (sf Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(sf).String())
+}
+
+// UnmarshalJSON 实现 json.Unmarshaler 接口
+func (sf *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch vv := v.(type) {
+	case float64:
+		*sf = Duration(vv * float64(time.Second))
+	case string:
+		d, err := time.ParseDuration(vv)
+		if err != nil {
+			return err
+		}
+		*sf = Duration(d)
+	default:
+		return fmt.Errorf("modbus: invalid duration %s", b)
+	}
+	return nil
+}
+
+// Point 寄存器到物模型属性的映射, 属性值 = 原始值 * Scale
+type Point struct {
+	Identifier string  `json:"identifier"`         // 物模型属性标识符
+	Area       Area    `json:"area"`               // 寄存器区
+	Address    uint16  `json:"address"`            // 起始地址
+	Type       Type    `json:"type"`               // 数据类型, 线圈及离散输入固定为bool
+	WordSwap   bool    `json:"wordSwap,omitempty"` // 32位类型低字在前
+	Scale      float64 `json:"scale,omitempty"`    // 缩放系数, 0表示不缩放
+	Writable   bool    `json:"writable,omitempty"` // 允许云端设置, 仅线圈及保持寄存器
+}
+
+// Device 子设备的映射
+type Device struct {
+	ProductKey string   `json:"productKey"`
+	DeviceName string   `json:"deviceName"`
+	SlaveID    byte     `json:"slaveId"`
+	Interval   Duration `json:"interval,omitempty"` // 轮询间隔, 默认 DefaultInterval
+	Points     []Point  `json:"points"`
+}
+
+// Config 映射配置
+type Config struct {
+	Devices []Device `json:"devices"`
+}
+
+// ParseConfig 解析json格式的映射配置
+func ParseConfig(b []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadConfig 加载json格式的映射配置文件
+func LoadConfig(name string) (*Config, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(b)
+}
+
+// Validate 校验映射配置
+func (sf *Config) Validate() error {
+	devices := make(map[string]struct{}, len(sf.Devices))
+	for _, dev := range sf.Devices {
+		if dev.ProductKey == "" || dev.DeviceName == "" {
+			return errors.New("modbus: device productKey and deviceName required")
+		}
+		key := aiot.FormatKey(dev.ProductKey, dev.DeviceName)
+		if _, ok := devices[key]; ok {
+			return fmt.Errorf("modbus: duplicate device %s", key)
+		}
+		devices[key] = struct{}{}
+		if dev.Interval < 0 {
+			return fmt.Errorf("modbus: device %s invalid interval", key)
+		}
+		ids := make(map[string]struct{}, len(dev.Points))
+		for _, p := range dev.Points {
+			if p.Identifier == "" {
+				return fmt.Errorf("modbus: device %s point identifier required", key)
+			}
+			if _, ok := ids[p.Identifier]; ok {
+				return fmt.Errorf("modbus: device %s duplicate identifier %s", key, p.Identifier)
+			}
+			ids[p.Identifier] = struct{}{}
+			if err := p.validate(); err != nil {
+				return fmt.Errorf("modbus: device %s point %s, %v", key, p.Identifier, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (sf *Point) validate() error {
+	switch sf.Area {
+	case AreaCoil, AreaDiscrete:
+		if sf.Type != "" && sf.Type != TypeBool {
+			return errors.New("bit area must be bool type")
+		}
+	case AreaInput, AreaHolding:
+		switch sf.Type {
+		case TypeBool, TypeInt16, TypeUint16, TypeInt32, TypeUint32, TypeFloat32:
+		default:
+			return fmt.Errorf("invalid type %q", sf.Type)
+		}
+	default:
+		return fmt.Errorf("invalid area %q", sf.Area)
+	}
+	if sf.Writable && (sf.Area == AreaDiscrete || sf.Area == AreaInput) {
+		return errors.New("read only area")
+	}
+	if int(sf.Address)+int(sf.quantity()) > 0x10000 {
+		return errors.New("address overflow")
+	}
+	return nil
+}
+
+// quantity 占用的线圈或寄存器数量
+func (sf *Point) quantity() uint16 {
+	switch sf.Type {
+	case TypeInt32, TypeUint32, TypeFloat32:
+		if !sf.Area.isBit() {
+			return 2
+		}
+	}
+	return 1
+}
+
+func (sf *Point) isBool() bool { return sf.Area.isBit() || sf.Type == TypeBool }
+
+func (sf *Point) scaled() bool { return sf.Scale != 0 && sf.Scale != 1 }
+
+// decode 将寄存器的原始值转换为属性值, 物模型的bool类型以0,1表示
+func (sf *Point) decode(regs []uint16) interface{} {
+	if sf.isBool() {
+		if regs[0] != 0 {
+			return int64(1)
+		}
+		return int64(0)
+	}
+	var raw uint32
+	if sf.quantity() == 2 {
+		hi, lo := regs[0], regs[1]
+		if sf.WordSwap {
+			hi, lo = lo, hi
+		}
+		raw = uint32(hi)<<16 | uint32(lo)
+	} else {
+		raw = uint32(regs[0])
+	}
+
+	var v int64
+	switch sf.Type {
+	case TypeInt16:
+		v = int64(int16(raw))
+	case TypeUint16, TypeUint32:
+		v = int64(raw)
+	case TypeInt32:
+		v = int64(int32(raw))
+	case TypeFloat32:
+		f := float64(math.Float32frombits(raw))
+		if sf.scaled() {
+			f *= sf.Scale
+		}
+		return f
+	}
+	if sf.scaled() {
+		return float64(v) * sf.Scale
+	}
+	return v
+}
+
+// encode 将属性值转换为寄存器的原始值
+func (sf *Point) encode(value json.RawMessage) ([]uint16, error) {
+	if sf.isBool() {
+		var b bool
+		if err := json.Unmarshal(value, &b); err != nil {
+			var n int
+			if err = json.Unmarshal(value, &n); err != nil || (n != 0 && n != 1) {
+				return nil, ErrValueRange
+			}
+			b = n == 1
+		}
+		if b {
+			return []uint16{1}, nil
+		}
+		return []uint16{0}, nil
+	}
+
+	var f float64
+	if err := json.Unmarshal(value, &f); err != nil {
+		return nil, err
+	}
+	if sf.scaled() {
+		f /= sf.Scale
+	}
+	var raw uint32
+	switch sf.Type {
+	case TypeFloat32:
+		raw = math.Float32bits(float32(f))
+	default:
+		f = math.Round(f)
+		min, max := sf.rangeOf()
+		if f < min || f > max {
+			return nil, ErrValueRange
+		}
+		raw = uint32(int64(f))
+	}
+	if sf.quantity() == 1 {
+		return []uint16{uint16(raw)}, nil
+	}
+	hi, lo := uint16(raw>>16), uint16(raw)
+	if sf.WordSwap {
+		hi, lo = lo, hi
+	}
+	return []uint16{hi, lo}, nil
+}
+
+func (sf *Point) rangeOf() (min, max float64) {
+	switch sf.Type {
+	case TypeInt16:
+		return math.MinInt16, math.MaxInt16
+	case TypeUint16:
+		return 0, math.MaxUint16
+	case TypeInt32:
+		return math.MinInt32, math.MaxInt32
+	default:
+		return 0, math.MaxUint32
+	}
+}
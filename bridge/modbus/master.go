@@ -0,0 +1,149 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package modbus
+
+import (
+	"encoding/binary"
+)
+
+// Master Modbus主站, 通过传输层读写从站的线圈及寄存器
+type Master struct {
+	t Transporter
+}
+
+// NewMaster 创建主站
+func NewMaster(t Transporter) *Master {
+	return &Master{t}
+}
+
+// ReadCoils 读线圈
+func (sf *Master) ReadCoils(slaveID byte, address, quantity uint16) ([]bool, error) {
+	return sf.readBits(slaveID, FuncReadCoils, address, quantity)
+}
+
+// ReadDiscreteInputs 读离散输入
+func (sf *Master) ReadDiscreteInputs(slaveID byte, address, quantity uint16) ([]bool, error) {
+	return sf.readBits(slaveID, FuncReadDiscreteInputs, address, quantity)
+}
+
+// ReadHoldingRegisters 读保持寄存器
+func (sf *Master) ReadHoldingRegisters(slaveID byte, address, quantity uint16) ([]uint16, error) {
+	return sf.readRegisters(slaveID, FuncReadHoldingRegisters, address, quantity)
+}
+
+// ReadInputRegisters 读输入寄存器
+func (sf *Master) ReadInputRegisters(slaveID byte, address, quantity uint16) ([]uint16, error) {
+	return sf.readRegisters(slaveID, FuncReadInputRegisters, address, quantity)
+}
+
+// WriteSingleCoil 写单个线圈
+func (sf *Master) WriteSingleCoil(slaveID byte, address uint16, value bool) error {
+	v := uint16(0x0000)
+	if value {
+		v = 0xff00
+	}
+	pdu := make([]byte, 5)
+	pdu[0] = FuncWriteSingleCoil
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], v)
+	return sf.write(slaveID, pdu)
+}
+
+// WriteSingleRegister 写单个保持寄存器
+func (sf *Master) WriteSingleRegister(slaveID byte, address, value uint16) error {
+	pdu := make([]byte, 5)
+	pdu[0] = FuncWriteSingleRegister
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], value)
+	return sf.write(slaveID, pdu)
+}
+
+// WriteMultipleRegisters 写多个保持寄存器
+func (sf *Master) WriteMultipleRegisters(slaveID byte, address uint16, values []uint16) error {
+	if len(values) == 0 || len(values) > MaxWriteRegisters {
+		return ErrInvalidQuantity
+	}
+	pdu := make([]byte, 6, 6+len(values)*2)
+	pdu[0] = FuncWriteMultipleRegisters
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], uint16(len(values)))
+	pdu[5] = byte(len(values) * 2)
+	for _, v := range values {
+		pdu = append(pdu, byte(v>>8), byte(v))
+	}
+	return sf.write(slaveID, pdu)
+}
+
+func (sf *Master) readBits(slaveID, fn byte, address, quantity uint16) ([]bool, error) {
+	if quantity == 0 || quantity > MaxReadBits {
+		return nil, ErrInvalidQuantity
+	}
+	data, err := sf.read(slaveID, fn, address, quantity, int(quantity+7)/8)
+	if err != nil {
+		return nil, err
+	}
+	bits := make([]bool, quantity)
+	for i := range bits {
+		bits[i] = data[i/8]&(1<<uint(i%8)) != 0
+	}
+	return bits, nil
+}
+
+func (sf *Master) readRegisters(slaveID, fn byte, address, quantity uint16) ([]uint16, error) {
+	if quantity == 0 || quantity > MaxReadRegisters {
+		return nil, ErrInvalidQuantity
+	}
+	data, err := sf.read(slaveID, fn, address, quantity, int(quantity)*2)
+	if err != nil {
+		return nil, err
+	}
+	regs := make([]uint16, quantity)
+	for i := range regs {
+		regs[i] = binary.BigEndian.Uint16(data[i*2:])
+	}
+	return regs, nil
+}
+
+// read 发送读请求, 返回应答的数据域, size为期望的数据域长度
+func (sf *Master) read(slaveID, fn byte, address, quantity uint16, size int) ([]byte, error) {
+	pdu := make([]byte, 5)
+	pdu[0] = fn
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], quantity)
+	rsp, err := sf.send(slaveID, pdu)
+	if err != nil {
+		return nil, err
+	}
+	if len(rsp) != size+2 || int(rsp[1]) != size {
+		return nil, ErrInvalidResponse
+	}
+	return rsp[2:], nil
+}
+
+// write 发送写请求, 应答为请求的前5个字节
+func (sf *Master) write(slaveID byte, pdu []byte) error {
+	rsp, err := sf.send(slaveID, pdu)
+	if err != nil {
+		return err
+	}
+	if len(rsp) != 5 || string(rsp) != string(pdu[:5]) {
+		return ErrInvalidResponse
+	}
+	return nil
+}
+
+func (sf *Master) send(slaveID byte, pdu []byte) ([]byte, error) {
+	rsp, err := sf.t.Send(slaveID, pdu)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(rsp) == 2 && rsp[0] == pdu[0]|0x80:
+		return nil, &ExceptionError{pdu[0], rsp[1]}
+	case len(rsp) < 2 || rsp[0] != pdu[0]:
+		return nil, ErrInvalidResponse
+	}
+	return rsp, nil
+}
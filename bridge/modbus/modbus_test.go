@@ -0,0 +1,129 @@
+package modbus
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newSimulator() *Simulator {
+	sim := NewSimulator()
+	sim.SetCoils(1, 0, true, false, true)
+	sim.SetDiscreteInputs(1, 10, false, true)
+	sim.SetInputRegisters(1, 0, 0xfff6, 0x0001, 0x0002)
+	sim.SetHoldingRegisters(1, 100, 0, 0, 0, 0)
+	return sim
+}
+
+func testMaster(t *testing.T, m *Master, sim *Simulator) {
+	bits, err := m.ReadCoils(1, 0, 3)
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false, true}, bits)
+	bits, err = m.ReadDiscreteInputs(1, 10, 2)
+	require.NoError(t, err)
+	require.Equal(t, []bool{false, true}, bits)
+	regs, err := m.ReadInputRegisters(1, 0, 3)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0xfff6, 0x0001, 0x0002}, regs)
+
+	require.NoError(t, m.WriteSingleCoil(1, 1, true))
+	require.True(t, sim.Coil(1, 1))
+	require.NoError(t, m.WriteSingleRegister(1, 100, 0x1234))
+	require.NoError(t, m.WriteMultipleRegisters(1, 101, []uint16{0xabcd, 0x0102}))
+	regs, err = m.ReadHoldingRegisters(1, 100, 3)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0x1234, 0xabcd, 0x0102}, regs)
+	require.Equal(t, regs, sim.HoldingRegisters(1, 100, 3))
+
+	_, err = m.ReadHoldingRegisters(1, 200, 1)
+	require.Equal(t, &ExceptionError{FuncReadHoldingRegisters, ExceptionIllegalDataAddress}, err)
+	_, err = m.ReadHoldingRegisters(1, 0, 0)
+	require.Equal(t, ErrInvalidQuantity, err)
+}
+
+func TestMasterTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	sim := newSimulator()
+	go sim.ServeTCP(l) // nolint: errcheck
+
+	tcp := NewTCP(l.Addr().String(), time.Second)
+	defer tcp.Close()
+	testMaster(t, NewMaster(tcp), sim)
+
+	// 从站不存在时超时, 之后自动重连
+	tcp.timeout = time.Millisecond * 50
+	_, err = NewMaster(tcp).ReadCoils(9, 0, 1)
+	require.Error(t, err)
+	_, err = NewMaster(tcp).ReadCoils(1, 0, 1)
+	require.NoError(t, err)
+}
+
+func TestMasterRTU(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	sim := newSimulator()
+	go sim.ServeRTU(server) // nolint: errcheck
+
+	testMaster(t, NewMaster(NewRTU(client, time.Second)), sim)
+}
+
+func TestRTUFrame(t *testing.T) {
+	// 读保持寄存器 slave 1, address 0, quantity 2
+	frame := rtuFrame(1, []byte{0x03, 0x00, 0x00, 0x00, 0x02})
+	require.Equal(t, []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x02, 0xc4, 0x0b}, frame)
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`{"devices":[{"productKey":"pk","deviceName":"dn","slaveId":1,"interval":"5s",
+		"points":[{"identifier":"temp","area":"input","address":0,"type":"int16","scale":0.1},
+		{"identifier":"switch","area":"coil","address":0,"writable":true}]}]}`))
+	require.NoError(t, err)
+	require.Equal(t, Duration(time.Second*5), cfg.Devices[0].Interval)
+
+	for _, s := range []string{
+		`{"devices":[{"productKey":"pk","points":[]}]}`,
+		`{"devices":[{"productKey":"pk","deviceName":"dn"},{"productKey":"pk","deviceName":"dn"}]}`,
+		`{"devices":[{"productKey":"pk","deviceName":"dn","points":[{"identifier":"a","area":"input","type":"int16","writable":true}]}]}`,
+		`{"devices":[{"productKey":"pk","deviceName":"dn","points":[{"identifier":"a","area":"coil","type":"int16"}]}]}`,
+		`{"devices":[{"productKey":"pk","deviceName":"dn","points":[{"identifier":"a","area":"holding","type":"int64"}]}]}`,
+		`{"devices":[{"productKey":"pk","deviceName":"dn","points":[{"identifier":"a","area":"holding","type":"int32","address":65535}]}]}`,
+	} {
+		_, err = ParseConfig([]byte(s))
+		require.Error(t, err, s)
+	}
+}
+
+func TestPointCodec(t *testing.T) {
+	tests := []struct {
+		point Point
+		regs  []uint16
+		value interface{}
+		raw   string
+	}{
+		{Point{Area: AreaHolding, Type: TypeInt16}, []uint16{0xfff6}, int64(-10), "-10"},
+		{Point{Area: AreaHolding, Type: TypeInt16, Scale: 0.1}, []uint16{0xfff6}, float64(-1), "-1"},
+		{Point{Area: AreaHolding, Type: TypeUint32}, []uint16{0x0001, 0x0002}, int64(0x10002), "65538"},
+		{Point{Area: AreaHolding, Type: TypeInt32, WordSwap: true}, []uint16{0xfffe, 0xffff}, int64(-2), "-2"},
+		{Point{Area: AreaHolding, Type: TypeFloat32}, []uint16{0x3fc0, 0x0000}, float64(1.5), "1.5"},
+		{Point{Area: AreaHolding, Type: TypeBool}, []uint16{1}, int64(1), "true"},
+		{Point{Area: AreaCoil}, []uint16{0}, int64(0), "0"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.value, tt.point.decode(tt.regs))
+		regs, err := tt.point.encode(json.RawMessage(tt.raw))
+		require.NoError(t, err)
+		require.Equal(t, tt.regs, regs)
+	}
+
+	_, err := (&Point{Area: AreaHolding, Type: TypeUint16}).encode(json.RawMessage("-1"))
+	require.Equal(t, ErrValueRange, err)
+	_, err = (&Point{Area: AreaHolding, Type: TypeInt16, Scale: 0.1}).encode(json.RawMessage("3276.8"))
+	require.Equal(t, ErrValueRange, err)
+	_, err = (&Point{Area: AreaCoil}).encode(json.RawMessage("2"))
+	require.Equal(t, ErrValueRange, err)
+}
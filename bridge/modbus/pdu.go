@@ -0,0 +1,134 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package modbus Modbus TCP/RTU 南向桥接,
+// 按映射配置周期轮询子设备的寄存器并上报为物模型属性, 同时将云端的属性设置转换为寄存器写入
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 功能码
+const (
+	FuncReadCoils              byte = 0x01
+	FuncReadDiscreteInputs     byte = 0x02
+	FuncReadHoldingRegisters   byte = 0x03
+	FuncReadInputRegisters     byte = 0x04
+	FuncWriteSingleCoil        byte = 0x05
+	FuncWriteSingleRegister    byte = 0x06
+	FuncWriteMultipleRegisters byte = 0x10
+)
+
+// 异常码
+const (
+	ExceptionIllegalFunction    byte = 0x01
+	ExceptionIllegalDataAddress byte = 0x02
+	ExceptionIllegalDataValue   byte = 0x03
+	ExceptionSlaveDeviceFailure byte = 0x04
+)
+
+// 单次请求的数量限制
+const (
+	MaxReadBits       = 2000
+	MaxReadRegisters  = 125
+	MaxWriteRegisters = 123
+)
+
+// 错误定义
+var (
+	ErrInvalidQuantity = errors.New("modbus: invalid quantity")
+	ErrInvalidResponse = errors.New("modbus: invalid response")
+	ErrCRCMismatch     = errors.New("modbus: crc mismatch")
+)
+
+// ExceptionError 从站返回的异常应答
+type ExceptionError struct {
+	Function byte
+	Code     byte
+}
+
+// Error 实现error接口
+func (sf *ExceptionError) Error() string {
+	return fmt.Sprintf("modbus: function 0x%02x exception %d", sf.Function, sf.Code)
+}
+
+// crc16 modbus crc, 多项式0xA001, 初值0xFFFF
+func crc16(b []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, v := range b {
+		crc ^= uint16(v)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// rtuFrame 组装RTU帧: | slaveID | pdu | crc(little endian) |
+func rtuFrame(slaveID byte, pdu []byte) []byte {
+	frame := make([]byte, 0, len(pdu)+3)
+	frame = append(frame, slaveID)
+	frame = append(frame, pdu...)
+	crc := crc16(frame)
+	return append(frame, byte(crc), byte(crc>>8))
+}
+
+// readRTUFrame 从流中读取一个RTU帧, 返回从站地址及pdu.
+// RTU帧没有长度域, request为true时按请求格式, 否则按应答格式由功能码推算帧长
+func readRTUFrame(r io.Reader, request bool) (byte, []byte, error) {
+	head := make([]byte, 2, 260)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+	fn := head[1]
+	var n int // 功能码后剩余的长度(不含crc)
+	switch {
+	case fn&0x80 != 0 && !request:
+		n = 1
+	case fn == FuncWriteSingleCoil, fn == FuncWriteSingleRegister:
+		n = 4
+	case fn <= FuncReadInputRegisters && fn != 0:
+		if request {
+			n = 4
+		} else {
+			cnt := make([]byte, 1)
+			if _, err := io.ReadFull(r, cnt); err != nil {
+				return 0, nil, err
+			}
+			head = append(head, cnt[0])
+			n = int(cnt[0])
+		}
+	case fn == FuncWriteMultipleRegisters:
+		if request {
+			hdr := make([]byte, 5)
+			if _, err := io.ReadFull(r, hdr); err != nil {
+				return 0, nil, err
+			}
+			head = append(head, hdr...)
+			n = int(hdr[4])
+		} else {
+			n = 4
+		}
+	default:
+		return 0, nil, ErrInvalidResponse
+	}
+
+	rest := make([]byte, n+2)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return 0, nil, err
+	}
+	frame := append(head, rest...)
+	if crc16(frame[:len(frame)-2]) != binary.LittleEndian.Uint16(frame[len(frame)-2:]) {
+		return 0, nil, ErrCRCMismatch
+	}
+	return frame[0], frame[1 : len(frame)-2], nil
+}
@@ -0,0 +1,245 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// Simulator 进程内的Modbus从站模拟, 用于测试及现场联调.
+// 仅已设置的线圈及寄存器可以访问, 其它地址返回 ExceptionIllegalDataAddress,
+// 未设置的从站不应答
+type Simulator struct {
+	mu     sync.Mutex
+	slaves map[byte]*memory
+}
+
+type memory struct {
+	coils    map[uint16]bool
+	discrete map[uint16]bool
+	input    map[uint16]uint16
+	holding  map[uint16]uint16
+}
+
+// NewSimulator 创建从站模拟
+func NewSimulator() *Simulator {
+	return &Simulator{slaves: make(map[byte]*memory)}
+}
+
+func (sf *Simulator) slave(slaveID byte) *memory {
+	m, ok := sf.slaves[slaveID]
+	if !ok {
+		m = &memory{
+			coils:    make(map[uint16]bool),
+			discrete: make(map[uint16]bool),
+			input:    make(map[uint16]uint16),
+			holding:  make(map[uint16]uint16),
+		}
+		sf.slaves[slaveID] = m
+	}
+	return m
+}
+
+// SetCoils 设置从address开始的线圈
+func (sf *Simulator) SetCoils(slaveID byte, address uint16, values ...bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	m := sf.slave(slaveID)
+	for i, v := range values {
+		m.coils[address+uint16(i)] = v
+	}
+}
+
+// SetDiscreteInputs 设置从address开始的离散输入
+func (sf *Simulator) SetDiscreteInputs(slaveID byte, address uint16, values ...bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	m := sf.slave(slaveID)
+	for i, v := range values {
+		m.discrete[address+uint16(i)] = v
+	}
+}
+
+// SetInputRegisters 设置从address开始的输入寄存器
+func (sf *Simulator) SetInputRegisters(slaveID byte, address uint16, values ...uint16) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	m := sf.slave(slaveID)
+	for i, v := range values {
+		m.input[address+uint16(i)] = v
+	}
+}
+
+// SetHoldingRegisters 设置从address开始的保持寄存器
+func (sf *Simulator) SetHoldingRegisters(slaveID byte, address uint16, values ...uint16) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	m := sf.slave(slaveID)
+	for i, v := range values {
+		m.holding[address+uint16(i)] = v
+	}
+}
+
+// Coil 获取线圈的值
+func (sf *Simulator) Coil(slaveID byte, address uint16) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.slave(slaveID).coils[address]
+}
+
+// HoldingRegisters 获取从address开始的quantity个保持寄存器的值
+func (sf *Simulator) HoldingRegisters(slaveID byte, address, quantity uint16) []uint16 {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	m := sf.slave(slaveID)
+	values := make([]uint16, quantity)
+	for i := range values {
+		values[i] = m.holding[address+uint16(i)]
+	}
+	return values
+}
+
+// Handle 处理请求pdu, 返回应答pdu, 从站不存在时返回nil
+func (sf *Simulator) Handle(slaveID byte, pdu []byte) []byte {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	m, ok := sf.slaves[slaveID]
+	if !ok || len(pdu) < 5 {
+		return nil
+	}
+	fn := pdu[0]
+	address := binary.BigEndian.Uint16(pdu[1:])
+	value := binary.BigEndian.Uint16(pdu[3:])
+	exception := func(code byte) []byte { return []byte{fn | 0x80, code} }
+
+	switch fn {
+	case FuncReadCoils, FuncReadDiscreteInputs:
+		bits := m.coils
+		if fn == FuncReadDiscreteInputs {
+			bits = m.discrete
+		}
+		if value == 0 || value > MaxReadBits {
+			return exception(ExceptionIllegalDataValue)
+		}
+		data := make([]byte, (value+7)/8)
+		for i := uint16(0); i < value; i++ {
+			b, ok := bits[address+i]
+			if !ok {
+				return exception(ExceptionIllegalDataAddress)
+			}
+			if b {
+				data[i/8] |= 1 << (i % 8)
+			}
+		}
+		return append([]byte{fn, byte(len(data))}, data...)
+	case FuncReadHoldingRegisters, FuncReadInputRegisters:
+		regs := m.holding
+		if fn == FuncReadInputRegisters {
+			regs = m.input
+		}
+		if value == 0 || value > MaxReadRegisters {
+			return exception(ExceptionIllegalDataValue)
+		}
+		rsp := []byte{fn, byte(value * 2)}
+		for i := uint16(0); i < value; i++ {
+			v, ok := regs[address+i]
+			if !ok {
+				return exception(ExceptionIllegalDataAddress)
+			}
+			rsp = append(rsp, byte(v>>8), byte(v))
+		}
+		return rsp
+	case FuncWriteSingleCoil:
+		if _, ok := m.coils[address]; !ok {
+			return exception(ExceptionIllegalDataAddress)
+		}
+		if value != 0 && value != 0xff00 {
+			return exception(ExceptionIllegalDataValue)
+		}
+		m.coils[address] = value == 0xff00
+		return pdu[:5]
+	case FuncWriteSingleRegister:
+		if _, ok := m.holding[address]; !ok {
+			return exception(ExceptionIllegalDataAddress)
+		}
+		m.holding[address] = value
+		return pdu[:5]
+	case FuncWriteMultipleRegisters:
+		if value == 0 || value > MaxWriteRegisters || len(pdu) != 6+int(value)*2 || int(pdu[5]) != int(value)*2 {
+			return exception(ExceptionIllegalDataValue)
+		}
+		for i := uint16(0); i < value; i++ {
+			if _, ok := m.holding[address+i]; !ok {
+				return exception(ExceptionIllegalDataAddress)
+			}
+		}
+		for i := uint16(0); i < value; i++ {
+			m.holding[address+i] = binary.BigEndian.Uint16(pdu[6+i*2:])
+		}
+		return pdu[:5]
+	}
+	return exception(ExceptionIllegalFunction)
+}
+
+// ServeTCP 以Modbus TCP接受连接并处理请求, 直到listener关闭
+func (sf *Simulator) ServeTCP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go sf.serveTCPConn(conn)
+	}
+}
+
+func (sf *Simulator) serveTCPConn(conn net.Conn) {
+	defer conn.Close()
+	head := make([]byte, 7)
+	for {
+		if _, err := io.ReadFull(conn, head); err != nil {
+			return
+		}
+		length := int(binary.BigEndian.Uint16(head[4:]))
+		if length < 2 || length > 254 {
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		rsp := sf.Handle(head[6], pdu)
+		if rsp == nil {
+			continue
+		}
+		adu := make([]byte, 7, 7+len(rsp))
+		copy(adu, head[:4])
+		binary.BigEndian.PutUint16(adu[4:], uint16(len(rsp)+1))
+		adu[6] = head[6]
+		if _, err := conn.Write(append(adu, rsp...)); err != nil {
+			return
+		}
+	}
+}
+
+// ServeRTU 以Modbus RTU处理rw上的请求, 直到读写出错
+func (sf *Simulator) ServeRTU(rw io.ReadWriter) error {
+	for {
+		slaveID, pdu, err := readRTUFrame(rw, true)
+		if err != nil {
+			if err == ErrCRCMismatch || err == ErrInvalidResponse {
+				continue
+			}
+			return err
+		}
+		if rsp := sf.Handle(slaveID, pdu); rsp != nil {
+			if _, err = rw.Write(rtuFrame(slaveID, rsp)); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultTransportTimeout 默认的单次请求超时时间
+const DefaultTransportTimeout = time.Second
+
+// Transporter 传输层, 将请求pdu发往从站并返回应答pdu, 实现需保证并发安全
+type Transporter interface {
+	Send(slaveID byte, pdu []byte) ([]byte, error)
+}
+
+// TCP Modbus TCP 传输层, 首次请求时连接, 出错后断开并在下次请求时重连
+type TCP struct {
+	address string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	tid  uint16
+}
+
+var _ Transporter = (*TCP)(nil)
+
+// NewTCP 创建Modbus TCP传输层, timeout为连接及单次请求的超时时间, 默认 DefaultTransportTimeout
+func NewTCP(address string, timeout time.Duration) *TCP {
+	if timeout <= 0 {
+		timeout = DefaultTransportTimeout
+	}
+	return &TCP{address: address, timeout: timeout}
+}
+
+// Send 实现 Transporter 接口
+// 帧格式: | transaction id(2) | protocol id(2) | length(2) | unit id(1) | pdu |
+func (sf *TCP) Send(slaveID byte, pdu []byte) ([]byte, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	if sf.conn == nil {
+		conn, err := net.DialTimeout("tcp", sf.address, sf.timeout)
+		if err != nil {
+			return nil, err
+		}
+		sf.conn = conn
+	}
+	rsp, err := sf.send(slaveID, pdu)
+	if err != nil {
+		sf.conn.Close() // nolint: errcheck
+		sf.conn = nil
+	}
+	return rsp, err
+}
+
+func (sf *TCP) send(slaveID byte, pdu []byte) ([]byte, error) {
+	if err := sf.conn.SetDeadline(time.Now().Add(sf.timeout)); err != nil {
+		return nil, err
+	}
+	sf.tid++
+	adu := make([]byte, 7, 7+len(pdu))
+	binary.BigEndian.PutUint16(adu, sf.tid)
+	binary.BigEndian.PutUint16(adu[4:], uint16(len(pdu)+1))
+	adu[6] = slaveID
+	if _, err := sf.conn.Write(append(adu, pdu...)); err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, 7)
+	if _, err := io.ReadFull(sf.conn, head); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(head[4:]))
+	if binary.BigEndian.Uint16(head) != sf.tid || binary.BigEndian.Uint16(head[2:]) != 0 ||
+		head[6] != slaveID || length < 2 || length > 254 {
+		return nil, ErrInvalidResponse
+	}
+	rsp := make([]byte, length-1)
+	if _, err := io.ReadFull(sf.conn, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// Close 关闭连接
+func (sf *TCP) Close() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.conn == nil {
+		return nil
+	}
+	err := sf.conn.Close()
+	sf.conn = nil
+	return err
+}
+
+// RTU Modbus RTU 传输层, rw通常为已打开的串口
+type RTU struct {
+	rw      io.ReadWriter
+	timeout time.Duration
+
+	mu sync.Mutex
+}
+
+var _ Transporter = (*RTU)(nil)
+
+// NewRTU 创建Modbus RTU传输层, rw实现 SetDeadline(time.Time) error 时启用单次请求的超时,
+// 默认 DefaultTransportTimeout
+func NewRTU(rw io.ReadWriter, timeout time.Duration) *RTU {
+	if timeout <= 0 {
+		timeout = DefaultTransportTimeout
+	}
+	return &RTU{rw: rw, timeout: timeout}
+}
+
+// Send 实现 Transporter 接口
+// 帧格式: | slave id(1) | pdu | crc(2) |
+func (sf *RTU) Send(slaveID byte, pdu []byte) ([]byte, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	if d, ok := sf.rw.(interface{ SetDeadline(time.Time) error }); ok {
+		if err := d.SetDeadline(time.Now().Add(sf.timeout)); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := sf.rw.Write(rtuFrame(slaveID, pdu)); err != nil {
+		return nil, err
+	}
+	id, rsp, err := readRTUFrame(sf.rw, false)
+	if err != nil {
+		return nil, err
+	}
+	if id != slaveID {
+		return nil, ErrInvalidResponse
+	}
+	return rsp, nil
+}
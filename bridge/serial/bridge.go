@@ -0,0 +1,322 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// 默认值
+const (
+	DefaultGap      = 50 * time.Millisecond // 默认帧间隔
+	DefaultMaxFrame = 1024                  // 默认最大帧长
+	DefaultReopen   = time.Second           // 串口出错后重新打开的间隔
+)
+
+// 错误定义
+var (
+	ErrUnknownDevice = errors.New("serial: unknown device")
+	ErrPortClosed    = errors.New("serial: port not opened")
+)
+
+// AddressFunc 从帧中提取子设备地址
+type AddressFunc func(frame []byte) (addr int, ok bool)
+
+// ByteAddress 子设备地址为帧中offset处的一个字节, 如Modbus RTU的从站地址
+func ByteAddress(offset int) AddressFunc {
+	return func(frame []byte) (int, bool) {
+		if offset < 0 || offset >= len(frame) {
+			return 0, false
+		}
+		return int(frame[offset]), true
+	}
+}
+
+// Device 串口上的子设备
+type Device struct {
+	infra.MetaPair
+	Address int // 子设备地址, Port.Address 为nil时忽略
+}
+
+// Port 串口及其上子设备的映射
+type Port struct {
+	Name     string        // 串口设备路径, 如 /dev/ttyS1
+	Config   Config        // 串口配置
+	Framer   Framer        // 分帧器, 默认 Timeout
+	Gap      time.Duration // 帧间隔, 默认 DefaultGap
+	MaxFrame int           // 最大帧长, 超过时丢弃累积的数据, 默认 DefaultMaxFrame
+	// Address 从上行帧中提取子设备地址, nil表示整个串口对应唯一的子设备
+	Address AddressFunc
+	Devices []Device
+}
+
+type port struct {
+	Port
+	addrs map[int]infra.MetaPair
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (sf *port) file() *os.File {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.f
+}
+
+func (sf *port) setFile(f *os.File) {
+	sf.mu.Lock()
+	sf.f = f
+	sf.mu.Unlock()
+}
+
+// closeFile 关闭串口以中断阻塞的读
+func (sf *port) closeFile() {
+	sf.mu.Lock()
+	if sf.f != nil {
+		sf.f.Close() // nolint: errcheck
+		sf.f = nil
+	}
+	sf.mu.Unlock()
+}
+
+// device 上行帧所属的子设备
+func (sf *port) device(frame []byte) (infra.MetaPair, bool) {
+	if sf.Address == nil {
+		return sf.Devices[0].MetaPair, true
+	}
+	addr, ok := sf.Address(frame)
+	if !ok {
+		return infra.MetaPair{}, false
+	}
+	pair, ok := sf.addrs[addr]
+	return pair, ok
+}
+
+// Bridge 串口透传网关
+type Bridge struct {
+	c       *aiot.Client
+	ports   []*port
+	devices map[string]*port
+
+	mu      sync.Mutex
+	started bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// New 创建串口透传网关, Client需使能透传(aiot.WithEnableModelRaw)
+func New(c *aiot.Client, ports ...Port) (*Bridge, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bridge{
+		c:       c,
+		devices: make(map[string]*port),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for _, pt := range ports {
+		if pt.Name == "" || len(pt.Devices) == 0 || (pt.Address == nil && len(pt.Devices) != 1) {
+			return nil, fmt.Errorf("%w: port %q", ErrInvalidConfig, pt.Name)
+		}
+		if pt.Framer == nil {
+			pt.Framer = Timeout()
+		}
+		if pt.Gap <= 0 {
+			pt.Gap = DefaultGap
+		}
+		if pt.MaxFrame <= 0 {
+			pt.MaxFrame = DefaultMaxFrame
+		}
+		p := &port{Port: pt, addrs: make(map[int]infra.MetaPair)}
+		for _, dev := range pt.Devices {
+			key := aiot.FormatKey(dev.ProductKey, dev.DeviceName)
+			if _, ok := b.devices[key]; ok {
+				return nil, fmt.Errorf("%w: duplicate device %s", ErrInvalidConfig, key)
+			}
+			if _, ok := p.addrs[dev.Address]; ok && pt.Address != nil {
+				return nil, fmt.Errorf("%w: duplicate address %d", ErrInvalidConfig, dev.Address)
+			}
+			b.devices[key] = p
+			p.addrs[dev.Address] = dev.MetaPair
+		}
+		b.ports = append(b.ports, p)
+	}
+	return b, nil
+}
+
+// Start 打开所有串口并开始接收, 任一串口打开失败时返回错误.
+// 之后串口出错时间隔 DefaultReopen 重新打开
+func (sf *Bridge) Start() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.started {
+		return nil
+	}
+	for i, p := range sf.ports {
+		f, err := Open(p.Name, p.Config)
+		if err != nil {
+			for _, p := range sf.ports[:i] {
+				p.closeFile()
+			}
+			return err
+		}
+		p.setFile(f)
+	}
+	sf.started = true
+	for _, p := range sf.ports {
+		sf.wg.Add(1)
+		go sf.run(p, p.file())
+	}
+	return nil
+}
+
+// Close 关闭所有串口
+func (sf *Bridge) Close() error {
+	sf.cancel()
+	for _, p := range sf.ports {
+		p.closeFile()
+	}
+	sf.wg.Wait()
+	return nil
+}
+
+func (sf *Bridge) run(p *port, f *os.File) {
+	defer sf.wg.Done()
+	for {
+		err := sf.serve(p, f)
+		p.closeFile()
+		for {
+			if sf.ctx.Err() != nil {
+				return
+			}
+			sf.c.Log.Warnf("serial: port %s failed, %+v", p.Name, err)
+			select {
+			case <-sf.ctx.Done():
+				return
+			case <-time.After(DefaultReopen):
+			}
+			if f, err = Open(p.Name, p.Config); err == nil {
+				break
+			}
+		}
+		p.setFile(f)
+		if sf.ctx.Err() != nil { // 与Close竞争
+			p.closeFile()
+			return
+		}
+	}
+}
+
+// serve 读取并分帧, 有未完成的数据时以帧间隔为读超时, 超时即为空闲
+func (sf *Bridge) serve(p *port, f *os.File) error {
+	buf := make([]byte, 0, p.MaxFrame)
+	chunk := make([]byte, 256)
+	for {
+		deadline := time.Time{}
+		if len(buf) > 0 {
+			deadline = time.Now().Add(p.Gap)
+		}
+		if err := f.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+		n, err := f.Read(chunk)
+		idle := false
+		if err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				return err
+			}
+			idle = true
+		}
+		buf = sf.split(p, append(buf, chunk[:n]...), idle)
+		if len(buf) > p.MaxFrame {
+			sf.c.Log.Warnf("serial: port %s frame too large, dropped %d bytes", p.Name, len(buf))
+			buf = buf[:0]
+		}
+	}
+}
+
+// split 切分出所有完整的帧并上传, 返回剩余的数据, 空闲时丢弃剩余的数据
+func (sf *Bridge) split(p *port, buf []byte, idle bool) []byte {
+	data := buf
+	for len(data) > 0 {
+		advance, frame, err := p.Framer.Split(data, idle)
+		if err != nil {
+			sf.c.Log.Warnf("serial: port %s, %+v", p.Name, err)
+			return buf[:0]
+		}
+		if advance <= 0 || advance > len(data) {
+			break
+		}
+		if frame != nil {
+			sf.upload(p, append([]byte(nil), frame...))
+		}
+		data = data[advance:]
+	}
+	if idle {
+		return buf[:0]
+	}
+	return buf[:copy(buf, data)]
+}
+
+func (sf *Bridge) upload(p *port, frame []byte) {
+	pair, ok := p.device(frame)
+	if !ok {
+		sf.c.Log.Debugf("serial: port %s frame of unknown device, % x", p.Name, frame)
+		return
+	}
+	if !sf.c.IsActive(pair.ProductKey, pair.DeviceName) {
+		sf.c.Log.Debugf("serial: device %s not active", aiot.FormatKey(pair.ProductKey, pair.DeviceName))
+		return
+	}
+	if err := sf.c.ThingModelUpRaw(pair.ProductKey, pair.DeviceName, frame); err != nil {
+		sf.c.Log.Warnf("serial: thing model up raw failed, %+v", err)
+	}
+}
+
+// Write 将数据原样写入子设备所在的串口
+func (sf *Bridge) Write(pk, dn string, payload []byte) error {
+	p, ok := sf.devices[aiot.FormatKey(pk, dn)]
+	if !ok {
+		return ErrUnknownDevice
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.f == nil {
+		return ErrPortClosed
+	}
+	_, err := p.f.Write(payload)
+	return err
+}
+
+// Callback 包装事件接口, 映射的子设备的透传下行数据原样写入对应的串口,
+// 其它设备的事件交由next处理, 使用 aiot.Client.SetCallback 或 aiot.WithCallback 设置
+func (sf *Bridge) Callback(next aiot.Callback) aiot.Callback {
+	if next == nil {
+		next = aiot.NopCb{}
+	}
+	return &bridgeCallback{next, sf}
+}
+
+type bridgeCallback struct {
+	aiot.Callback
+	b *Bridge
+}
+
+// ThingModelDownRaw 实现 aiot.Callback 接口
+func (sf *bridgeCallback) ThingModelDownRaw(c *aiot.Client, pk, dn string, payload []byte) error {
+	if _, ok := sf.b.devices[aiot.FormatKey(pk, dn)]; !ok {
+		return sf.Callback.ThingModelDownRaw(c, pk, dn, payload)
+	}
+	return sf.b.Write(pk, dn, payload)
+}
@@ -0,0 +1,137 @@
+package serial
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// fakeConn 记录透传上行的数据
+type fakeConn struct {
+	mu     sync.Mutex
+	frames map[string][][]byte
+}
+
+func (sf *fakeConn) Publish(topic string, _ byte, payload interface{}) error {
+	if !strings.HasSuffix(topic, "/thing/model/up_raw") {
+		return nil
+	}
+	b, _ := payload.([]byte)
+	sf.mu.Lock()
+	sf.frames[topic] = append(sf.frames[topic], b)
+	sf.mu.Unlock()
+	return nil
+}
+
+func (sf *fakeConn) Subscribe(string, aiot.ProcDownStream) error { return nil }
+func (sf *fakeConn) UnSubscribe(...string) error                 { return nil }
+func (sf *fakeConn) Close() error                                { return nil }
+
+func (sf *fakeConn) wait(t *testing.T, topic string, n int) [][]byte {
+	require.Eventually(t, func() bool {
+		sf.mu.Lock()
+		defer sf.mu.Unlock()
+		return len(sf.frames[topic]) >= n
+	}, time.Second, time.Millisecond*5)
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.frames[topic]
+}
+
+func openPTY(t *testing.T) (*os.File, string) {
+	master, name, err := OpenPTY()
+	if err != nil {
+		t.Skipf("pseudo-terminal unavailable, %v", err)
+	}
+	t.Cleanup(func() { master.Close() }) // nolint: errcheck
+	return master, name
+}
+
+func newClient(t *testing.T, dns ...string) (*aiot.Client, *fakeConn) {
+	conn := &fakeConn{frames: make(map[string][][]byte)}
+	c := aiot.New(infra.MetaTriad{ProductKey: "gpk", DeviceName: "gdn", DeviceSecret: "gds"}, conn,
+		aiot.WithEnableGateway(), aiot.WithEnableModelRaw())
+	for _, dn := range dns {
+		require.NoError(t, c.AddSubDevice(infra.MetaTriad{ProductKey: "pk", DeviceName: dn, DeviceSecret: "ds"}))
+		require.NoError(t, c.SetDeviceStatus("pk", dn, aiot.DevStatusOnline))
+	}
+	return c, conn
+}
+
+func TestBridgeAddress(t *testing.T) {
+	master, name := openPTY(t)
+	c, conn := newClient(t, "m1", "m2")
+	b, err := New(c, Port{
+		Name:    name,
+		Config:  Config{BaudRate: 115200},
+		Framer:  Delimiter([]byte{0x0d}),
+		Address: ByteAddress(0),
+		Devices: []Device{
+			{MetaPair: infra.MetaPair{ProductKey: "pk", DeviceName: "m1"}, Address: 1},
+			{MetaPair: infra.MetaPair{ProductKey: "pk", DeviceName: "m2"}, Address: 2},
+		},
+	})
+	require.NoError(t, err)
+	c.SetCallback(b.Callback(nil))
+	require.NoError(t, b.Start())
+	defer b.Close()
+
+	// 分多次写入, 含未知地址的帧
+	_, err = master.Write([]byte{0x01, 0x10, 0x0d, 0x02})
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 10)
+	_, err = master.Write([]byte{0x20, 0x0d, 0x09, 0x0d})
+	require.NoError(t, err)
+
+	require.Equal(t, [][]byte{{0x01, 0x10, 0x0d}}, conn.wait(t, "/sys/pk/m1/thing/model/up_raw", 1))
+	require.Equal(t, [][]byte{{0x02, 0x20, 0x0d}}, conn.wait(t, "/sys/pk/m2/thing/model/up_raw", 1))
+
+	// 透传下行原样写回串口
+	require.NoError(t, aiot.ProcThingModelDownRaw(c, "/sys/pk/m2/thing/model/down_raw", []byte{0x02, 0x03, 0x0d}))
+	got := make([]byte, 3)
+	require.NoError(t, master.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = io.ReadFull(master, got)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x02, 0x03, 0x0d}, got)
+
+	require.Equal(t, ErrUnknownDevice, b.Write("pk", "none", []byte{1}))
+}
+
+func TestBridgeTimeout(t *testing.T) {
+	master, name := openPTY(t)
+	c, conn := newClient(t, "dev")
+	b, err := New(c, Port{
+		Name:    name,
+		Gap:     time.Millisecond * 30,
+		Devices: []Device{{MetaPair: infra.MetaPair{ProductKey: "pk", DeviceName: "dev"}}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Start())
+	defer b.Close()
+
+	_, err = master.Write([]byte("hello"))
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 100)
+	_, err = master.Write([]byte("world"))
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("hello"), []byte("world")}, conn.wait(t, "/sys/pk/dev/thing/model/up_raw", 2))
+}
+
+func TestNewInvalid(t *testing.T) {
+	c, _ := newClient(t)
+	_, err := New(c, Port{Name: "/dev/null"})
+	require.Error(t, err)
+	_, err = New(c, Port{Name: "/dev/null", Devices: []Device{
+		{MetaPair: infra.MetaPair{ProductKey: "pk", DeviceName: "a"}},
+		{MetaPair: infra.MetaPair{ProductKey: "pk", DeviceName: "b"}},
+	}})
+	require.Error(t, err)
+}
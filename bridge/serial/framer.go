@@ -0,0 +1,115 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package serial
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrInvalidFrame 帧格式错误, 分帧器应丢弃当前累积的数据
+var ErrInvalidFrame = errors.New("serial: invalid frame")
+
+// Framer 分帧器, 类似 bufio.SplitFunc, 从累积的字节流中切分出一帧.
+// idle 表示自最后一个字节起已超过帧间隔(Port.Gap)没有收到新数据.
+// 返回消费的字节数及帧, 数据不足一帧时返回 0, nil, nil;
+// 返回错误时累积的数据将被丢弃
+type Framer interface {
+	Split(data []byte, idle bool) (advance int, frame []byte, err error)
+}
+
+// FramerFunc 分帧器函数适配
+type FramerFunc func(data []byte, idle bool) (advance int, frame []byte, err error)
+
+// Split 实现 Framer 接口
+func (f FramerFunc) Split(data []byte, idle bool) (int, []byte, error) {
+	return f(data, idle)
+}
+
+// Timeout 按帧间隔分帧, 帧间隔内收到的所有数据为一帧, 适用于没有帧界定的协议
+func Timeout() Framer {
+	return FramerFunc(func(data []byte, idle bool) (int, []byte, error) {
+		if idle && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+}
+
+// Delimiter 按帧尾分隔符分帧, 帧包含分隔符. 超过帧间隔仍未收到分隔符的数据将被丢弃
+func Delimiter(delim []byte) Framer {
+	delim = append([]byte(nil), delim...)
+	return FramerFunc(func(data []byte, idle bool) (int, []byte, error) {
+		if i := bytes.Index(data, delim); i >= 0 {
+			n := i + len(delim)
+			return n, data[:n], nil
+		}
+		if idle && len(data) > 0 {
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	})
+}
+
+// LengthField 按长度域分帧
+// 帧长 = Offset + Size + 长度域的值 + Adjust
+type LengthField struct {
+	Offset    int    // 长度域的偏移
+	Size      int    // 长度域的字节数, 1, 2或4
+	BigEndian bool   // 长度域为大端
+	Adjust    int    // 帧长的修正值, 如长度域之后还有校验码等未计入长度的字段
+	Header    []byte // 帧头, 不为空时先在数据中查找帧头以同步
+}
+
+// Split 实现 Framer 接口, 超过帧间隔仍不完整的帧将被丢弃
+func (sf LengthField) Split(data []byte, idle bool) (int, []byte, error) {
+	if sf.Size < 1 || sf.Size > 4 || sf.Size == 3 {
+		return 0, nil, ErrInvalidFrame
+	}
+	skip := 0
+	if len(sf.Header) > 0 {
+		i := bytes.Index(data, sf.Header)
+		if i < 0 {
+			if idle {
+				return len(data), nil, nil
+			}
+			// 保留可能是帧头前缀的尾部
+			if n := len(data) - len(sf.Header) + 1; n > 0 {
+				return n, nil, nil
+			}
+			return 0, nil, nil
+		}
+		skip, data = i, data[i:]
+	}
+	head := sf.Offset + sf.Size
+	if len(data) < head {
+		return sf.incomplete(skip, data, idle)
+	}
+	var length int
+	for i := 0; i < sf.Size; i++ {
+		b := data[sf.Offset+i]
+		if sf.BigEndian {
+			length = length<<8 | int(b)
+		} else {
+			length |= int(b) << (8 * uint(i))
+		}
+	}
+	n := head + length + sf.Adjust
+	if n < head {
+		return 0, nil, ErrInvalidFrame
+	}
+	if len(data) < n {
+		return sf.incomplete(skip, data, idle)
+	}
+	return skip + n, data[:n], nil
+}
+
+// incomplete 帧不完整, 空闲时丢弃全部数据, 否则仅丢弃帧头之前的数据
+func (sf LengthField) incomplete(skip int, data []byte, idle bool) (int, []byte, error) {
+	if idle {
+		return skip + len(data), nil, nil
+	}
+	return skip, nil, nil
+}
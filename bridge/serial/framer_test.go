@@ -0,0 +1,73 @@
+package serial
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// splitAll 模拟网关的分帧循环
+func splitAll(f Framer, data []byte, idle bool) ([][]byte, []byte) {
+	var frames [][]byte
+	for len(data) > 0 {
+		advance, frame, err := f.Split(data, idle)
+		if err != nil {
+			return frames, nil
+		}
+		if advance == 0 {
+			break
+		}
+		if frame != nil {
+			frames = append(frames, frame)
+		}
+		data = data[advance:]
+	}
+	return frames, data
+}
+
+func TestTimeout(t *testing.T) {
+	frames, rest := splitAll(Timeout(), []byte{1, 2, 3}, false)
+	require.Empty(t, frames)
+	require.Equal(t, []byte{1, 2, 3}, rest)
+	frames, rest = splitAll(Timeout(), []byte{1, 2, 3}, true)
+	require.Equal(t, [][]byte{{1, 2, 3}}, frames)
+	require.Empty(t, rest)
+}
+
+func TestDelimiter(t *testing.T) {
+	f := Delimiter([]byte("\r\n"))
+	frames, rest := splitAll(f, []byte("a=1\r\nb=2\r\nc="), false)
+	require.Equal(t, [][]byte{[]byte("a=1\r\n"), []byte("b=2\r\n")}, frames)
+	require.Equal(t, []byte("c="), rest)
+
+	frames, rest = splitAll(f, rest, true)
+	require.Empty(t, frames)
+	require.Empty(t, rest)
+}
+
+func TestLengthField(t *testing.T) {
+	// | 0xaa 0x55 | len(2, big endian) | payload | crc(1) |
+	f := LengthField{Offset: 2, Size: 2, BigEndian: true, Adjust: 1, Header: []byte{0xaa, 0x55}}
+	stream := []byte{0x00, 0xaa, 0x55, 0x00, 0x02, 0x10, 0x20, 0xff, 0xaa, 0x55, 0x00, 0x01, 0x30}
+	frames, rest := splitAll(f, stream, false)
+	require.Equal(t, [][]byte{{0xaa, 0x55, 0x00, 0x02, 0x10, 0x20, 0xff}}, frames)
+	require.Equal(t, []byte{0xaa, 0x55, 0x00, 0x01, 0x30}, rest)
+
+	frames, rest = splitAll(f, append(rest, 0xee), false)
+	require.Equal(t, [][]byte{{0xaa, 0x55, 0x00, 0x01, 0x30, 0xee}}, frames)
+	require.Empty(t, rest)
+
+	// 未找到帧头时保留可能的帧头前缀
+	_, rest = splitAll(f, []byte{0x01, 0x02, 0xaa}, false)
+	require.Equal(t, []byte{0xaa}, rest)
+	_, rest = splitAll(f, []byte{0x01, 0x02, 0xaa}, true)
+	require.Empty(t, rest)
+
+	// 小端, 无帧头
+	f = LengthField{Size: 1}
+	frames, _ = splitAll(f, []byte{0x02, 0x01, 0x02, 0x00}, false)
+	require.Equal(t, [][]byte{{0x02, 0x01, 0x02}, {0x00}}, frames)
+
+	_, _, err := LengthField{Size: 3}.Split([]byte{1, 2, 3}, false)
+	require.Equal(t, ErrInvalidFrame, err)
+}
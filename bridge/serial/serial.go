@@ -0,0 +1,61 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package serial 串口透传网关, 将串口(RS-485/RS-232)上私有协议的字节流分帧后,
+// 以子设备的身份通过透传主题(thing/model/up_raw)原样上传, 由云端的数据解析脚本解析,
+// 透传下行(thing/model/down_raw)的数据原样写回子设备所在的串口
+package serial
+
+import (
+	"errors"
+)
+
+// 校验位
+const (
+	ParityNone = 'N'
+	ParityOdd  = 'O'
+	ParityEven = 'E'
+)
+
+// 错误定义
+var (
+	ErrNotSupported  = errors.New("serial: not supported on this platform")
+	ErrInvalidBaud   = errors.New("serial: unsupported baud rate")
+	ErrInvalidConfig = errors.New("serial: invalid config")
+)
+
+// Config 串口配置
+type Config struct {
+	BaudRate int  // 波特率, 默认9600
+	DataBits int  // 数据位, 5-8, 默认8
+	StopBits int  // 停止位, 1或2, 默认1
+	Parity   byte // 校验位, 默认 ParityNone
+}
+
+func (sf *Config) setDefaults() {
+	if sf.BaudRate == 0 {
+		sf.BaudRate = 9600
+	}
+	if sf.DataBits == 0 {
+		sf.DataBits = 8
+	}
+	if sf.StopBits == 0 {
+		sf.StopBits = 1
+	}
+	if sf.Parity == 0 {
+		sf.Parity = ParityNone
+	}
+}
+
+func (sf *Config) validate() error {
+	if sf.DataBits < 5 || sf.DataBits > 8 || (sf.StopBits != 1 && sf.StopBits != 2) {
+		return ErrInvalidConfig
+	}
+	switch sf.Parity {
+	case ParityNone, ParityOdd, ParityEven:
+	default:
+		return ErrInvalidConfig
+	}
+	return nil
+}
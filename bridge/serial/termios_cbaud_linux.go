@@ -0,0 +1,11 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+//go:build linux && !ppc64 && !ppc64le
+// +build linux,!ppc64,!ppc64le
+
+package serial
+
+// cbaud 波特率掩码, syscall未导出
+const cbaud = 0x100f
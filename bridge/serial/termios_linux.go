@@ -0,0 +1,123 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package serial
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+var bauds = map[int]uint32{
+	1200:   syscall.B1200,
+	2400:   syscall.B2400,
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+	460800: syscall.B460800,
+	921600: syscall.B921600,
+}
+
+// Open 以raw模式打开串口, 返回的文件支持 SetReadDeadline
+func Open(name string, cfg Config) (*os.File, error) {
+	cfg.setDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	speed, ok := bauds[cfg.BaudRate]
+	if !ok {
+		return nil, ErrInvalidBaud
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = setRaw(f, speed, cfg); err != nil {
+		f.Close() // nolint: errcheck
+		return nil, err
+	}
+	return f, nil
+}
+
+func setRaw(f *os.File, speed uint32, cfg Config) error {
+	var t syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return err
+	}
+	// 同 cfmakeraw
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.PARODD | syscall.CSTOPB | cbaud
+	// 波特率只通过Cflag设置, 部分架构(如mips)的Termios没有Ispeed,Ospeed字段
+	t.Cflag |= syscall.CREAD | syscall.CLOCAL | speed
+	switch cfg.DataBits {
+	case 5:
+		t.Cflag |= syscall.CS5
+	case 6:
+		t.Cflag |= syscall.CS6
+	case 7:
+		t.Cflag |= syscall.CS7
+	default:
+		t.Cflag |= syscall.CS8
+	}
+	if cfg.StopBits == 2 {
+		t.Cflag |= syscall.CSTOPB
+	}
+	switch cfg.Parity {
+	case ParityOdd:
+		t.Cflag |= syscall.PARENB | syscall.PARODD
+	case ParityEven:
+		t.Cflag |= syscall.PARENB
+	}
+	t.Cc[syscall.VMIN], t.Cc[syscall.VTIME] = 1, 0
+	return ioctl(f, syscall.TCSETS, uintptr(unsafe.Pointer(&t)))
+}
+
+// OpenPTY 打开一对伪终端, 用于测试及仿真, slaveName 可作为串口名传给 Open,
+// 写入master的数据即为串口收到的数据
+func OpenPTY() (master *os.File, slaveName string, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var n uint32
+	var unlock int32
+	if err = ioctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err == nil {
+		err = ioctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	}
+	if err != nil {
+		master.Close() // nolint: errcheck
+		return nil, "", err
+	}
+	return master, "/dev/pts/" + strconv.FormatUint(uint64(n), 10), nil
+}
+
+func ioctl(f *os.File, req, arg uintptr) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package serial
+
+import (
+	"os"
+)
+
+// Open 以raw模式打开串口, 当前平台不支持
+func Open(string, Config) (*os.File, error) {
+	return nil, ErrNotSupported
+}
+
+// OpenPTY 打开一对伪终端, 当前平台不支持
+func OpenPTY() (*os.File, string, error) {
+	return nil, "", ErrNotSupported
+}
@@ -6,6 +6,7 @@ package aiot
 
 import (
 	"encoding/json"
+	"strconv"
 	"sync/atomic"
 
 	"github.com/things-go/aliyun-iot/uri"
@@ -17,18 +18,39 @@ func (sf *Client) nextRequestID() uint {
 }
 
 // Request 发送请求,API内部已实现json序列化
+// 设置了透传编解码器时,物模型的上行请求将编码后发往透传主题
 // _uri 唯一定位服务器或(topic)
 // requestID: 请求ID
 // method: 方法
 // params: 消息体Request的params
 func (sf *Client) Request(_uri string, requestID uint, method string, params interface{}) error {
-	out, err := json.Marshal(&Request{requestID, sf.version, params, method})
+	req := &Request{requestID, sf.version, params, method}
+	if sf.codec != nil {
+		if ok, err := sf.requestWithCodec(_uri, req); ok {
+			return err
+		}
+	}
+	out, err := json.Marshal(req)
 	if err != nil {
 		return err
 	}
+	if sf.replayCache != nil && sf.isSubDevURI(_uri) {
+		sf.replayCache.SetDefault(strconv.FormatUint(uint64(requestID), 10), replayEntry{_uri, out})
+	}
 	return sf.Publish(_uri, 1, out)
 }
 
+// isSubDevURI 是否为子设备的请求主题 /sys/{productKey}/{deviceName}/...
+func (sf *Client) isSubDevURI(_uri string) bool {
+	uris := uri.Spilt(_uri)
+	if len(uris) < 3 || uris[0] != "sys" ||
+		(uris[1] == sf.tetrad.ProductKey && uris[2] == sf.tetrad.DeviceName) {
+		return false
+	}
+	_, err := sf.Search(uris[1], uris[2])
+	return err == nil
+}
+
 // SendRequest 发送请求,API内部已实现json序列化,requestID内部生成
 // _uri 唯一定位服务器或(topic)
 // method: 方法
@@ -43,10 +65,16 @@ func (sf *Client) SendRequest(_uri, method string, params interface{}) (*Token,
 }
 
 // Response 发送回复
+// 设置了透传编解码器时,物模型服务调用的回复将编码后发往透传主题
 // _uri 唯一定位服务器或(topic)
 // Response: 回复
 // API内部已实现json序列化
 func (sf *Client) Response(_uri string, rsp Response) error {
+	if sf.codec != nil {
+		if ok, err := sf.responseWithCodec(_uri, &rsp); ok {
+			return err
+		}
+	}
 	out, err := json.Marshal(rsp)
 	if err != nil {
 		return err
@@ -209,6 +237,10 @@ func (sf *Client) SubscribeAllTopic(productKey, deviceName string, isSub bool) e
 			if err = sf.Subscribe(_uri, ProcThingSubRegisterReply); err != nil {
 				sf.Log.Warnf(err.Error())
 			}
+			_uri = uri.URI(uri.SysPrefix, uri.ThingProxyProductRegisterReply, productKey, deviceName)
+			if err = sf.Subscribe(_uri, ProcThingProxyProductRegisterReply); err != nil {
+				sf.Log.Warnf(err.Error())
+			}
 			// 子设备上线,下线,topic需要用网关的productKey,deviceName,
 			// 使用的是网关的通道,所以子设备不注册相关主题
 			_uri = uri.URI(uri.ExtSessionPrefix, uri.CombineLoginReply, productKey, deviceName)
@@ -283,6 +315,27 @@ func (sf *Client) SubscribeAllTopic(productKey, deviceName string, isSub bool) e
 			if err = sf.Subscribe(_uri, ProcThingOtaFirmwareGetReply); err != nil {
 				sf.Log.Warnf(err.Error())
 			}
+
+			// 文件分片下载应答
+			_uri = uri.URI(uri.SysPrefix, uri.ThingFileDownloadReply, productKey, deviceName)
+			if err = sf.Subscribe(_uri, ProcThingFileDownloadReply); err != nil {
+				sf.Log.Warnf(err.Error())
+			}
+		}
+		// 文件上传
+		if sf.hasFileUpload {
+			_uri = uri.URI(uri.SysPrefix, uri.ThingFileUploadInitReply, productKey, deviceName)
+			if err = sf.Subscribe(_uri, ProcThingFileUploadInitReply); err != nil {
+				sf.Log.Warnf(err.Error())
+			}
+			_uri = uri.URI(uri.SysPrefix, uri.ThingFileUploadSendReply, productKey, deviceName)
+			if err = sf.Subscribe(_uri, ProcThingFileUploadSendReply); err != nil {
+				sf.Log.Warnf(err.Error())
+			}
+			_uri = uri.URI(uri.SysPrefix, uri.ThingFileUploadCancelReply, productKey, deviceName)
+			if err = sf.Subscribe(_uri, ProcThingFileUploadCancelReply); err != nil {
+				sf.Log.Warnf(err.Error())
+			}
 		}
 	}
 
@@ -354,6 +407,7 @@ func (sf *Client) UnSubscribeAllTopic(productKey, deviceName string, isSub bool)
 			topicList = append(topicList,
 				// 子设备动态注册,topic需要用网关的productKey,deviceName
 				uri.URI(uri.SysPrefix, uri.ThingSubRegisterReply, productKey, deviceName),
+				uri.URI(uri.SysPrefix, uri.ThingProxyProductRegisterReply, productKey, deviceName),
 				// 子设备上线,下线,topic需要用网关的productKey,deviceName,
 				// 使用的是网关的通道,所以子设备不注册相关主题
 				uri.URI(uri.ExtSessionPrefix, uri.CombineLoginReply, productKey, deviceName),
@@ -385,6 +439,16 @@ func (sf *Client) UnSubscribeAllTopic(productKey, deviceName string, isSub bool)
 			uri.URI(uri.OtaDeviceUpgradePrefix, "", productKey, deviceName),
 			// OTA 固件版本查询应答
 			uri.URI(uri.SysPrefix, uri.ThingOtaFirmwareGetReply, productKey, deviceName),
+			// 文件分片下载应答
+			uri.URI(uri.SysPrefix, uri.ThingFileDownloadReply, productKey, deviceName),
+		)
+	}
+	// 文件上传
+	if sf.hasFileUpload {
+		topicList = append(topicList,
+			uri.URI(uri.SysPrefix, uri.ThingFileUploadInitReply, productKey, deviceName),
+			uri.URI(uri.SysPrefix, uri.ThingFileUploadSendReply, productKey, deviceName),
+			uri.URI(uri.SysPrefix, uri.ThingFileUploadCancelReply, productKey, deviceName),
 		)
 	}
 
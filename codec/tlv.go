@@ -0,0 +1,362 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package codec 透传数据编解码器的参考实现
+// 帧格式为阿里云数据解析脚本示例中常用的 "method字节 + id + TLV" 格式:
+//
+//	请求: | method 1字节 | id 4字节 | TLV ... |
+//	应答: | method 1字节 | id 4字节 | code 2字节 | TLV ... |
+//	TLV:  | tag 1字节 | length 2字节 | value length字节 |
+//
+// 多字节整数均为大端序.
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// 默认的method字节定义,与阿里云数据解析脚本示例一致
+const (
+	MethodPropertyPost      byte = 0x00 // 属性上报
+	MethodPropertySet       byte = 0x01 // 属性设置
+	MethodPropertyPostReply byte = 0x02 // 属性上报应答
+	MethodPropertySetReply  byte = 0x03 // 属性设置应答
+)
+
+// 帧头长度
+const (
+	requestHeadSize  = 5
+	responseHeadSize = 7
+	tlvHeadSize      = 3
+)
+
+// Type 数据类型
+type Type byte
+
+// 支持的数据类型
+const (
+	TypeInt    Type = iota // int32, 4字节
+	TypeFloat              // float32, 4字节
+	TypeDouble             // float64, 8字节
+	TypeBool               // 1字节, 0: false, 1: true, 与物模型bool类型一致,解码为0或1
+	TypeText               // utf-8 字符串
+)
+
+// 错误定义
+var (
+	ErrUnknownMethod = aiot.ErrCodecUnknownMethod
+	ErrUnknownTag    = errors.New("codec: unknown tag")
+	ErrShortFrame    = errors.New("codec: short frame")
+)
+
+// Field TLV 字段定义, 将tag映射到物模型的标识符
+type Field struct {
+	Tag        byte
+	Identifier string
+	Type       Type
+}
+
+// Method method定义
+type Method struct {
+	Code      byte   // 请求的method字节
+	ReplyCode byte   // 应答的method字节
+	Method    string // Alink method, 如 thing.event.{tsl.event.identifier}.post, thing.service.{tsl.service.identifier}
+}
+
+// Option option
+type Option func(*TLV)
+
+// WithMethod 增加一个method定义,如事件上报或服务调用
+func WithMethod(m Method) Option {
+	return func(t *TLV) {
+		t.addMethod(m)
+	}
+}
+
+// WithFields 增加TLV字段定义, 属性, 事件输出参数, 服务输入输出参数共享同一tag空间
+func WithFields(fields ...Field) Option {
+	return func(t *TLV) {
+		for _, f := range fields {
+			t.tags[f.Tag] = f
+			t.identifiers[f.Identifier] = f
+		}
+	}
+}
+
+// TLV "method字节 + id + TLV" 格式的编解码器, 实现 aiot.Codec 接口
+type TLV struct {
+	methods     map[string]Method
+	codes       map[byte]Method
+	replyCodes  map[byte]Method
+	tags        map[byte]Field
+	identifiers map[string]Field
+}
+
+var _ aiot.Codec = (*TLV)(nil)
+
+// New 新建TLV编解码器, 默认包含属性上报和属性设置的method定义
+func New(opts ...Option) *TLV {
+	t := &TLV{
+		methods:     make(map[string]Method),
+		codes:       make(map[byte]Method),
+		replyCodes:  make(map[byte]Method),
+		tags:        make(map[byte]Field),
+		identifiers: make(map[string]Field),
+	}
+	t.addMethod(Method{MethodPropertyPost, MethodPropertyPostReply, infra.MethodEventPropertyPost})
+	t.addMethod(Method{MethodPropertySet, MethodPropertySetReply, infra.MethodServicePropertySet})
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func (sf *TLV) addMethod(m Method) {
+	sf.methods[m.Method] = m
+	sf.codes[m.Code] = m
+	sf.replyCodes[m.ReplyCode] = m
+}
+
+// EncodeRequest 实现 aiot.Codec 接口
+func (sf *TLV) EncodeRequest(_, _ string, req *aiot.Request) ([]byte, error) {
+	m, ok := sf.methods[req.Method]
+	if !ok {
+		return nil, ErrUnknownMethod
+	}
+	params, err := json.Marshal(req.Params)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteByte(m.Code)
+	binary.Write(buf, binary.BigEndian, uint32(req.ID)) // nolint: errcheck
+	if err = sf.encodeItems(buf, params, req.Method != infra.MethodEventPropertyPost); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeResponse 实现 aiot.Codec 接口
+func (sf *TLV) DecodeResponse(_, _ string, raw []byte) (string, *aiot.ResponseRawData, error) {
+	if len(raw) < responseHeadSize {
+		return "", nil, ErrShortFrame
+	}
+	m, ok := sf.replyCodes[raw[0]]
+	if !ok {
+		return "", nil, ErrUnknownMethod
+	}
+	data, err := sf.decodeItems(raw[responseHeadSize:])
+	if err != nil {
+		return "", nil, err
+	}
+	return m.Method, &aiot.ResponseRawData{
+		ID:   uint(binary.BigEndian.Uint32(raw[1:])),
+		Code: int(binary.BigEndian.Uint16(raw[5:])),
+		Data: data,
+	}, nil
+}
+
+// DecodeRequest 实现 aiot.Codec 接口
+func (sf *TLV) DecodeRequest(_, _ string, raw []byte) (*aiot.RequestRawData, error) {
+	if len(raw) < requestHeadSize {
+		return nil, ErrShortFrame
+	}
+	m, ok := sf.codes[raw[0]]
+	if !ok {
+		return nil, ErrUnknownMethod
+	}
+	params, err := sf.decodeItems(raw[requestHeadSize:])
+	if err != nil {
+		return nil, err
+	}
+	return &aiot.RequestRawData{
+		ID:     uint(binary.BigEndian.Uint32(raw[1:])),
+		Params: params,
+		Method: m.Method,
+	}, nil
+}
+
+// EncodeResponse 实现 aiot.Codec 接口
+func (sf *TLV) EncodeResponse(_, _, method string, rsp *aiot.Response) ([]byte, error) {
+	m, ok := sf.methods[method]
+	if !ok {
+		return nil, ErrUnknownMethod
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteByte(m.ReplyCode)
+	binary.Write(buf, binary.BigEndian, uint32(rsp.ID))   // nolint: errcheck
+	binary.Write(buf, binary.BigEndian, uint16(rsp.Code)) // nolint: errcheck
+	if rsp.Data != nil {
+		data, err := json.Marshal(rsp.Data)
+		if err != nil {
+			return nil, err
+		}
+		if err = sf.encodeItems(buf, data, false); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeItems 编码json对象的各项为TLV
+// 属性上报的值可以是 {"value": v, "time": t} 的形式, 事件上报的参数在 "value" 域中
+func (sf *TLV) encodeItems(buf *bytes.Buffer, data []byte, unwrap bool) error {
+	items := make(map[string]json.RawMessage)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	// 字符串形式的json对象, 如应答的Data常用 "{}" 表示空对象
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		data = []byte(s)
+	}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	if v, ok := items["value"]; ok && unwrap {
+		return sf.encodeItems(buf, v, false)
+	}
+	// 按tag顺序编码,保证输出稳定
+	for tag := 0; tag <= math.MaxUint8; tag++ {
+		f, ok := sf.tags[byte(tag)]
+		if !ok {
+			continue
+		}
+		v, ok := items[f.Identifier]
+		if !ok {
+			continue
+		}
+		if err := encodeValue(buf, f, v); err != nil {
+			return err
+		}
+	}
+	for k := range items {
+		if _, ok := sf.identifiers[k]; !ok {
+			return fmt.Errorf("codec: unknown identifier %s", k)
+		}
+	}
+	return nil
+}
+
+func encodeValue(buf *bytes.Buffer, f Field, v json.RawMessage) error {
+	var wrapper struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if len(v) > 0 && v[0] == '{' {
+		if err := json.Unmarshal(v, &wrapper); err != nil {
+			return err
+		}
+		v = wrapper.Value
+	}
+
+	var b []byte
+	switch f.Type {
+	case TypeInt:
+		var n int32
+		if err := json.Unmarshal(v, &n); err != nil {
+			return err
+		}
+		b = make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(n))
+	case TypeFloat:
+		var n float32
+		if err := json.Unmarshal(v, &n); err != nil {
+			return err
+		}
+		b = make([]byte, 4)
+		binary.BigEndian.PutUint32(b, math.Float32bits(n))
+	case TypeDouble:
+		var n float64
+		if err := json.Unmarshal(v, &n); err != nil {
+			return err
+		}
+		b = make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(n))
+	case TypeBool:
+		var n bool
+		if err := json.Unmarshal(v, &n); err != nil {
+			var i int
+			if err = json.Unmarshal(v, &i); err != nil {
+				return err
+			}
+			n = i != 0
+		}
+		b = []byte{0}
+		if n {
+			b[0] = 1
+		}
+	case TypeText:
+		var str string
+		if err := json.Unmarshal(v, &str); err != nil {
+			return err
+		}
+		b = []byte(str)
+	default:
+		return fmt.Errorf("codec: unknown type %d of %s", f.Type, f.Identifier)
+	}
+	if len(b) > math.MaxUint16 {
+		return fmt.Errorf("codec: value of %s too long", f.Identifier)
+	}
+	buf.WriteByte(f.Tag)
+	binary.Write(buf, binary.BigEndian, uint16(len(b))) // nolint: errcheck
+	buf.Write(b)
+	return nil
+}
+
+// decodeItems 解码TLV为json对象
+func (sf *TLV) decodeItems(raw []byte) (json.RawMessage, error) {
+	items := make(map[string]interface{})
+	for len(raw) > 0 {
+		if len(raw) < tlvHeadSize {
+			return nil, ErrShortFrame
+		}
+		tag, length := raw[0], int(binary.BigEndian.Uint16(raw[1:]))
+		raw = raw[tlvHeadSize:]
+		if len(raw) < length {
+			return nil, ErrShortFrame
+		}
+		f, ok := sf.tags[tag]
+		if !ok {
+			return nil, ErrUnknownTag
+		}
+		v, err := decodeValue(f, raw[:length])
+		if err != nil {
+			return nil, err
+		}
+		items[f.Identifier] = v
+		raw = raw[length:]
+	}
+	return json.Marshal(items)
+}
+
+func decodeValue(f Field, b []byte) (interface{}, error) {
+	size := map[Type]int{TypeInt: 4, TypeFloat: 4, TypeDouble: 8, TypeBool: 1}
+	if n, ok := size[f.Type]; ok && len(b) != n {
+		return nil, fmt.Errorf("codec: invalid length %d of %s", len(b), f.Identifier)
+	}
+	switch f.Type {
+	case TypeInt:
+		return int32(binary.BigEndian.Uint32(b)), nil
+	case TypeFloat:
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), nil
+	case TypeDouble:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case TypeBool:
+		if b[0] != 0 {
+			return 1, nil
+		}
+		return 0, nil
+	case TypeText:
+		return string(b), nil
+	}
+	return nil, fmt.Errorf("codec: unknown type %d of %s", f.Type, f.Identifier)
+}
@@ -0,0 +1,123 @@
+package codec
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+func newTestTLV() *TLV {
+	return New(
+		WithMethod(Method{0x10, 0x11, "thing.event.alarm.post"}),
+		WithMethod(Method{0x20, 0x21, "thing.service.reboot"}),
+		WithFields(
+			Field{0x01, "temperature", TypeFloat},
+			Field{0x02, "switch", TypeBool},
+			Field{0x03, "count", TypeInt},
+			Field{0x04, "name", TypeText},
+			Field{0x05, "delay", TypeDouble},
+		),
+	)
+}
+
+func TestTLVRequest(t *testing.T) {
+	c := newTestTLV()
+
+	t.Run("property post", func(t *testing.T) {
+		raw, err := c.EncodeRequest("pk", "dn", &aiot.Request{
+			ID:     0x01020304,
+			Params: map[string]interface{}{"count": 258, "switch": map[string]interface{}{"value": 1, "time": 1}},
+			Method: infra.MethodEventPropertyPost,
+		})
+		require.NoError(t, err)
+		require.Equal(t, []byte{
+			MethodPropertyPost, 0x01, 0x02, 0x03, 0x04,
+			0x02, 0x00, 0x01, 0x01,
+			0x03, 0x00, 0x04, 0x00, 0x00, 0x01, 0x02,
+		}, raw)
+	})
+
+	t.Run("event post", func(t *testing.T) {
+		raw, err := c.EncodeRequest("pk", "dn", &aiot.Request{
+			ID:     1,
+			Params: map[string]interface{}{"value": map[string]interface{}{"name": "ab"}, "time": 1},
+			Method: "thing.event.alarm.post",
+		})
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x10, 0x00, 0x00, 0x00, 0x01, 0x04, 0x00, 0x02, 'a', 'b'}, raw)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := c.EncodeRequest("pk", "dn", &aiot.Request{Method: "thing.event.unknown.post"})
+		require.Equal(t, ErrUnknownMethod, err)
+
+		_, err = c.EncodeRequest("pk", "dn", &aiot.Request{
+			Params: map[string]interface{}{"unknown": 1},
+			Method: infra.MethodEventPropertyPost,
+		})
+		require.Error(t, err)
+	})
+}
+
+func TestTLVDownRequest(t *testing.T) {
+	c := newTestTLV()
+
+	req, err := c.DecodeRequest("pk", "dn", []byte{
+		MethodPropertySet, 0x00, 0x00, 0x00, 0x09,
+		0x02, 0x00, 0x01, 0x01,
+		0x04, 0x00, 0x02, 'o', 'k',
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint(9), req.ID)
+	require.Equal(t, infra.MethodServicePropertySet, req.Method)
+	require.JSONEq(t, `{"switch":1,"name":"ok"}`, string(req.Params))
+
+	_, err = c.DecodeRequest("pk", "dn", []byte{MethodPropertySet, 0x00})
+	require.Equal(t, ErrShortFrame, err)
+	_, err = c.DecodeRequest("pk", "dn", []byte{0xff, 0x00, 0x00, 0x00, 0x01})
+	require.Equal(t, ErrUnknownMethod, err)
+	_, err = c.DecodeRequest("pk", "dn", []byte{MethodPropertySet, 0x00, 0x00, 0x00, 0x01, 0x7f, 0x00, 0x00})
+	require.Equal(t, ErrUnknownTag, err)
+}
+
+func TestTLVResponse(t *testing.T) {
+	c := newTestTLV()
+
+	t.Run("up reply", func(t *testing.T) {
+		method, rsp, err := c.DecodeResponse("pk", "dn", []byte{MethodPropertyPostReply, 0x00, 0x00, 0x00, 0x05, 0x00, 0xc8})
+		require.NoError(t, err)
+		require.Equal(t, infra.MethodEventPropertyPost, method)
+		require.Equal(t, uint(5), rsp.ID)
+		require.Equal(t, infra.CodeSuccess, rsp.Code)
+		require.JSONEq(t, `{}`, string(rsp.Data))
+	})
+
+	t.Run("down reply", func(t *testing.T) {
+		raw, err := c.EncodeResponse("pk", "dn", infra.MethodServicePropertySet,
+			&aiot.Response{ID: 5, Code: infra.CodeSuccess, Data: "{}"})
+		require.NoError(t, err)
+		require.Equal(t, []byte{MethodPropertySetReply, 0x00, 0x00, 0x00, 0x05, 0x00, 0xc8}, raw)
+		raw, err = c.EncodeResponse("pk", "dn", infra.MethodServicePropertySet,
+			&aiot.Response{ID: 5, Code: infra.CodeSuccess, Data: map[string]interface{}{}})
+		require.NoError(t, err)
+		require.Equal(t, []byte{MethodPropertySetReply, 0x00, 0x00, 0x00, 0x05, 0x00, 0xc8}, raw)
+		_, err = c.EncodeResponse("pk", "dn", infra.MethodServicePropertySet,
+			&aiot.Response{ID: 5, Code: infra.CodeSuccess, Data: `{"unknown":1}`})
+		require.Error(t, err)
+
+		raw, err = c.EncodeResponse("pk", "dn", "thing.service.reboot",
+			&aiot.Response{ID: 6, Code: infra.CodeSuccess, Data: map[string]interface{}{"delay": 1.5}})
+		require.NoError(t, err)
+		method, rsp, err := New(WithMethod(Method{0x21, 0x21, "thing.service.reboot"}),
+			WithFields(Field{0x05, "delay", TypeDouble})).DecodeResponse("pk", "dn", raw)
+		require.NoError(t, err)
+		require.Equal(t, "thing.service.reboot", method)
+		var data map[string]float64
+		require.NoError(t, json.Unmarshal(rsp.Data, &data))
+		require.Equal(t, 1.5, data["delay"])
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package adapter 将设备身份凭证提供者适配到 aiot, http, sign 及 dynamic,
+// 使这些包不依赖 credentials 及其第三方包
+package adapter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/credentials"
+	"github.com/things-go/aliyun-iot/dynamic"
+	aiothttp "github.com/things-go/aliyun-iot/http"
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/sign"
+)
+
+// New 根据凭证提供者的设备三元组创建一个物管理客户端, 见 aiot.New
+func New(p credentials.Provider, conn aiot.Conn, opts ...aiot.Option) (*aiot.Client, error) {
+	c, err := credentials.Retrieve(p)
+	if err != nil {
+		return nil, err
+	}
+	return aiot.New(c.Triad(), conn, opts...), nil
+}
+
+// NewWithMQTT 根据凭证提供者的设备三元组新建MQTTClient, 见 aiot.NewWithMQTT
+func NewWithMQTT(p credentials.Provider, c mqtt.Client, opts ...aiot.Option) (*aiot.MQTTClient, error) {
+	cred, err := credentials.Retrieve(p)
+	if err != nil {
+		return nil, err
+	}
+	return aiot.NewWithMQTT(cred.Triad(), c, opts...), nil
+}
+
+// NewHTTP 根据凭证提供者新建alink http client,
+// 自定义域名时作为host, 提供了CA证书时使用该CA校验服务端, opts可覆盖这些设置.
+// HTTP认证不支持X.509证书, 凭证只提供了设备证书时返回 sign.ErrX509Unsupported
+func NewHTTP(p credentials.Provider, opts ...aiothttp.Option) (*aiothttp.Client, error) {
+	c, err := credentials.Retrieve(p)
+	if err != nil {
+		return nil, err
+	}
+	if c.DeviceSecret == "" && c.X509() {
+		return nil, sign.ErrX509Unsupported
+	}
+	var defaults []aiothttp.Option
+	if c.Region.Region == infra.CloudRegionCustom {
+		defaults = append(defaults, aiothttp.WithEndpoint(c.Region.CustomDomain))
+	}
+	if len(c.CACert) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(c.CACert) {
+			return nil, errors.New("invalid ca cert")
+		}
+		defaults = append(defaults, aiothttp.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+			},
+		}))
+	}
+	return aiothttp.New(c.Triad(), append(defaults, opts...)...), nil
+}
+
+// Sign 根据凭证提供者的设备三元组及地域生成MQTT签名, 选项同 sign.Generate,
+// 凭证提供了设备证书及私钥时使用X.509证书认证
+func Sign(p credentials.Provider, opts ...sign.Option) (*sign.Sign, error) {
+	c, err := credentials.Retrieve(p)
+	if err != nil {
+		return nil, err
+	}
+	if c.X509() {
+		opts = append([]sign.Option{sign.WithX509()}, opts...)
+	}
+	return sign.Generate(c.Triad(), c.Region, opts...)
+}
+
+// TLSConfig 根据凭证提供者的CA证书及设备证书创建tls配置,
+// 凭证未提供设备证书时同 sign.TLSConfig
+func TLSConfig(p credentials.Provider) (*tls.Config, error) {
+	c, err := credentials.Retrieve(p)
+	if err != nil {
+		return nil, err
+	}
+	if !c.X509() {
+		return sign.TLSConfig(c.CACert)
+	}
+	cert, err := tls.X509KeyPair(c.ClientCert, c.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+	return sign.TLSConfig(c.CACert, cert)
+}
+
+// DynamicCloud 一型一密HTTPS动态注册的凭证提供者, 见 credentials.Dynamic,
+// base提供ProductKey,ProductSecret,DeviceName及地域, 获得的DeviceSecret缓存到cachePath, 只在首次获取时注册
+func DynamicCloud(c *dynamic.Client, base credentials.Provider, cachePath string, signMethods ...string) credentials.Provider {
+	return credentials.Dynamic(base, func(meta *infra.MetaTetrad, crd infra.CloudRegionDomain) error {
+		return c.RegisterCloud(meta, crd, signMethods...)
+	}, cachePath)
+}
@@ -0,0 +1,137 @@
+package adapter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/credentials"
+	"github.com/things-go/aliyun-iot/dynamic"
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/sign"
+)
+
+// testCertificate 生成自签名的设备证书及私钥
+func testCertificate(t *testing.T) (certPem, keyPem []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dn"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestSign(t *testing.T) {
+	crd := infra.CloudRegionDomain{Region: infra.CloudRegionCustom, CustomDomain: "iot.custom.com"}
+	signout, err := Sign(credentials.Static(credentials.Credentials{
+		MetaTetrad: infra.MetaTetrad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"},
+		Region:     crd,
+	}))
+	require.NoError(t, err)
+	want, err := sign.Generate(infra.MetaTriad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"}, crd)
+	require.NoError(t, err)
+	require.Equal(t, want, signout)
+
+	_, err = Sign(credentials.Static(credentials.Credentials{}))
+	require.Error(t, err)
+
+	// X.509证书认证
+	certPem, keyPem := testCertificate(t)
+	x509Creds := credentials.Static(credentials.Credentials{
+		MetaTetrad: infra.MetaTetrad{ProductKey: "pk", DeviceName: "dn"},
+		ClientCert: certPem,
+		ClientKey:  keyPem,
+	})
+	signout, err = Sign(x509Creds)
+	require.NoError(t, err)
+	require.Empty(t, signout.Password)
+	require.Equal(t, "x509.itls.cn-shanghai.aliyuncs.com", signout.HostName)
+
+	cfg, err := TLSConfig(x509Creds)
+	require.NoError(t, err)
+	require.Len(t, cfg.Certificates, 1)
+
+	// HTTP认证不支持X.509证书
+	_, err = NewHTTP(x509Creds)
+	require.Equal(t, sign.ErrX509Unsupported, err)
+}
+
+type nopConn struct{}
+
+func (nopConn) Publish(string, byte, interface{}) error     { return nil }
+func (nopConn) Subscribe(string, aiot.ProcDownStream) error { return nil }
+func (nopConn) UnSubscribe(...string) error                 { return nil }
+func (nopConn) Close() error                                { return nil }
+
+func TestNew(t *testing.T) {
+	p := credentials.Static(credentials.Credentials{
+		MetaTetrad: infra.MetaTetrad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"},
+	})
+	c, err := New(p, nopConn{})
+	require.NoError(t, err)
+	ds, err := c.DeviceSecret("pk", "dn")
+	require.NoError(t, err)
+	require.Equal(t, "ds", ds)
+
+	_, err = New(credentials.Static(credentials.Credentials{}), nopConn{})
+	require.Error(t, err)
+
+	_, err = NewHTTP(p)
+	require.NoError(t, err)
+	_, err = NewHTTP(credentials.Static(credentials.Credentials{
+		MetaTetrad: infra.MetaTetrad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"},
+		CACert:     []byte("invalid"),
+	}))
+	require.Error(t, err)
+}
+
+func TestDynamicCloud(t *testing.T) {
+	registered := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registered++
+		rsp := dynamic.Response{
+			Code: infra.CodeSuccess,
+			Data: infra.MetaTriad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"},
+		}
+		r.ParseForm() // nolint: errcheck
+		source := "deviceName" + r.Form.Get("deviceName") + "productKey" + r.Form.Get("productKey") + "random" + r.Form.Get("random")
+		if r.URL.Path != "/auth/register/device" || infra.Hmac(r.Form.Get("signMethod"), "ps", source) != r.Form.Get("sign") {
+			rsp = dynamic.Response{Code: infra.CodeRequestError, Message: "invalid sign"}
+		}
+		json.NewEncoder(w).Encode(rsp) // nolint: errcheck
+	}))
+	defer srv.Close()
+
+	base := credentials.Static(credentials.Credentials{
+		MetaTetrad: infra.MetaTetrad{ProductKey: "pk", ProductSecret: "ps", DeviceName: "dn"},
+		Region:     infra.CloudRegionDomain{Region: infra.CloudRegionCustom, CustomDomain: srv.URL},
+	})
+	cache := filepath.Join(t.TempDir(), "secret.json")
+	for i := 0; i < 2; i++ {
+		c, err := credentials.Retrieve(DynamicCloud(dynamic.New(), base, cache))
+		require.NoError(t, err)
+		require.Equal(t, "ds", c.DeviceSecret)
+	}
+	require.Equal(t, 1, registered)
+}
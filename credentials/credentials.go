@@ -0,0 +1,109 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package credentials 设备身份凭证的提供者, 统一从静态配置, 环境变量,
+// 预置的JSON/YAML凭证文件及一型一密动态注册获取设备身份, 地域及CA证书
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// 错误定义
+var (
+	ErrIncomplete    = errors.New("credentials: incomplete identity")
+	ErrUnknownRegion = errors.New("credentials: unknown region")
+)
+
+// Credentials 设备身份凭证
+type Credentials struct {
+	infra.MetaTetrad
+	Region infra.CloudRegionDomain // 云端地域
+	CACert []byte                  // CA证书(PEM), 为空表示使用默认
+	// X.509证书认证的设备证书及私钥(PEM), 设置时DeviceSecret可以为空
+	ClientCert []byte
+	ClientKey  []byte
+}
+
+// X509 是否使用X.509证书认证
+func (sf Credentials) X509() bool {
+	return len(sf.ClientCert) > 0 && len(sf.ClientKey) > 0
+}
+
+// Triad 设备三元组
+func (sf Credentials) Triad() infra.MetaTriad {
+	return infra.MetaTriad{
+		ProductKey:   sf.ProductKey,
+		DeviceName:   sf.DeviceName,
+		DeviceSecret: sf.DeviceSecret,
+	}
+}
+
+// Validate 校验设备三元组是否完整, X.509证书认证时不需要DeviceSecret
+func (sf Credentials) Validate() error {
+	if sf.ProductKey == "" || sf.DeviceName == "" || (sf.DeviceSecret == "" && !sf.X509()) {
+		return fmt.Errorf("%w: %s", ErrIncomplete, infra.ClientID(sf.ProductKey, sf.DeviceName))
+	}
+	if sf.Region.Region == infra.CloudRegionCustom && sf.Region.CustomDomain == "" {
+		return fmt.Errorf("%w: empty custom domain", ErrUnknownRegion)
+	}
+	return nil
+}
+
+// Provider 设备身份凭证的提供者
+type Provider interface {
+	// Retrieve 获取设备身份凭证
+	Retrieve() (Credentials, error)
+}
+
+// ProviderFunc 凭证提供者函数适配
+type ProviderFunc func() (Credentials, error)
+
+// Retrieve 实现 Provider 接口
+func (f ProviderFunc) Retrieve() (Credentials, error) { return f() }
+
+// Static 静态的设备身份凭证
+func Static(c Credentials) Provider {
+	return ProviderFunc(func() (Credentials, error) { return c, nil })
+}
+
+// Retrieve 从提供者获取并校验设备身份凭证
+func Retrieve(p Provider) (Credentials, error) {
+	c, err := p.Retrieve()
+	if err != nil {
+		return Credentials{}, err
+	}
+	if err = c.Validate(); err != nil {
+		return Credentials{}, err
+	}
+	return c, nil
+}
+
+// 地域名称
+var regions = map[string]infra.CloudRegion{
+	"":               infra.CloudRegionShangHai,
+	"cn-shanghai":    infra.CloudRegionShangHai,
+	"ap-southeast-1": infra.CloudRegionSingapore,
+	"ap-northeast-1": infra.CloudRegionJapan,
+	"us-west-1":      infra.CloudRegionAmerica,
+	"eu-central-1":   infra.CloudRegionGermany,
+	"custom":         infra.CloudRegionCustom,
+}
+
+// ParseRegion 解析地域, 支持 cn-shanghai(默认), ap-southeast-1, ap-northeast-1, us-west-1, eu-central-1,
+// 设置了customDomain时为自定义域名
+func ParseRegion(region, customDomain string) (infra.CloudRegionDomain, error) {
+	if customDomain != "" {
+		return infra.CloudRegionDomain{Region: infra.CloudRegionCustom, CustomDomain: customDomain}, nil
+	}
+	r, ok := regions[strings.ToLower(region)]
+	if !ok || r == infra.CloudRegionCustom {
+		return infra.CloudRegionDomain{}, fmt.Errorf("%w: %q", ErrUnknownRegion, region)
+	}
+	return infra.CloudRegionDomain{Region: r}, nil
+}
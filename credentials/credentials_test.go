@@ -0,0 +1,186 @@
+package credentials
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+const testCA = "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+
+func TestStatic(t *testing.T) {
+	_, err := Retrieve(Static(Credentials{MetaTetrad: infra.MetaTetrad{ProductKey: "pk", DeviceName: "dn"}}))
+	require.True(t, errors.Is(err, ErrIncomplete))
+
+	want := Credentials{MetaTetrad: infra.MetaTetrad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"}}
+	c, err := Retrieve(Static(want))
+	require.NoError(t, err)
+	require.Equal(t, want, c)
+	require.Equal(t, infra.MetaTriad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"}, c.Triad())
+}
+
+func TestParseRegion(t *testing.T) {
+	crd, err := ParseRegion("", "")
+	require.NoError(t, err)
+	require.Equal(t, infra.CloudRegionShangHai, crd.Region)
+	crd, err = ParseRegion("AP-Northeast-1", "")
+	require.NoError(t, err)
+	require.Equal(t, infra.CloudRegionJapan, crd.Region)
+	crd, err = ParseRegion("", "iot.example.com:1883")
+	require.NoError(t, err)
+	require.Equal(t, infra.CloudRegionDomain{Region: infra.CloudRegionCustom, CustomDomain: "iot.example.com:1883"}, crd)
+	_, err = ParseRegion("custom", "")
+	require.True(t, errors.Is(err, ErrUnknownRegion))
+	_, err = ParseRegion("mars-1", "")
+	require.True(t, errors.Is(err, ErrUnknownRegion))
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ca.crt"), []byte(testCA), 0600))
+
+	jsonFile := filepath.Join(dir, "device.json")
+	require.NoError(t, ioutil.WriteFile(jsonFile, []byte(`{
+		"productKey": "pk",
+		"deviceName": "dn",
+		"deviceSecret": "ds",
+		"region": "us-west-1",
+		"caCert": "ca.crt"
+	}`), 0600))
+	c, err := Retrieve(File(jsonFile))
+	require.NoError(t, err)
+	require.Equal(t, infra.MetaTriad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"}, c.Triad())
+	require.Equal(t, infra.CloudRegionAmerica, c.Region.Region)
+	require.Equal(t, testCA, string(c.CACert))
+
+	yamlFile := filepath.Join(dir, "device.yaml")
+	require.NoError(t, ioutil.WriteFile(yamlFile, []byte(`
+productKey: pk
+productSecret: ps
+deviceName: dn
+customDomain: iot.example.com
+caCert: |
+  -----BEGIN CERTIFICATE-----
+  MIIB
+  -----END CERTIFICATE-----
+`), 0600))
+	c, err = File(yamlFile).Retrieve()
+	require.NoError(t, err)
+	require.Equal(t, "ps", c.ProductSecret)
+	require.Equal(t, infra.CloudRegionDomain{Region: infra.CloudRegionCustom, CustomDomain: "iot.example.com"}, c.Region)
+	require.Equal(t, testCA, string(c.CACert))
+	_, err = Retrieve(File(yamlFile))
+	require.True(t, errors.Is(err, ErrIncomplete))
+
+	x509File := filepath.Join(dir, "x509.json")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "device.key"), []byte("key"), 0600))
+	require.NoError(t, ioutil.WriteFile(x509File, []byte(`{
+		"productKey": "pk",
+		"deviceName": "dn",
+		"clientCert": "base64://Y2VydA==",
+		"clientKey": "device.key"
+	}`), 0600))
+	c, err = Retrieve(File(x509File))
+	require.NoError(t, err)
+	require.True(t, c.X509())
+	require.Equal(t, "cert", string(c.ClientCert))
+	require.Equal(t, "key", string(c.ClientKey))
+
+	_, err = File(filepath.Join(dir, "missing.json")).Retrieve()
+	require.Error(t, err)
+}
+
+func TestEnv(t *testing.T) {
+	for k, v := range map[string]string{
+		"TEST_AIOT_PRODUCT_KEY":   "pk",
+		"TEST_AIOT_DEVICE_NAME":   "dn",
+		"TEST_AIOT_DEVICE_SECRET": "ds",
+		"TEST_AIOT_REGION":        "eu-central-1",
+	} {
+		require.NoError(t, os.Setenv(k, v))
+		defer os.Unsetenv(k) // nolint: errcheck
+	}
+	c, err := Retrieve(Env("TEST_AIOT_"))
+	require.NoError(t, err)
+	require.Equal(t, infra.MetaTriad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"}, c.Triad())
+	require.Equal(t, infra.CloudRegionGermany, c.Region.Region)
+}
+
+func TestDynamic(t *testing.T) {
+	cache := filepath.Join(t.TempDir(), "secret.json")
+	base := Static(Credentials{MetaTetrad: infra.MetaTetrad{ProductKey: "pk", ProductSecret: "ps", DeviceName: "dn"}})
+	registered := 0
+	register := func(meta *infra.MetaTetrad, crd infra.CloudRegionDomain) error {
+		registered++
+		if meta.ProductSecret != "ps" {
+			return errors.New("invalid product secret")
+		}
+		meta.DeviceSecret = "ds"
+		return nil
+	}
+
+	p := Dynamic(base, register, cache)
+	for i := 0; i < 2; i++ {
+		c, err := Retrieve(p)
+		require.NoError(t, err)
+		require.Equal(t, "ds", c.DeviceSecret)
+	}
+	require.Equal(t, 1, registered)
+	fi, err := os.Stat(cache)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// 重启后使用缓存, 不再注册
+	c, err := Retrieve(Dynamic(base, register, cache))
+	require.NoError(t, err)
+	require.Equal(t, "ds", c.DeviceSecret)
+	require.Equal(t, 1, registered)
+
+	// 缓存不属于该设备时重新注册
+	other := Static(Credentials{MetaTetrad: infra.MetaTetrad{ProductKey: "pk", ProductSecret: "bad", DeviceName: "other"}})
+	_, err = Retrieve(Dynamic(other, register, cache))
+	require.Error(t, err)
+	require.Equal(t, 2, registered)
+
+	// base切换设备身份后不使用原设备的DeviceSecret
+	dn := "dn"
+	switched := ProviderFunc(func() (Credentials, error) {
+		return Credentials{MetaTetrad: infra.MetaTetrad{ProductKey: "pk", ProductSecret: "ps", DeviceName: dn}}, nil
+	})
+	p = Dynamic(switched, func(meta *infra.MetaTetrad, crd infra.CloudRegionDomain) error {
+		registered++
+		meta.DeviceSecret = "ds-" + meta.DeviceName
+		return nil
+	}, "")
+	c, err = Retrieve(p)
+	require.NoError(t, err)
+	require.Equal(t, "ds-dn", c.DeviceSecret)
+	dn = "dn2"
+	c, err = Retrieve(p)
+	require.NoError(t, err)
+	require.Equal(t, "ds-dn2", c.DeviceSecret)
+	require.Equal(t, 4, registered)
+
+	// 已提供DeviceSecret时不注册
+	c, err = Retrieve(Dynamic(Static(Credentials{MetaTetrad: infra.MetaTetrad{
+		ProductKey: "pk", DeviceName: "dn", DeviceSecret: "given",
+	}}), register, ""))
+	require.NoError(t, err)
+	require.Equal(t, "given", c.DeviceSecret)
+	require.Equal(t, 4, registered)
+
+	// X.509证书认证时不注册
+	_, err = Retrieve(Dynamic(Static(Credentials{
+		MetaTetrad: infra.MetaTetrad{ProductKey: "pk", DeviceName: "dn"},
+		ClientCert: []byte("cert"),
+		ClientKey:  []byte("key"),
+	}), register, ""))
+	require.NoError(t, err)
+	require.Equal(t, 4, registered)
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package credentials
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// RegisterFunc 一型一密动态注册, 成功时设置meta的DeviceSecret, 如 dynamic.Client.RegisterCloud
+type RegisterFunc func(meta *infra.MetaTetrad, crd infra.CloudRegionDomain) error
+
+// cacheEntry 动态注册获得的DeviceSecret的缓存
+type cacheEntry struct {
+	ProductKey   string `json:"productKey"`
+	DeviceName   string `json:"deviceName"`
+	DeviceSecret string `json:"deviceSecret"`
+}
+
+type dynamicProvider struct {
+	base      Provider
+	register  RegisterFunc
+	cachePath string
+
+	mu     sync.Mutex
+	secret cacheEntry
+}
+
+// Dynamic 一型一密动态注册获取设备身份凭证, base提供ProductKey,ProductSecret,DeviceName及地域.
+// base已提供DeviceSecret或X.509证书时直接使用, 否则先查找cachePath中缓存的DeviceSecret,
+// 没有缓存时才动态注册, 并将获得的DeviceSecret缓存到cachePath, cachePath为空表示只缓存在内存.
+// NOTE: 阿里云限制激活过的设备不可再注册, 需妥善保存缓存文件
+func Dynamic(base Provider, register RegisterFunc, cachePath string) Provider {
+	return &dynamicProvider{base: base, register: register, cachePath: cachePath}
+}
+
+// Retrieve 实现 Provider 接口
+func (sf *dynamicProvider) Retrieve() (Credentials, error) {
+	c, err := sf.base.Retrieve()
+	if err != nil || c.DeviceSecret != "" || c.X509() {
+		return c, err
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	// 缓存只属于获取它的设备, base切换设备身份后需重新查找或注册
+	if sf.secret.ProductKey != c.ProductKey || sf.secret.DeviceName != c.DeviceName {
+		sf.secret = cacheEntry{c.ProductKey, c.DeviceName, sf.loadCache(c.ProductKey, c.DeviceName)}
+	}
+	if sf.secret.DeviceSecret == "" {
+		meta := c.MetaTetrad
+		if err = sf.register(&meta, c.Region); err != nil {
+			return Credentials{}, err
+		}
+		if meta.DeviceSecret == "" {
+			return Credentials{}, ErrIncomplete
+		}
+		if err = sf.storeCache(meta); err != nil {
+			return Credentials{}, err
+		}
+		sf.secret.DeviceSecret = meta.DeviceSecret
+	}
+	c.DeviceSecret = sf.secret.DeviceSecret
+	return c, nil
+}
+
+// loadCache 读取缓存的DeviceSecret, 缓存不存在或不属于该设备时返回空
+func (sf *dynamicProvider) loadCache(pk, dn string) string {
+	if sf.cachePath == "" {
+		return ""
+	}
+	b, err := ioutil.ReadFile(sf.cachePath)
+	if err != nil {
+		return ""
+	}
+	var entry cacheEntry
+	if json.Unmarshal(b, &entry) != nil || entry.ProductKey != pk || entry.DeviceName != dn {
+		return ""
+	}
+	return entry.DeviceSecret
+}
+
+// storeCache 原子地写入缓存, 文件权限0600
+func (sf *dynamicProvider) storeCache(meta infra.MetaTetrad) error {
+	if sf.cachePath == "" {
+		return nil
+	}
+	b, err := json.Marshal(cacheEntry{meta.ProductKey, meta.DeviceName, meta.DeviceSecret})
+	if err != nil {
+		return err
+	}
+	tmp := sf.cachePath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmp) // nolint: errcheck
+		return err
+	}
+	return os.Rename(tmp, sf.cachePath)
+}
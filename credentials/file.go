@@ -0,0 +1,135 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package credentials
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// Bundle 预置的凭证文件格式, 如:
+//
+//	{
+//	  "productKey": "a1xxx",
+//	  "productSecret": "",
+//	  "deviceName": "dev1",
+//	  "deviceSecret": "xxx",
+//	  "region": "cn-shanghai",
+//	  "customDomain": "",
+//	  "caCert": "ca.crt",
+//	  "clientCert": "",
+//	  "clientKey": ""
+//	}
+//
+// caCert 可以是PEM文本, "base64://"前缀的PEM, 或CA证书文件路径(相对路径相对凭证文件所在目录),
+// clientCert, clientKey 为X.509证书认证的设备证书及私钥, 格式同caCert
+type Bundle struct {
+	ProductKey    string `json:"productKey" yaml:"productKey"`
+	ProductSecret string `json:"productSecret,omitempty" yaml:"productSecret,omitempty"`
+	DeviceName    string `json:"deviceName" yaml:"deviceName"`
+	DeviceSecret  string `json:"deviceSecret,omitempty" yaml:"deviceSecret,omitempty"`
+	Region        string `json:"region,omitempty" yaml:"region,omitempty"`
+	CustomDomain  string `json:"customDomain,omitempty" yaml:"customDomain,omitempty"`
+	CACert        string `json:"caCert,omitempty" yaml:"caCert,omitempty"`
+	ClientCert    string `json:"clientCert,omitempty" yaml:"clientCert,omitempty"`
+	ClientKey     string `json:"clientKey,omitempty" yaml:"clientKey,omitempty"`
+}
+
+// Credentials 转换为设备身份凭证, dir为加载相对路径的证书文件的目录
+func (sf Bundle) Credentials(dir string) (Credentials, error) {
+	crd, err := ParseRegion(sf.Region, sf.CustomDomain)
+	if err != nil {
+		return Credentials{}, err
+	}
+	c := Credentials{
+		MetaTetrad: infra.MetaTetrad{
+			ProductKey:    sf.ProductKey,
+			ProductSecret: sf.ProductSecret,
+			DeviceName:    sf.DeviceName,
+			DeviceSecret:  sf.DeviceSecret,
+		},
+		Region: crd,
+	}
+	if c.CACert, err = loadPEM(sf.CACert, dir); err != nil {
+		return Credentials{}, err
+	}
+	if c.ClientCert, err = loadPEM(sf.ClientCert, dir); err != nil {
+		return Credentials{}, err
+	}
+	if c.ClientKey, err = loadPEM(sf.ClientKey, dir); err != nil {
+		return Credentials{}, err
+	}
+	return c, nil
+}
+
+// loadPEM 加载PEM文本, "base64://"前缀的PEM或PEM文件
+func loadPEM(v, dir string) ([]byte, error) {
+	switch {
+	case v == "":
+		return nil, nil
+	case strings.HasPrefix(strings.TrimSpace(v), "-----BEGIN"):
+		return []byte(v), nil
+	case !strings.HasPrefix(v, "base64://") && !filepath.IsAbs(v) && dir != "":
+		v = filepath.Join(dir, v)
+	}
+	return infra.LoadCrt(v)
+}
+
+// File 预置的凭证文件, 扩展名为 .yaml 或 .yml 时按YAML解析, 否则按JSON解析,
+// 每次获取时重新读取文件
+func File(path string) Provider {
+	return ProviderFunc(func() (Credentials, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return Credentials{}, err
+		}
+		var bundle Bundle
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(b, &bundle)
+		default:
+			err = json.Unmarshal(b, &bundle)
+		}
+		if err != nil {
+			return Credentials{}, err
+		}
+		return bundle.Credentials(filepath.Dir(path))
+	})
+}
+
+// DefaultEnvPrefix 默认环境变量前缀
+const DefaultEnvPrefix = "AIOT_"
+
+// Env 从环境变量获取设备身份凭证, prefix为空时使用 DefaultEnvPrefix. 环境变量:
+//
+//	{prefix}PRODUCT_KEY, {prefix}PRODUCT_SECRET, {prefix}DEVICE_NAME, {prefix}DEVICE_SECRET,
+//	{prefix}REGION, {prefix}CUSTOM_DOMAIN, {prefix}CA_CERT, {prefix}CLIENT_CERT, {prefix}CLIENT_KEY
+//
+// CA_CERT, CLIENT_CERT, CLIENT_KEY 同 Bundle, 相对路径相对当前工作目录
+func Env(prefix string) Provider {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	return ProviderFunc(func() (Credentials, error) {
+		return Bundle{
+			ProductKey:    os.Getenv(prefix + "PRODUCT_KEY"),
+			ProductSecret: os.Getenv(prefix + "PRODUCT_SECRET"),
+			DeviceName:    os.Getenv(prefix + "DEVICE_NAME"),
+			DeviceSecret:  os.Getenv(prefix + "DEVICE_SECRET"),
+			Region:        os.Getenv(prefix + "REGION"),
+			CustomDomain:  os.Getenv(prefix + "CUSTOM_DOMAIN"),
+			CACert:        os.Getenv(prefix + "CA_CERT"),
+			ClientCert:    os.Getenv(prefix + "CLIENT_CERT"),
+			ClientKey:     os.Getenv(prefix + "CLIENT_KEY"),
+		}.Credentials("")
+	})
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package devstore 子设备持久化存储的实现
+package devstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	aiot "github.com/things-go/aliyun-iot"
+)
+
+// 错误定义
+var (
+	ErrInvalidKey = errors.New("devstore: key length must be 16, 24 or 32")
+	ErrCorrupted  = errors.New("devstore: corrupted file")
+)
+
+// Option 选项
+type Option func(*File)
+
+// WithAESGCM 使用AES-GCM加密存储,key长度为16,24或32字节,分别对应AES-128,AES-192,AES-256
+func WithAESGCM(key []byte) Option {
+	return func(f *File) {
+		f.key = append([]byte(nil), key...)
+	}
+}
+
+// WithPerm 设置文件权限,默认0600
+func WithPerm(perm os.FileMode) Option {
+	return func(f *File) {
+		f.perm = perm
+	}
+}
+
+// File 以json文件存储子设备记录,每次写入均完整地原子替换文件, 实现 aiot.DevStore 接口
+type File struct {
+	name string
+	key  []byte
+	perm os.FileMode
+
+	mu      sync.Mutex
+	records map[string]aiot.DevRecord
+}
+
+var _ aiot.DevStore = (*File)(nil)
+
+// NewFile 创建文件存储
+func NewFile(name string, opts ...Option) (*File, error) {
+	f := &File{
+		name:    name,
+		perm:    0600,
+		records: make(map[string]aiot.DevRecord),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.key != nil {
+		switch len(f.key) {
+		case 16, 24, 32:
+		default:
+			return nil, ErrInvalidKey
+		}
+	}
+	return f, nil
+}
+
+// NewEncryptedFile 创建AES-GCM加密的文件存储
+func NewEncryptedFile(name string, key []byte, opts ...Option) (*File, error) {
+	return NewFile(name, append(opts, WithAESGCM(key))...)
+}
+
+// Load 实现 aiot.DevStore 接口, 文件不存在时返回空记录
+func (sf *File) Load() ([]aiot.DevRecord, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	b, err := ioutil.ReadFile(sf.name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if sf.key != nil {
+		if b, err = sf.decrypt(b); err != nil {
+			return nil, err
+		}
+	}
+	var recs []aiot.DevRecord
+	if err = json.Unmarshal(b, &recs); err != nil {
+		return nil, err
+	}
+	sf.records = make(map[string]aiot.DevRecord, len(recs))
+	for _, rec := range recs {
+		sf.records[aiot.FormatKey(rec.ProductKey, rec.DeviceName)] = rec
+	}
+	return recs, nil
+}
+
+// Put 实现 aiot.DevStore 接口
+func (sf *File) Put(rec aiot.DevRecord) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.records[aiot.FormatKey(rec.ProductKey, rec.DeviceName)] = rec
+	return sf.saveLocked()
+}
+
+// Delete 实现 aiot.DevStore 接口
+func (sf *File) Delete(pk, dn string) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	key := aiot.FormatKey(pk, dn)
+	if _, ok := sf.records[key]; !ok {
+		return nil
+	}
+	delete(sf.records, key)
+	return sf.saveLocked()
+}
+
+// saveLocked 原子地写入文件
+func (sf *File) saveLocked() error {
+	recs := make([]aiot.DevRecord, 0, len(sf.records))
+	for _, rec := range sf.records {
+		recs = append(recs, rec)
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		return aiot.FormatKey(recs[i].ProductKey, recs[i].DeviceName) <
+			aiot.FormatKey(recs[j].ProductKey, recs[j].DeviceName)
+	})
+	b, err := json.Marshal(recs)
+	if err != nil {
+		return err
+	}
+	if sf.key != nil {
+		if b, err = sf.encrypt(b); err != nil {
+			return err
+		}
+	}
+
+	tmp := sf.name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, sf.perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmp) // nolint: errcheck
+		return err
+	}
+	return os.Rename(tmp, sf.name)
+}
+
+// encrypt 加密, 格式为: | nonce | ciphertext |
+func (sf *File) encrypt(plain []byte) ([]byte, error) {
+	aead, err := sf.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (sf *File) decrypt(b []byte) ([]byte, error) {
+	aead, err := sf.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, ErrCorrupted
+	}
+	plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	return plain, nil
+}
+
+func (sf *File) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(sf.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
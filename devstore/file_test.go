@@ -0,0 +1,106 @@
+package devstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+var root = infra.MetaTriad{ProductKey: "gpk", DeviceName: "gdn", DeviceSecret: "gds"}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "devstore")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) }) // nolint: errcheck
+	return dir
+}
+
+func TestFile(t *testing.T) {
+	name := filepath.Join(tempDir(t), "devices.json")
+	store, err := NewFile(name)
+	require.NoError(t, err)
+
+	mgr, err := aiot.NewDevMgrWithStore(root, store)
+	require.NoError(t, err)
+	require.NoError(t, mgr.Add(infra.MetaTriad{ProductKey: "pk", DeviceName: "a"}))
+	require.NoError(t, mgr.Add(infra.MetaTriad{ProductKey: "pk", DeviceName: "b", DeviceSecret: "bs"}))
+	require.NoError(t, mgr.SetDeviceSecret("pk", "a", "as"))
+	require.NoError(t, mgr.SetDeviceAvail("pk", "b", false))
+	require.NoError(t, mgr.SetDeviceStatus("pk", "a", aiot.DevStatusOnline))
+	require.NoError(t, mgr.Add(infra.MetaTriad{ProductKey: "pk", DeviceName: "c"}))
+	require.NoError(t, mgr.Delete("pk", "c"))
+
+	// 重启后加载
+	store, err = NewFile(name)
+	require.NoError(t, err)
+	mgr, err = aiot.NewDevMgrWithStore(root, store)
+	require.NoError(t, err)
+	require.Equal(t, 3, mgr.Len())
+
+	node, err := mgr.Search("pk", "a")
+	require.NoError(t, err)
+	require.Equal(t, "as", node.DeviceSecret())
+	require.Equal(t, aiot.DevStatusAttached, node.Status()) // 会话不保留
+	node, err = mgr.Search("pk", "b")
+	require.NoError(t, err)
+	require.Equal(t, "bs", node.DeviceSecret())
+	require.False(t, node.Avail())
+	_, err = mgr.Search("pk", "c")
+	require.Equal(t, aiot.ErrNotFound, err)
+
+	b, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	var recs []aiot.DevRecord
+	require.NoError(t, json.Unmarshal(b, &recs))
+	require.Len(t, recs, 2)
+	_, err = os.Stat(name + ".tmp")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestEncryptedFile(t *testing.T) {
+	name := filepath.Join(tempDir(t), "devices.bin")
+	key := bytes.Repeat([]byte{0x5a}, 32)
+	store, err := NewEncryptedFile(name, key)
+	require.NoError(t, err)
+
+	mgr, err := aiot.NewDevMgrWithStore(root, store)
+	require.NoError(t, err)
+	require.NoError(t, mgr.Add(infra.MetaTriad{ProductKey: "pk", DeviceName: "a", DeviceSecret: "secret-a"}))
+
+	b, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(b, []byte("secret-a")))
+
+	store, err = NewEncryptedFile(name, key)
+	require.NoError(t, err)
+	mgr, err = aiot.NewDevMgrWithStore(root, store)
+	require.NoError(t, err)
+	ds, err := mgr.DeviceSecret("pk", "a")
+	require.NoError(t, err)
+	require.Equal(t, "secret-a", ds)
+
+	// 密钥错误
+	store, err = NewEncryptedFile(name, bytes.Repeat([]byte{0x11}, 32))
+	require.NoError(t, err)
+	_, err = aiot.NewDevMgrWithStore(root, store)
+	require.Equal(t, ErrCorrupted, err)
+
+	_, err = NewEncryptedFile(name, []byte("short"))
+	require.Equal(t, ErrInvalidKey, err)
+}
+
+func TestFileNotExist(t *testing.T) {
+	store, err := NewFile(filepath.Join(tempDir(t), "none.json"))
+	require.NoError(t, err)
+	recs, err := store.Load()
+	require.NoError(t, err)
+	require.Empty(t, recs)
+}
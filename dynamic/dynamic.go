@@ -2,17 +2,21 @@
 // Use of this source code is governed by a MIT style
 // license that can be found in the LICENSE file.
 
-// Package dynamic 实现动态注册,只限直连设备动态注册,阿里云目前限制激活过的设备不可再注册
+// Package dynamic 实现动态注册,只限直连设备动态注册,阿里云目前限制激活过的设备不可再注册,
+// 支持HTTPS动态注册(RegisterCloud)及MQTT动态注册(RegisterMQTT),MQTT动态注册支持免预注册,
+// 不支持X.509证书认证的设备
 package dynamic
 
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/things-go/aliyun-iot/infra"
 )
@@ -24,6 +28,9 @@ const (
 	hmacMD5    = "hmacmd5"
 )
 
+// DefaultTimeout 动态注册的默认超时时间
+const DefaultTimeout = 10 * time.Second
+
 // Option option
 type Option func(*Client)
 
@@ -34,15 +41,42 @@ func WithHTTPClient(c *http.Client) Option {
 	}
 }
 
+// WithTLSConfig 设置MQTT动态注册使用的tls配置
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(client *Client) {
+		client.tlsConfig = cfg
+	}
+}
+
+// WithBroker 设置MQTT动态注册的broker地址,如 tls://host:port, 覆盖根据region生成的地址
+func WithBroker(addr string) Option {
+	return func(client *Client) {
+		client.broker = addr
+	}
+}
+
+// WithTimeout 设置动态注册的超时时间, 默认 DefaultTimeout, 与调用时ctx的期限取较早者
+func WithTimeout(t time.Duration) Option {
+	return func(client *Client) {
+		if t > 0 {
+			client.timeout = t
+		}
+	}
+}
+
 // Client dynamic client
 type Client struct {
-	httpc *http.Client
+	httpc     *http.Client
+	tlsConfig *tls.Config
+	broker    string
+	timeout   time.Duration
 }
 
 // New new a dynamic client
 func New(opts ...Option) *Client {
 	c := &Client{
-		http.DefaultClient,
+		httpc:   http.DefaultClient,
+		timeout: DefaultTimeout,
 	}
 
 	for _, opt := range opts {
@@ -75,15 +109,18 @@ func (sf *Client) RegisterCloud(meta *infra.MetaTetrad, crd infra.CloudRegionDom
 		if crd.CustomDomain == "" {
 			return errors.New("invalid custom domain")
 		}
-		if !strings.Contains(crd.CustomDomain, "://") {
-			domain = "http://" + crd.CustomDomain
+		domain = crd.CustomDomain
+		if !strings.Contains(domain, "://") {
+			domain = "http://" + domain
 		}
 	} else {
 		domain = "https://" + infra.HTTPCloudDomain[crd.Region]
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), sf.timeout)
+	defer cancel()
 	requestBody := requestBody(meta, signMethods...)
-	request, err := http.NewRequestWithContext(context.Background(), http.MethodPost,
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost,
 		domain+"/auth/register/device", bytes.NewBufferString(requestBody))
 	if err != nil {
 		return err
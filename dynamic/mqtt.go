@@ -0,0 +1,150 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dynamic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/sign"
+)
+
+// MQTT动态注册时云端下发凭证的主题, 无需订阅
+const (
+	TopicRegister = "/ext/register" // 预注册, 下发DeviceSecret
+	TopicRegNwl   = "/ext/regnwl"   // 免预注册, 下发clientId和deviceToken
+)
+
+// ErrInvalidCredential 云端下发的凭证无效
+var ErrInvalidCredential = errors.New("invalid credential")
+
+// Credential MQTT动态注册获得的凭证
+type Credential struct {
+	ProductKey   string `json:"productKey"`
+	DeviceName   string `json:"deviceName"`
+	DeviceSecret string `json:"deviceSecret,omitempty"` // 预注册时下发
+	ClientID     string `json:"clientId,omitempty"`     // 免预注册时下发
+	DeviceToken  string `json:"deviceToken,omitempty"`  // 免预注册时下发
+}
+
+// NoPreRegistration 是否为免预注册方式获得的凭证
+func (sf *Credential) NoPreRegistration() bool {
+	return sf.DeviceToken != ""
+}
+
+// Triad 登录使用的三元组, 免预注册时DeviceSecret为空
+func (sf *Credential) Triad() infra.MetaTriad {
+	return infra.MetaTriad{
+		ProductKey:   sf.ProductKey,
+		DeviceName:   sf.DeviceName,
+		DeviceSecret: sf.DeviceSecret,
+	}
+}
+
+// SignOptions 使用凭证登录时 sign.Generate 需要的选项,
+// 免预注册时为 SecureModeNoPreRegistration 及下发的clientId和deviceToken
+func (sf *Credential) SignOptions() []sign.Option {
+	if !sf.NoPreRegistration() {
+		return nil
+	}
+	return []sign.Option{
+		sign.WithSecureMode(sign.SecureModeNoPreRegistration),
+		sign.WithClientID(sf.ClientID),
+		sign.WithDeviceToken(sf.DeviceToken),
+	}
+}
+
+// ParseCredential 解析云端在 TopicRegister 或 TopicRegNwl 下发的凭证
+func ParseCredential(topic string, payload []byte) (*Credential, error) {
+	cred := &Credential{}
+	if err := json.Unmarshal(payload, cred); err != nil {
+		return nil, err
+	}
+	if cred.ProductKey == "" || cred.DeviceName == "" {
+		return nil, ErrInvalidCredential
+	}
+	switch topic {
+	case TopicRegister:
+		if cred.DeviceSecret == "" {
+			return nil, ErrInvalidCredential
+		}
+	case TopicRegNwl:
+		if cred.ClientID == "" || cred.DeviceToken == "" {
+			return nil, ErrInvalidCredential
+		}
+	default:
+		return nil, ErrInvalidCredential
+	}
+	return cred, nil
+}
+
+// RegisterMQTT 一型一密MQTT动态注册,传入四元组,根据ProductKey,ProductSecret和deviceName获得凭证,
+// 注册完成后断开连接, 使用 Credential.Triad 和 Credential.SignOptions 调用 sign.Generate 正常登录.
+// meta: 预注册时成功将直接修改meta的DeviceSecret
+// crd: 指定注册的云端
+// opts: sign.GenerateRegister 的选项, 默认为预注册方式,
+// 使用 sign.WithSecureMode(sign.SecureModeNoPreRegistration) 时为免预注册方式, 设备名称无需在平台预注册
+// ctx 未设置期限或期限更晚时, 超时时间为 WithTimeout 设置的时间
+// NOTE: 阿里云目前限制激活过的设备不可再注册
+func (sf *Client) RegisterMQTT(ctx context.Context, meta *infra.MetaTetrad,
+	crd infra.CloudRegionDomain, opts ...sign.Option) (*Credential, error) {
+	if meta == nil {
+		return nil, errors.New("invalid parameter")
+	}
+	signs, err := sign.GenerateRegister(*meta, crd, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, sf.timeout)
+	defer cancel()
+	broker := signs.Addr
+	if sf.broker != "" {
+		broker = sf.broker
+	}
+
+	msgs := make(chan mqtt.Message, 1)
+	cli := mqtt.NewClient(mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(signs.ClientIDWithExt()).
+		SetUsername(signs.UserName).
+		SetPassword(signs.Password).
+		SetCleanSession(true).
+		SetAutoReconnect(false).
+		SetTLSConfig(sf.tlsConfig).
+		SetDefaultPublishHandler(func(_ mqtt.Client, msg mqtt.Message) {
+			select {
+			case msgs <- msg:
+			default:
+			}
+		}))
+	token := cli.Connect()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-token.Done():
+	}
+	if err = token.Error(); err != nil {
+		return nil, err
+	}
+	defer cli.Disconnect(250)
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case msg := <-msgs:
+		cred, err := ParseCredential(msg.Topic(), msg.Payload())
+		if err != nil {
+			return nil, err
+		}
+		if !cred.NoPreRegistration() {
+			meta.DeviceSecret = cred.DeviceSecret
+		}
+		return cred, nil
+	}
+}
@@ -0,0 +1,219 @@
+package dynamic
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/sign"
+)
+
+// connectPacket MQTT CONNECT报文中的认证信息
+type connectPacket struct {
+	clientID, username, password string
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n, shift uint
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= uint(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return typ, body, err
+}
+
+func readString(b []byte) (string, []byte) {
+	n := int(binary.BigEndian.Uint16(b))
+	return string(b[2 : 2+n]), b[2+n:]
+}
+
+func parseConnect(body []byte) connectPacket {
+	_, body = readString(body) // protocol name
+	flags := body[1]
+	body = body[4:] // level, flags, keepalive
+	var p connectPacket
+	p.clientID, body = readString(body)
+	if flags&0x80 != 0 {
+		p.username, body = readString(body)
+	}
+	if flags&0x40 != 0 {
+		p.password, _ = readString(body)
+	}
+	return p
+}
+
+func publishPacket(topic string, payload []byte) []byte {
+	n := 2 + len(topic) + len(payload)
+	pkt := []byte{0x30}
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+	pkt = append(pkt, byte(len(topic)>>8), byte(len(topic)))
+	pkt = append(pkt, topic...)
+	return append(pkt, payload...)
+}
+
+// fakeBroker 模拟云端, 校验注册签名后下发凭证
+// 返回broker地址及收到的CONNECT报文
+func fakeBroker(t *testing.T, productSecret, topic string, reply interface{}) (string, <-chan connectPacket) {
+	connects := make(chan connectPacket, 1)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				typ, body, err := readPacket(r)
+				if err != nil || typ>>4 != 1 {
+					return
+				}
+				p := parseConnect(body)
+				select {
+				case connects <- p:
+				default:
+				}
+				ext := p.clientID[strings.Index(p.clientID, "|"):]
+				i := strings.Index(ext, "random=")
+				random := ext[i+len("random=") : i+len("random=")+16]
+				pkdn := strings.SplitN(p.username, "&", 2)
+				source := "deviceName" + pkdn[0] + "productKey" + pkdn[1] + "random" + random
+				if infra.Hmac("hmacsha256", productSecret, source) != p.password {
+					conn.Write([]byte{0x20, 0x02, 0x00, 0x04}) // nolint: errcheck
+					return
+				}
+				conn.Write([]byte{0x20, 0x02, 0x00, 0x00}) // nolint: errcheck
+				payload, _ := json.Marshal(reply)
+				conn.Write(publishPacket(topic, payload)) // nolint: errcheck
+				for {
+					if _, _, err = readPacket(r); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return "tcp://" + l.Addr().String(), connects
+}
+
+func TestRegisterMQTT(t *testing.T) {
+	crd := infra.CloudRegionDomain{Region: infra.CloudRegionShangHai}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	t.Run("register", func(t *testing.T) {
+		broker, connects := fakeBroker(t, "ps", TopicRegister,
+			Credential{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"})
+		meta := infra.MetaTetrad{ProductKey: "pk", ProductSecret: "ps", DeviceName: "dn"}
+		cred, err := New(WithBroker(broker)).RegisterMQTT(ctx, &meta, crd)
+		require.NoError(t, err)
+		p := <-connects
+		require.Equal(t, "dn&pk", p.username)
+		require.Contains(t, p.clientID, "authType=register")
+		require.Contains(t, p.clientID, "securemode=2")
+		require.False(t, cred.NoPreRegistration())
+		require.Equal(t, "ds", meta.DeviceSecret)
+		require.Equal(t, infra.MetaTriad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"}, cred.Triad())
+		require.Nil(t, cred.SignOptions())
+	})
+
+	t.Run("regnwl", func(t *testing.T) {
+		broker, connects := fakeBroker(t, "ps", TopicRegNwl,
+			Credential{ProductKey: "pk", DeviceName: "dn", ClientID: "cid", DeviceToken: "token"})
+		meta := infra.MetaTetrad{ProductKey: "pk", ProductSecret: "ps", DeviceName: "dn"}
+		cred, err := New(WithBroker(broker)).RegisterMQTT(ctx, &meta, crd,
+			sign.WithSecureMode(sign.SecureModeNoPreRegistration))
+		require.NoError(t, err)
+		p := <-connects
+		require.Contains(t, p.clientID, "authType=regnwl")
+		require.Contains(t, p.clientID, "securemode=-2")
+		require.True(t, cred.NoPreRegistration())
+		require.Empty(t, meta.DeviceSecret)
+
+		signs, err := sign.Generate(cred.Triad(), crd, cred.SignOptions()...)
+		require.NoError(t, err)
+		require.Equal(t, "cid", signs.ClientID)
+		require.Equal(t, "token", signs.Password)
+		require.Contains(t, signs.ClientIDWithExt(), "authType=connwl")
+	})
+
+	t.Run("bad product secret", func(t *testing.T) {
+		broker, _ := fakeBroker(t, "ps", TopicRegister, nil)
+		meta := infra.MetaTetrad{ProductKey: "pk", ProductSecret: "bad", DeviceName: "dn"}
+		_, err := New(WithBroker(broker)).RegisterMQTT(ctx, &meta, crd)
+		require.Error(t, err)
+	})
+}
+
+func TestRegisterMQTTTimeout(t *testing.T) {
+	// 不应答CONNECT的broker
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(ioutil.Discard, conn) // nolint: errcheck
+			}()
+		}
+	}()
+
+	crd := infra.CloudRegionDomain{Region: infra.CloudRegionShangHai}
+	meta := infra.MetaTetrad{ProductKey: "pk", ProductSecret: "ps", DeviceName: "dn"}
+	_, err = New(WithBroker("tcp://"+l.Addr().String()), WithTimeout(time.Millisecond*50)).
+		RegisterMQTT(context.Background(), &meta, crd)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestParseCredential(t *testing.T) {
+	_, err := ParseCredential(TopicRegister, []byte(`{"productKey":"pk","deviceName":"dn"}`))
+	require.Equal(t, ErrInvalidCredential, err)
+	_, err = ParseCredential(TopicRegNwl, []byte(`{"productKey":"pk","deviceName":"dn","deviceSecret":"ds"}`))
+	require.Equal(t, ErrInvalidCredential, err)
+	_, err = ParseCredential("/ext/other", []byte(`{"productKey":"pk","deviceName":"dn","deviceSecret":"ds"}`))
+	require.Equal(t, ErrInvalidCredential, err)
+	cred, err := ParseCredential(TopicRegister, []byte(`{"productKey":"pk","deviceName":"dn","deviceSecret":"ds"}`))
+	require.NoError(t, err)
+	require.Equal(t, "ds", cred.DeviceSecret)
+}
@@ -20,4 +20,8 @@ var (
 	ErrNotPermit         = errors.New("not permit")
 	ErrNotActive         = errors.New("device not active")
 	ErrNotAvail          = errors.New("device not avail")
+	ErrInvalidTransition = errors.New("invalid status transition")
+	ErrNotRegistered     = errors.New("device not registered")
+	// ErrCodecUnknownMethod 透传编解码器不支持该method, 应答将按Alink格式发送
+	ErrCodecUnknownMethod = errors.New("codec: unknown method")
 )
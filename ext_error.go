@@ -6,6 +6,8 @@ package aiot
 
 import (
 	"encoding/json"
+	"strconv"
+	"time"
 
 	"github.com/things-go/aliyun-iot/infra"
 	"github.com/things-go/aliyun-iot/uri"
@@ -22,6 +24,8 @@ type ExtErrorResponse struct {
 }
 
 // ProcExtErrorResponse 处理错误的回复,仅与子设备
+// 子设备会话或离线错误时更新子设备状态, 520(会话错误)及6401(拓扑关系不存在)时自动重新登陆子设备,
+// 见 WithSubDevRelogin
 // response:  ext/error/{productKey}/{deviceName}
 // subscribe: ext/error/{productKey}/{deviceName}
 func ProcExtErrorResponse(c *Client, rawURI string, payload []byte) error {
@@ -39,9 +43,123 @@ func ProcExtErrorResponse(c *Client, rawURI string, payload []byte) error {
 		err = infra.NewCodeError(rsp.Code, rsp.Message)
 	}
 
-	c.signalPending(Message{rsp.ID, nil, err})
-	c.Log.Debugf("ext.error.response @%d", rsp.ID)
-
 	pk, dn := rsp.Data.ProductKey, rsp.Data.DeviceName
+	// 请求将在子设备重新登陆后重放时,由重放请求的应答通知等待者
+	if !c.subDevOffline(pk, dn, rsp.Code, rsp.ID) {
+		c.signalPending(Message{rsp.ID, nil, err})
+	}
+	c.Log.Debugf("ext.error.response @%d", rsp.ID)
 	return c.gwCb.ExtErrorResponse(c, err, pk, dn)
 }
+
+// relogin 子设备重新登陆的状态
+type relogin struct {
+	last    time.Time // 最近一次重新登陆的时间
+	running bool
+	replays []uint // 等待重放的请求ID
+}
+
+// replayEntry 可重放的请求
+type replayEntry struct {
+	uri     string
+	payload []byte
+}
+
+// subDevOfflineStatus 子设备会话或离线错误码, 返回子设备离线后的状态及能否自动重新登陆
+func subDevOfflineStatus(code int) (status DevStatus, relogin, ok bool) {
+	switch code {
+	case infra.CodeSubDevSessionError: // 会话不存在或不是通过当前网关上线
+		return DevStatusAttached, true, true
+	case infra.CodeTopoRelationNotExist: // 拓扑关系不存在, 需重新添加拓扑后登陆
+		return DevStatusRegistered, true, true
+	case infra.CodeSubDevKickedOff, infra.CodeSubDevLoginDump: // 同一设备已在其它地方登陆, 重新登陆将互相踢下线
+		return DevStatusAttached, false, true
+	}
+	return DevStatusUnauthorized, false, false
+}
+
+// subDevOffline 子设备会话或离线错误时,将子设备置为离线状态,可自动重新登陆的错误码将重新登陆,
+// 同一子设备的重新登陆受 reloginInterval 限制, 返回true表示请求id将在重新登陆后重放
+func (sf *Client) subDevOffline(pk, dn string, code int, id uint) bool {
+	status, canRelogin, ok := subDevOfflineStatus(code)
+	if !ok || !sf.isGateway || pk == "" || dn == "" {
+		return false
+	}
+	node, err := sf.SearchAvail(pk, dn)
+	if err != nil || node.Status() < status {
+		return false
+	}
+	sf.UpdateDeviceStatus(pk, dn, status, StatusCauseSessionError) // nolint: errcheck
+	if !canRelogin || sf.reloginInterval <= 0 {
+		return false
+	}
+
+	replay := false
+	sf.reloginMu.Lock()
+	defer sf.reloginMu.Unlock()
+	if sf.replayCache != nil {
+		_, replay = sf.replayCache.Get(strconv.FormatUint(uint64(id), 10))
+	}
+	key := FormatKey(pk, dn)
+	rl, ok := sf.relogins[key]
+	if !ok {
+		rl = &relogin{}
+		sf.relogins[key] = rl
+	}
+	if rl.running {
+		if replay {
+			rl.replays = append(rl.replays, id)
+		}
+		return replay
+	}
+	if time.Since(rl.last) < sf.reloginInterval {
+		sf.Log.Warnf("sub-device %s relogin too frequently", key)
+		return false
+	}
+	rl.last, rl.running = time.Now(), true
+	if replay {
+		rl.replays = append(rl.replays, id)
+	}
+	// 应答在当前协程中处理,需在新的协程中等待登陆应答
+	go sf.reloginSubDev(pk, dn, rl)
+	return replay
+}
+
+// reloginSubDev 重新登陆子设备,拓扑关系不存在时先添加拓扑,重新订阅其主题并重放失败的请求
+func (sf *Client) reloginSubDev(pk, dn string, rl *relogin) {
+	var err error
+	if st, _ := sf.DeviceStatus(pk, dn); st < DevStatusAttached {
+		err = sf.LinkThingTopoAdd(pk, dn, sf.reloginTimeout)
+	}
+	if err == nil {
+		err = sf.LinkExtCombineLogin(CombinePair{pk, dn, false}, sf.reloginTimeout)
+	}
+	if err == nil {
+		err = sf.SubscribeAllTopic(pk, dn, true)
+	}
+	if err == nil {
+		sf.UpdateDeviceStatus(pk, dn, DevStatusOnline, StatusCauseSubscribe) // nolint: errcheck
+	} else {
+		sf.Log.Warnf("sub-device %s relogin failed, %+v", FormatKey(pk, dn), err)
+	}
+
+	sf.reloginMu.Lock()
+	replays := rl.replays
+	rl.replays, rl.running = nil, false
+	sf.reloginMu.Unlock()
+	for _, id := range replays {
+		key := strconv.FormatUint(uint64(id), 10)
+		v, ok := sf.replayCache.Get(key)
+		if !ok {
+			continue
+		}
+		entry := v.(replayEntry)
+		e := err
+		if e == nil {
+			e = sf.Publish(entry.uri, 1, entry.payload)
+		}
+		if e != nil {
+			sf.signalPending(Message{id, nil, e})
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package aiot
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/uri"
+)
+
+const testExtErrorURI = "/ext/error/gwpk/gwdn"
+
+// onlineSubDevice 添加并上线子设备 pk.dn
+func onlineSubDevice(t *testing.T, opts ...Option) (*Client, *fakeCloud) {
+	c, cloud := newGateway(opts...)
+	require.NoError(t, c.AddSubDevice(infra.MetaTriad{ProductKey: "pk", DeviceName: "dn"}))
+	require.NoError(t, c.SubDeviceConnect("pk", "dn", false, time.Second))
+	return c, cloud
+}
+
+func extError(id uint, code int) []byte {
+	return []byte(fmt.Sprintf(`{"id":"%d","code":%d,"data":{"productKey":"pk","deviceName":"dn"}}`, id, code))
+}
+
+func requireStatus(t *testing.T, c *Client, want DevStatus) {
+	require.Eventually(t, func() bool {
+		st, err := c.DeviceStatus("pk", "dn")
+		return err == nil && st == want
+	}, time.Second, time.Millisecond*5)
+}
+
+// requestID 发往主题的第i个请求的ID
+func requestID(t *testing.T, cloud *fakeCloud, topic string, i int) uint {
+	payloads := cloud.published(topic)
+	require.True(t, len(payloads) > i)
+	var req Request
+	require.NoError(t, json.Unmarshal(payloads[i], &req))
+	return req.ID
+}
+
+func TestSubDevRelogin(t *testing.T) {
+	c, cloud := onlineSubDevice(t, WithSubDevRelogin(time.Hour))
+	require.Equal(t, 1, cloud.count("/combine/login"))
+
+	require.NoError(t, ProcExtErrorResponse(c, testExtErrorURI, extError(1, infra.CodeSubDevSessionError)))
+	require.Eventually(t, func() bool { return cloud.count("/combine/login") == 2 }, time.Second, time.Millisecond*5)
+	requireStatus(t, c, DevStatusOnline)
+	require.Equal(t, 1, cloud.count("/thing/topo/add"))
+
+	// 重新登陆受间隔限制
+	require.NoError(t, ProcExtErrorResponse(c, testExtErrorURI, extError(2, infra.CodeSubDevSessionError)))
+	requireStatus(t, c, DevStatusAttached)
+	time.Sleep(time.Millisecond * 20)
+	require.Equal(t, 2, cloud.count("/combine/login"))
+}
+
+// dropConn 不应答任何请求
+type dropConn struct{ Conn }
+
+func (dropConn) Publish(string, byte, interface{}) error { return nil }
+
+func TestSubDevReloginTimeout(t *testing.T) {
+	c, _ := onlineSubDevice(t, WithSubDevReloginTimeout(time.Millisecond*20))
+	c.Conn = dropConn{c.Conn}
+
+	require.NoError(t, ProcExtErrorResponse(c, testExtErrorURI, extError(1, infra.CodeSubDevSessionError)))
+	// 登陆请求按重新登陆的超时时间失败
+	require.Eventually(t, func() bool {
+		c.reloginMu.Lock()
+		defer c.reloginMu.Unlock()
+		rl, ok := c.relogins[FormatKey("pk", "dn")]
+		return ok && !rl.running
+	}, time.Millisecond*500, time.Millisecond*5)
+	requireStatus(t, c, DevStatusAttached)
+}
+
+func TestSubDevReloginTopoNotExist(t *testing.T) {
+	c, cloud := onlineSubDevice(t)
+	require.NoError(t, ProcExtErrorResponse(c, testExtErrorURI, extError(1, infra.CodeTopoRelationNotExist)))
+	require.Eventually(t, func() bool { return cloud.count("/combine/login") == 2 }, time.Second, time.Millisecond*5)
+	requireStatus(t, c, DevStatusOnline)
+	require.Equal(t, 2, cloud.count("/thing/topo/add"))
+}
+
+func TestSubDevKickedOff(t *testing.T) {
+	for _, code := range []int{infra.CodeSubDevKickedOff, infra.CodeSubDevLoginDump} {
+		c, cloud := onlineSubDevice(t)
+		require.NoError(t, ProcExtErrorResponse(c, testExtErrorURI, extError(1, code)))
+		requireStatus(t, c, DevStatusAttached)
+		time.Sleep(time.Millisecond * 20)
+		require.Equal(t, 1, cloud.count("/combine/login"))
+	}
+
+	// 重新登陆禁用时只更新状态
+	c, cloud := onlineSubDevice(t, WithSubDevRelogin(0))
+	require.NoError(t, ProcExtErrorResponse(c, testExtErrorURI, extError(1, infra.CodeSubDevSessionError)))
+	requireStatus(t, c, DevStatusAttached)
+	require.Equal(t, 1, cloud.count("/combine/login"))
+}
+
+func TestSubDevReplay(t *testing.T) {
+	c, cloud := onlineSubDevice(t, WithSubDevReplay())
+	topic := uri.URI(uri.SysPrefix, uri.ThingEventPropertyPost, "pk", "dn")
+
+	token, err := c.ThingEventPropertyPost("pk", "dn", map[string]int{"temp": 1})
+	require.NoError(t, err)
+	id := requestID(t, cloud, topic, 0)
+	require.Equal(t, 1, c.replayCache.ItemCount())
+
+	// 会话错误后重新登陆并重放请求, 由重放请求的应答通知等待者
+	require.NoError(t, ProcExtErrorResponse(c, testExtErrorURI, extError(id, infra.CodeSubDevSessionError)))
+	require.Eventually(t, func() bool { return len(cloud.published(topic)) == 2 }, time.Second, time.Millisecond*5)
+	require.Equal(t, requestID(t, cloud, topic, 0), requestID(t, cloud, topic, 1))
+	require.NoError(t, ProcThingEventPostReply(c, topic+"_reply", []byte(fmt.Sprintf(`{"id":"%d","code":200}`, id))))
+	_, err = token.Wait(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 0, c.replayCache.ItemCount())
+
+	// 成功的应答移除缓存
+	_, err = c.ThingEventPropertyPost("pk", "dn", map[string]int{"temp": 2})
+	require.NoError(t, err)
+	require.Equal(t, 1, c.replayCache.ItemCount())
+	id = requestID(t, cloud, topic, 2)
+	require.NoError(t, ProcThingEventPostReply(c, topic+"_reply", []byte(fmt.Sprintf(`{"id":"%d","code":200}`, id))))
+	require.Equal(t, 0, c.replayCache.ItemCount())
+
+	// 网关自身的请求不缓存
+	_, err = c.ThingEventPropertyPost("gwpk", "gwdn", map[string]int{"temp": 3})
+	require.NoError(t, err)
+	require.Equal(t, 0, c.replayCache.ItemCount())
+}
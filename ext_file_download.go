@@ -0,0 +1,136 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package aiot
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/uri"
+)
+
+// @see https://help.aliyun.com/document_detail/147680.html
+// 通过MQTT分片下载文件(如OTA固件), 应答为二进制格式:
+//
+//	| json长度 2字节 | json头 | 文件分片 | CRC16/IBM 2字节 |
+//
+// 多字节整数均为大端序.
+
+// 分片大小限制
+const (
+	FileBlockSizeMin = 256
+	FileBlockSizeMax = 131072
+)
+
+// 文件下载错误
+var (
+	ErrFileBlockCRC = errors.New("file block crc mismatch")
+)
+
+// FileInfo 文件信息, OTA固件使用 OtaFirmwareData 中的 StreamID, StreamFileID
+type FileInfo struct {
+	StreamID int64 `json:"streamId"`
+	FileID   int64 `json:"fileId"`
+}
+
+// FileBlock 请求的文件分片
+type FileBlock struct {
+	Size   int   `json:"size"`
+	Offset int64 `json:"offset"`
+}
+
+// FileDownloadParams 文件分片下载请求参数域
+type FileDownloadParams struct {
+	FileToken string    `json:"fileToken,omitempty"`
+	FileInfo  *FileInfo `json:"fileInfo,omitempty"`
+	FileBlock FileBlock `json:"fileBlock"`
+}
+
+// FileDownloadData 文件分片下载应答数据域
+type FileDownloadData struct {
+	FileToken  string `json:"fileToken,omitempty"`
+	FileLength int64  `json:"fileLength"`
+	BSize      int    `json:"bSize"`
+	BOffset    int64  `json:"bOffset"`
+}
+
+// FileDownloadReply 文件分片下载应答
+type FileDownloadReply struct {
+	ID      uint             `json:"id,string"`
+	Code    int              `json:"code"`
+	Message string           `json:"msg"`
+	Data    FileDownloadData `json:"data"`
+	Block   []byte           `json:"-"` // 文件分片
+}
+
+// ThingFileDownload 请求下载文件分片, 应答通过Token获取 *FileDownloadReply
+// request：  /sys/{productKey}/{deviceName}/thing/file/download
+// response： /sys/{productKey}/{deviceName}/thing/file/download_reply
+func (sf *Client) ThingFileDownload(pk, dn string, params FileDownloadParams) (*Token, error) {
+	if !sf.hasOTA {
+		return nil, ErrNotSupportFeature
+	}
+	if params.FileBlock.Size < FileBlockSizeMin || params.FileBlock.Size > FileBlockSizeMax ||
+		params.FileBlock.Offset < 0 {
+		return nil, ErrInvalidParameter
+	}
+	if !sf.IsActive(pk, dn) {
+		return nil, ErrNotActive
+	}
+	_uri := uri.URI(uri.SysPrefix, uri.ThingFileDownload, pk, dn)
+	return sf.SendRequest(_uri, infra.MethodFileDownload, params)
+}
+
+// ProcThingFileDownloadReply 处理文件分片下载应答,校验分片的CRC
+// request：  /sys/{productKey}/{deviceName}/thing/file/download
+// response： /sys/{productKey}/{deviceName}/thing/file/download_reply
+// subscribe：/sys/{productKey}/{deviceName}/thing/file/download_reply
+func ProcThingFileDownloadReply(c *Client, rawURI string, payload []byte) error {
+	uris := uri.Spilt(rawURI)
+	if len(uris) < 6 {
+		return ErrInvalidURI
+	}
+	rsp, err := DecodeFileDownloadReply(payload)
+	if rsp == nil {
+		return err
+	}
+	if err == nil && rsp.Code != infra.CodeSuccess {
+		err = infra.NewCodeError(rsp.Code, rsp.Message)
+	}
+	c.Log.Debugf("thing.file.download.reply @%d", rsp.ID)
+	c.signalPending(Message{rsp.ID, rsp, err})
+	return nil
+}
+
+// DecodeFileDownloadReply 解码文件分片下载应答
+// 应答的json头可以解析但分片CRC校验失败时,返回应答及 ErrFileBlockCRC
+func DecodeFileDownloadReply(payload []byte) (*FileDownloadReply, error) {
+	if len(payload) < 2 {
+		return nil, ErrInvalidParameter
+	}
+	n := int(binary.BigEndian.Uint16(payload))
+	if len(payload) < 2+n {
+		return nil, ErrInvalidParameter
+	}
+	rsp := &FileDownloadReply{}
+	if err := json.Unmarshal(payload[2:2+n], rsp); err != nil {
+		return nil, err
+	}
+	if rsp.Code != infra.CodeSuccess {
+		return rsp, nil
+	}
+	body := payload[2+n:]
+	if len(body) < 2 || len(body)-2 != rsp.Data.BSize {
+		return rsp, ErrFileBlockCRC
+	}
+	rsp.Block = body[:len(body)-2]
+	if infra.CRC16IBM(rsp.Block) != binary.BigEndian.Uint16(body[len(body)-2:]) {
+		rsp.Block = nil
+		return rsp, ErrFileBlockCRC
+	}
+	return rsp, nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package aiot
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/uri"
+)
+
+// @see https://help.aliyun.com/document_detail/200753.html
+// 通过MQTT分片上传文件, 分片上传请求为二进制格式:
+//
+//	| json长度 2字节 | json头 | 文件分片 | CRC16/IBM 2字节 |
+//
+// 多字节整数均为大端序.
+
+// 文件上传冲突策略
+const (
+	FileConflictOverwrite = "overwrite" // 覆盖云端同名文件
+	FileConflictAppend    = "append"    // 续传云端同名未完成上传的文件
+	FileConflictReject    = "reject"    // 云端存在同名文件时拒绝上传
+)
+
+// FileUploadInitParams 文件上传初始化请求参数域
+type FileUploadInitParams struct {
+	FileName         string                 `json:"fileName"`
+	FileSize         int64                  `json:"fileSize"`
+	ConflictStrategy string                 `json:"conflictStrategy,omitempty"`
+	FicMode          string                 `json:"ficMode,omitempty"`  // 完整文件的校验方法, 如 crc64
+	FicValue         string                 `json:"ficValue,omitempty"` // 完整文件的校验值
+	InitUID          string                 `json:"initUid,omitempty"`
+	ExtraParams      map[string]interface{} `json:"extraParams,omitempty"`
+}
+
+// FileUploadInitData 文件上传初始化应答数据域
+type FileUploadInitData struct {
+	FileName         string `json:"fileName"`
+	FileSize         int64  `json:"fileSize"`
+	ConflictStrategy string `json:"conflictStrategy"`
+	UploadID         string `json:"uploadId"`
+	Offset           int64  `json:"offset"` // 续传时云端已接收的长度
+	InitUID          string `json:"initUid,omitempty"`
+}
+
+// FileUploadSendParams 文件分片上传请求参数域
+type FileUploadSendParams struct {
+	UploadID   string `json:"uploadId"`
+	Offset     int64  `json:"offset"`
+	BSize      int    `json:"bSize"`
+	IsComplete bool   `json:"isComplete"`
+}
+
+// FileUploadSendData 文件分片上传应答数据域
+type FileUploadSendData struct {
+	UploadID  string          `json:"uploadId"`
+	Offset    int64           `json:"offset"`
+	BSize     int             `json:"bSize"`
+	Complete  bool            `json:"complete"`
+	FileStore json.RawMessage `json:"fileStore,omitempty"` // 上传完成时的文件存储信息
+}
+
+// FileUploadCancelParams 取消文件上传请求参数域
+type FileUploadCancelParams struct {
+	UploadID string `json:"uploadId"`
+}
+
+// ThingFileUploadInit 初始化文件上传, 应答通过Token获取 *FileUploadInitData
+// request：  /sys/{productKey}/{deviceName}/thing/file/upload/mqtt/init
+// response： /sys/{productKey}/{deviceName}/thing/file/upload/mqtt/init_reply
+func (sf *Client) ThingFileUploadInit(pk, dn string, params FileUploadInitParams) (*Token, error) {
+	if !sf.hasFileUpload {
+		return nil, ErrNotSupportFeature
+	}
+	if !sf.IsActive(pk, dn) {
+		return nil, ErrNotActive
+	}
+	_uri := uri.URI(uri.SysPrefix, uri.ThingFileUploadInit, pk, dn)
+	return sf.SendRequest(_uri, infra.MethodFileUploadInit, params)
+}
+
+// ThingFileUploadSend 上传文件分片, 应答通过Token获取 *FileUploadSendData
+// request：  /sys/{productKey}/{deviceName}/thing/file/upload/mqtt/send
+// response： /sys/{productKey}/{deviceName}/thing/file/upload/mqtt/send_reply
+func (sf *Client) ThingFileUploadSend(pk, dn string, params FileUploadSendParams, block []byte) (*Token, error) {
+	if !sf.hasFileUpload {
+		return nil, ErrNotSupportFeature
+	}
+	if len(block) > FileBlockSizeMax {
+		return nil, ErrInvalidParameter
+	}
+	if !sf.IsActive(pk, dn) {
+		return nil, ErrNotActive
+	}
+	id := sf.nextRequestID()
+	params.BSize = len(block)
+	head, err := json.Marshal(&struct {
+		ID     uint                 `json:"id,string"`
+		Params FileUploadSendParams `json:"params"`
+	}{id, params})
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, 2, 2+len(head)+len(block)+2)
+	binary.BigEndian.PutUint16(payload, uint16(len(head)))
+	payload = append(append(payload, head...), block...)
+	payload = append(payload, 0, 0)
+	binary.BigEndian.PutUint16(payload[len(payload)-2:], infra.CRC16IBM(block))
+
+	sf.Log.Debugf("thing.file.upload.mqtt.send @%d", id)
+	_uri := uri.URI(uri.SysPrefix, uri.ThingFileUploadSend, pk, dn)
+	if err = sf.Publish(_uri, 1, payload); err != nil {
+		return nil, err
+	}
+	return sf.putPending(id), nil
+}
+
+// ThingFileUploadCancel 取消文件上传
+// request：  /sys/{productKey}/{deviceName}/thing/file/upload/mqtt/cancel
+// response： /sys/{productKey}/{deviceName}/thing/file/upload/mqtt/cancel_reply
+func (sf *Client) ThingFileUploadCancel(pk, dn, uploadID string) (*Token, error) {
+	if !sf.hasFileUpload {
+		return nil, ErrNotSupportFeature
+	}
+	if !sf.IsActive(pk, dn) {
+		return nil, ErrNotActive
+	}
+	_uri := uri.URI(uri.SysPrefix, uri.ThingFileUploadCancel, pk, dn)
+	return sf.SendRequest(_uri, infra.MethodFileUploadCancel, FileUploadCancelParams{uploadID})
+}
+
+// ProcThingFileUploadInitReply 处理文件上传初始化应答
+// request：  /sys/{productKey}/{deviceName}/thing/file/upload/mqtt/init
+// response： /sys/{productKey}/{deviceName}/thing/file/upload/mqtt/init_reply
+// subscribe：/sys/{productKey}/{deviceName}/thing/file/upload/mqtt/init_reply
+func ProcThingFileUploadInitReply(c *Client, rawURI string, payload []byte) error {
+	return procFileUploadReply(c, rawURI, payload, "thing.file.upload.mqtt.init.reply", &FileUploadInitData{})
+}
+
+// ProcThingFileUploadSendReply 处理文件分片上传应答
+// request：  /sys/{productKey}/{deviceName}/thing/file/upload/mqtt/send
+// response： /sys/{productKey}/{deviceName}/thing/file/upload/mqtt/send_reply
+// subscribe：/sys/{productKey}/{deviceName}/thing/file/upload/mqtt/send_reply
+func ProcThingFileUploadSendReply(c *Client, rawURI string, payload []byte) error {
+	return procFileUploadReply(c, rawURI, payload, "thing.file.upload.mqtt.send.reply", &FileUploadSendData{})
+}
+
+// ProcThingFileUploadCancelReply 处理取消文件上传应答
+// request：  /sys/{productKey}/{deviceName}/thing/file/upload/mqtt/cancel
+// response： /sys/{productKey}/{deviceName}/thing/file/upload/mqtt/cancel_reply
+// subscribe：/sys/{productKey}/{deviceName}/thing/file/upload/mqtt/cancel_reply
+func ProcThingFileUploadCancelReply(c *Client, rawURI string, payload []byte) error {
+	return procFileUploadReply(c, rawURI, payload, "thing.file.upload.mqtt.cancel.reply", &FileUploadCancelParams{})
+}
+
+// procFileUploadReply 处理文件上传相关应答, 应答数据解码到data, 并通过Token通知
+func procFileUploadReply(c *Client, rawURI string, payload []byte, name string, data interface{}) error {
+	uris := uri.Spilt(rawURI)
+	if len(uris) < 8 {
+		return ErrInvalidURI
+	}
+	rsp := &ResponseRawData{}
+	err := json.Unmarshal(payload, rsp)
+	if err != nil {
+		return err
+	}
+	if rsp.Code != infra.CodeSuccess {
+		err = infra.NewCodeError(rsp.Code, rsp.Message)
+	} else if len(rsp.Data) > 0 {
+		if err = json.Unmarshal(rsp.Data, data); err != nil {
+			return err
+		}
+	}
+	c.Log.Debugf("%s @%d", name, rsp.ID)
+	c.signalPending(Message{rsp.ID, data, err})
+	return nil
+}
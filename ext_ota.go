@@ -6,6 +6,7 @@ package aiot
 
 import (
 	"encoding/json"
+	"strings"
 
 	"github.com/things-go/aliyun-iot/infra"
 	uri "github.com/things-go/aliyun-iot/uri"
@@ -93,8 +94,30 @@ type OtaFirmwareData struct {
 	SignMethod string `json:"signMethod"`
 	MD5        string `json:"md5"`
 	Module     string `json:"module"`
+	// 下载协议, 为mqtt时通过 ThingFileDownload 分片下载固件
+	DProtocol    string `json:"dProtocol,omitempty"`
+	StreamID     int64  `json:"streamId,omitempty"`
+	StreamFileID int64  `json:"streamFileId,omitempty"`
+	// 升级包的自定义参数
+	ExtData map[string]interface{} `json:"extData,omitempty"`
+	// 升级包的签名, 对升级包摘要的数字签名, base64编码
+	DigestSign string `json:"digestsign,omitempty"`
+	// 多文件升级包的文件列表, 此时url, size, sign, md5为空
+	Files []OtaFile `json:"files,omitempty"`
 }
 
+// OtaFile 多文件升级包中的文件, 签名方法同 OtaFirmwareData.SignMethod
+type OtaFile struct {
+	FileName string `json:"fileName"`
+	FileSize int64  `json:"fileSize"`
+	FileURL  string `json:"fileUrl"`
+	FileSign string `json:"fileSign"`
+	FileMD5  string `json:"fileMd5,omitempty"`
+}
+
+// OtaDProtocolMQTT 通过MQTT下载固件
+const OtaDProtocolMQTT = "mqtt"
+
 // OtaFirmwareResponse ota firmware response
 type OtaFirmwareResponse struct {
 	ID      uint            `json:"id,string"`
@@ -103,6 +126,28 @@ type OtaFirmwareResponse struct {
 	Message string          `json:"message"`
 }
 
+// UnmarshalJSON 实现json.Unmarshaler接口, 平台推送的code可能为字符串, 如 "1000"
+func (sf *OtaFirmwareResponse) UnmarshalJSON(b []byte) error {
+	type response OtaFirmwareResponse
+	rsp := struct {
+		*response
+		Code json.Number `json:"code"`
+	}{response: (*response)(sf)}
+	if err := json.Unmarshal(b, &rsp); err != nil {
+		return err
+	}
+	if rsp.Code == "" {
+		sf.Code = 0
+		return nil
+	}
+	code, err := rsp.Code.Int64()
+	if err != nil {
+		return err
+	}
+	sf.Code = int(code)
+	return nil
+}
+
 // ThingOtaFirmwareGet 请求固件信息
 // module: 不指定则表示请求默认（default）模块的固件信息
 // request： /sys/{productKey}/{deviceName}/thing/ota/firmware/get
@@ -138,10 +183,14 @@ func ProcThingOtaFirmwareGetReply(c *Client, rawURI string, payload []byte) erro
 	c.Log.Debugf("thing.ota.firmware.get.reply @%d", rsp.ID)
 	c.signalPending(Message{rsp.ID, rsp.Data, err})
 	pk, dn := uris[1], uris[2]
+	if err == nil && (rsp.Data.URL != "" || len(rsp.Data.Files) > 0 ||
+		strings.EqualFold(rsp.Data.DProtocol, OtaDProtocolMQTT)) {
+		c.otaUpgrade(pk, dn, rsp.Data)
+	}
 	return c.cb.ThingOtaFirmwareGetReply(c, pk, dn, rsp.Data)
 }
 
-// ProcOtaUpgrade 处理物联网平台推送固件信息
+// ProcOtaUpgrade 处理物联网平台推送固件信息,设置了 OtaUpgrader 时交由其处理升级
 // request：  /ota/device/upgrade/${YourProductKey}/${YourDeviceName}
 // subscribe：/ota/device/upgrade/${YourProductKey}/${YourDeviceName}
 func ProcOtaUpgrade(c *Client, rawURI string, payload []byte) error {
@@ -156,5 +205,27 @@ func ProcOtaUpgrade(c *Client, rawURI string, payload []byte) error {
 	}
 	c.Log.Debugf("thing.device.upgrade")
 	pk, dn := uris[3], uris[4]
+	c.otaUpgrade(pk, dn, rsp.Data)
 	return c.cb.OtaUpgrade(c, pk, dn, rsp)
 }
+
+// OtaUpgrader OTA升级处理器, 收到平台推送的固件信息或请求固件信息的应答时调用
+// 实现不应阻塞,耗时的升级过程应在其它协程中进行
+type OtaUpgrader interface {
+	OtaUpgrade(c *Client, productKey, deviceName string, data OtaFirmwareData) error
+}
+
+// SetOtaUpgrader 设置OTA升级处理器,同时使能ota功能,需在Connect之前设置
+func (sf *Client) SetOtaUpgrader(u OtaUpgrader) {
+	sf.hasOTA = true
+	sf.otaUpgrader = u
+}
+
+func (sf *Client) otaUpgrade(pk, dn string, data OtaFirmwareData) {
+	if sf.otaUpgrader == nil {
+		return
+	}
+	if err := sf.otaUpgrader.OtaUpgrade(sf, pk, dn, data); err != nil {
+		sf.Log.Warnf("ota upgrade %s.%s failed, %+v", pk, dn, err)
+	}
+}
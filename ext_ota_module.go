@@ -0,0 +1,107 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package aiot
+
+import (
+	"sync"
+)
+
+// OtaModuleDefault 默认模块,其固件版本号等同于整个设备的固件版本号
+const OtaModuleDefault = "default"
+
+// OtaVersionFunc 获取设备模块当前运行的固件版本,返回空表示该设备无此模块
+type OtaVersionFunc func(productKey, deviceName string) string
+
+// otaModules OTA模块注册表
+type otaModules struct {
+	rw       sync.RWMutex
+	names    []string
+	versions map[string]OtaVersionFunc
+}
+
+// OtaModuleName 规范化模块名,空为默认模块
+func OtaModuleName(module string) string {
+	if module == "" {
+		return OtaModuleDefault
+	}
+	return module
+}
+
+// AddOtaModule 注册一个OTA模块(如 default, mcu, modem, app)及其版本获取函数,
+// 同时使能ota功能,已存在则替换版本获取函数
+func (sf *Client) AddOtaModule(module string, version OtaVersionFunc) {
+	sf.hasOTA = true
+	module = OtaModuleName(module)
+
+	sf.otaModules.rw.Lock()
+	defer sf.otaModules.rw.Unlock()
+	if sf.otaModules.versions == nil {
+		sf.otaModules.versions = make(map[string]OtaVersionFunc)
+	}
+	if _, ok := sf.otaModules.versions[module]; !ok {
+		sf.otaModules.names = append(sf.otaModules.names, module)
+	}
+	sf.otaModules.versions[module] = version
+}
+
+// OtaModules 已注册的OTA模块,按注册顺序
+func (sf *Client) OtaModules() []string {
+	sf.otaModules.rw.RLock()
+	defer sf.otaModules.rw.RUnlock()
+	return append([]string(nil), sf.otaModules.names...)
+}
+
+// OtaModuleVersion 获取设备模块当前的固件版本
+func (sf *Client) OtaModuleVersion(pk, dn, module string) (string, bool) {
+	sf.otaModules.rw.RLock()
+	version, ok := sf.otaModules.versions[OtaModuleName(module)]
+	sf.otaModules.rw.RUnlock()
+	if !ok || version == nil {
+		return "", false
+	}
+	ver := version(pk, dn)
+	return ver, ver != ""
+}
+
+// OtaInformModules 上报设备所有已注册模块的固件版本,
+// updated 为刚升级完成的模块版本,优先于版本获取函数的结果
+func (sf *Client) OtaInformModules(pk, dn string, updated ...OtaInformParams) error {
+	versions := make(map[string]string, len(updated))
+	for _, v := range updated {
+		versions[OtaModuleName(v.Module)] = v.Version
+	}
+
+	var firstErr error
+	inform := func(module, version string) {
+		if err := sf.OtaInform(pk, dn, OtaInformParams{version, module}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, module := range sf.OtaModules() {
+		version, ok := versions[module]
+		if ok {
+			delete(versions, module)
+		} else if version, ok = sf.OtaModuleVersion(pk, dn, module); !ok {
+			continue
+		}
+		inform(module, version)
+	}
+	for _, v := range updated { // 未注册的模块
+		if version, ok := versions[OtaModuleName(v.Module)]; ok {
+			inform(v.Module, version)
+		}
+	}
+	return firstErr
+}
+
+// otaInformModulesOnline 设备上线后上报所有模块的固件版本
+func (sf *Client) otaInformModulesOnline(pk, dn string) {
+	if len(sf.OtaModules()) == 0 {
+		return
+	}
+	if err := sf.OtaInformModules(pk, dn); err != nil {
+		sf.Log.Warnf("ota inform modules of %s.%s failed, %+v", pk, dn, err)
+	}
+}
@@ -0,0 +1,26 @@
+package aiot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcThingOtaFirmwareGetReplyMQTT(t *testing.T) {
+	up := &testUpgrader{}
+	c := New(testGateway, &fakeCloud{}, WithOtaUpgrader(up))
+	topic := "/sys/gwpk/gwdn/thing/ota/firmware/get_reply"
+
+	// 没有固件信息时不升级
+	require.NoError(t, ProcThingOtaFirmwareGetReply(c, topic, []byte(`{"id":"1","code":200,"data":{}}`)))
+	require.Empty(t, up.data)
+
+	// MQTT文件流下载的升级包没有url
+	require.NoError(t, ProcThingOtaFirmwareGetReply(c, topic, []byte(`{"id":"2","code":200,"data":{
+		"size":1024,"version":"2.0","signMethod":"MD5","sign":"d41d8cd98f00b204e9800998ecf8427e",
+		"dProtocol":"mqtt","streamId":1,"streamFileId":2}}`)))
+	require.Len(t, up.data, 1)
+	require.Equal(t, OtaDProtocolMQTT, up.data[0].DProtocol)
+	require.Empty(t, up.data[0].URL)
+	require.Equal(t, int64(1), up.data[0].StreamID)
+}
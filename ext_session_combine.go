@@ -59,19 +59,11 @@ func (sf *Client) extCombineLogin(cp CombinePair) (*Token, error) {
 	if !sf.isGateway {
 		return nil, ErrNotSupportFeature
 	}
-	ds, err := sf.DeviceSecret(cp.ProductKey, cp.DeviceName)
+	timestamp := infra.Millisecond(time.Now())
+	clientID, signs, err := sf.calcSign(cp.ProductKey, cp.DeviceName, timestamp)
 	if err != nil {
 		return nil, err
 	}
-
-	timestamp := infra.Millisecond(time.Now())
-	clientID, signs := infra.CalcSign("hmacsha256",
-		infra.MetaTriad{
-			ProductKey:   cp.ProductKey,
-			DeviceName:   cp.DeviceName,
-			DeviceSecret: ds,
-		},
-		timestamp)
 	id := sf.nextRequestID()
 	req, err := json.Marshal(&Request{
 		id,
@@ -127,15 +119,10 @@ func (sf *Client) extCombineBatchLogin(pairs []CombinePair) (*Token, error) {
 	timestamp := infra.Millisecond(time.Now())
 	clps := make([]CombineLoginParams, 0, len(pairs))
 	for _, cp := range pairs {
-		ds, err := sf.DeviceSecret(cp.ProductKey, cp.DeviceName)
+		clientID, signs, err := sf.calcSign(cp.ProductKey, cp.DeviceName, timestamp)
 		if err != nil {
 			return nil, err
 		}
-		clientID, signs := infra.CalcSign("hmacsha256", infra.MetaTriad{
-			ProductKey:   cp.ProductKey,
-			DeviceName:   cp.DeviceName,
-			DeviceSecret: ds,
-		}, timestamp)
 		clps = append(clps, CombineLoginParams{
 			cp.ProductKey,
 			cp.DeviceName,
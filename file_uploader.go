@@ -0,0 +1,208 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package aiot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// 文件上传默认值
+const (
+	DefaultUploadBlockSize = 64 * 1024
+	DefaultUploadTimeout   = time.Second * 10
+	DefaultUploadRetry     = 3
+)
+
+// FileUpload 文件上传任务, 直连设备及网关子设备均可上传
+type FileUpload struct {
+	ProductKey string
+	DeviceName string
+	FileName   string
+	Reader     io.ReaderAt
+	Size       int64
+	// 冲突策略, 默认 FileConflictOverwrite, 为 FileConflictAppend 时从云端已接收的位置续传
+	ConflictStrategy string
+	ExtraParams      map[string]interface{}
+	// Progress 每个分片上传成功后调用
+	Progress func(sent, total int64)
+}
+
+// FileUploaderOption 文件上传器选项
+type FileUploaderOption func(*FileUploader)
+
+// WithUploadBlockSize 设置分片大小, 范围 [FileBlockSizeMin, FileBlockSizeMax], 默认 DefaultUploadBlockSize
+func WithUploadBlockSize(size int) FileUploaderOption {
+	return func(u *FileUploader) {
+		if size < FileBlockSizeMin {
+			size = FileBlockSizeMin
+		} else if size > FileBlockSizeMax {
+			size = FileBlockSizeMax
+		}
+		u.blockSize = size
+	}
+}
+
+// WithUploadTimeout 设置每个请求的应答超时时间, 默认 DefaultUploadTimeout
+func WithUploadTimeout(timeout time.Duration) FileUploaderOption {
+	return func(u *FileUploader) {
+		u.timeout = timeout
+	}
+}
+
+// WithUploadRetry 设置分片失败的重试次数, 默认 DefaultUploadRetry
+// 设备离线时将等待设备重新上线后续传,不计入重试次数
+func WithUploadRetry(retry int) FileUploaderOption {
+	return func(u *FileUploader) {
+		u.retry = retry
+	}
+}
+
+// FileUploader MQTT文件上传器, 需使能 WithEnableFileUpload
+type FileUploader struct {
+	c         *Client
+	blockSize int
+	timeout   time.Duration
+	retry     int
+}
+
+// NewFileUploader 新建文件上传器
+func NewFileUploader(c *Client, opts ...FileUploaderOption) *FileUploader {
+	u := &FileUploader{
+		c:         c,
+		blockSize: DefaultUploadBlockSize,
+		timeout:   DefaultUploadTimeout,
+		retry:     DefaultUploadRetry,
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+	return u
+}
+
+// UploadFile 使用 FileUploader 上传文件, 见 FileUploader.Upload
+func (sf *Client) UploadFile(ctx context.Context, up *FileUpload, opts ...FileUploaderOption) (*FileUploadSendData, error) {
+	return NewFileUploader(sf, opts...).Upload(ctx, up)
+}
+
+// Upload 上传文件, 返回最后一个分片的应答.
+// 分片失败时在同一位置退避重试, 设备离线时等待重新上线且不计入重试次数,
+// 初始化成功后ctx取消时将取消云端的上传
+func (sf *FileUploader) Upload(ctx context.Context, up *FileUpload) (*FileUploadSendData, error) {
+	strategy := up.ConflictStrategy
+	if strategy == "" {
+		strategy = FileConflictOverwrite
+	}
+	init, err := sf.init(ctx, up, strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	fails := 0
+	offset := init.Offset
+	block := make([]byte, sf.blockSize)
+	for {
+		if err = ctx.Err(); err != nil {
+			sf.cancel(up, init.UploadID)
+			return nil, err
+		}
+		n := int64(sf.blockSize)
+		if up.Size-offset < n {
+			n = up.Size - offset
+		}
+		m, err := up.Reader.ReadAt(block[:n], offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if m == 0 && n > 0 { // 文件比Size短
+			return nil, io.ErrUnexpectedEOF
+		}
+		rsp, err := sf.c.LinkThingFileUploadSend(up.ProductKey, up.DeviceName, FileUploadSendParams{
+			UploadID:   init.UploadID,
+			Offset:     offset,
+			IsComplete: offset+int64(m) >= up.Size,
+		}, block[:m], sf.timeout)
+		if err == nil {
+			fails = 0
+			offset += int64(m)
+			if up.Progress != nil {
+				up.Progress(offset, up.Size)
+			}
+			if rsp.Complete || offset >= up.Size {
+				return rsp, nil
+			}
+			continue
+		}
+
+		if !errors.Is(err, ErrNotActive) {
+			if fails++; fails > sf.retry {
+				return nil, err
+			}
+		}
+		sf.c.Log.Warnf("file upload %s block @%d failed, %+v", up.FileName, offset, err)
+		if err = sf.backoff(ctx, fails); err != nil {
+			sf.cancel(up, init.UploadID)
+			return nil, err
+		}
+	}
+}
+
+// Cancel 取消云端的上传
+func (sf *FileUploader) Cancel(pk, dn, uploadID string) error {
+	return sf.c.LinkThingFileUploadCancel(pk, dn, uploadID, sf.timeout)
+}
+
+// init 初始化上传, 设备离线时等待重新上线
+func (sf *FileUploader) init(ctx context.Context, up *FileUpload, strategy string) (*FileUploadInitData, error) {
+	for fails := 0; ; {
+		data, err := sf.c.LinkThingFileUploadInit(up.ProductKey, up.DeviceName, FileUploadInitParams{
+			FileName:         up.FileName,
+			FileSize:         up.Size,
+			ConflictStrategy: strategy,
+			ExtraParams:      up.ExtraParams,
+		}, sf.timeout)
+		if err == nil {
+			if data.Offset < 0 || data.Offset > up.Size {
+				data.Offset = 0
+			}
+			return data, nil
+		}
+		if !errors.Is(err, ErrNotActive) {
+			if fails++; fails > sf.retry {
+				return nil, err
+			}
+		}
+		if err = sf.backoff(ctx, fails); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff 失败后等待重试, 等待时间从timeout的1/10起随失败次数加倍, 最长为timeout
+func (sf *FileUploader) backoff(ctx context.Context, fails int) error {
+	wait := sf.timeout / 10
+	for i := 1; i < fails && wait < sf.timeout; i++ {
+		wait *= 2
+	}
+	if wait > sf.timeout {
+		wait = sf.timeout
+	}
+	tm := time.NewTimer(wait)
+	defer tm.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tm.C:
+		return nil
+	}
+}
+
+func (sf *FileUploader) cancel(up *FileUpload, uploadID string) {
+	if err := sf.Cancel(up.ProductKey, up.DeviceName, uploadID); err != nil {
+		sf.c.Log.Warnf("file upload %s cancel failed, %+v", up.FileName, err)
+	}
+}
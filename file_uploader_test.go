@@ -0,0 +1,241 @@
+package aiot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// fileCloud 模拟云端的MQTT文件上传
+type fileCloud struct {
+	c *Client
+
+	mu       sync.Mutex
+	received []byte // 云端已接收的文件内容
+	inits    []FileUploadInitParams
+	offsets  []int64 // 每个分片请求的偏移
+	cancels  []string
+	crcErrs  int
+	// failInit, failSend 返回非零时, 第i个请求应答对应的错误码
+	failInit func(i int) int
+	failSend func(i int) int
+}
+
+func (sf *fileCloud) Publish(topic string, _ byte, payload interface{}) error {
+	b, _ := payload.([]byte)
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	var id uint
+	var proc ProcDownStream
+	var data interface{}
+	code := infra.CodeSuccess
+	switch {
+	case strings.HasSuffix(topic, "/upload/mqtt/init"):
+		var req struct {
+			ID     uint                 `json:"id,string"`
+			Params FileUploadInitParams `json:"params"`
+		}
+		json.Unmarshal(b, &req) // nolint: errcheck
+		id, proc = req.ID, ProcThingFileUploadInitReply
+		if sf.failInit != nil {
+			if c := sf.failInit(len(sf.inits)); c != 0 {
+				code = c
+			}
+		}
+		sf.inits = append(sf.inits, req.Params)
+		if code == infra.CodeSuccess && req.Params.ConflictStrategy != FileConflictAppend {
+			sf.received = nil
+		}
+		data = FileUploadInitData{FileName: req.Params.FileName, UploadID: "u1", Offset: int64(len(sf.received))}
+	case strings.HasSuffix(topic, "/upload/mqtt/send"):
+		n := int(binary.BigEndian.Uint16(b))
+		var head struct {
+			ID     uint                 `json:"id,string"`
+			Params FileUploadSendParams `json:"params"`
+		}
+		json.Unmarshal(b[2:2+n], &head) // nolint: errcheck
+		block := b[2+n : len(b)-2]
+		id, proc = head.ID, ProcThingFileUploadSendReply
+		if sf.failSend != nil {
+			if c := sf.failSend(len(sf.offsets)); c != 0 {
+				code = c
+			}
+		}
+		sf.offsets = append(sf.offsets, head.Params.Offset)
+		switch {
+		case binary.BigEndian.Uint16(b[len(b)-2:]) != infra.CRC16IBM(block) || head.Params.BSize != len(block):
+			sf.crcErrs++
+			code = infra.CodeRequestError
+		case head.Params.Offset != int64(len(sf.received)):
+			code = infra.CodeRequestError
+		case code == infra.CodeSuccess:
+			sf.received = append(sf.received, block...)
+		}
+		data = FileUploadSendData{UploadID: head.Params.UploadID, Offset: head.Params.Offset,
+			BSize: len(block), Complete: head.Params.IsComplete}
+	case strings.HasSuffix(topic, "/upload/mqtt/cancel"):
+		var req struct {
+			ID     uint                   `json:"id,string"`
+			Params FileUploadCancelParams `json:"params"`
+		}
+		json.Unmarshal(b, &req) // nolint: errcheck
+		sf.cancels = append(sf.cancels, req.Params.UploadID)
+		id, proc, data = req.ID, ProcThingFileUploadCancelReply, req.Params
+	default:
+		return nil
+	}
+	rsp := Response{ID: id, Code: code, Data: data}
+	if code != infra.CodeSuccess {
+		rsp.Data = nil
+	}
+	out, _ := json.Marshal(rsp)
+	go func() {
+		time.Sleep(time.Millisecond * 2)
+		proc(sf.c, topic+"_reply", out) // nolint: errcheck
+	}()
+	return nil
+}
+
+func (sf *fileCloud) Subscribe(string, ProcDownStream) error { return nil }
+func (sf *fileCloud) UnSubscribe(...string) error            { return nil }
+func (sf *fileCloud) Close() error                           { return nil }
+
+func newFileUploader(opts ...FileUploaderOption) (*FileUploader, *fileCloud) {
+	cloud := &fileCloud{}
+	cloud.c = New(testGateway, cloud, WithEnableFileUpload())
+	opts = append([]FileUploaderOption{WithUploadBlockSize(FileBlockSizeMin), WithUploadTimeout(time.Second)}, opts...)
+	return NewFileUploader(cloud.c, opts...), cloud
+}
+
+// testFile 长度为2.5个最小分片的文件
+func testFile() []byte {
+	b := make([]byte, FileBlockSizeMin*5/2)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func fileUpload(b []byte) *FileUpload {
+	return &FileUpload{
+		ProductKey: testGateway.ProductKey,
+		DeviceName: testGateway.DeviceName,
+		FileName:   "test.log",
+		Reader:     bytes.NewReader(b),
+		Size:       int64(len(b)),
+	}
+}
+
+func TestFileUpload(t *testing.T) {
+	u, cloud := newFileUploader()
+	file := testFile()
+	up := fileUpload(file)
+	var progress []int64
+	up.Progress = func(sent, total int64) {
+		require.Equal(t, int64(len(file)), total)
+		progress = append(progress, sent)
+	}
+
+	rsp, err := u.Upload(context.Background(), up)
+	require.NoError(t, err)
+	require.True(t, rsp.Complete)
+	require.Equal(t, file, cloud.received)
+	require.Zero(t, cloud.crcErrs)
+	require.Equal(t, []int64{0, FileBlockSizeMin, FileBlockSizeMin * 2}, cloud.offsets)
+	require.Equal(t, []int64{FileBlockSizeMin, FileBlockSizeMin * 2, int64(len(file))}, progress)
+	require.Len(t, cloud.inits, 1)
+	require.Equal(t, FileConflictOverwrite, cloud.inits[0].ConflictStrategy)
+}
+
+func TestFileUploadRetry(t *testing.T) {
+	// 第2个分片失败一次, 在同一位置重试
+	u, cloud := newFileUploader()
+	cloud.failSend = func(i int) int {
+		if i == 1 {
+			return infra.CodeRequestError
+		}
+		return 0
+	}
+	file := testFile()
+	_, err := u.Upload(context.Background(), fileUpload(file))
+	require.NoError(t, err)
+	require.Equal(t, file, cloud.received)
+	require.Equal(t, []int64{0, FileBlockSizeMin, FileBlockSizeMin, FileBlockSizeMin * 2}, cloud.offsets)
+	require.Len(t, cloud.inits, 1)
+
+	// 超过重试次数
+	u, cloud = newFileUploader(WithUploadRetry(2))
+	cloud.failSend = func(int) int { return infra.CodeRequestError }
+	_, err = u.Upload(context.Background(), fileUpload(file))
+	require.Error(t, err)
+	require.Len(t, cloud.offsets, 3)
+}
+
+func TestFileUploadResume(t *testing.T) {
+	u, cloud := newFileUploader()
+	file := testFile()
+	cloud.received = append([]byte(nil), file[:FileBlockSizeMin+10]...)
+
+	up := fileUpload(file)
+	up.ConflictStrategy = FileConflictAppend
+	_, err := u.Upload(context.Background(), up)
+	require.NoError(t, err)
+	require.Equal(t, file, cloud.received)
+	require.Equal(t, []int64{FileBlockSizeMin + 10, FileBlockSizeMin*2 + 10}, cloud.offsets)
+}
+
+func TestFileUploadCancel(t *testing.T) {
+	u, cloud := newFileUploader()
+	ctx, cancel := context.WithCancel(context.Background())
+	up := fileUpload(testFile())
+	up.Progress = func(int64, int64) { cancel() }
+	_, err := u.Upload(ctx, up)
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, []string{"u1"}, cloud.cancels)
+	require.Len(t, cloud.offsets, 1)
+
+	// 退避重试时取消
+	u, cloud = newFileUploader()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	cloud.failSend = func(i int) int {
+		cancel()
+		return infra.CodeRequestError
+	}
+	_, err = u.Upload(ctx, fileUpload(testFile()))
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, []string{"u1"}, cloud.cancels)
+	require.Len(t, cloud.offsets, 1)
+}
+
+func TestFileUploadShortRead(t *testing.T) {
+	u, cloud := newFileUploader()
+	file := testFile()
+	up := fileUpload(file[:len(file)-10])
+	up.Size = int64(len(file))
+	_, err := u.Upload(context.Background(), up)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+	require.Zero(t, cloud.crcErrs)
+	require.Equal(t, file[:len(file)-10], cloud.received)
+}
+
+func TestClientUploadFile(t *testing.T) {
+	_, cloud := newFileUploader()
+	file := testFile()
+	rsp, err := cloud.c.UploadFile(context.Background(), fileUpload(file), WithUploadBlockSize(FileBlockSizeMin))
+	require.NoError(t, err)
+	require.True(t, rsp.Complete)
+	require.Equal(t, file, cloud.received)
+	require.Len(t, cloud.offsets, 3)
+}
@@ -0,0 +1,355 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gateway
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// 子设备发现的默认值
+const (
+	DefaultFoundBatchSize = 20
+	DefaultFoundDelay     = time.Second
+	DefaultFoundTTL       = time.Hour
+)
+
+// Candidate 发现的子设备候选
+type Candidate struct {
+	infra.MetaPair
+	Metadata map[string]string // 扫描得到的附加信息,如地址,型号等
+}
+
+// Discoverer 子设备发现者,周期扫描或事件驱动,
+// Discover 阻塞直到ctx结束,期间通过found上报发现的子设备候选
+type Discoverer interface {
+	Discover(ctx context.Context, found func(cs ...Candidate)) error
+}
+
+// ScanFunc 单次扫描函数
+type ScanFunc func(ctx context.Context) ([]Candidate, error)
+
+type periodic struct {
+	interval time.Duration
+	scan     ScanFunc
+}
+
+// Periodic 周期扫描的发现者,每隔interval调用一次scan
+func Periodic(interval time.Duration, scan ScanFunc) Discoverer {
+	return &periodic{interval, scan}
+}
+
+// Discover 实现 Discoverer 接口
+func (sf *periodic) Discover(ctx context.Context, found func(cs ...Candidate)) error {
+	tk := time.NewTicker(sf.interval)
+	defer tk.Stop()
+	for {
+		cs, err := sf.scan(ctx)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		found(cs...)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tk.C:
+		}
+	}
+}
+
+// DiscoveryOption 子设备发现的选项
+type DiscoveryOption func(*Discovery)
+
+// WithSupervisor 设置子设备监管,云端通知添加拓扑关系的子设备将交由监管自动连接
+func WithSupervisor(s *Supervisor) DiscoveryOption {
+	return func(d *Discovery) {
+		d.sup = s
+	}
+}
+
+// WithFoundBatch 设置发现设备列表上报的单个批次数量及合并等待时间
+func WithFoundBatch(size int, delay time.Duration) DiscoveryOption {
+	return func(d *Discovery) {
+		if size > 0 {
+			d.batchSize = size
+		}
+		if delay > 0 {
+			d.delay = delay
+		}
+	}
+}
+
+// WithFoundTTL 设置已上报但云端未批准的候选再次上报的间隔,默认 DefaultFoundTTL,
+// 超过该时间未再被发现的候选将被移除
+func WithFoundTTL(ttl time.Duration) DiscoveryOption {
+	return func(d *Discovery) {
+		if ttl > 0 {
+			d.ttl = ttl
+		}
+	}
+}
+
+// WithFoundTimeout 设置发现设备列表上报的超时时间,默认 DefaultTimeout
+func WithFoundTimeout(t time.Duration) DiscoveryOption {
+	return func(d *Discovery) {
+		if t > 0 {
+			d.timeout = t
+		}
+	}
+}
+
+type candidate struct {
+	Candidate
+	queued   bool
+	reported time.Time
+	seen     time.Time // 最近一次被发现的时间
+}
+
+// Discovery 子设备发现,汇总各发现者的候选,去除已管理的子设备后通过 ThingListFound 批量上报
+type Discovery struct {
+	c         *aiot.Client
+	sup       *Supervisor
+	batchSize int
+	delay     time.Duration
+	ttl       time.Duration
+	timeout   time.Duration
+
+	mu          sync.Mutex
+	discoverers []Discoverer
+	candidates  map[string]*candidate
+	queue       []string
+	started     bool
+
+	flush  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDiscovery 创建子设备发现
+func NewDiscovery(c *aiot.Client, opts ...DiscoveryOption) *Discovery {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Discovery{
+		c:          c,
+		batchSize:  DefaultFoundBatchSize,
+		delay:      DefaultFoundDelay,
+		ttl:        DefaultFoundTTL,
+		timeout:    DefaultTimeout,
+		candidates: make(map[string]*candidate),
+		flush:      make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Register 注册发现者,已启动时立即运行
+func (sf *Discovery) Register(ds ...Discoverer) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.discoverers = append(sf.discoverers, ds...)
+	if sf.started {
+		for _, d := range ds {
+			sf.goDiscover(d)
+		}
+	}
+}
+
+// Start 启动所有发现者及上报
+func (sf *Discovery) Start() {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.started {
+		return
+	}
+	sf.started = true
+	for _, d := range sf.discoverers {
+		sf.goDiscover(d)
+	}
+	sf.wg.Add(1)
+	go sf.run()
+}
+
+// Close 停止所有发现者及上报
+func (sf *Discovery) Close() error {
+	sf.cancel()
+	sf.wg.Wait()
+	return nil
+}
+
+func (sf *Discovery) goDiscover(d Discoverer) {
+	sf.wg.Add(1)
+	go func() {
+		defer sf.wg.Done()
+		if err := d.Discover(sf.ctx, sf.Found); err != nil {
+			sf.c.Log.Warnf("gateway: discoverer stopped, %+v", err)
+		}
+	}()
+}
+
+// Found 上报发现的子设备候选,已管理的,已排队的或已上报未超过TTL的候选将被忽略
+func (sf *Discovery) Found(cs ...Candidate) {
+	now := time.Now()
+	sf.mu.Lock()
+	sf.expireLocked(now)
+	for _, cd := range cs {
+		if cd.ProductKey == "" || cd.DeviceName == "" {
+			continue
+		}
+		key := aiot.FormatKey(cd.ProductKey, cd.DeviceName)
+		if _, err := sf.c.Search(cd.ProductKey, cd.DeviceName); err == nil {
+			delete(sf.candidates, key)
+			continue
+		}
+		v, ok := sf.candidates[key]
+		if !ok {
+			v = &candidate{}
+			sf.candidates[key] = v
+		}
+		v.Candidate = cd
+		v.seen = now
+		if v.queued || (!v.reported.IsZero() && now.Sub(v.reported) < sf.ttl) {
+			continue
+		}
+		v.queued = true
+		sf.queue = append(sf.queue, key)
+	}
+	full := len(sf.queue) >= sf.batchSize
+	sf.mu.Unlock()
+	if full {
+		select {
+		case sf.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// expireLocked 移除超过TTL未再被发现的候选,如已被云端拒绝或已离开的子设备
+func (sf *Discovery) expireLocked(now time.Time) {
+	for key, v := range sf.candidates {
+		if !v.queued && now.Sub(v.seen) >= sf.ttl {
+			delete(sf.candidates, key)
+		}
+	}
+}
+
+// Candidates 已发现但云端尚未批准的子设备候选
+func (sf *Discovery) Candidates() []Candidate {
+	sf.mu.Lock()
+	cs := make([]Candidate, 0, len(sf.candidates))
+	for _, v := range sf.candidates {
+		cs = append(cs, v.Candidate)
+	}
+	sf.mu.Unlock()
+	sort.Slice(cs, func(i, j int) bool {
+		return aiot.FormatKey(cs[i].ProductKey, cs[i].DeviceName) <
+			aiot.FormatKey(cs[j].ProductKey, cs[j].DeviceName)
+	})
+	return cs
+}
+
+func (sf *Discovery) run() {
+	defer sf.wg.Done()
+	tk := time.NewTicker(sf.delay)
+	defer tk.Stop()
+	for {
+		select {
+		case <-sf.ctx.Done():
+			return
+		case <-tk.C:
+		case <-sf.flush:
+		}
+		sf.Report()
+	}
+}
+
+// Report 立即上报所有排队的候选
+func (sf *Discovery) Report() {
+	for {
+		sf.mu.Lock()
+		n := sf.batchSize
+		if len(sf.queue) < n {
+			n = len(sf.queue)
+		}
+		keys := sf.queue[:n]
+		sf.queue = sf.queue[n:]
+		pairs := make([]infra.MetaPair, 0, n)
+		for _, key := range keys {
+			if v, ok := sf.candidates[key]; ok {
+				pairs = append(pairs, v.MetaPair)
+			}
+		}
+		sf.mu.Unlock()
+		if len(keys) == 0 {
+			return
+		}
+		if len(pairs) == 0 {
+			continue
+		}
+
+		err := sf.c.LinkThingListFound(pairs, sf.timeout)
+		if err != nil {
+			sf.c.Log.Warnf("gateway: thing list found failed, %+v", err)
+		}
+		sf.mu.Lock()
+		for _, key := range keys {
+			if v, ok := sf.candidates[key]; ok {
+				v.queued = false
+				if err == nil {
+					v.reported = time.Now()
+				}
+			}
+		}
+		sf.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// approve 云端通知添加拓扑关系,子设备添加到设备管理并交由监管连接
+func (sf *Discovery) approve(pairs []infra.MetaPair) {
+	sf.mu.Lock()
+	for _, pair := range pairs {
+		meta := infra.MetaTriad{ProductKey: pair.ProductKey, DeviceName: pair.DeviceName}
+		sf.c.AddSubDevice(meta) // nolint: errcheck
+		delete(sf.candidates, aiot.FormatKey(pair.ProductKey, pair.DeviceName))
+	}
+	sf.mu.Unlock()
+	if sf.sup == nil {
+		return
+	}
+	for _, pair := range pairs {
+		sf.sup.Add(infra.MetaTriad{ProductKey: pair.ProductKey, DeviceName: pair.DeviceName})
+	}
+}
+
+// Callback 包装网关事件接口, 收到云端添加拓扑关系的通知时,
+// 子设备将交由监管自动连接, 然后再调用next, 使用 aiot.Client.SetGwCallback 设置
+func (sf *Discovery) Callback(next aiot.GwCallback) aiot.GwCallback {
+	if next == nil {
+		next = aiot.NopGwCb{}
+	}
+	return &discoveryCallback{next, sf}
+}
+
+type discoveryCallback struct {
+	aiot.GwCallback
+	d *Discovery
+}
+
+// ThingTopoAddNotify 实现 aiot.GwCallback 接口
+func (sf *discoveryCallback) ThingTopoAddNotify(c *aiot.Client, params []infra.MetaPair) error {
+	sf.d.approve(params)
+	return sf.GwCallback.ThingTopoAddNotify(c, params)
+}
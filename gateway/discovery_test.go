@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+func candidates(dns ...string) []Candidate {
+	cs := make([]Candidate, 0, len(dns))
+	for _, dn := range dns {
+		cs = append(cs, Candidate{
+			MetaPair: infra.MetaPair{ProductKey: "pk", DeviceName: dn},
+			Metadata: map[string]string{"addr": dn},
+		})
+	}
+	return cs
+}
+
+func TestDiscoveryFound(t *testing.T) {
+	c, cloud := newGateway(nil)
+	require.NoError(t, c.AddSubDevice(infra.MetaTriad{ProductKey: "pk", DeviceName: "a"}))
+
+	d := NewDiscovery(c, WithFoundBatch(2, time.Second), WithFoundTimeout(time.Second), WithFoundTTL(time.Millisecond*50))
+	d.Found(candidates("a", "b", "c", "b", "d")...) // a已管理,b重复
+	d.Report()
+	require.Equal(t, 2, cloud.Count("/thing/list/found"))
+	require.Equal(t, []infra.MetaPair{{ProductKey: "pk", DeviceName: "b"}, {ProductKey: "pk", DeviceName: "c"},
+		{ProductKey: "pk", DeviceName: "d"}}, cloud.Found())
+	require.Len(t, d.Candidates(), 3)
+
+	// 已上报未超过TTL的候选不再上报
+	d.Found(candidates("b", "c")...)
+	d.Report()
+	require.Equal(t, 2, cloud.Count("/thing/list/found"))
+
+	time.Sleep(time.Millisecond * 60)
+	d.Found(candidates("b")...)
+	d.Report()
+	require.Equal(t, 3, cloud.Count("/thing/list/found"))
+
+	// 超过TTL未再发现的候选被移除
+	require.Equal(t, []Candidate{candidates("b")[0]}, d.Candidates())
+
+	// 已被管理的候选被移除
+	require.NoError(t, c.AddSubDevice(infra.MetaTriad{ProductKey: "pk", DeviceName: "b"}))
+	d.Found(candidates("b")...)
+	require.Empty(t, d.Candidates())
+}
+
+func TestDiscoveryApprove(t *testing.T) {
+	c, cloud := newGateway(nil)
+	s := New(c, WithTimeout(time.Second))
+	s.Start()
+	defer s.Close()
+
+	d := NewDiscovery(c, WithSupervisor(s), WithFoundBatch(10, time.Millisecond*10))
+	c.SetGwCallback(d.Callback(nil))
+	scanned := make(chan struct{}, 1)
+	d.Register(Periodic(time.Millisecond*10, func(context.Context) ([]Candidate, error) {
+		select {
+		case scanned <- struct{}{}:
+		default:
+		}
+		return candidates("x", "y"), nil
+	}))
+	d.Start()
+	defer d.Close()
+
+	require.Eventually(t, func() bool { return cloud.Count("/thing/list/found") == 1 }, time.Second, time.Millisecond*10)
+	<-scanned
+
+	// 云端批准x
+	notify, err := json.Marshal(aiot.TopoAddNotifyRequest{
+		ID:     1,
+		Params: []infra.MetaPair{{ProductKey: "pk", DeviceName: "x"}},
+		Method: "thing.topo.add.notify",
+	})
+	require.NoError(t, err)
+	require.NoError(t, aiot.ProcThingTopoAddNotify(c, "/sys/gpk/gdn/thing/topo/add/notify", notify))
+	require.Eventually(t, func() bool { return c.IsActive("pk", "x") }, time.Second, time.Millisecond*10)
+
+	cs := d.Candidates()
+	require.Len(t, cs, 1)
+	require.Equal(t, "y", cs[0].DeviceName)
+	require.Equal(t, 1, cloud.Count("/thing/list/found"))
+}
@@ -0,0 +1,567 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package gateway 网关子设备管理
+// Supervisor 以声明式的方式维护网关下的子设备: 应用声明期望在线的子设备集合,
+// Supervisor 负责驱动各子设备经过 注册 -> 添加拓扑 -> 上线 -> 订阅 的各状态,
+// 失败时按退避策略重试, 单个子设备的失败不影响其它子设备.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// 平台限制
+const (
+	MaxBatchLogin = aiot.CombineBatchMax // 单个批次上下线的子设备数量不超过5个
+	MaxOnline     = 1500                 // 一个网关下同时在线的子设备数量不能超过1500
+)
+
+// 默认值
+const (
+	DefaultTimeout    = time.Second * 5
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = time.Minute * 2
+	DefaultInterval   = time.Second * 30
+)
+
+// 错误定义
+var (
+	ErrOnlineLimit = errors.New("gateway: online sub-devices reach limit")
+)
+
+// Progress 子设备的进度
+type Progress struct {
+	ProductKey string
+	DeviceName string
+	Status     aiot.DevStatus // 当前状态
+	Attempts   int            // 连续失败的次数
+	Err        error          // 最近一次失败的原因
+	Terminal   bool           // 是否为终态错误,终态后不再重试,直到重新声明该子设备
+	Next       time.Time      // 下次重试的时间
+}
+
+// Option 选项
+type Option func(*Supervisor)
+
+// WithTimeout 设置每个请求的超时时间,默认 DefaultTimeout
+func WithTimeout(t time.Duration) Option {
+	return func(s *Supervisor) {
+		if t > 0 {
+			s.timeout = t
+		}
+	}
+}
+
+// WithBackoff 设置失败重试的退避时间,从min开始每次失败翻倍,最大为max
+func WithBackoff(min, max time.Duration) Option {
+	return func(s *Supervisor) {
+		if min > 0 && max >= min {
+			s.minBackoff, s.maxBackoff = min, max
+		}
+	}
+}
+
+// WithMaxRetry 设置连续失败的最大次数,超过后转为终态错误,默认0不限制
+func WithMaxRetry(n int) Option {
+	return func(s *Supervisor) {
+		s.maxRetry = n
+	}
+}
+
+// WithInterval 设置巡检的间隔,默认 DefaultInterval
+func WithInterval(t time.Duration) Option {
+	return func(s *Supervisor) {
+		if t > 0 {
+			s.interval = t
+		}
+	}
+}
+
+// WithMaxOnline 设置同时在线的子设备数上限,不能超过平台限制 MaxOnline
+func WithMaxOnline(n int) Option {
+	return func(s *Supervisor) {
+		if n > 0 && n <= MaxOnline {
+			s.maxOnline = n
+		}
+	}
+}
+
+// WithCleanSession 设置子设备上线时的cleanSession
+func WithCleanSession(clean bool) Option {
+	return func(s *Supervisor) {
+		s.cleanSession = clean
+	}
+}
+
+// WithProgress 设置子设备进度的回调,状态变化或失败时调用
+func WithProgress(f func(p Progress)) Option {
+	return func(s *Supervisor) {
+		s.progress = f
+	}
+}
+
+type device struct {
+	meta     infra.MetaTriad
+	attempts int
+	next     time.Time
+	err      error
+	terminal bool
+}
+
+// Supervisor 子设备监管
+type Supervisor struct {
+	c            *aiot.Client
+	timeout      time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	maxRetry     int
+	interval     time.Duration
+	maxOnline    int
+	cleanSession bool
+	progress     func(p Progress)
+
+	mu      sync.Mutex
+	devices map[string]*device
+	removed map[string]infra.MetaPair
+
+	runMu  sync.Mutex // 保证同一时刻只有一次巡检
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New 创建子设备监管, c 需使能网关功能
+func New(c *aiot.Client, opts ...Option) *Supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Supervisor{
+		c:          c,
+		timeout:    DefaultTimeout,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+		interval:   DefaultInterval,
+		maxOnline:  MaxOnline,
+		devices:    make(map[string]*device),
+		removed:    make(map[string]infra.MetaPair),
+		wake:       make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Start 启动后台巡检
+func (sf *Supervisor) Start() {
+	sf.wg.Add(1)
+	go sf.run()
+}
+
+// Close 停止后台巡检,已在线的子设备保持在线
+func (sf *Supervisor) Close() error {
+	sf.cancel()
+	sf.wg.Wait()
+	return nil
+}
+
+// SetDesired 设置期望在线的子设备集合,不在集合中的子设备将被下线并移除
+// 子设备的DeviceSecret为空时,将通过子设备动态注册获取
+func (sf *Supervisor) SetDesired(metas ...infra.MetaTriad) {
+	sf.mu.Lock()
+	devices := make(map[string]*device, len(metas))
+	for _, meta := range metas {
+		devices[aiot.FormatKey(meta.ProductKey, meta.DeviceName)] = sf.declareLocked(meta)
+	}
+	for key, d := range sf.devices {
+		if _, ok := devices[key]; !ok {
+			sf.removed[key] = infra.MetaPair{ProductKey: d.meta.ProductKey, DeviceName: d.meta.DeviceName}
+		}
+	}
+	sf.devices = devices
+	sf.mu.Unlock()
+	sf.Trigger()
+}
+
+// Add 增加一个期望在线的子设备
+func (sf *Supervisor) Add(meta infra.MetaTriad) {
+	sf.mu.Lock()
+	sf.devices[aiot.FormatKey(meta.ProductKey, meta.DeviceName)] = sf.declareLocked(meta)
+	sf.mu.Unlock()
+	sf.Trigger()
+}
+
+// Remove 移除一个期望在线的子设备,子设备将被下线
+func (sf *Supervisor) Remove(pk, dn string) {
+	key := aiot.FormatKey(pk, dn)
+	sf.mu.Lock()
+	if _, ok := sf.devices[key]; ok {
+		delete(sf.devices, key)
+		sf.removed[key] = infra.MetaPair{ProductKey: pk, DeviceName: dn}
+	}
+	sf.mu.Unlock()
+	sf.Trigger()
+}
+
+// declareLocked 声明子设备,已存在的子设备更换密钥时清除失败记录
+func (sf *Supervisor) declareLocked(meta infra.MetaTriad) *device {
+	key := aiot.FormatKey(meta.ProductKey, meta.DeviceName)
+	delete(sf.removed, key)
+	d, ok := sf.devices[key]
+	if !ok {
+		return &device{meta: meta}
+	}
+	if meta.DeviceSecret != "" && meta.DeviceSecret != d.meta.DeviceSecret {
+		d.meta.DeviceSecret = meta.DeviceSecret
+		d.attempts, d.err, d.terminal, d.next = 0, nil, false, time.Time{}
+	}
+	return d
+}
+
+// Devices 所有期望在线的子设备的进度
+func (sf *Supervisor) Devices() []Progress {
+	sf.mu.Lock()
+	ps := make([]Progress, 0, len(sf.devices))
+	for _, d := range sf.devices {
+		ps = append(ps, sf.progressLocked(d))
+	}
+	sf.mu.Unlock()
+	sort.Slice(ps, func(i, j int) bool {
+		return aiot.FormatKey(ps[i].ProductKey, ps[i].DeviceName) <
+			aiot.FormatKey(ps[j].ProductKey, ps[j].DeviceName)
+	})
+	return ps
+}
+
+// Trigger 立即触发一次后台巡检
+func (sf *Supervisor) Trigger() {
+	select {
+	case sf.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Reconcile 同步执行一次巡检,使在线的子设备与期望集合一致
+func (sf *Supervisor) Reconcile() {
+	sf.reconcile()
+}
+
+func (sf *Supervisor) run() {
+	defer sf.wg.Done()
+	tm := time.NewTimer(0)
+	defer tm.Stop()
+	for {
+		select {
+		case <-sf.ctx.Done():
+			return
+		case <-sf.wake:
+			if !tm.Stop() {
+				select {
+				case <-tm.C:
+				default:
+				}
+			}
+		case <-tm.C:
+		}
+		next := sf.reconcile()
+		tm.Reset(time.Until(next))
+	}
+}
+
+// reconcile 巡检,返回下次巡检的时间
+func (sf *Supervisor) reconcile() time.Time {
+	sf.runMu.Lock()
+	defer sf.runMu.Unlock()
+
+	now := time.Now()
+	sf.mu.Lock()
+	removed := sf.removed
+	sf.removed = make(map[string]infra.MetaPair)
+	metas := make([]infra.MetaTriad, 0, len(sf.devices))
+	for _, d := range sf.devices {
+		if !d.terminal && !d.next.After(now) {
+			metas = append(metas, d.meta)
+		}
+	}
+	sf.mu.Unlock()
+	sort.Slice(metas, func(i, j int) bool {
+		return aiot.FormatKey(metas[i].ProductKey, metas[i].DeviceName) <
+			aiot.FormatKey(metas[j].ProductKey, metas[j].DeviceName)
+	})
+
+	sf.offline(removed)
+
+	online := sf.onlineCount()
+	var attached []infra.MetaTriad
+	for _, meta := range metas {
+		if sf.ctx.Err() != nil {
+			break
+		}
+		status, err := sf.attach(meta)
+		if err != nil {
+			sf.fail(meta, err)
+			continue
+		}
+		switch status {
+		case aiot.DevStatusAttached:
+			attached = append(attached, meta)
+		case aiot.DevStatusLogined:
+			sf.subscribe(meta) // 已上线但未订阅
+		}
+	}
+	if n := sf.maxOnline - online; len(attached) > n {
+		if n < 0 {
+			n = 0
+		}
+		for _, meta := range attached[n:] {
+			sf.limit(meta)
+		}
+		attached = attached[:n]
+	}
+	for len(attached) > 0 && sf.ctx.Err() == nil {
+		n := MaxBatchLogin
+		if len(attached) < n {
+			n = len(attached)
+		}
+		sf.login(attached[:n])
+		attached = attached[n:]
+	}
+	return sf.nextTime()
+}
+
+// attach 确保子设备已注册并已添加拓扑,返回当前状态
+func (sf *Supervisor) attach(meta infra.MetaTriad) (aiot.DevStatus, error) {
+	pk, dn := meta.ProductKey, meta.DeviceName
+	node, err := sf.c.SearchAvail(pk, dn)
+	if err == aiot.ErrNotFound {
+		if err = sf.c.AddSubDevice(meta); err != nil {
+			return aiot.DevStatusUnauthorized, err
+		}
+		node, err = sf.c.SearchAvail(pk, dn)
+	}
+	if err != nil {
+		return aiot.DevStatusUnauthorized, err
+	}
+	if meta.DeviceSecret != "" && node.DeviceSecret() != meta.DeviceSecret {
+		sf.c.SetDeviceSecret(pk, dn, meta.DeviceSecret) // nolint: errcheck
+	}
+
+	status := node.Status()
+	if status >= aiot.DevStatusAttached {
+		return status, nil
+	}
+	if !sf.c.HasDeviceSecret(pk, dn) {
+		if err = sf.c.SubDeviceRegister(pk, dn, sf.timeout); err != nil {
+			return status, err
+		}
+		sf.succeed(meta, aiot.DevStatusRegistered)
+	} else if status < aiot.DevStatusRegistered {
+		sf.c.UpdateDeviceStatus(pk, dn, aiot.DevStatusRegistered, aiot.StatusCauseRegister) // nolint: errcheck
+	}
+	if err = sf.c.LinkThingTopoAdd(pk, dn, sf.timeout); err != nil {
+		return aiot.DevStatusRegistered, err
+	}
+	sf.succeed(meta, aiot.DevStatusAttached)
+	return aiot.DevStatusAttached, nil
+}
+
+// login 批量上线,批量上线为原子接口,失败时逐个上线以隔离失败的子设备
+func (sf *Supervisor) login(metas []infra.MetaTriad) {
+	pairs := make([]aiot.CombinePair, 0, len(metas))
+	for _, meta := range metas {
+		pairs = append(pairs, aiot.CombinePair{
+			ProductKey:   meta.ProductKey,
+			DeviceName:   meta.DeviceName,
+			CleanSession: sf.cleanSession,
+		})
+	}
+	err := sf.c.LinkExtCombineBatchLogin(pairs, sf.timeout)
+	if err == nil {
+		for _, meta := range metas {
+			sf.succeed(meta, aiot.DevStatusLogined)
+			sf.subscribe(meta)
+		}
+		return
+	}
+	if len(pairs) == 1 {
+		sf.fail(metas[0], err)
+		return
+	}
+	for i, cp := range pairs {
+		if err := sf.c.LinkExtCombineLogin(cp, sf.timeout); err != nil {
+			sf.fail(metas[i], err)
+			continue
+		}
+		sf.succeed(metas[i], aiot.DevStatusLogined)
+		sf.subscribe(metas[i])
+	}
+}
+
+// subscribe 订阅子设备的所有主题,完成后子设备在线
+func (sf *Supervisor) subscribe(meta infra.MetaTriad) bool {
+	if err := sf.c.SubscribeAllTopic(meta.ProductKey, meta.DeviceName, true); err != nil {
+		sf.fail(meta, err)
+		return false
+	}
+	sf.c.UpdateDeviceStatus(meta.ProductKey, meta.DeviceName, aiot.DevStatusOnline, aiot.StatusCauseSubscribe) // nolint: errcheck
+	sf.succeed(meta, aiot.DevStatusOnline)
+	return true
+}
+
+// offline 下线并移除不再期望在线的子设备
+func (sf *Supervisor) offline(removed map[string]infra.MetaPair) {
+	pairs := make([]infra.MetaPair, 0, len(removed))
+	for _, pair := range removed {
+		node, err := sf.c.Search(pair.ProductKey, pair.DeviceName)
+		if err != nil {
+			continue
+		}
+		if node.Status() >= aiot.DevStatusLogined {
+			pairs = append(pairs, pair)
+		} else {
+			sf.c.Delete(pair.ProductKey, pair.DeviceName) // nolint: errcheck
+		}
+	}
+	for len(pairs) > 0 {
+		n := MaxBatchLogin
+		if len(pairs) < n {
+			n = len(pairs)
+		}
+		if err := sf.c.LinkExtCombineBatchLogout(pairs[:n], sf.timeout); err != nil {
+			sf.c.Log.Warnf("gateway: sub-devices logout failed, %+v", err)
+		}
+		for _, pair := range pairs[:n] {
+			sf.c.UnSubscribeAllTopic(pair.ProductKey, pair.DeviceName, true) // nolint: errcheck
+			sf.c.Delete(pair.ProductKey, pair.DeviceName)                    // nolint: errcheck
+		}
+		pairs = pairs[n:]
+	}
+}
+
+// onlineCount 网关下已上线的子设备数, 包括不由监管管理的子设备
+func (sf *Supervisor) onlineCount() int {
+	n := 0
+	for _, node := range sf.c.List() {
+		if node.Status() >= aiot.DevStatusLogined {
+			n++
+		}
+	}
+	return n
+}
+
+// nextTime 下次巡检的时间,为最早的重试时间,最迟不超过巡检间隔
+func (sf *Supervisor) nextTime() time.Time {
+	next := time.Now().Add(sf.interval)
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, d := range sf.devices {
+		if !d.terminal && d.err != nil && d.err != ErrOnlineLimit && d.next.Before(next) {
+			next = d.next
+		}
+	}
+	return next
+}
+
+func (sf *Supervisor) succeed(meta infra.MetaTriad, status aiot.DevStatus) {
+	sf.update(meta, func(d *device) {
+		if status == aiot.DevStatusOnline {
+			d.attempts, d.err, d.next = 0, nil, time.Time{}
+		}
+	})
+}
+
+func (sf *Supervisor) fail(meta infra.MetaTriad, err error) {
+	sf.update(meta, func(d *device) {
+		d.attempts++
+		d.err = err
+		d.terminal = isTerminal(err) || (sf.maxRetry > 0 && d.attempts >= sf.maxRetry)
+		d.next = time.Now().Add(sf.backoff(d.attempts))
+	})
+	sf.c.Log.Warnf("gateway: sub-device %s connect failed, %+v", aiot.FormatKey(meta.ProductKey, meta.DeviceName), err)
+}
+
+// limit 超过在线数上限,不计入失败次数,每次巡检时检查是否有空出的名额
+func (sf *Supervisor) limit(meta infra.MetaTriad) {
+	sf.update(meta, func(d *device) {
+		d.err = ErrOnlineLimit
+		d.next = time.Time{}
+	})
+}
+
+// update 更新子设备的记录并回调进度,子设备已不在期望集合中时忽略
+func (sf *Supervisor) update(meta infra.MetaTriad, f func(d *device)) {
+	sf.mu.Lock()
+	d, ok := sf.devices[aiot.FormatKey(meta.ProductKey, meta.DeviceName)]
+	if !ok {
+		sf.mu.Unlock()
+		return
+	}
+	f(d)
+	p := sf.progressLocked(d)
+	sf.mu.Unlock()
+	if sf.progress != nil {
+		sf.progress(p)
+	}
+}
+
+func (sf *Supervisor) progressLocked(d *device) Progress {
+	p := Progress{
+		ProductKey: d.meta.ProductKey,
+		DeviceName: d.meta.DeviceName,
+		Attempts:   d.attempts,
+		Err:        d.err,
+		Terminal:   d.terminal,
+		Next:       d.next,
+	}
+	if node, err := sf.c.Search(d.meta.ProductKey, d.meta.DeviceName); err == nil {
+		p.Status = node.Status()
+	}
+	return p
+}
+
+// backoff 第attempts次失败后的退避时间
+func (sf *Supervisor) backoff(attempts int) time.Duration {
+	d := sf.minBackoff
+	for i := 1; i < attempts && d < sf.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > sf.maxBackoff {
+		d = sf.maxBackoff
+	}
+	return d
+}
+
+// isTerminal 重试无法恢复的错误
+func isTerminal(err error) bool {
+	if errors.Is(err, aiot.ErrNotSupportFeature) ||
+		errors.Is(err, aiot.ErrNotPermit) ||
+		errors.Is(err, aiot.ErrInvalidParameter) {
+		return true
+	}
+	var e *infra.CodeError
+	if !errors.As(err, &e) {
+		return false
+	}
+	switch e.Code() {
+	case infra.CodeDeviceNotFound,
+		infra.CodeDeviceDisabled,
+		infra.CodeDevDynamicRegisterNotEnable,
+		infra.CodeDevHasBindGateway,
+		infra.CodeTopoRelationCannotAddBySelf,
+		infra.CodeSubDevDeleted,
+		infra.CodeSubDevDisabled,
+		infra.CodeSubDevSignInvalid:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,268 @@
+package gateway
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/internal/aiottest"
+)
+
+var gwMeta = infra.MetaTriad{ProductKey: "gpk", DeviceName: "gdn", DeviceSecret: "gds"}
+
+func newGateway(fail func(topic string, pairs []infra.MetaPair) int) (*aiot.Client, *aiottest.Cloud) {
+	c, cloud := aiottest.New(gwMeta, aiot.WithEnableGateway())
+	cloud.SetFail(fail)
+	return c, cloud
+}
+
+func subDevices(n int) []infra.MetaTriad {
+	metas := make([]infra.MetaTriad, 0, n)
+	for i := 0; i < n; i++ {
+		metas = append(metas, infra.MetaTriad{ProductKey: "pk", DeviceName: string(rune('a' + i))})
+	}
+	return metas
+}
+
+func TestSupervisor(t *testing.T) {
+	c, cloud := newGateway(nil)
+	s := New(c, WithTimeout(time.Second))
+	s.SetDesired(subDevices(7)...)
+	s.Reconcile()
+
+	for _, p := range s.Devices() {
+		require.NoError(t, p.Err)
+		require.Equal(t, aiot.DevStatusOnline, p.Status)
+		require.True(t, c.IsActive(p.ProductKey, p.DeviceName))
+	}
+	require.Equal(t, 7, cloud.Count("/thing/sub/register"))
+	require.Equal(t, 7, cloud.Count("/thing/topo/add"))
+	require.Equal(t, 2, cloud.Count("/combine/batch_login"))
+
+	// 已在线的子设备不再重复上线
+	s.Reconcile()
+	require.Equal(t, 2, cloud.Count("/combine/batch_login"))
+
+	// 移除的子设备下线
+	s.SetDesired(subDevices(2)...)
+	s.Reconcile()
+	require.Equal(t, 1, cloud.Count("/combine/batch_logout"))
+	require.Len(t, s.Devices(), 2)
+	_, err := c.Search("pk", "c")
+	require.Equal(t, aiot.ErrNotFound, err)
+}
+
+func TestSupervisorWatch(t *testing.T) {
+	c, _ := newGateway(nil)
+	ch, cancel := c.WatchChan(16)
+	s := New(c, WithTimeout(time.Second))
+	s.SetDesired(subDevices(1)...)
+	s.Reconcile()
+	cancel()
+
+	var events []aiot.DevStatusEvent
+	for e := range ch {
+		events = append(events, e)
+	}
+	require.Equal(t, []aiot.DevStatusEvent{
+		{MetaPair: infra.MetaPair{ProductKey: "pk", DeviceName: "a"}, Old: aiot.DevStatusUnauthorized, New: aiot.DevStatusRegistered, Cause: aiot.StatusCauseRegister},
+		{MetaPair: infra.MetaPair{ProductKey: "pk", DeviceName: "a"}, Old: aiot.DevStatusRegistered, New: aiot.DevStatusAttached, Cause: aiot.StatusCauseTopoAdd},
+		{MetaPair: infra.MetaPair{ProductKey: "pk", DeviceName: "a"}, Old: aiot.DevStatusAttached, New: aiot.DevStatusLogined, Cause: aiot.StatusCauseLogin},
+		{MetaPair: infra.MetaPair{ProductKey: "pk", DeviceName: "a"}, Old: aiot.DevStatusLogined, New: aiot.DevStatusOnline, Cause: aiot.StatusCauseSubscribe},
+	}, events)
+
+	st, err := c.DeviceStatus("pk", "a")
+	require.NoError(t, err)
+	require.Equal(t, aiot.DevStatusOnline, st)
+	require.Len(t, c.Filter(aiot.FilterProductKey("pk"), aiot.FilterStatus(aiot.DevStatusOnline)), 1)
+	require.Empty(t, c.Filter(aiot.FilterStatus(aiot.DevStatusAttached)))
+	require.Equal(t, aiot.ErrInvalidTransition, c.UpdateDeviceStatus("pk", "a", aiot.DevStatusOnline+1, aiot.StatusCauseSet))
+	require.NoError(t, c.UpdateDeviceStatus("pk", "a", aiot.DevStatusAttached, aiot.StatusCauseSet))
+	require.Equal(t, aiot.ErrInvalidTransition, c.UpdateDeviceStatus("pk", "a", aiot.DevStatusOnline, aiot.StatusCauseSet))
+	// SetDeviceStatus 不校验状态转换
+	require.NoError(t, c.SetDeviceStatus("pk", "a", aiot.DevStatusOnline))
+}
+
+func TestSupervisorProductRegister(t *testing.T) {
+	c, cloud := aiottest.New(gwMeta, aiot.WithEnableGateway(), aiot.WithProductSecret("pk", aiottest.ProductSecret))
+	s := New(c, WithTimeout(time.Second))
+	s.SetDesired(subDevices(2)...)
+	s.Reconcile()
+
+	for _, p := range s.Devices() {
+		require.NoError(t, p.Err)
+		require.Equal(t, aiot.DevStatusOnline, p.Status)
+	}
+	require.Equal(t, 2, cloud.Count("/thing/proxy/provisioning/product_register"))
+	require.Equal(t, 0, cloud.Count("/thing/sub/register"))
+	ds, err := c.DeviceSecret("pk", "a")
+	require.NoError(t, err)
+	require.Equal(t, "ds", ds)
+
+	// ProductSecret错误时注册失败
+	c.SetProductSecret("pk", "bad")
+	require.NoError(t, c.AddSubDevice(infra.MetaTriad{ProductKey: "pk", DeviceName: "x"}))
+	_, err = c.LinkThingProxyProductRegister("pk", "x", time.Second)
+	var codeErr *infra.CodeError
+	require.ErrorAs(t, err, &codeErr)
+	require.Equal(t, infra.CodeRequestError, codeErr.Code())
+
+	// 未设置ProductSecret
+	_, err = c.LinkThingProxyProductRegister("other", "x", time.Second)
+	require.Equal(t, aiot.ErrNotFound, err)
+}
+
+func TestSupervisorSigner(t *testing.T) {
+	var mu sync.Mutex
+	signed := 0
+	c, cloud := aiottest.New(gwMeta, aiot.WithEnableGateway(),
+		aiot.WithSigner(infra.SecretSigner(func(infra.MetaPair) (string, error) {
+			mu.Lock()
+			signed++
+			mu.Unlock()
+			return "ds", nil
+		})))
+	s := New(c, WithTimeout(time.Second))
+	s.SetDesired(subDevices(2)...)
+	s.Reconcile()
+
+	for _, p := range s.Devices() {
+		require.NoError(t, p.Err)
+		require.Equal(t, aiot.DevStatusOnline, p.Status)
+		ds, err := c.DeviceSecret(p.ProductKey, p.DeviceName)
+		require.NoError(t, err)
+		require.Empty(t, ds)
+	}
+	// 设备证书由签名器持有, 不进行动态注册
+	require.Equal(t, 0, cloud.Count("/thing/sub/register"))
+	require.Equal(t, 2, cloud.Count("/thing/topo/add"))
+	mu.Lock()
+	require.Equal(t, 4, signed) // 拓扑添加及上线各一次
+	mu.Unlock()
+}
+
+func TestSupervisorFailure(t *testing.T) {
+	var mu sync.Mutex
+	topoFailed := false
+	c, cloud := newGateway(func(topic string, pairs []infra.MetaPair) int {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, p := range pairs {
+			switch {
+			case p.DeviceName == "a" && strings.HasSuffix(topic, "/thing/sub/register"):
+				return infra.CodeDeviceNotFound // 终态错误
+			case p.DeviceName == "b" && strings.HasSuffix(topic, "/thing/topo/add") && !topoFailed:
+				topoFailed = true
+				return infra.CodeRequestTooMany // 暂时性错误
+			case p.DeviceName == "c" && strings.HasSuffix(topic, "login"):
+				return infra.CodeSubDevSessionError
+			}
+		}
+		return 0
+	})
+	var progress []Progress
+	s := New(c,
+		WithTimeout(time.Second),
+		WithBackoff(time.Millisecond*10, time.Millisecond*20),
+		WithMaxRetry(3),
+		WithProgress(func(p Progress) {
+			mu.Lock()
+			progress = append(progress, p)
+			mu.Unlock()
+		}))
+	s.SetDesired(subDevices(4)...)
+	s.Reconcile()
+
+	ps := s.Devices()
+	require.True(t, ps[0].Terminal)
+	require.Equal(t, aiot.DevStatusUnauthorized, ps[0].Status)
+	require.False(t, ps[1].Terminal)
+	require.Equal(t, aiot.DevStatusRegistered, ps[1].Status)
+	require.Equal(t, 1, ps[2].Attempts)
+	require.Equal(t, aiot.DevStatusAttached, ps[2].Status)
+	// 批量上线失败后逐个上线,不影响其它子设备
+	require.Equal(t, aiot.DevStatusOnline, ps[3].Status)
+	require.Equal(t, 1, cloud.Count("/combine/batch_login"))
+	require.Equal(t, 2, cloud.Count("/combine/login"))
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond * 30)
+		s.Reconcile()
+	}
+	ps = s.Devices()
+	require.Equal(t, aiot.DevStatusOnline, ps[1].Status)
+	require.NoError(t, ps[1].Err)
+	require.True(t, ps[2].Terminal)
+	require.Equal(t, 3, ps[2].Attempts)
+	require.Equal(t, 4, cloud.Count("/thing/sub/register")) // 终态错误不再重试
+
+	mu.Lock()
+	require.NotEmpty(t, progress)
+	mu.Unlock()
+}
+
+func TestSupervisorOnlineLimit(t *testing.T) {
+	c, _ := newGateway(nil)
+	s := New(c, WithTimeout(time.Second), WithMaxOnline(3))
+	s.SetDesired(subDevices(5)...)
+	s.Reconcile()
+
+	online := 0
+	for _, p := range s.Devices() {
+		if p.Status == aiot.DevStatusOnline {
+			online++
+		} else {
+			require.Equal(t, ErrOnlineLimit, p.Err)
+			require.Zero(t, p.Attempts)
+		}
+	}
+	require.Equal(t, 3, online)
+
+	// 移除已在线的子设备后,空出的名额由等待的子设备补上
+	s.SetDesired(subDevices(5)[2:]...)
+	s.Reconcile()
+	for _, p := range s.Devices() {
+		require.Equal(t, aiot.DevStatusOnline, p.Status)
+	}
+}
+
+func TestSupervisorOnlineLimitUnmanaged(t *testing.T) {
+	c, _ := newGateway(nil)
+	// 不由监管管理的在线子设备同样占用名额
+	require.NoError(t, c.AddSubDevice(infra.MetaTriad{ProductKey: "other", DeviceName: "x"}))
+	require.NoError(t, c.SetDeviceStatus("other", "x", aiot.DevStatusOnline))
+	s := New(c, WithTimeout(time.Second), WithMaxOnline(3))
+	s.SetDesired(subDevices(3)...)
+	s.Reconcile()
+
+	online := 0
+	for _, p := range s.Devices() {
+		if p.Status == aiot.DevStatusOnline {
+			online++
+		} else {
+			require.Equal(t, ErrOnlineLimit, p.Err)
+		}
+	}
+	require.Equal(t, 2, online)
+}
+
+func TestSupervisorStart(t *testing.T) {
+	c, _ := newGateway(nil)
+	s := New(c, WithTimeout(time.Second))
+	s.Start()
+	defer s.Close()
+
+	s.Add(infra.MetaTriad{ProductKey: "pk", DeviceName: "a", DeviceSecret: "ds"})
+	require.Eventually(t, func() bool { return c.IsActive("pk", "a") }, time.Second, time.Millisecond*10)
+
+	s.Remove("pk", "a")
+	require.Eventually(t, func() bool {
+		_, err := c.Search("pk", "a")
+		return err == aiot.ErrNotFound
+	}, time.Second, time.Millisecond*10)
+}
@@ -73,6 +73,7 @@ type Client struct {
 	endpoint   string
 	version    string
 	signMethod string
+	signer     infra.Signer
 
 	token atomic.Value
 	group singleflight.Group
@@ -112,7 +113,7 @@ func (sf *Client) getToken() (string, error) {
 }
 
 func (sf *Client) refreshToken() (string, error) {
-	if sf.triad.ProductKey == "" || sf.triad.DeviceName == "" || sf.triad.DeviceSecret == "" {
+	if sf.triad.ProductKey == "" || sf.triad.DeviceName == "" || (sf.signer == nil && sf.triad.DeviceSecret == "") {
 		return "", errors.New("invalid device meta triad")
 	}
 	tk, err, _ := sf.group.Do("auth", func() (interface{}, error) {
@@ -126,7 +127,15 @@ func (sf *Client) refreshToken() (string, error) {
 			signMethod = hmacmd5
 		}
 		timestamp := infra.Millisecond(time.Now())
-		clientID, sign := infra.CalcSign(signMethod, sf.triad, timestamp)
+		signer := sf.signer
+		if signer == nil {
+			signer = infra.TriadSigner(sf.triad)
+		}
+		clientID, sign, err := infra.CalcSignWith(signer, signMethod,
+			infra.MetaPair{ProductKey: sf.triad.ProductKey, DeviceName: sf.triad.DeviceName}, timestamp)
+		if err != nil {
+			return "", err
+		}
 
 		b, err := json.Marshal(&AuthRequest{
 			sf.version,
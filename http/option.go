@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/things-go/aliyun-iot/infra"
 	"github.com/things-go/aliyun-iot/logger"
 )
 
@@ -45,6 +46,13 @@ func WithSignMethod(method string) Option {
 	}
 }
 
+// WithSigner 设置签名器, 由签名器使用设备的DeviceSecret加签, 此时三元组的DeviceSecret可以为空
+func WithSigner(s infra.Signer) Option {
+	return func(c *Client) {
+		c.signer = s
+	}
+}
+
 // WithLogger 设置日志
 func WithLogger(l logger.Logger) Option {
 	return func(c *Client) {
@@ -35,3 +35,19 @@ func Hmac(method, key, val string) string {
 	h.Write([]byte(val)) // nolint: errCheck
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+// CRC16IBM 计算CRC16/IBM(ARC)校验值, 多项式0x8005, 初值0x0000, 输入输出反转
+func CRC16IBM(b []byte) uint16 {
+	var crc uint16
+	for _, v := range b {
+		crc ^= uint16(v)
+		for i := 0; i < 8; i++ {
+			if crc&0x0001 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -59,3 +59,12 @@ func TestHmac(t *testing.T) {
 		})
 	}
 }
+
+func TestCRC16IBM(t *testing.T) {
+	if got := CRC16IBM([]byte("123456789")); got != 0xbb3d {
+		t.Errorf("CRC16IBM() = %#04x, want 0xbb3d", got)
+	}
+	if got := CRC16IBM(nil); got != 0 {
+		t.Errorf("CRC16IBM(nil) = %#04x, want 0", got)
+	}
+}
@@ -105,6 +105,7 @@ const (
 	CodeSubDevDeleted      = 521  // 子设备已被删除
 	CodeSubDevDisabled     = 522  // 子设备已被禁用
 	CodeSubDevSignInvalid  = 6287 // 子设备密码或签名错误
+	CodeSubDevKickedOff    = 427  // 子设备被踢下线, 有使用相同设备证书信息的设备登录
 )
 
 // 设备属性、事件、服务相关错误码
@@ -10,7 +10,6 @@ import (
 	"io/ioutil"
 	"math/bits"
 	"math/rand"
-	"strconv"
 	"strings"
 	"time"
 	"unsafe"
@@ -57,12 +56,9 @@ func Time(msec int64) time.Time {
 // http 支持 hmacmd5, hmacsha1
 // timestamp: 时间戳,单位: ms
 func CalcSign(method string, meta MetaTriad, timestamp int64) (string, string) {
+	pair := MetaPair{ProductKey: meta.ProductKey, DeviceName: meta.DeviceName}
 	clientID := ClientID(meta.ProductKey, meta.DeviceName)
-	source := "clientId" + clientID +
-		"deviceName" + meta.DeviceName +
-		"productKey" + meta.ProductKey +
-		"timestamp" + strconv.FormatInt(timestamp, 10)
-	return clientID, Hmac(method, meta.DeviceSecret, source)
+	return clientID, Hmac(method, meta.DeviceSecret, SignContent(clientID, pair, timestamp))
 }
 
 // LoadCrt 加载tls cert
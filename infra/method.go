@@ -10,17 +10,23 @@ const (
 	MethodEventFormatPost          = "thing.event.%s.post"
 	MethodEventPropertyPackPost    = "thing.event.property.pack.post"
 	MethodEventPropertyHistoryPost = "thing.event.property.history.post"
+	MethodServicePropertySet       = "thing.service.property.set"
+	MethodServiceFormat            = "thing.service.%s"
 	MethodDeviceInfoUpdate         = "thing.deviceinfo.update"
 	MethodDeviceInfoDelete         = "thing.deviceinfo.delete"
 	MethodDesiredPropertyGet       = "thing.property.desired.get"
 	MethodDesiredPropertyDelete    = "thing.property.desired.delete"
 	MethodOtaFirmwareGet           = "thing.ota.firmware.get"
+	MethodFileDownload             = "thing.file.download"
+	MethodFileUploadInit           = "thing.file.upload.mqtt.init"
+	MethodFileUploadCancel         = "thing.file.upload.mqtt.cancel"
 	MethodDslTemplateGet           = "thing.dsltemplate.get"
 	MethodDynamicTslGet            = "thing.dynamicTsl.get"
 	MethodConfigGet                = "thing.config.get"
 	MethodConfigLogGet             = "thing.config.log.get"
 	MethodLogPost                  = "thing.log.post"
 	MethodSubDevRegister           = "thing.sub.register"
+	MethodProxyProductRegister     = "thing.proxy.provisioning.product_register"
 	MethodTopoAdd                  = "thing.topo.add"
 	MethodTopoDelete               = "thing.topo.delete"
 	MethodTopoGet                  = "thing.topo.get"
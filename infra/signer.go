@@ -0,0 +1,71 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package infra
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrNoSecret 签名器没有该设备的DeviceSecret
+var ErrNoSecret = errors.New("no device secret")
+
+// Signer 设备签名器, 使用设备的DeviceSecret对content加签, 返回十六进制的签名值.
+// 实现可以将DeviceSecret保存在安全芯片或独立的进程中, 使其不出现在本进程内存中
+// method: hmacmd5, hmacsha1, hmacsha256
+type Signer interface {
+	Sign(method string, pair MetaPair, content string) (string, error)
+}
+
+// SignerFunc 签名器函数适配
+type SignerFunc func(method string, pair MetaPair, content string) (string, error)
+
+// Sign 实现 Signer 接口
+func (f SignerFunc) Sign(method string, pair MetaPair, content string) (string, error) {
+	return f(method, pair, content)
+}
+
+// SecretSigner 默认的HMAC签名器, 通过函数获取设备的DeviceSecret
+type SecretSigner func(pair MetaPair) (string, error)
+
+// Sign 实现 Signer 接口
+func (f SecretSigner) Sign(method string, pair MetaPair, content string) (string, error) {
+	ds, err := f(pair)
+	if err != nil {
+		return "", err
+	}
+	if ds == "" {
+		return "", ErrNoSecret
+	}
+	return Hmac(method, ds, content), nil
+}
+
+// TriadSigner 持有单个设备三元组的HMAC签名器
+func TriadSigner(meta MetaTriad) Signer {
+	return SecretSigner(func(pair MetaPair) (string, error) {
+		if pair.ProductKey != meta.ProductKey || pair.DeviceName != meta.DeviceName {
+			return "", ErrNoSecret
+		}
+		return meta.DeviceSecret, nil
+	})
+}
+
+// SignContent 设备认证的加签内容, clientId{clientID}deviceName{dn}productKey{pk}timestamp{timestamp}
+func SignContent(clientID string, pair MetaPair, timestamp int64) string {
+	return "clientId" + clientID +
+		"deviceName" + pair.DeviceName +
+		"productKey" + pair.ProductKey +
+		"timestamp" + strconv.FormatInt(timestamp, 10)
+}
+
+// CalcSignWith 使用签名器计算签名, 返回clientID和加签后的值, 同 CalcSign
+func CalcSignWith(s Signer, method string, pair MetaPair, timestamp int64) (string, string, error) {
+	clientID := ClientID(pair.ProductKey, pair.DeviceName)
+	sign, err := s.Sign(method, pair, SignContent(clientID, pair, timestamp))
+	if err != nil {
+		return "", "", err
+	}
+	return clientID, sign, nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package aiottest 子包测试共用的模拟云端.
+// 根包aiot的内部测试因循环导入无法使用, 仍使用其自身的测试桩.
+package aiottest
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// 模拟云端使用的密钥
+const (
+	ProductSecret = "ps" // 一型一密免预注册校验签名的ProductSecret
+	DeviceSecret  = "ds" // 子设备动态注册下发的DeviceSecret
+)
+
+// Message 网关发布的消息
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// Cloud 模拟云端, 实现 aiot.Conn 接口, 按主题异步应答网关的请求
+type Cloud struct {
+	c *aiot.Client
+
+	mu       sync.Mutex
+	messages []Message
+	found    []infra.MetaPair
+	fail     func(topic string, pairs []infra.MetaPair) int
+}
+
+// New 创建模拟云端及连接它的客户端
+func New(meta infra.MetaTriad, opts ...aiot.Option) (*aiot.Client, *Cloud) {
+	cloud := &Cloud{}
+	cloud.c = aiot.New(meta, cloud, opts...)
+	return cloud.c, cloud
+}
+
+// SetFail 设置失败应答, fail返回非零时, 该主题的请求应答对应的错误码
+func (sf *Cloud) SetFail(fail func(topic string, pairs []infra.MetaPair) int) {
+	sf.mu.Lock()
+	sf.fail = fail
+	sf.mu.Unlock()
+}
+
+// Publish 实现 aiot.Conn 接口
+func (sf *Cloud) Publish(topic string, _ byte, payload interface{}) error {
+	b, _ := payload.([]byte)
+	sf.mu.Lock()
+	sf.messages = append(sf.messages, Message{topic, b})
+	fail := sf.fail
+	sf.mu.Unlock()
+
+	var req struct {
+		ID     uint            `json:"id,string"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(b, &req); err != nil {
+		return nil
+	}
+	var pairs []infra.MetaPair
+	var proc aiot.ProcDownStream
+	var data interface{}
+	switch {
+	case strings.HasSuffix(topic, "/thing/sub/register"):
+		json.Unmarshal(req.Params, &pairs) // nolint: errcheck
+		regs := make([]aiot.SubRegisterData, 0, len(pairs))
+		for _, p := range pairs {
+			regs = append(regs, aiot.SubRegisterData{ProductKey: p.ProductKey, DeviceName: p.DeviceName, DeviceSecret: DeviceSecret})
+		}
+		proc, data = aiot.ProcThingSubRegisterReply, regs
+	case strings.HasSuffix(topic, "/thing/proxy/provisioning/product_register"):
+		var params aiot.ProductRegisterParams
+		json.Unmarshal(req.Params, &params) // nolint: errcheck
+		var prd aiot.ProductRegisterData
+		for _, p := range params.Proxieds {
+			pairs = append(pairs, infra.MetaPair{ProductKey: p.ProductKey, DeviceName: p.DeviceName})
+			source := "deviceName" + p.DeviceName + "productKey" + p.ProductKey + "random" + p.Random
+			if infra.Hmac(p.SignMethod, ProductSecret, source) != p.Sign {
+				prd.ErrorInfos = append(prd.ErrorInfos, aiot.ProductRegisterError{
+					ProductKey: p.ProductKey, DeviceName: p.DeviceName, Code: infra.CodeRequestError, Message: "sign mismatch",
+				})
+				continue
+			}
+			prd.Successes = append(prd.Successes, aiot.SubRegisterData{ProductKey: p.ProductKey, DeviceName: p.DeviceName, DeviceSecret: DeviceSecret})
+		}
+		proc, data = aiot.ProcThingProxyProductRegisterReply, prd
+	case strings.HasSuffix(topic, "/thing/topo/add"):
+		json.Unmarshal(req.Params, &pairs) // nolint: errcheck
+		proc, data = aiot.ProcThingTopoAddReply, pairs
+	case strings.HasSuffix(topic, "/thing/topo/delete"):
+		json.Unmarshal(req.Params, &pairs) // nolint: errcheck
+		proc, data = aiot.ProcThingTopoDeleteReply, pairs
+	case strings.HasSuffix(topic, "/combine/batch_login"):
+		var params aiot.CombineBatchLoginParams
+		json.Unmarshal(req.Params, &params) // nolint: errcheck
+		for _, p := range params.DeviceList {
+			pairs = append(pairs, infra.MetaPair{ProductKey: p.ProductKey, DeviceName: p.DeviceName})
+		}
+		proc = aiot.ProcExtCombineBatchLoginReply
+	case strings.HasSuffix(topic, "/combine/login"):
+		var p infra.MetaPair
+		json.Unmarshal(req.Params, &p) // nolint: errcheck
+		pairs = append(pairs, p)
+		proc = aiot.ProcExtCombineLoginReply
+	case strings.HasSuffix(topic, "/combine/batch_logout"):
+		proc = aiot.ProcExtCombineBatchLogoutReply
+	case strings.HasSuffix(topic, "/combine/logout"):
+		proc = aiot.ProcExtCombineLogoutReply
+	case strings.HasSuffix(topic, "/thing/list/found"):
+		json.Unmarshal(req.Params, &pairs) // nolint: errcheck
+		sf.mu.Lock()
+		sf.found = append(sf.found, pairs...)
+		sf.mu.Unlock()
+		proc = aiot.ProcThingListFoundReply
+	case strings.HasSuffix(topic, "/post"):
+		proc = aiot.ProcThingEventPostReply
+	default:
+		return nil
+	}
+	rsp := aiot.Response{ID: req.ID, Code: infra.CodeSuccess, Data: data}
+	if fail != nil {
+		if code := fail(topic, pairs); code != 0 {
+			rsp.Code, rsp.Data = code, nil
+		}
+	}
+	out, _ := json.Marshal(rsp)
+	go func() {
+		time.Sleep(time.Millisecond * 5)
+		proc(sf.c, topic+"_reply", out) // nolint: errcheck
+	}()
+	return nil
+}
+
+// Subscribe 实现 aiot.Conn 接口
+func (sf *Cloud) Subscribe(string, aiot.ProcDownStream) error { return nil }
+
+// UnSubscribe 实现 aiot.Conn 接口
+func (sf *Cloud) UnSubscribe(...string) error { return nil }
+
+// Close 实现 aiot.Conn 接口
+func (sf *Cloud) Close() error { return nil }
+
+// Count 主题后缀为suffix的已发布消息数
+func (sf *Cloud) Count(suffix string) int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	n := 0
+	for _, m := range sf.messages {
+		if strings.HasSuffix(m.Topic, suffix) {
+			n++
+		}
+	}
+	return n
+}
+
+// Last 主题后缀为suffix的最后一条消息的负载, 没有时返回nil
+func (sf *Cloud) Last(suffix string) []byte {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for i := len(sf.messages) - 1; i >= 0; i-- {
+		if strings.HasSuffix(sf.messages[i].Topic, suffix) {
+			return sf.messages[i].Payload
+		}
+	}
+	return nil
+}
+
+// Found 已上报发现的子设备
+func (sf *Cloud) Found() []infra.MetaPair {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return append([]infra.MetaPair(nil), sf.found...)
+}
@@ -0,0 +1,451 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package local 网关的本地南向接口, 供网关旁运行的非Go进程(C, Python等驱动)作为子设备接入.
+// 接口为监听在Unix socket或本机回环地址上的HTTP/JSON, 下行事件以SSE(text/event-stream)推送.
+// 每个请求需以 X-Owner 头(或owner查询参数)标识驱动进程, 驱动进程只能操作自己注册的子设备,
+// 网关已管理但非经本接口注册的子设备不能被注册接管.
+// X-Owner 仅用于区分驱动进程, 并非认证, 本机任意可访问监听地址的进程均可冒用,
+// 访问控制依赖Unix socket的文件权限或回环地址.
+// 因aiot包不能导入本包(循环导入), 本接口未作为网关 aiot.Client 的选项内嵌,
+// 而是独立的服务, 由 Server.Callback 接入网关的下行回调.
+//
+//	POST   /v1/devices                         注册并连接子设备, body为 Device
+//	GET    /v1/devices                         驱动进程拥有的子设备
+//	DELETE /v1/devices/{pk}/{dn}               注销子设备
+//	POST   /v1/devices/{pk}/{dn}/properties    上报属性, body为属性的json对象
+//	POST   /v1/devices/{pk}/{dn}/events/{id}   上报事件, body为事件参数的json对象
+//	POST   /v1/devices/{pk}/{dn}/replies       回复下行事件, body为 Reply
+//	GET    /v1/events                          下行事件流, 每条事件为 Event
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/gateway"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// 默认值
+const (
+	DefaultTimeout   = 5 * time.Second  // 请求云端的超时时间
+	DefaultQueueSize = 64               // 每个事件流缓存的事件数
+	DefaultKeepAlive = 15 * time.Second // 事件流的保活间隔
+
+	// HeaderOwner 标识驱动进程的请求头
+	HeaderOwner = "X-Owner"
+)
+
+// 错误定义
+var (
+	ErrNotLoopback = errors.New("local: tcp address must be loopback")
+	ErrNoOwner     = errors.New("local: owner required")
+	ErrNotOwner    = errors.New("local: device owned by another process")
+	ErrManaged     = errors.New("local: device managed by gateway")
+	ErrNotListened = errors.New("local: owner not listening events")
+	ErrQueueFull   = errors.New("local: event queue full")
+)
+
+// 下行事件类型
+const (
+	EventPropertySet = "property_set" // 属性设置
+	EventService     = "service"      // 服务调用
+)
+
+// Device 子设备
+type Device struct {
+	ProductKey   string `json:"productKey"`
+	DeviceName   string `json:"deviceName"`
+	DeviceSecret string `json:"deviceSecret,omitempty"`
+	Status       string `json:"status,omitempty"` // 子设备状态, 仅应答中有效
+}
+
+// Event 下行事件
+type Event struct {
+	Type       string          `json:"type"`
+	ProductKey string          `json:"productKey"`
+	DeviceName string          `json:"deviceName"`
+	Service    string          `json:"service,omitempty"` // 服务标识符, 仅服务调用
+	ID         uint            `json:"id,string"`
+	Params     json.RawMessage `json:"params"`
+}
+
+// Reply 驱动进程对下行事件的回复, Type,Service,ID 同对应的 Event
+type Reply struct {
+	Type    string          `json:"type"`
+	Service string          `json:"service,omitempty"`
+	ID      uint            `json:"id,string"`
+	Code    int             `json:"code"` // 0或200为成功
+	Data    json.RawMessage `json:"data,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+// Option 选项
+type Option func(*Server)
+
+// WithTimeout 设置请求云端的超时时间, 默认 DefaultTimeout
+func WithTimeout(t time.Duration) Option {
+	return func(s *Server) {
+		if t > 0 {
+			s.timeout = t
+		}
+	}
+}
+
+// WithSupervisor 设置子设备监管, 注册的子设备交由监管连接, 注册请求立即返回
+func WithSupervisor(sup *gateway.Supervisor) Option {
+	return func(s *Server) {
+		s.sup = sup
+	}
+}
+
+// WithQueueSize 设置每个事件流缓存的事件数, 默认 DefaultQueueSize
+func WithQueueSize(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.queueSize = n
+		}
+	}
+}
+
+// WithKeepAlive 设置事件流的保活间隔, 默认 DefaultKeepAlive
+func WithKeepAlive(t time.Duration) Option {
+	return func(s *Server) {
+		if t > 0 {
+			s.keepAlive = t
+		}
+	}
+}
+
+// Server 本地南向接口服务, 实现 http.Handler 接口.
+// 子设备的归属仅保存在内存中, 网关重启后驱动进程需重新注册
+type Server struct {
+	c         *aiot.Client
+	sup       *gateway.Supervisor
+	timeout   time.Duration
+	queueSize int
+	keepAlive time.Duration
+
+	mu      sync.Mutex
+	owners  map[string]string  // FormatKey(pk, dn) -> owner
+	added   map[string]bool    // 经本接口添加到网关的子设备, FormatKey(pk, dn)
+	streams map[string]*stream // owner -> stream
+	srv     *http.Server
+}
+
+// New 创建本地南向接口服务
+func New(c *aiot.Client, opts ...Option) *Server {
+	s := &Server{
+		c:         c,
+		timeout:   DefaultTimeout,
+		queueSize: DefaultQueueSize,
+		keepAlive: DefaultKeepAlive,
+		owners:    make(map[string]string),
+		added:     make(map[string]bool),
+		streams:   make(map[string]*stream),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.srv = &http.Server{Handler: s}
+	return s
+}
+
+// Listen 监听本地地址, network为"unix"时移除残留的socket文件,
+// 为"tcp"时地址必须为回环地址
+func Listen(network, address string) (net.Listener, error) {
+	switch network {
+	case "unix":
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	case "tcp", "tcp4", "tcp6":
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, ErrNotLoopback
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// Serve 在l上提供服务, 直到 Close
+func (sf *Server) Serve(l net.Listener) error {
+	err := sf.srv.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Close 关闭服务及所有事件流
+func (sf *Server) Close() error {
+	sf.mu.Lock()
+	for owner, st := range sf.streams {
+		st.close()
+		delete(sf.streams, owner)
+	}
+	sf.mu.Unlock()
+	return sf.srv.Close()
+}
+
+// ServeHTTP 实现 http.Handler 接口
+func (sf *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	owner := r.Header.Get(HeaderOwner)
+	if owner == "" {
+		owner = r.URL.Query().Get("owner")
+	}
+	if owner == "" {
+		writeError(w, ErrNoOwner)
+		return
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(path) < 2 || path[0] != "v1" {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case len(path) == 2 && path[1] == "events" && r.Method == http.MethodGet:
+		sf.handleEvents(w, r, owner)
+	case len(path) == 2 && path[1] == "devices" && r.Method == http.MethodGet:
+		sf.handleList(w, owner)
+	case len(path) == 2 && path[1] == "devices" && r.Method == http.MethodPost:
+		sf.handleRegister(w, r, owner)
+	case len(path) >= 4 && path[1] == "devices":
+		pk, dn := path[2], path[3]
+		if err := sf.checkOwner(pk, dn, owner); err != nil {
+			writeError(w, err)
+			return
+		}
+		switch {
+		case len(path) == 4 && r.Method == http.MethodDelete:
+			sf.handleUnregister(w, pk, dn)
+		case len(path) == 5 && path[4] == "properties" && r.Method == http.MethodPost:
+			sf.handlePost(w, r, func(params json.RawMessage) error {
+				return sf.c.LinkThingEventPropertyPost(pk, dn, params, sf.timeout)
+			})
+		case len(path) == 6 && path[4] == "events" && r.Method == http.MethodPost:
+			sf.handlePost(w, r, func(params json.RawMessage) error {
+				return sf.c.LinkThingEventPost(pk, dn, path[5], params, sf.timeout)
+			})
+		case len(path) == 5 && path[4] == "replies" && r.Method == http.MethodPost:
+			sf.handleReply(w, r, pk, dn)
+		default:
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (sf *Server) owner(pk, dn string) string {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.owners[aiot.FormatKey(pk, dn)]
+}
+
+func (sf *Server) checkOwner(pk, dn, owner string) error {
+	switch sf.owner(pk, dn) {
+	case owner:
+		return nil
+	case "":
+		return aiot.ErrNotFound
+	default:
+		return ErrNotOwner
+	}
+}
+
+func (sf *Server) handleList(w http.ResponseWriter, owner string) {
+	owned := make(map[string]struct{})
+	sf.mu.Lock()
+	for key, o := range sf.owners {
+		if o == owner {
+			owned[key] = struct{}{}
+		}
+	}
+	sf.mu.Unlock()
+
+	devices := make([]Device, 0, len(owned))
+	for _, node := range sf.c.List() {
+		if _, ok := owned[aiot.FormatKey(node.ProductKey(), node.DeviceName())]; ok {
+			devices = append(devices, Device{
+				ProductKey: node.ProductKey(),
+				DeviceName: node.DeviceName(),
+				Status:     node.Status().String(),
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func (sf *Server) handleRegister(w http.ResponseWriter, r *http.Request, owner string) {
+	var dev Device
+	if err := json.NewDecoder(r.Body).Decode(&dev); err != nil || dev.ProductKey == "" || dev.DeviceName == "" {
+		writeError(w, aiot.ErrInvalidParameter)
+		return
+	}
+	key := aiot.FormatKey(dev.ProductKey, dev.DeviceName)
+	sf.mu.Lock()
+	if o, ok := sf.owners[key]; ok && o != owner {
+		sf.mu.Unlock()
+		writeError(w, ErrNotOwner)
+		return
+	}
+	sf.owners[key] = owner
+	sf.mu.Unlock()
+
+	meta := infra.MetaTriad{ProductKey: dev.ProductKey, DeviceName: dev.DeviceName, DeviceSecret: dev.DeviceSecret}
+	err := sf.c.AddSubDevice(meta)
+	if err == aiot.ErrDeviceHasExist {
+		// 网关自身管理的子设备不能被接管
+		sf.mu.Lock()
+		if !sf.added[key] {
+			err = ErrManaged
+		}
+		sf.mu.Unlock()
+	} else if err == nil {
+		sf.mu.Lock()
+		sf.added[key] = true
+		sf.mu.Unlock()
+	}
+	if err == aiot.ErrDeviceHasExist {
+		err = nil
+		if dev.DeviceSecret != "" {
+			err = sf.c.SetDeviceSecret(dev.ProductKey, dev.DeviceName, dev.DeviceSecret)
+		}
+	}
+	if err != nil {
+		sf.mu.Lock()
+		delete(sf.owners, key)
+		sf.mu.Unlock()
+		writeError(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	if sf.sup != nil {
+		sf.sup.Add(meta)
+		status = http.StatusAccepted
+	} else if !sf.c.IsActive(dev.ProductKey, dev.DeviceName) {
+		if err = sf.c.SubDeviceConnect(dev.ProductKey, dev.DeviceName, true, sf.timeout); err != nil {
+			sf.mu.Lock()
+			delete(sf.owners, key)
+			sf.mu.Unlock()
+			writeError(w, err)
+			return
+		}
+	}
+	st, _ := sf.c.DeviceStatus(dev.ProductKey, dev.DeviceName)
+	writeJSON(w, status, Device{ProductKey: dev.ProductKey, DeviceName: dev.DeviceName, Status: st.String()})
+}
+
+func (sf *Server) handleUnregister(w http.ResponseWriter, pk, dn string) {
+	sf.mu.Lock()
+	delete(sf.owners, aiot.FormatKey(pk, dn))
+	sf.mu.Unlock()
+	if sf.sup != nil {
+		sf.sup.Remove(pk, dn)
+	} else if sf.c.IsActive(pk, dn) {
+		if err := sf.c.LinkExtCombineLogout(pk, dn, sf.timeout); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (sf *Server) handlePost(w http.ResponseWriter, r *http.Request, post func(params json.RawMessage) error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var params map[string]json.RawMessage
+	if err = json.Unmarshal(b, &params); err != nil {
+		writeError(w, aiot.ErrInvalidParameter)
+		return
+	}
+	if err = post(b); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (sf *Server) handleReply(w http.ResponseWriter, r *http.Request, pk, dn string) {
+	var reply Reply
+	if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+		writeError(w, aiot.ErrInvalidParameter)
+		return
+	}
+	rsp := aiot.Response{ID: reply.ID, Code: reply.Code, Data: "{}", Message: reply.Message}
+	if rsp.Code == 0 {
+		rsp.Code = infra.CodeSuccess
+	}
+	if len(reply.Data) > 0 {
+		rsp.Data = reply.Data
+	}
+
+	var err error
+	switch reply.Type {
+	case EventPropertySet:
+		err = sf.c.ThingServicePropertySetResponse(pk, dn, rsp)
+	case EventService:
+		err = sf.c.ThingServiceResponse(pk, dn, reply.Service, rsp)
+	default:
+		err = aiot.ErrInvalidParameter
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errorBody 错误应答, code为云端的错误码(如有)
+type errorBody struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var status int
+	var codeErr *infra.CodeError
+	body := errorBody{Message: err.Error()}
+	switch {
+	case err == ErrNoOwner, err == aiot.ErrInvalidParameter:
+		status = http.StatusBadRequest
+	case err == ErrNotOwner, err == ErrManaged:
+		status = http.StatusForbidden
+	case err == aiot.ErrNotFound:
+		status = http.StatusNotFound
+	case err == aiot.ErrNotActive, err == aiot.ErrNotAvail:
+		status = http.StatusConflict
+	case err == aiot.ErrWaitTimeout:
+		status = http.StatusGatewayTimeout
+	case errors.As(err, &codeErr):
+		status, body.Code = http.StatusBadGateway, codeErr.Code()
+	default:
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) // nolint: errcheck
+}
@@ -0,0 +1,204 @@
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/internal/aiottest"
+)
+
+type testClient struct {
+	t     *testing.T
+	hc    *http.Client
+	owner string
+}
+
+func (sf *testClient) do(method, path, body string) (int, []byte) {
+	req, err := http.NewRequest(method, "http://local"+path, strings.NewReader(body))
+	require.NoError(sf.t, err)
+	req.Header.Set(HeaderOwner, sf.owner)
+	rsp, err := sf.hc.Do(req)
+	require.NoError(sf.t, err)
+	defer rsp.Body.Close()
+	b, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(sf.t, err)
+	return rsp.StatusCode, b
+}
+
+func newServer(t *testing.T) (*aiot.Client, *aiottest.Cloud, *http.Client) {
+	c, cloud := aiottest.New(infra.MetaTriad{ProductKey: "gpk", DeviceName: "gdn", DeviceSecret: "gds"},
+		aiot.WithEnableGateway())
+
+	dir, err := ioutil.TempDir("", "local")
+	require.NoError(t, err)
+	sock := filepath.Join(dir, "gateway.sock")
+	l, err := Listen("unix", sock)
+	require.NoError(t, err)
+	s := New(c, WithTimeout(time.Second), WithKeepAlive(time.Millisecond*20))
+	c.SetCallback(s.Callback(nil))
+	go s.Serve(l) // nolint: errcheck
+	t.Cleanup(func() {
+		s.Close()         // nolint: errcheck
+		os.RemoveAll(dir) // nolint: errcheck
+	})
+
+	hc := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	return c, cloud, hc
+}
+
+// failOn 主题后缀为suffix的请求应答错误码code
+func failOn(suffix string, code int) func(string, []infra.MetaPair) int {
+	return func(topic string, _ []infra.MetaPair) int {
+		if strings.HasSuffix(topic, suffix) {
+			return code
+		}
+		return 0
+	}
+}
+
+func TestServer(t *testing.T) {
+	c, cloud, hc := newServer(t)
+	drv := &testClient{t, hc, "driver"}
+	other := &testClient{t, hc, "other"}
+
+	code, body := drv.do(http.MethodPost, "/v1/devices", `{"productKey":"pk","deviceName":"dn"}`)
+	require.Equal(t, http.StatusOK, code, string(body))
+	require.True(t, c.IsActive("pk", "dn"))
+
+	code, body = drv.do(http.MethodGet, "/v1/devices", "")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `[{"productKey":"pk","deviceName":"dn","status":"online"}]`, string(body))
+
+	// 属性及事件上报
+	code, body = drv.do(http.MethodPost, "/v1/devices/pk/dn/properties", `{"temp":21.5}`)
+	require.Equal(t, http.StatusNoContent, code, string(body))
+	require.Contains(t, string(cloud.Last("/sys/pk/dn/thing/event/property/post")), `"temp":21.5`)
+	code, _ = drv.do(http.MethodPost, "/v1/devices/pk/dn/events/alarm", `{"level":1}`)
+	require.Equal(t, http.StatusNoContent, code)
+	require.NotNil(t, cloud.Last("/sys/pk/dn/thing/event/alarm/post"))
+	code, _ = drv.do(http.MethodPost, "/v1/devices/pk/dn/properties", `[1]`)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	cloud.SetFail(failOn("/post", infra.CodePropertyTooMuch))
+	code, body = drv.do(http.MethodPost, "/v1/devices/pk/dn/properties", `{"temp":21.5}`)
+	require.Equal(t, http.StatusBadGateway, code)
+	require.Contains(t, string(body), `"code":6104`)
+
+	// 其它驱动进程不能操作
+	code, _ = other.do(http.MethodPost, "/v1/devices/pk/dn/properties", `{"temp":1}`)
+	require.Equal(t, http.StatusForbidden, code)
+	code, _ = other.do(http.MethodPost, "/v1/devices", `{"productKey":"pk","deviceName":"dn"}`)
+	require.Equal(t, http.StatusForbidden, code)
+	code, _ = drv.do(http.MethodPost, "/v1/devices/pk/none/properties", `{"temp":1}`)
+	require.Equal(t, http.StatusNotFound, code)
+	code, _ = (&testClient{t, hc, ""}).do(http.MethodGet, "/v1/devices", "")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	// 网关自身管理的子设备不能被接管
+	require.NoError(t, c.AddSubDevice(infra.MetaTriad{ProductKey: "pk", DeviceName: "gw"}))
+	code, body = drv.do(http.MethodPost, "/v1/devices", `{"productKey":"pk","deviceName":"gw","deviceSecret":"x"}`)
+	require.Equal(t, http.StatusForbidden, code)
+	require.Contains(t, string(body), ErrManaged.Error())
+	ds, err := c.DeviceSecret("pk", "gw")
+	require.NoError(t, err)
+	require.Empty(t, ds)
+	code, _ = drv.do(http.MethodPost, "/v1/devices/pk/gw/properties", `{"temp":1}`)
+	require.Equal(t, http.StatusNotFound, code)
+
+	code, _ = drv.do(http.MethodDelete, "/v1/devices/pk/dn", "")
+	require.Equal(t, http.StatusNoContent, code)
+	require.False(t, c.IsActive("pk", "dn"))
+	code, body = drv.do(http.MethodGet, "/v1/devices", "")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `[]`, string(body))
+
+	// 经本接口添加的子设备注销后可再次注册
+	code, body = other.do(http.MethodPost, "/v1/devices", `{"productKey":"pk","deviceName":"dn"}`)
+	require.Equal(t, http.StatusOK, code, string(body))
+	require.True(t, c.IsActive("pk", "dn"))
+}
+
+func TestServerRegisterFailed(t *testing.T) {
+	c, cloud, hc := newServer(t)
+	drv := &testClient{t, hc, "driver"}
+	other := &testClient{t, hc, "other"}
+
+	cloud.SetFail(failOn("/combine/login", infra.CodeRequestError))
+	code, _ := drv.do(http.MethodPost, "/v1/devices", `{"productKey":"pk","deviceName":"dn"}`)
+	require.Equal(t, http.StatusBadGateway, code)
+	require.False(t, c.IsActive("pk", "dn"))
+
+	// 上线失败后释放归属, 其它驱动进程可以注册
+	cloud.SetFail(nil)
+	code, body := other.do(http.MethodPost, "/v1/devices", `{"productKey":"pk","deviceName":"dn"}`)
+	require.Equal(t, http.StatusOK, code, string(body))
+	require.True(t, c.IsActive("pk", "dn"))
+}
+
+func TestServerEvents(t *testing.T) {
+	c, cloud, hc := newServer(t)
+	drv := &testClient{t, hc, "driver"}
+	code, _ := drv.do(http.MethodPost, "/v1/devices", `{"productKey":"pk","deviceName":"dn"}`)
+	require.Equal(t, http.StatusOK, code)
+
+	// 未监听事件流时直接回复错误
+	require.NoError(t, aiot.ProcThingServiceRequest(c, "/sys/pk/dn/thing/service/property/set",
+		[]byte(`{"id":"1","version":"1.0","method":"thing.service.property.set","params":{"switch":1}}`)))
+	var rsp aiot.ResponseRawData
+	require.NoError(t, json.Unmarshal(cloud.Last("/sys/pk/dn/thing/service/property/set_reply"), &rsp))
+	require.Equal(t, infra.CodeRequestError, rsp.Code)
+
+	req, err := http.NewRequest(http.MethodGet, "http://local/v1/events?owner=driver", nil)
+	require.NoError(t, err)
+	stream, err := hc.Do(req)
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	require.Equal(t, "text/event-stream", stream.Header.Get("Content-Type"))
+
+	require.NoError(t, aiot.ProcThingServiceRequest(c, "/sys/pk/dn/thing/service/reboot",
+		[]byte(`{"id":"2","version":"1.0","method":"thing.service.reboot","params":{"delay":3}}`)))
+	r := bufio.NewReader(stream.Body)
+	var e Event
+	for {
+		line, err := r.ReadBytes('\n')
+		require.NoError(t, err)
+		if bytes.HasPrefix(line, []byte("data: ")) {
+			require.NoError(t, json.Unmarshal(line[len("data: "):], &e))
+			break
+		}
+	}
+	require.Equal(t, Event{EventService, "pk", "dn", "reboot", 2, json.RawMessage(`{"delay":3}`)}, e)
+
+	code, body := drv.do(http.MethodPost, "/v1/devices/pk/dn/replies", `{"type":"service","service":"reboot","id":"2","data":{"ok":true}}`)
+	require.Equal(t, http.StatusNoContent, code, string(body))
+	require.NoError(t, json.Unmarshal(cloud.Last("/sys/pk/dn/thing/service/reboot_reply"), &rsp))
+	require.Equal(t, uint(2), rsp.ID)
+	require.Equal(t, infra.CodeSuccess, rsp.Code)
+	require.JSONEq(t, `{"ok":true}`, string(rsp.Data))
+}
+
+func TestListen(t *testing.T) {
+	_, err := Listen("tcp", "0.0.0.0:0")
+	require.Equal(t, ErrNotLoopback, err)
+	l, err := Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l.Close() // nolint: errcheck
+}
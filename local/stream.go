@@ -0,0 +1,147 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// stream 驱动进程的下行事件流
+type stream struct {
+	ch   chan Event
+	done chan struct{}
+	once sync.Once
+}
+
+func (sf *stream) close() {
+	sf.once.Do(func() { close(sf.done) })
+}
+
+// handleEvents 以SSE推送下行事件, 同一驱动进程的新事件流将替换旧的事件流
+func (sf *Server) handleEvents(w http.ResponseWriter, r *http.Request, owner string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	st := &stream{
+		ch:   make(chan Event, sf.queueSize),
+		done: make(chan struct{}),
+	}
+	sf.mu.Lock()
+	if old, ok := sf.streams[owner]; ok {
+		old.close()
+	}
+	sf.streams[owner] = st
+	sf.mu.Unlock()
+	defer func() {
+		sf.mu.Lock()
+		if sf.streams[owner] == st {
+			delete(sf.streams, owner)
+		}
+		sf.mu.Unlock()
+		st.close()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	tk := time.NewTicker(sf.keepAlive)
+	defer tk.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-st.done:
+			return
+		case <-tk.C:
+			_, err = w.Write([]byte(": keepalive\n\n"))
+		case e := <-st.ch:
+			var b []byte
+			if b, err = json.Marshal(e); err == nil {
+				_, err = w.Write(append(append([]byte("event: "+e.Type+"\ndata: "), b...), '\n', '\n'))
+			}
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// deliver 将下行事件推送到子设备所属驱动进程的事件流
+func (sf *Server) deliver(owner string, e Event) error {
+	sf.mu.Lock()
+	st, ok := sf.streams[owner]
+	sf.mu.Unlock()
+	if !ok {
+		return ErrNotListened
+	}
+	select {
+	case st.ch <- e:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Callback 包装事件接口, 已注册到本地的子设备的属性设置及服务调用将推送到所属驱动进程,
+// 由驱动进程回复, 其它设备的事件交由next处理, 使用 aiot.Client.SetCallback 或 aiot.WithCallback 设置
+func (sf *Server) Callback(next aiot.Callback) aiot.Callback {
+	if next == nil {
+		next = aiot.NopCb{}
+	}
+	return &serverCallback{next, sf}
+}
+
+type serverCallback struct {
+	aiot.Callback
+	s *Server
+}
+
+// ThingServicePropertySet 实现 aiot.Callback 接口
+func (sf *serverCallback) ThingServicePropertySet(c *aiot.Client, pk, dn string, payload []byte) error {
+	owner := sf.s.owner(pk, dn)
+	if owner == "" {
+		return sf.Callback.ThingServicePropertySet(c, pk, dn, payload)
+	}
+	req := &aiot.RequestRawData{}
+	if err := json.Unmarshal(payload, req); err != nil {
+		return err
+	}
+	err := sf.s.deliver(owner, Event{EventPropertySet, pk, dn, "", req.ID, req.Params})
+	if err != nil {
+		return c.ThingServicePropertySetResponse(pk, dn,
+			aiot.Response{ID: req.ID, Code: infra.CodeRequestError, Data: "{}", Message: err.Error()})
+	}
+	return nil
+}
+
+// ThingServiceRequest 实现 aiot.Callback 接口
+func (sf *serverCallback) ThingServiceRequest(c *aiot.Client, srvID, pk, dn string, payload []byte) error {
+	owner := sf.s.owner(pk, dn)
+	if owner == "" {
+		return sf.Callback.ThingServiceRequest(c, srvID, pk, dn, payload)
+	}
+	req := &aiot.RequestRawData{}
+	if err := json.Unmarshal(payload, req); err != nil {
+		return err
+	}
+	err := sf.s.deliver(owner, Event{EventService, pk, dn, srvID, req.ID, req.Params})
+	if err != nil {
+		return c.ThingServiceResponse(pk, dn, srvID,
+			aiot.Response{ID: req.ID, Code: infra.CodeRequestError, Data: "{}", Message: err.Error()})
+	}
+	return nil
+}
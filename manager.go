@@ -5,6 +5,7 @@
 package aiot
 
 import (
+	"encoding/json"
 	"sync"
 
 	"github.com/things-go/aliyun-iot/infra"
@@ -23,11 +24,70 @@ const (
 	DevStatusOnline                        // After All Topic Subscribed
 )
 
+// String 实现 fmt.Stringer 接口
+func (sf DevStatus) String() string {
+	switch sf {
+	case DevStatusUnauthorized:
+		return "unauthorized"
+	case DevStatusAuthorized:
+		return "authorized"
+	case DevStatusRegistered:
+		return "registered"
+	case DevStatusAttached:
+		return "attached"
+	case DevStatusLogined:
+		return "logined"
+	case DevStatusOnline:
+		return "online"
+	}
+	return "unknown"
+}
+
+// CanTransitDevStatus 设备状态能否从from变为to,
+// 状态可以任意回退, 但升级到 DevStatusLogined 需处于 DevStatusAttached,
+// 升级到 DevStatusOnline 需处于 DevStatusLogined
+func CanTransitDevStatus(from, to DevStatus) bool {
+	switch {
+	case to <= from:
+		return true
+	case to == DevStatusLogined:
+		return from == DevStatusAttached
+	case to == DevStatusOnline:
+		return from == DevStatusLogined
+	}
+	return to <= DevStatusOnline
+}
+
 // DevMgr 设备管理
 type DevMgr struct {
 	root  DevNode // 网关设备节点或独立设备节点信息
 	rw    sync.RWMutex
 	nodes map[string]*DevNode
+	store DevStore
+
+	wmu       sync.Mutex
+	watcherID uint64
+	watchers  map[uint64]func(e DevStatusEvent)
+}
+
+// DevRecord 子设备的持久化记录
+type DevRecord struct {
+	ProductKey   string          `json:"productKey"`
+	DeviceName   string          `json:"deviceName"`
+	DeviceSecret string          `json:"deviceSecret"`
+	Avail        bool            `json:"avail"`
+	Status       DevStatus       `json:"status"`
+	Extend       json.RawMessage `json:"extend,omitempty"`
+}
+
+// DevStore 子设备持久化存储,实现需保证每次写入的原子性
+type DevStore interface {
+	// Load 加载所有子设备记录
+	Load() ([]DevRecord, error)
+	// Put 写入或更新一个子设备记录
+	Put(rec DevRecord) error
+	// Delete 删除一个子设备记录
+	Delete(pk, dn string) error
 }
 
 // DevNode 设备节点
@@ -74,6 +134,101 @@ func NewDevMgr(root infra.MetaTriad) *DevMgr {
 	}
 }
 
+// NewDevMgrWithStore 创建使用持久化存储的设备管理,并从存储中加载子设备,
+// 加载失败时返回的设备管理不进行持久化,见 LoadStore
+func NewDevMgrWithStore(root infra.MetaTriad, store DevStore) (*DevMgr, error) {
+	mgr := NewDevMgr(root)
+	return mgr, mgr.LoadStore(store)
+}
+
+// LoadStore 从存储中加载子设备,加载成功后使用该存储持久化,已使用存储时不再加载.
+// 加载失败时不使用该存储,以免未加载的记录被覆盖,可再次调用重试.
+// 内存中已有的子设备不被存储中的记录覆盖,并写入存储.
+// 子设备的会话在重启后不再存在,高于 DevStatusAttached 的状态将按 DevStatusAttached 持久化及加载,
+// 扩展参数以json格式持久化,加载后为 json.RawMessage
+func (sf *DevMgr) LoadStore(store DevStore) error {
+	sf.rw.Lock()
+	defer sf.rw.Unlock()
+	if sf.store != nil {
+		return nil
+	}
+	recs, err := store.Load()
+	if err != nil {
+		return err
+	}
+	for _, node := range sf.nodes {
+		if err = store.Put(node.record()); err != nil {
+			return err
+		}
+	}
+	for _, rec := range recs {
+		if rec.ProductKey == "" || rec.DeviceName == "" {
+			continue
+		}
+		key := FormatKey(rec.ProductKey, rec.DeviceName)
+		if _, ok := sf.nodes[key]; ok {
+			continue
+		}
+		node := &DevNode{
+			rec.ProductKey,
+			rec.DeviceName,
+			rec.DeviceSecret,
+			rec.Avail,
+			persistStatus(rec.Status),
+			nil,
+		}
+		if len(rec.Extend) > 0 {
+			node.ext = rec.Extend
+		}
+		sf.nodes[key] = node
+	}
+	sf.store = store
+	return nil
+}
+
+// persistStatus 持久化的状态
+func persistStatus(status DevStatus) DevStatus {
+	if status > DevStatusAttached {
+		return DevStatusAttached
+	}
+	return status
+}
+
+// record 子设备节点的持久化记录
+func (sf *DevNode) record() DevRecord {
+	rec := DevRecord{
+		ProductKey:   sf.productKey,
+		DeviceName:   sf.deviceName,
+		DeviceSecret: sf.deviceSecret,
+		Avail:        sf.avail,
+		Status:       persistStatus(sf.status),
+	}
+	if sf.ext != nil {
+		if b, err := json.Marshal(sf.ext); err == nil {
+			rec.Extend = b
+		}
+	}
+	return rec
+}
+
+// updateLocked 修改子设备节点,持久化记录有变化时写入存储
+func (sf *DevMgr) updateLocked(node *DevNode, f func(node *DevNode)) error {
+	if sf.store == nil || node == &sf.root {
+		f(node)
+		return nil
+	}
+	old := node.record()
+	f(node)
+	rec := node.record()
+	if old.DeviceSecret == rec.DeviceSecret &&
+		old.Avail == rec.Avail &&
+		old.Status == rec.Status &&
+		string(old.Extend) == string(rec.Extend) {
+		return nil
+	}
+	return sf.store.Put(rec)
+}
+
 // Len 设备个数,含root设备
 func (sf *DevMgr) Len() int {
 	sf.rw.RLock()
@@ -81,7 +236,7 @@ func (sf *DevMgr) Len() int {
 	return len(sf.nodes) + 1
 }
 
-// Add 增加一个子设备,子设备处于 DevStatusUnauthorized
+// Add 增加一个子设备,子设备处于 DevStatusUnauthorized, 持久化失败时不增加并返回错误
 func (sf *DevMgr) Add(meta infra.MetaTriad) error {
 	if meta.ProductKey == "" || meta.DeviceName == "" {
 		return ErrInvalidParameter
@@ -97,7 +252,7 @@ func (sf *DevMgr) Add(meta infra.MetaTriad) error {
 	if ok {
 		return ErrDeviceHasExist
 	}
-	sf.nodes[FormatKey(meta.ProductKey, meta.DeviceName)] = &DevNode{
+	node := &DevNode{
 		meta.ProductKey,
 		meta.DeviceName,
 		meta.DeviceSecret,
@@ -105,14 +260,37 @@ func (sf *DevMgr) Add(meta infra.MetaTriad) error {
 		DevStatusUnauthorized,
 		nil,
 	}
+	if sf.store != nil {
+		if err := sf.store.Put(node.record()); err != nil {
+			return err
+		}
+	}
+	sf.nodes[FormatKey(meta.ProductKey, meta.DeviceName)] = node
 	return nil
 }
 
-// Delete 删除一个子设备
-func (sf *DevMgr) Delete(pk, dn string) {
+// Delete 删除一个子设备, 返回值为持久化存储的错误,
+// 删除后通知所有观察者, 新状态为 DevStatusUnauthorized, 原因为 StatusCauseDelete
+func (sf *DevMgr) Delete(pk, dn string) error {
+	var err error
+
 	sf.rw.Lock()
-	defer sf.rw.Unlock()
-	delete(sf.nodes, FormatKey(pk, dn))
+	key := FormatKey(pk, dn)
+	node, ok := sf.nodes[key]
+	if !ok {
+		sf.rw.Unlock()
+		return nil
+	}
+	delete(sf.nodes, key)
+	if sf.store != nil {
+		err = sf.store.Delete(pk, dn)
+	}
+	sf.rw.Unlock()
+
+	sf.notify(DevStatusEvent{
+		infra.MetaPair{ProductKey: pk, DeviceName: dn}, node.status, DevStatusUnauthorized, StatusCauseDelete,
+	})
+	return err
 }
 
 func (sf *DevMgr) searchLocked(pk, dn string) (*DevNode, error) {
@@ -167,8 +345,7 @@ func (sf *DevMgr) SetDeviceSecret(pk, dn, ds string) error {
 	if err != nil {
 		return err
 	}
-	node.deviceSecret = ds
-	return nil
+	return sf.updateLocked(node, func(node *DevNode) { node.deviceSecret = ds })
 }
 
 // DeviceSecret 设备DeviceSecret
@@ -192,8 +369,7 @@ func (sf *DevMgr) SetDeviceAvail(pk, dn string, enable bool) error {
 	if err != nil {
 		return err
 	}
-	node.avail = enable
-	return nil
+	return sf.updateLocked(node, func(node *DevNode) { node.avail = enable })
 }
 
 // DeviceAvail 获取avail
@@ -208,21 +384,54 @@ func (sf *DevMgr) DeviceAvail(pk, dn string) (bool, error) {
 	return node.avail, nil
 }
 
-// SetDeviceStatus 设置设备的状态
+// SetDeviceStatus 设置设备的状态,不校验状态转换,原因为 StatusCauseSet,状态变化时通知所有观察者
 func (sf *DevMgr) SetDeviceStatus(pk, dn string, status DevStatus) error {
-	sf.rw.Lock()
-	defer sf.rw.Unlock()
+	return sf.setDeviceStatus(pk, dn, status, StatusCauseSet, false)
+}
 
+// UpdateDeviceStatus 设置设备的状态并注明原因,状态变化时通知所有观察者,
+// 状态变化不满足 CanTransitDevStatus 时返回 ErrInvalidTransition
+func (sf *DevMgr) UpdateDeviceStatus(pk, dn string, status DevStatus, cause string) error {
+	return sf.setDeviceStatus(pk, dn, status, cause, true)
+}
+
+func (sf *DevMgr) setDeviceStatus(pk, dn string, status DevStatus, cause string, check bool) error {
+	sf.rw.Lock()
 	node, err := sf.searchLocked(pk, dn)
 	if err != nil {
+		sf.rw.Unlock()
 		return err
 	}
-	node.status = status
-	return nil
+	old := node.status
+	if old == status {
+		sf.rw.Unlock()
+		return nil
+	}
+	if check && !CanTransitDevStatus(old, status) {
+		sf.rw.Unlock()
+		return ErrInvalidTransition
+	}
+	err = sf.updateLocked(node, func(node *DevNode) { node.status = status })
+	sf.rw.Unlock()
+
+	sf.notify(DevStatusEvent{infra.MetaPair{ProductKey: pk, DeviceName: dn}, old, status, cause})
+	return err
 }
 
 // DeviceStatus 获取设备的状态
-func (sf *DevMgr) DeviceStatus(pk, dn string, status DevStatus) error {
+func (sf *DevMgr) DeviceStatus(pk, dn string) (DevStatus, error) {
+	sf.rw.RLock()
+	defer sf.rw.RUnlock()
+
+	node, err := sf.searchLocked(pk, dn)
+	if err != nil {
+		return DevStatusUnauthorized, err
+	}
+	return node.status, nil
+}
+
+// SetExtend 设置设备的扩展参数
+func (sf *DevMgr) SetExtend(pk, dn string, ext interface{}) error {
 	sf.rw.Lock()
 	defer sf.rw.Unlock()
 
@@ -230,8 +439,7 @@ func (sf *DevMgr) DeviceStatus(pk, dn string, status DevStatus) error {
 	if err != nil {
 		return err
 	}
-	node.status = status
-	return nil
+	return sf.updateLocked(node, func(node *DevNode) { node.ext = ext })
 }
 
 // FormatKey format pk dn --> {pk}.{dn}
@@ -0,0 +1,85 @@
+package aiot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+var errStore = errors.New("store failed")
+
+// memStore 内存存储, loadErr/putErr非nil时对应操作失败
+type memStore struct {
+	recs    map[string]DevRecord
+	loadErr error
+	putErr  error
+}
+
+func (sf *memStore) Load() ([]DevRecord, error) {
+	if sf.loadErr != nil {
+		return nil, sf.loadErr
+	}
+	recs := make([]DevRecord, 0, len(sf.recs))
+	for _, rec := range sf.recs {
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+func (sf *memStore) Put(rec DevRecord) error {
+	if sf.putErr != nil {
+		return sf.putErr
+	}
+	sf.recs[FormatKey(rec.ProductKey, rec.DeviceName)] = rec
+	return nil
+}
+
+func (sf *memStore) Delete(pk, dn string) error {
+	delete(sf.recs, FormatKey(pk, dn))
+	return nil
+}
+
+func TestDevMgrLoadStore(t *testing.T) {
+	store := &memStore{
+		recs:    map[string]DevRecord{FormatKey("pk", "a"): {ProductKey: "pk", DeviceName: "a", DeviceSecret: "sa", Avail: true}},
+		loadErr: errStore,
+	}
+	c := New(testGateway, nil, WithEnableGateway(), WithDevStore(store))
+	require.Equal(t, errStore, c.LoadDevStore())
+	_, err := c.Search("pk", "a")
+	require.Equal(t, ErrNotFound, err)
+
+	// 加载成功前不持久化,不覆盖存储中的记录
+	require.NoError(t, c.AddSubDevice(infra.MetaTriad{ProductKey: "pk", DeviceName: "b"}))
+	require.Len(t, store.recs, 1)
+
+	store.loadErr = nil
+	require.NoError(t, c.LoadDevStore())
+	ds, err := c.DeviceSecret("pk", "a")
+	require.NoError(t, err)
+	require.Equal(t, "sa", ds)
+	require.Len(t, store.recs, 2)
+	require.Equal(t, 3, c.Len())
+
+	// 已加载不再加载
+	store.loadErr = errStore
+	require.NoError(t, c.LoadDevStore())
+}
+
+func TestDevMgrAddStoreFailed(t *testing.T) {
+	store := &memStore{recs: make(map[string]DevRecord)}
+	m, err := NewDevMgrWithStore(testGateway, store)
+	require.NoError(t, err)
+
+	store.putErr = errStore
+	require.Equal(t, errStore, m.Add(infra.MetaTriad{ProductKey: "pk", DeviceName: "a"}))
+	_, err = m.Search("pk", "a")
+	require.Equal(t, ErrNotFound, err)
+
+	store.putErr = nil
+	require.NoError(t, m.Add(infra.MetaTriad{ProductKey: "pk", DeviceName: "a"}))
+	require.Len(t, store.recs, 1)
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package aiot
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// 设备状态变化的原因
+const (
+	StatusCauseSet          = "set"           // 调用 SetDeviceStatus
+	StatusCauseRegister     = "register"      // 子设备注册
+	StatusCauseTopoAdd      = "topo add"      // 添加拓扑关系
+	StatusCauseTopoDelete   = "topo delete"   // 删除拓扑关系
+	StatusCauseTopology     = "topology"      // 与云端拓扑关系比对
+	StatusCauseLogin        = "login"         // 子设备上线
+	StatusCauseLogout       = "logout"        // 子设备下线
+	StatusCauseSubscribe    = "subscribe"     // 订阅子设备的所有主题
+	StatusCauseSessionError = "session error" // 子设备会话或离线错误
+	StatusCauseDelete       = "delete"        // 从设备管理中删除
+)
+
+// DevStatusEvent 设备状态变化事件
+type DevStatusEvent struct {
+	infra.MetaPair
+	Old   DevStatus
+	New   DevStatus
+	Cause string
+}
+
+// Watch 注册设备状态变化的回调,返回取消注册的函数,
+// 回调在状态变化的协程中同步调用,不应阻塞
+func (sf *DevMgr) Watch(f func(e DevStatusEvent)) (cancel func()) {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+	if sf.watchers == nil {
+		sf.watchers = make(map[uint64]func(e DevStatusEvent))
+	}
+	sf.watcherID++
+	id := sf.watcherID
+	sf.watchers[id] = f
+	return func() {
+		sf.wmu.Lock()
+		delete(sf.watchers, id)
+		sf.wmu.Unlock()
+	}
+}
+
+// WatchChan 以通道的方式观察设备状态变化,size为通道缓存大小,缓存满时丢弃事件,
+// 返回取消观察的函数,取消后通道将被关闭
+func (sf *DevMgr) WatchChan(size int) (<-chan DevStatusEvent, func()) {
+	var mu sync.Mutex
+	closed := false
+	ch := make(chan DevStatusEvent, size)
+	cancel := sf.Watch(func(e DevStatusEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	})
+	return ch, func() {
+		cancel()
+		mu.Lock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+		mu.Unlock()
+	}
+}
+
+func (sf *DevMgr) notify(e DevStatusEvent) {
+	sf.wmu.Lock()
+	fs := make([]func(e DevStatusEvent), 0, len(sf.watchers))
+	for _, f := range sf.watchers {
+		fs = append(fs, f)
+	}
+	sf.wmu.Unlock()
+	for _, f := range fs {
+		f(e)
+	}
+}
+
+// DevFilter 子设备节点的过滤条件
+type DevFilter func(node *DevNode) bool
+
+// FilterProductKey 过滤指定productKey的子设备
+func FilterProductKey(pk string) DevFilter {
+	return func(node *DevNode) bool { return node.productKey == pk }
+}
+
+// FilterStatus 过滤处于指定状态之一的子设备
+func FilterStatus(status ...DevStatus) DevFilter {
+	return func(node *DevNode) bool {
+		for _, st := range status {
+			if node.status == st {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FilterAvail 过滤avail为指定值的子设备
+func FilterAvail(avail bool) DevFilter {
+	return func(node *DevNode) bool { return node.avail == avail }
+}
+
+// List 所有子设备节点的快照,不含root设备,按 FormatKey 排序
+func (sf *DevMgr) List() []DevNode {
+	return sf.Filter()
+}
+
+// Filter 满足所有过滤条件的子设备节点的快照,不含root设备,按 FormatKey 排序
+func (sf *DevMgr) Filter(filters ...DevFilter) []DevNode {
+	sf.rw.RLock()
+	nodes := make([]DevNode, 0, len(sf.nodes))
+next:
+	for _, node := range sf.nodes {
+		for _, f := range filters {
+			if !f(node) {
+				continue next
+			}
+		}
+		nodes = append(nodes, *node)
+	}
+	sf.rw.RUnlock()
+	sort.Slice(nodes, func(i, j int) bool {
+		return FormatKey(nodes[i].productKey, nodes[i].deviceName) <
+			FormatKey(nodes[j].productKey, nodes[j].deviceName)
+	})
+	return nodes
+}
@@ -0,0 +1,133 @@
+package aiot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+func TestDevMgrWatchDelete(t *testing.T) {
+	m := NewDevMgr(testGateway)
+	require.NoError(t, m.Add(infra.MetaTriad{ProductKey: "pk", DeviceName: "dn"}))
+	require.NoError(t, m.UpdateDeviceStatus("pk", "dn", DevStatusAttached, StatusCauseTopoAdd))
+
+	var events []DevStatusEvent
+	cancel := m.Watch(func(e DevStatusEvent) { events = append(events, e) })
+	defer cancel()
+
+	require.NoError(t, m.Delete("pk", "dn"))
+	_, err := m.Search("pk", "dn")
+	require.Error(t, err)
+	require.Equal(t, []DevStatusEvent{{
+		MetaPair: infra.MetaPair{ProductKey: "pk", DeviceName: "dn"},
+		Old:      DevStatusAttached,
+		New:      DevStatusUnauthorized,
+		Cause:    StatusCauseDelete,
+	}}, events)
+
+	// 删除不存在的设备不通知
+	require.NoError(t, m.Delete("pk", "dn"))
+	require.Len(t, events, 1)
+}
+
+func TestDevMgrSetDeviceStatus(t *testing.T) {
+	m := NewDevMgr(testGateway)
+	require.NoError(t, m.Add(infra.MetaTriad{ProductKey: "pk", DeviceName: "dn"}))
+
+	var events []DevStatusEvent
+	cancel := m.Watch(func(e DevStatusEvent) { events = append(events, e) })
+	defer cancel()
+
+	// UpdateDeviceStatus 校验状态转换, SetDeviceStatus 不校验
+	require.Equal(t, ErrInvalidTransition, m.UpdateDeviceStatus("pk", "dn", DevStatusOnline, StatusCauseLogin))
+	require.NoError(t, m.SetDeviceStatus("pk", "dn", DevStatusOnline))
+	require.NoError(t, m.SetDeviceStatus("pk", "dn", DevStatusOnline))
+	require.Equal(t, []DevStatusEvent{{
+		MetaPair: infra.MetaPair{ProductKey: "pk", DeviceName: "dn"},
+		Old:      DevStatusUnauthorized,
+		New:      DevStatusOnline,
+		Cause:    StatusCauseSet,
+	}}, events)
+	require.Equal(t, ErrNotFound, m.SetDeviceStatus("pk", "none", DevStatusOnline))
+}
+
+func TestDevMgrWatchCancel(t *testing.T) {
+	m := NewDevMgr(testGateway)
+	require.NoError(t, m.Add(infra.MetaTriad{ProductKey: "pk", DeviceName: "dn"}))
+
+	var a, b int
+	cancelA := m.Watch(func(DevStatusEvent) { a++ })
+	cancelB := m.Watch(func(DevStatusEvent) { b++ })
+	require.NoError(t, m.SetDeviceStatus("pk", "dn", DevStatusAttached))
+	cancelA()
+	cancelA() // 重复取消无影响
+	require.NoError(t, m.SetDeviceStatus("pk", "dn", DevStatusRegistered))
+	cancelB()
+	require.NoError(t, m.SetDeviceStatus("pk", "dn", DevStatusAttached))
+	require.Equal(t, 1, a)
+	require.Equal(t, 2, b)
+}
+
+func TestDevMgrWatchChan(t *testing.T) {
+	m := NewDevMgr(testGateway)
+	require.NoError(t, m.Add(infra.MetaTriad{ProductKey: "pk", DeviceName: "dn"}))
+
+	// 缓存满时丢弃事件, 不阻塞状态变化
+	ch, cancel := m.WatchChan(1)
+	require.NoError(t, m.SetDeviceStatus("pk", "dn", DevStatusRegistered))
+	require.NoError(t, m.SetDeviceStatus("pk", "dn", DevStatusAttached))
+	require.NoError(t, m.Delete("pk", "dn"))
+	e := <-ch
+	require.Equal(t, DevStatusRegistered, e.New)
+	select {
+	case e := <-ch:
+		require.FailNow(t, "unexpected event", "%+v", e)
+	default:
+	}
+
+	// 取消后通道关闭, 不再接收事件
+	cancel()
+	cancel()
+	require.NoError(t, m.Add(infra.MetaTriad{ProductKey: "pk", DeviceName: "dn"}))
+	require.NoError(t, m.SetDeviceStatus("pk", "dn", DevStatusRegistered))
+	_, ok := <-ch
+	require.False(t, ok)
+}
+
+func TestDevMgrFilter(t *testing.T) {
+	m := NewDevMgr(testGateway)
+	for _, meta := range []infra.MetaTriad{
+		{ProductKey: "pk2", DeviceName: "c"},
+		{ProductKey: "pk1", DeviceName: "b"},
+		{ProductKey: "pk1", DeviceName: "a"},
+	} {
+		require.NoError(t, m.Add(meta))
+	}
+	require.NoError(t, m.SetDeviceStatus("pk1", "a", DevStatusOnline))
+	require.NoError(t, m.SetDeviceStatus("pk2", "c", DevStatusAttached))
+	require.NoError(t, m.SetDeviceAvail("pk1", "b", false))
+
+	names := func(nodes []DevNode) []string {
+		var ns []string
+		for _, node := range nodes {
+			ns = append(ns, node.ProductKey()+"."+node.DeviceName())
+		}
+		return ns
+	}
+	// 不含root设备, 按 FormatKey 排序
+	require.Equal(t, []string{"pk1.a", "pk1.b", "pk2.c"}, names(m.List()))
+	require.Equal(t, []string{"pk1.a", "pk1.b"}, names(m.Filter(FilterProductKey("pk1"))))
+	require.Equal(t, []string{"pk1.a", "pk2.c"}, names(m.Filter(FilterStatus(DevStatusOnline, DevStatusAttached))))
+	require.Equal(t, []string{"pk1.b"}, names(m.Filter(FilterAvail(false))))
+	require.Equal(t, []string{"pk1.a"}, names(m.Filter(FilterProductKey("pk1"), FilterAvail(true))))
+	require.Empty(t, m.Filter(FilterProductKey("pk2"), FilterStatus(DevStatusOnline)))
+
+	// 快照, 修改不影响设备管理
+	nodes := m.List()
+	nodes[0].status = DevStatusUnauthorized
+	st, err := m.DeviceStatus("pk1", "a")
+	require.NoError(t, err)
+	require.Equal(t, DevStatusOnline, st)
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package bspatch 流式应用 bsdiff(BSDIFF40格式) 差分补丁.
+// 补丁格式:
+//
+//	| "BSDIFF40" | ctrl压缩长度 8字节 | diff压缩长度 8字节 | 新文件长度 8字节 |
+//	| bzip2(ctrl) | bzip2(diff) | bzip2(extra) |
+//
+// 8字节整数为小端序的符号-幅值(最高位为符号位)表示.
+// 旧镜像通过 io.ReaderAt 按需读取,新镜像直接写入 io.Writer,不需要将镜像整体载入内存.
+package bspatch
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 补丁头
+const (
+	magic      = "BSDIFF40"
+	headerSize = 32
+	bufferSize = 32 * 1024
+)
+
+// 错误定义
+var (
+	ErrCorruptPatch = errors.New("bspatch: corrupt patch")
+)
+
+// Header 补丁头
+type Header struct {
+	CtrlLen int64 // 控制块压缩后的长度
+	DiffLen int64 // 差异块压缩后的长度
+	NewSize int64 // 新文件长度
+}
+
+// ReadHeader 读取补丁头, patchSize为补丁的长度, ctrl块及diff块须在补丁范围内
+func ReadHeader(patch io.ReaderAt, patchSize int64) (*Header, error) {
+	buf := make([]byte, headerSize)
+	if _, err := patch.ReadAt(buf, 0); err != nil {
+		if err == io.EOF {
+			err = ErrCorruptPatch
+		}
+		return nil, err
+	}
+	if string(buf[:8]) != magic {
+		return nil, ErrCorruptPatch
+	}
+	h := &Header{
+		CtrlLen: offtin(buf[8:]),
+		DiffLen: offtin(buf[16:]),
+		NewSize: offtin(buf[24:]),
+	}
+	if h.CtrlLen < 0 || h.DiffLen < 0 || h.NewSize < 0 ||
+		h.CtrlLen > patchSize-headerSize || h.DiffLen > patchSize-headerSize-h.CtrlLen {
+		return nil, ErrCorruptPatch
+	}
+	return h, nil
+}
+
+// Patch 将补丁patch应用到旧镜像old,新镜像写入w,返回新镜像的长度
+// oldSize为旧镜像的长度, patchSize为补丁的长度
+func Patch(old io.ReaderAt, oldSize int64, patch io.ReaderAt, patchSize int64, w io.Writer) (int64, error) {
+	h, err := ReadHeader(patch, patchSize)
+	if err != nil {
+		return 0, err
+	}
+	ctrl := bzip2.NewReader(io.NewSectionReader(patch, headerSize, h.CtrlLen))
+	diff := bufio.NewReaderSize(
+		bzip2.NewReader(io.NewSectionReader(patch, headerSize+h.CtrlLen, h.DiffLen)), bufferSize)
+	extra := bufio.NewReaderSize(
+		bzip2.NewReader(io.NewSectionReader(patch, headerSize+h.CtrlLen+h.DiffLen, patchSize-headerSize-h.CtrlLen-h.DiffLen)), bufferSize)
+
+	var oldPos, newPos int64
+	ctrlBuf := make([]byte, 24)
+	diffBuf := make([]byte, bufferSize)
+	oldBuf := make([]byte, bufferSize)
+	for newPos < h.NewSize {
+		if _, err = io.ReadFull(ctrl, ctrlBuf); err != nil {
+			return newPos, corrupt(err)
+		}
+		x, y, z := offtin(ctrlBuf), offtin(ctrlBuf[8:]), offtin(ctrlBuf[16:])
+		if x < 0 || y < 0 || newPos+x+y > h.NewSize {
+			return newPos, ErrCorruptPatch
+		}
+
+		// diff块与旧镜像对应字节相加
+		for remain := x; remain > 0; {
+			n := int64(len(diffBuf))
+			if remain < n {
+				n = remain
+			}
+			if _, err = io.ReadFull(diff, diffBuf[:n]); err != nil {
+				return newPos, corrupt(err)
+			}
+			if err = addOld(old, oldSize, oldPos, diffBuf[:n], oldBuf); err != nil {
+				return newPos, err
+			}
+			if _, err = w.Write(diffBuf[:n]); err != nil {
+				return newPos, err
+			}
+			remain -= n
+			oldPos += n
+			newPos += n
+		}
+
+		// extra块直接复制
+		if y > 0 {
+			if _, err = io.CopyN(w, extra, y); err != nil {
+				return newPos, corrupt(err)
+			}
+			newPos += y
+		}
+		oldPos += z
+	}
+	return newPos, nil
+}
+
+// addOld 将旧镜像[pos, pos+len(b))范围内的字节加到b上,超出旧镜像范围的部分不变
+func addOld(old io.ReaderAt, oldSize, pos int64, b, buf []byte) error {
+	start, end := pos, pos+int64(len(b))
+	if start < 0 {
+		start = 0
+	}
+	if end > oldSize {
+		end = oldSize
+	}
+	if start >= end {
+		return nil
+	}
+	ob := buf[:end-start]
+	if _, err := old.ReadAt(ob, start); err != nil && err != io.EOF {
+		return err
+	}
+	d := b[start-pos:]
+	for i := range ob {
+		d[i] += ob[i]
+	}
+	return nil
+}
+
+func corrupt(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrCorruptPatch
+	}
+	return fmt.Errorf("bspatch: %w", err)
+}
+
+// offtin 解码8字节小端序的符号-幅值整数
+func offtin(b []byte) int64 {
+	y := int64(b[7] & 0x7f)
+	for i := 6; i >= 0; i-- {
+		y = y<<8 | int64(b[i])
+	}
+	if b[7]&0x80 != 0 {
+		y = -y
+	}
+	return y
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package bspatch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readFixture(t *testing.T) (old, want, patch []byte) {
+	var err error
+	old, err = ioutil.ReadFile("testdata/old.bin")
+	require.NoError(t, err)
+	want, err = ioutil.ReadFile("testdata/new.bin")
+	require.NoError(t, err)
+	patch, err = ioutil.ReadFile("testdata/patch.bin")
+	require.NoError(t, err)
+	return old, want, patch
+}
+
+func TestPatch(t *testing.T) {
+	old, want, patch := readFixture(t)
+
+	h, err := ReadHeader(bytes.NewReader(patch), int64(len(patch)))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(want)), h.NewSize)
+
+	got := &bytes.Buffer{}
+	n, err := Patch(bytes.NewReader(old), int64(len(old)), bytes.NewReader(patch), int64(len(patch)), got)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(want)), n)
+	require.Equal(t, want, got.Bytes())
+}
+
+func TestPatchCorrupt(t *testing.T) {
+	old, _, patch := readFixture(t)
+
+	t.Run("magic", func(t *testing.T) {
+		p := append([]byte("BSDIFF41"), patch[8:]...)
+		_, err := Patch(bytes.NewReader(old), int64(len(old)), bytes.NewReader(p), int64(len(p)), ioutil.Discard)
+		require.ErrorIs(t, err, ErrCorruptPatch)
+	})
+
+	t.Run("short header", func(t *testing.T) {
+		_, err := Patch(bytes.NewReader(old), int64(len(old)), bytes.NewReader(patch[:10]), 10, ioutil.Discard)
+		require.ErrorIs(t, err, ErrCorruptPatch)
+	})
+
+	t.Run("block length", func(t *testing.T) {
+		// ctrl块及diff块长度之和溢出
+		p := append([]byte(nil), patch...)
+		copy(p[8:24], bytes.Repeat([]byte{0xff}, 16))
+		p[15], p[23] = 0x7f, 0x7f
+		_, err := ReadHeader(bytes.NewReader(p), int64(len(p)))
+		require.ErrorIs(t, err, ErrCorruptPatch)
+
+		// 超出补丁长度
+		_, err = ReadHeader(bytes.NewReader(patch), headerSize)
+		require.ErrorIs(t, err, ErrCorruptPatch)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := Patch(bytes.NewReader(old), int64(len(old)), bytes.NewReader(patch[:len(patch)-20]), int64(len(patch)-20), ioutil.Discard)
+		require.Error(t, err)
+	})
+}
+
+func TestOfftin(t *testing.T) {
+	require.Equal(t, int64(0x0102), offtin([]byte{0x02, 0x01, 0, 0, 0, 0, 0, 0}))
+	require.Equal(t, int64(-4000), offtin([]byte{0xa0, 0x0f, 0, 0, 0, 0, 0, 0x80}))
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/ota/bspatch"
+)
+
+// 差分升级错误
+var (
+	ErrNoDeltaSource = errors.New("ota: delta package without delta source")
+	ErrImageMismatch = errors.New("ota: patched image mismatch")
+	ErrNoImageDigest = errors.New("ota: delta package without patched image digest")
+)
+
+// 差分升级包extData中还原后完整固件的摘要.
+// 这些键不是平台定义的字段, 而是本包与应用之间的约定: 平台只透传推送升级包时的自定义参数(extData),
+// 应用需在推送差分升级包时以这些键携带完整固件的摘要. 采用其它约定时, 实现 DeltaSource.Digest
+const (
+	ExtDataImageSign       = "imageSign"       // 十六进制摘要
+	ExtDataImageSignMethod = "imageSignMethod" // 摘要方法, 见 SignMethodXXX, 为空表示同升级包的signMethod
+	ExtDataImageSize       = "imageSize"       // 固件长度
+)
+
+// ImageDigest 还原后完整固件的期望摘要
+type ImageDigest struct {
+	SignMethod string // 见 SignMethodXXX
+	Sign       string // 十六进制摘要
+	Size       int64  // 固件长度, 0表示不校验长度
+}
+
+// ExtDataDigest 从升级包的extData获取还原后完整固件的摘要, 没有摘要时返回 ErrNoImageDigest
+func ExtDataDigest(pkg *Package) (ImageDigest, error) {
+	ext := pkg.Data.ExtData
+	sign, _ := ext[ExtDataImageSign].(string)
+	if sign == "" {
+		return ImageDigest{}, ErrNoImageDigest
+	}
+	d := ImageDigest{SignMethod: pkg.Data.SignMethod, Sign: sign}
+	if v, _ := ext[ExtDataImageSignMethod].(string); v != "" {
+		d.SignMethod = v
+	}
+	switch v := ext[ExtDataImageSize].(type) {
+	case float64:
+		d.Size = int64(v)
+	case string:
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return ImageDigest{}, fmt.Errorf("ota: invalid %s, %w", ExtDataImageSize, err)
+		}
+		d.Size = size
+	}
+	return d, nil
+}
+
+// Image 当前运行的固件镜像
+type Image interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// DeltaSource 差分升级时提供当前运行的固件镜像,及还原后固件的期望摘要
+type DeltaSource interface {
+	// Open 打开当前运行的固件镜像, 返回镜像及其长度
+	Open(pkg *Package) (Image, int64, error)
+	// Digest 还原后固件的期望摘要, 摘要不能为空
+	Digest(pkg *Package) (ImageDigest, error)
+}
+
+// FileDeltaSource 以文件作为当前固件镜像的 DeltaSource, 还原后的摘要见 ExtDataDigest,
+// 使用其它extData约定时, 嵌入本类型并重新实现 Digest
+type FileDeltaSource func(pkg *Package) string
+
+// Open 实现 DeltaSource 接口
+func (f FileDeltaSource) Open(pkg *Package) (Image, int64, error) {
+	file, err := os.Open(f(pkg))
+	if err != nil {
+		return nil, 0, err
+	}
+	st, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, st.Size(), nil
+}
+
+// Digest 实现 DeltaSource 接口
+func (f FileDeltaSource) Digest(pkg *Package) (ImageDigest, error) { return ExtDataDigest(pkg) }
+
+// WithDeltaSource 设置差分升级的当前固件镜像来源, 差分升级包(isDiff = 1)将还原为完整固件后再安装
+func WithDeltaSource(src DeltaSource) Option {
+	return func(e *Engine) {
+		e.delta = src
+	}
+}
+
+// applyDelta 将差分包patch应用到当前固件镜像,还原后的固件写入pkg.File并校验
+func (sf *Engine) applyDelta(pkg *Package, patch string) error {
+	if sf.delta == nil {
+		return &Error{aiot.OtaProgressStepUpgradeFailed, ErrNoDeltaSource}
+	}
+	digest, err := sf.delta.Digest(pkg)
+	if err == nil && digest.Sign == "" {
+		err = ErrNoImageDigest
+	}
+	if err != nil {
+		return &Error{aiot.OtaProgressStepUpgradeFailed, err}
+	}
+	pf, err := os.Open(patch)
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+	st, err := pf.Stat()
+	if err != nil {
+		return err
+	}
+
+	img, size, err := sf.delta.Open(pkg)
+	if err != nil {
+		return &Error{aiot.OtaProgressStepUpgradeFailed, err}
+	}
+	defer img.Close()
+
+	out, err := os.Create(pkg.File)
+	if err != nil {
+		return err
+	}
+	_, err = bspatch.Patch(img, size, pf, st.Size(), out)
+	if err == nil {
+		err = out.Sync()
+	}
+	out.Close()
+	if err != nil {
+		os.Remove(pkg.File) // nolint: errcheck
+		return &Error{aiot.OtaProgressStepUpgradeFailed, err}
+	}
+
+	err = VerifyFile(pkg.File, aiot.OtaFirmwareData{Size: digest.Size, Sign: digest.Sign, SignMethod: digest.SignMethod})
+	if err != nil {
+		os.Remove(pkg.File) // nolint: errcheck
+		return &Error{aiot.OtaProgressStepVerifyFailed, fmt.Errorf("%w, %v", ErrImageMismatch, err)}
+	}
+	return nil
+}
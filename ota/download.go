@@ -0,0 +1,94 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// 下载缓冲大小
+const downloadBufferSize = 32 * 1024
+
+// download 下载url到文件name,如果文件已存在部分内容,使用Range请求断点续传
+// onProgress 在每次写入后调用
+func (sf *Engine) download(ctx context.Context, url, name string, size int64,
+	onProgress func(written, total int64)) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size > 0 && offset > size { // 文件异常,重新下载
+		offset = 0
+	}
+	if size > 0 && offset == size {
+		onProgress(offset, size)
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	rsp, err := sf.httpc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	switch rsp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK: // 服务器不支持Range, 从头下载
+		offset = 0
+	default:
+		return fmt.Errorf("ota: download status %s", rsp.Status)
+	}
+	if err = f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	total := size
+	if total <= 0 && rsp.ContentLength > 0 {
+		total = offset + rsp.ContentLength
+	}
+	written := offset
+	buf := make([]byte, downloadBufferSize)
+	for {
+		n, er := rsp.Body.Read(buf)
+		if n > 0 {
+			if _, err = f.Write(buf[:n]); err != nil {
+				return err
+			}
+			written += int64(n)
+			onProgress(written, total)
+		}
+		if er == io.EOF {
+			break
+		}
+		if er != nil {
+			return er
+		}
+	}
+	if size > 0 && written != size {
+		return fmt.Errorf("ota: download size %d, want %d", written, size)
+	}
+	return f.Sync()
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	aiot "github.com/things-go/aliyun-iot"
+)
+
+// MQTT下载默认值
+const (
+	DefaultBlockSize    = 4096
+	DefaultBlockTimeout = time.Second * 10
+	DefaultBlockRetry   = 3
+)
+
+// WithBlockSize 设置通过MQTT下载固件的分片大小,
+// 范围 [aiot.FileBlockSizeMin, aiot.FileBlockSizeMax], 默认 DefaultBlockSize
+func WithBlockSize(size int) Option {
+	return func(e *Engine) {
+		if size < aiot.FileBlockSizeMin {
+			size = aiot.FileBlockSizeMin
+		} else if size > aiot.FileBlockSizeMax {
+			size = aiot.FileBlockSizeMax
+		}
+		e.blockSize = size
+	}
+}
+
+// WithBlockTimeout 设置通过MQTT下载固件时单个分片的应答超时时间, 默认 DefaultBlockTimeout
+func WithBlockTimeout(timeout time.Duration) Option {
+	return func(e *Engine) {
+		e.blockTimeout = timeout
+	}
+}
+
+// WithBlockRetry 设置通过MQTT下载固件时单个分片的重试次数, 默认 DefaultBlockRetry
+// 设备离线或请求期间离线导致应答超时时,暂停至设备重新上线后从断点继续下载,不计入重试次数
+func WithBlockRetry(retry int) Option {
+	return func(e *Engine) {
+		e.blockRetry = retry
+	}
+}
+
+// downloadMQTT 通过MQTT分片下载固件到文件name,如果文件已存在部分内容,从断点继续下载
+// onProgress 在每个分片写入后调用
+func (sf *Engine) downloadMQTT(ctx context.Context, pk, dn string, data aiot.OtaFirmwareData, name string,
+	onProgress func(written, total int64)) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	size := data.Size
+	if size > 0 && offset > size { // 文件异常,重新下载
+		if err = f.Truncate(0); err != nil {
+			return err
+		}
+		if offset, err = f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	info := &aiot.FileInfo{StreamID: data.StreamID, FileID: data.StreamFileID}
+	for size <= 0 || offset < size {
+		blockSize := sf.blockSize
+		if size > 0 && size-offset < int64(blockSize) {
+			blockSize = int(size - offset)
+			if blockSize < aiot.FileBlockSizeMin {
+				blockSize = aiot.FileBlockSizeMin
+			}
+		}
+		rsp, err := sf.fetchBlock(ctx, pk, dn, aiot.FileDownloadParams{
+			FileInfo:  info,
+			FileBlock: aiot.FileBlock{Size: blockSize, Offset: offset},
+		})
+		if err != nil {
+			return err
+		}
+		if rsp.Data.BOffset != offset {
+			return fmt.Errorf("ota: block offset %d, want %d", rsp.Data.BOffset, offset)
+		}
+		if len(rsp.Block) == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if _, err = f.Write(rsp.Block); err != nil {
+			return err
+		}
+		offset += int64(len(rsp.Block))
+		if size <= 0 {
+			size = rsp.Data.FileLength
+		}
+		onProgress(offset, size)
+	}
+	return f.Sync()
+}
+
+// fetchBlock 请求一个分片,失败时重试
+func (sf *Engine) fetchBlock(ctx context.Context, pk, dn string,
+	params aiot.FileDownloadParams) (*aiot.FileDownloadReply, error) {
+	for attempt := 0; ; {
+		rsp, err := sf.c.LinkThingFileDownload(pk, dn, params, sf.blockTimeout)
+		if err == nil {
+			return rsp, nil
+		}
+		if errors.Is(err, aiot.ErrNotActive) || !sf.c.IsActive(pk, dn) {
+			if err = sf.waitActive(ctx, pk, dn); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if attempt++; attempt > sf.blockRetry {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(sf.blockTimeout / 10):
+		}
+	}
+}
+
+// waitActive 等待设备重新上线
+func (sf *Engine) waitActive(ctx context.Context, pk, dn string) error {
+	online := make(chan struct{}, 1)
+	cancel := sf.c.Watch(func(e aiot.DevStatusEvent) {
+		if e.ProductKey == pk && e.DeviceName == dn && e.New == aiot.DevStatusOnline {
+			select {
+			case online <- struct{}{}:
+			default:
+			}
+		}
+	})
+	defer cancel()
+	if sf.c.IsActive(pk, dn) {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-online:
+		return nil
+	}
+}
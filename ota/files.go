@@ -0,0 +1,78 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	aiot "github.com/things-go/aliyun-iot"
+)
+
+// WithPublicKey 设置校验升级包数字签名digestsign的公钥,
+// 设置后未签名或签名校验失败的升级包将上报校验失败, 见 VerifyDigestSign
+func WithPublicKey(pub crypto.PublicKey) Option {
+	return func(e *Engine) {
+		e.publicKey = pub
+	}
+}
+
+// fetchFiles 下载多文件升级包的所有文件并校验, 任一文件失败则整个升级包失败,
+// 已下载的部分保留用于断点续传, 完成后pkg.File为文件所在目录
+func (sf *Engine) fetchFiles(ctx context.Context, st *State, pkg *Package, rp *reporter) error {
+	data := pkg.Data
+	pkg.File = filepath.Join(sf.dir, jobKey(pkg.ProductKey, pkg.DeviceName, data.Module)+"-"+data.Version)
+	if err := os.MkdirAll(pkg.File, 0755); err != nil {
+		return err
+	}
+
+	var total, done int64
+	for _, f := range data.Files {
+		total += f.FileSize
+	}
+	names := make([]string, 0, len(data.Files))
+	for i, f := range data.Files {
+		name := filepath.Base(f.FileName)
+		if f.FileName == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+			name = "file" + strconv.Itoa(i)
+		}
+		name = filepath.Join(pkg.File, name)
+		err := sf.download(ctx, f.FileURL, name+partSuffix, f.FileSize, func(written, _ int64) {
+			rp.download(done+written, total)
+		})
+		if err != nil {
+			return &Error{aiot.OtaProgressStepDownloadFailed, fmt.Errorf("%s, %w", f.FileName, err)}
+		}
+		done += f.FileSize
+		names = append(names, name)
+	}
+
+	if err := sf.transit(st, PhaseVerifying, ""); err != nil {
+		return err
+	}
+	for i, f := range data.Files {
+		err := VerifyFile(names[i]+partSuffix, aiot.OtaFirmwareData{
+			Size:       f.FileSize,
+			Sign:       f.FileSign,
+			SignMethod: data.SignMethod,
+			MD5:        f.FileMD5,
+		})
+		if err != nil {
+			os.Remove(names[i] + partSuffix) // nolint: errcheck
+			return &Error{aiot.OtaProgressStepVerifyFailed, fmt.Errorf("%s, %w", f.FileName, err)}
+		}
+	}
+	for _, name := range names {
+		if err := os.Rename(name+partSuffix, name); err != nil {
+			return err
+		}
+	}
+	pkg.Files = names
+	return nil
+}
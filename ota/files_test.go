@@ -0,0 +1,153 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	aiot "github.com/things-go/aliyun-iot"
+)
+
+func multiFileData(url string, files map[string][]byte, order []string) aiot.OtaFirmwareData {
+	data := aiot.OtaFirmwareData{Version: "2.0.0", SignMethod: "SHA256"}
+	for _, name := range order {
+		b := files[name]
+		s := sha256.Sum256(b)
+		m := md5.Sum(b)
+		data.Files = append(data.Files, aiot.OtaFile{
+			FileName: name,
+			FileSize: int64(len(b)),
+			FileURL:  url + "/" + name,
+			FileSign: hex.EncodeToString(s[:]),
+			FileMD5:  hex.EncodeToString(m[:]),
+		})
+	}
+	return data
+}
+
+func signData(t *testing.T, key crypto.Signer, data *aiot.OtaFirmwareData) {
+	hashed := sha256.Sum256(DigestMessage(*data))
+	sig, err := key.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	require.NoError(t, err)
+	data.DigestSign = base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestVerifyDigestSign(t *testing.T) {
+	data := firmwareData("http://localhost/fw.bin", newFirmware(16))
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, VerifyDigestSign(&ecKey.PublicKey, data), ErrNoDigestSign)
+
+	signData(t, ecKey, &data)
+	require.NoError(t, VerifyDigestSign(&ecKey.PublicKey, data))
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	require.NoError(t, err)
+	pub, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.NoError(t, err)
+	require.NoError(t, VerifyDigestSign(pub, data))
+	require.ErrorIs(t, VerifyDigestSign(&rsaKey.PublicKey, data), ErrDigestSignMismatch)
+
+	signData(t, rsaKey, &data)
+	pub, err = ParsePublicKey(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey),
+	}))
+	require.NoError(t, err)
+	require.NoError(t, VerifyDigestSign(pub, data))
+
+	data.Sign = "tampered"
+	require.ErrorIs(t, VerifyDigestSign(pub, data), ErrDigestSignMismatch)
+}
+
+func TestEngineMultiFile(t *testing.T) {
+	files := map[string][]byte{
+		"app.bin":  newFirmware(3000),
+		"conf.bin": []byte("key=value"),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := files[filepath.Base(r.URL.Path)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(b))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "ota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	data := multiFileData(srv.URL, files, []string{"app.bin", "conf.bin"})
+	data.ExtData = map[string]interface{}{"key": "value"}
+	signData(t, key, &data)
+
+	t.Run("installed", func(t *testing.T) {
+		conn := &fakeConn{}
+		installed := make(map[string][]byte)
+		e := New(aiot.New(meta, conn), InstallerFunc(func(_ context.Context, pkg *Package) error {
+			require.Equal(t, "value", pkg.Data.ExtData["key"])
+			for i, name := range pkg.Files {
+				b, err := ioutil.ReadFile(name)
+				require.NoError(t, err)
+				installed[pkg.Data.Files[i].FileName] = b
+			}
+			return nil
+		}), WithDir(dir), WithPublicKey(&key.PublicKey))
+		require.NoError(t, e.Upgrade(context.Background(), "pk", "dn", data))
+		require.Equal(t, files, installed)
+		require.Equal(t, []string{"2.0.0"}, conn.versions())
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		conn := &fakeConn{}
+		e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error { return nil }),
+			WithDir(dir), WithPublicKey(&key.PublicKey))
+		unsigned := data
+		unsigned.DigestSign = ""
+		require.ErrorIs(t, e.Upgrade(context.Background(), "pk", "dn", unsigned), ErrNoDigestSign)
+		require.Equal(t, []int{aiot.OtaProgressStepVerifyFailed}, conn.steps())
+	})
+
+	t.Run("one file corrupt", func(t *testing.T) {
+		conn := &fakeConn{}
+		e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error {
+			t.Fatal("must not install")
+			return nil
+		}), WithDir(dir))
+		bad := multiFileData(srv.URL, files, []string{"app.bin", "conf.bin"})
+		bad.Files[1].FileSign = hex.EncodeToString(make([]byte, 32))
+		require.ErrorIs(t, e.Upgrade(context.Background(), "pk", "dn", bad), ErrDigestMismatch)
+		steps := conn.steps()
+		require.Equal(t, aiot.OtaProgressStepVerifyFailed, steps[len(steps)-1])
+	})
+}
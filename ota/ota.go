@@ -0,0 +1,539 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package ota 实现OTA升级引擎.
+// 收到平台推送的固件信息或请求固件信息的应答后,自动下载固件(支持断点续传),
+// 校验固件大小及签名,按设定的间隔上报升级进度,调用用户的安装器安装固件,
+// 升级成功后上报新的固件版本.
+// @see https://help.aliyun.com/document_detail/89307.html
+package ota
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	aiot "github.com/things-go/aliyun-iot"
+)
+
+// 默认值
+const (
+	DefaultProgressInterval = time.Second * 3
+	stateSuffix             = ".state"
+	partSuffix              = ".part"
+)
+
+// 错误定义
+var (
+	ErrInProgress     = errors.New("ota: upgrade in progress")
+	ErrNoURL          = errors.New("ota: firmware url empty")
+	ErrNoInstaller    = errors.New("ota: no installer for module")
+	ErrPendingConfirm = errors.New("ota: previous upgrade pending confirmation")
+)
+
+// Error 升级过程中的错误,Step为上报平台的失败进度, 见 aiot.OtaProgressStepXXX
+type Error struct {
+	Step int
+	Err  error
+}
+
+// Error 实现error接口
+func (sf *Error) Error() string {
+	return fmt.Sprintf("ota: step %d, %v", sf.Step, sf.Err)
+}
+
+// Unwrap 实现errors.Unwrap接口
+func (sf *Error) Unwrap() error { return sf.Err }
+
+// Package 已下载并通过校验的升级包, 差分升级时File为还原后的完整固件
+type Package struct {
+	ProductKey string
+	DeviceName string
+	Data       aiot.OtaFirmwareData
+	File       string   // 固件文件路径, 多文件升级包时为文件所在目录
+	Files      []string `json:",omitempty"` // 多文件升级包的文件路径, 与 Data.Files 一一对应
+}
+
+// Installer 固件安装器
+// 安装成功后引擎将上报新的固件版本,如果安装过程中设备重启,
+// 需在重启后调用 Engine.Start 完成版本上报, A/B分区升级见 SlotInstaller
+type Installer interface {
+	Install(ctx context.Context, pkg *Package) error
+}
+
+// InstallerFunc 安装器函数适配
+type InstallerFunc func(ctx context.Context, pkg *Package) error
+
+// Install 实现 Installer 接口
+func (f InstallerFunc) Install(ctx context.Context, pkg *Package) error { return f(ctx, pkg) }
+
+// VersionFunc 获取当前运行的固件版本, 未设置时使用 aiot.Client 的OTA模块注册表
+type VersionFunc func(productKey, deviceName, module string) string
+
+// Option option
+type Option func(*Engine)
+
+// WithDir 设置固件下载目录,默认 os.TempDir()/aiot-ota
+func WithDir(dir string) Option {
+	return func(e *Engine) {
+		e.dir = dir
+	}
+}
+
+// WithHTTPClient 设置下载固件使用的 http.Client, 默认 http.DefaultClient
+func WithHTTPClient(c *http.Client) Option {
+	return func(e *Engine) {
+		e.httpc = c
+	}
+}
+
+// WithProgressInterval 设置上报升级进度的最小间隔, 默认 DefaultProgressInterval
+func WithProgressInterval(d time.Duration) Option {
+	return func(e *Engine) {
+		e.progressInterval = d
+	}
+}
+
+// WithVersionFunc 设置获取当前固件版本的函数,用于重启后确认升级结果
+func WithVersionFunc(f VersionFunc) Option {
+	return func(e *Engine) {
+		e.version = f
+	}
+}
+
+// WithModuleInstaller 设置模块的安装器, 升级按 aiot.OtaFirmwareData.Module 路由到对应的安装器,
+// 未设置安装器的模块使用默认安装器
+func WithModuleInstaller(module string, installer Installer) Option {
+	return func(e *Engine) {
+		e.installers[aiot.OtaModuleName(module)] = installer
+	}
+}
+
+// Engine OTA升级引擎
+type Engine struct {
+	c          *aiot.Client
+	installer  Installer
+	installers map[string]Installer
+	flashers   map[string]SubDeviceFlasher
+
+	dir               string
+	httpc             *http.Client
+	progressInterval  time.Duration
+	version           VersionFunc
+	delta             DeltaSource
+	blockSize         int
+	blockTimeout      time.Duration
+	blockRetry        int
+	healthCheck       HealthCheck
+	confirmTimeout    time.Duration
+	windows           []Window
+	publicKey         crypto.PublicKey
+	subDevConcurrency int
+	now               func() time.Time
+
+	subDevSem chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	running   map[string]struct{}
+	confirms  map[string]chan error
+}
+
+var _ aiot.OtaUpgrader = (*Engine)(nil)
+
+// New 新建OTA升级引擎,并注册为Client的 aiot.OtaUpgrader
+// installer 为默认安装器,可以为nil,此时仅设置了安装器的模块可以升级
+func New(c *aiot.Client, installer Installer, opts ...Option) *Engine {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Engine{
+		c:          c,
+		installer:  installer,
+		installers: make(map[string]Installer),
+		flashers:   make(map[string]SubDeviceFlasher),
+
+		dir:               filepath.Join(os.TempDir(), "aiot-ota"),
+		httpc:             http.DefaultClient,
+		progressInterval:  DefaultProgressInterval,
+		blockSize:         DefaultBlockSize,
+		blockTimeout:      DefaultBlockTimeout,
+		blockRetry:        DefaultBlockRetry,
+		confirmTimeout:    DefaultConfirmTimeout,
+		subDevConcurrency: DefaultSubDeviceConcurrency,
+		now:               time.Now,
+
+		ctx:      ctx,
+		cancel:   cancel,
+		running:  make(map[string]struct{}),
+		confirms: make(map[string]chan error),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.subDevSem = make(chan struct{}, e.subDevConcurrency)
+	c.SetOtaUpgrader(e)
+	return e
+}
+
+// Start 设备重启后调用,确认重启前未完成的升级,并上报新的固件版本
+// 设置了 VersionFunc 时,当前版本与升级的版本不一致将上报烧写失败,
+// A/B分区升级将在后台进行健康确认, 确认失败或超时将自动回滚,
+// 重启前等待维护时间窗口的升级将在后台继续等待并安装
+func (sf *Engine) Start() error {
+	states, err := sf.loadStates()
+	if err != nil {
+		return err
+	}
+	for _, st := range states {
+		if st.Phase == PhaseScheduled {
+			sf.resume(st)
+			continue
+		}
+		if st.Phase != PhaseInstalling && st.Phase != PhasePendingConfirm {
+			continue
+		}
+		if _, ok := sf.installerOf(st.Package.ProductKey, st.Package.Data.Module).(SlotInstaller); !ok {
+			sf.confirm(st)
+			continue
+		}
+		sf.wg.Add(1)
+		go func(st *State) {
+			defer sf.wg.Done()
+			sf.confirm(st)
+		}(st)
+	}
+	return nil
+}
+
+// Close 取消所有正在进行的升级,并等待退出
+func (sf *Engine) Close() error {
+	sf.cancel()
+	sf.wg.Wait()
+	return nil
+}
+
+// OtaUpgrade 实现 aiot.OtaUpgrader 接口,在后台进行升级
+func (sf *Engine) OtaUpgrade(_ *aiot.Client, pk, dn string, data aiot.OtaFirmwareData) error {
+	key := jobKey(pk, dn, data.Module)
+	if !sf.acquire(key) {
+		return ErrInProgress
+	}
+	sf.wg.Add(1)
+	go func() {
+		defer sf.wg.Done()
+		defer sf.release(key)
+		if err := sf.run(sf.ctx, pk, dn, data); err != nil {
+			sf.c.Log.Errorf("ota: upgrade %s failed, %+v", key, err)
+		}
+	}()
+	return nil
+}
+
+// Upgrade 同步进行一次升级
+func (sf *Engine) Upgrade(ctx context.Context, pk, dn string, data aiot.OtaFirmwareData) error {
+	key := jobKey(pk, dn, data.Module)
+	if !sf.acquire(key) {
+		return ErrInProgress
+	}
+	defer sf.release(key)
+	return sf.run(ctx, pk, dn, data)
+}
+
+func (sf *Engine) acquire(key string) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if _, ok := sf.running[key]; ok {
+		return false
+	}
+	sf.running[key] = struct{}{}
+	return true
+}
+
+func (sf *Engine) release(key string) {
+	sf.mu.Lock()
+	delete(sf.running, key)
+	sf.mu.Unlock()
+}
+
+// resume 在后台继续重启前等待维护时间窗口的升级
+func (sf *Engine) resume(st *State) {
+	pkg := st.Package
+	key := jobKey(pkg.ProductKey, pkg.DeviceName, pkg.Data.Module)
+	if !sf.acquire(key) {
+		return
+	}
+	sf.wg.Add(1)
+	go func() {
+		defer sf.wg.Done()
+		defer sf.release(key)
+		err := sf.limit(sf.ctx, pkg.ProductKey)
+		if err != nil {
+			return
+		}
+		defer sf.unlimit(pkg.ProductKey)
+
+		installer := sf.installerOf(pkg.ProductKey, pkg.Data.Module)
+		if installer == nil {
+			err = &Error{aiot.OtaProgressStepUpgradeFailed, ErrNoInstaller}
+		} else if _, err = os.Stat(pkg.File); err == nil {
+			err = sf.install(sf.ctx, st, installer)
+		}
+		if st.Phase == PhaseScheduled && sf.ctx.Err() != nil {
+			return
+		}
+		os.RemoveAll(pkg.File) // nolint: errcheck
+		if err != nil {
+			sf.fail(st, pkg.ProductKey, pkg.DeviceName, pkg.Data.Module, err)
+			sf.c.Log.Errorf("ota: upgrade %s failed, %+v", key, err)
+		}
+	}()
+}
+
+// limit 限制子设备并发升级数
+func (sf *Engine) limit(ctx context.Context, pk string) error {
+	if _, ok := sf.flashers[pk]; !ok {
+		return nil
+	}
+	select {
+	case sf.subDevSem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (sf *Engine) unlimit(pk string) {
+	if _, ok := sf.flashers[pk]; ok {
+		<-sf.subDevSem
+	}
+}
+
+func (sf *Engine) run(ctx context.Context, pk, dn string, data aiot.OtaFirmwareData) error {
+	if err := sf.limit(ctx, pk); err != nil {
+		return err
+	}
+	defer sf.unlimit(pk)
+	st := &State{Phase: PhaseIdle}
+	if old, err := loadState(sf.statePath(pk, dn, data.Module)); err == nil {
+		switch old.Phase {
+		case PhasePendingConfirm:
+			sf.progress(pk, dn, data.Module, aiot.OtaProgressStepUpgradeFailed, ErrPendingConfirm.Error())
+			return &Error{aiot.OtaProgressStepUpgradeFailed, ErrPendingConfirm}
+		case PhaseCommitted, PhaseRolledBack:
+			st.Phase = old.Phase
+		}
+	}
+
+	err := sf.upgrade(ctx, st, pk, dn, data)
+	if err != nil && !(st.Phase == PhaseScheduled && ctx.Err() != nil) { // 等待维护窗口时中断不视为失败
+		sf.fail(st, pk, dn, data.Module, err)
+	}
+	return err
+}
+
+// fail 升级失败,回到idle并上报失败的进度
+func (sf *Engine) fail(st *State, pk, dn, module string, err error) {
+	step, desc := aiot.OtaProgressStepUpgradeFailed, err.Error()
+	var e *Error
+	if errors.As(err, &e) {
+		step, desc = e.Step, e.Err.Error()
+	}
+	if st.Package != nil {
+		if er := sf.transit(st, PhaseIdle, desc); er != nil {
+			sf.c.Log.Warnf("ota: %+v", er)
+		}
+	}
+	sf.progress(pk, dn, module, step, desc)
+}
+
+func (sf *Engine) upgrade(ctx context.Context, st *State, pk, dn string, data aiot.OtaFirmwareData) error {
+	installer := sf.installerOf(pk, data.Module)
+	if installer == nil {
+		return &Error{aiot.OtaProgressStepUpgradeFailed, ErrNoInstaller}
+	}
+	viaMQTT := strings.EqualFold(data.DProtocol, aiot.OtaDProtocolMQTT)
+	if data.URL == "" && !viaMQTT && len(data.Files) == 0 {
+		return &Error{aiot.OtaProgressStepDownloadFailed, ErrNoURL}
+	}
+	if sf.publicKey != nil {
+		if err := VerifyDigestSign(sf.publicKey, data); err != nil {
+			return &Error{aiot.OtaProgressStepVerifyFailed, err}
+		}
+	}
+	if err := os.MkdirAll(sf.dir, 0755); err != nil {
+		return err
+	}
+	pkg := &Package{ProductKey: pk, DeviceName: dn, Data: data}
+	st.Package = pkg
+	err := sf.transit(st, PhaseDownloading, "")
+	if err != nil {
+		return err
+	}
+	rp := sf.newReporter(pk, dn, data.Module, "downloading")
+	if _, ok := installer.(*flasherInstaller); ok { // 子设备下载占进度的一半
+		rp.span = 50
+	}
+	if len(data.Files) > 0 {
+		err = sf.fetchFiles(ctx, st, pkg, rp)
+	} else {
+		err = sf.fetch(ctx, st, pkg, viaMQTT, rp)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if st.Phase != PhaseScheduled { // 等待维护窗口时中断, 保留固件待重启后继续
+			os.RemoveAll(pkg.File) // nolint: errcheck
+		}
+	}()
+	return sf.install(ctx, st, installer)
+}
+
+// install 安装已校验的固件, 不在维护时间窗口内时先持久化为scheduled再等待
+func (sf *Engine) install(ctx context.Context, st *State, installer Installer) error {
+	pkg := st.Package
+	if st.Phase != PhaseScheduled && untilWindow(sf.windows, sf.now()) > 0 {
+		if err := sf.transit(st, PhaseScheduled, ""); err != nil {
+			return err
+		}
+	}
+	if err := sf.waitWindow(ctx); err != nil {
+		return err
+	}
+	if err := sf.transit(st, PhaseInstalling, ""); err != nil {
+		return err
+	}
+	if err := installer.Install(ctx, pkg); err != nil {
+		return &Error{aiot.OtaProgressStepProgramFailed, err}
+	}
+	if _, ok := installer.(SlotInstaller); ok { // 等待重启后确认
+		return sf.transit(st, PhasePendingConfirm, "")
+	}
+	if err := sf.transit(st, PhaseCommitted, ""); err != nil {
+		return err
+	}
+	sf.inform(pkg.ProductKey, pkg.DeviceName, pkg.Data.Module, pkg.Data.Version)
+	return nil
+}
+
+// fetch 下载单文件固件并校验, 差分包还原为完整固件, 完成后固件为pkg.File
+func (sf *Engine) fetch(ctx context.Context, st *State, pkg *Package, viaMQTT bool, rp *reporter) error {
+	var err error
+
+	data := pkg.Data
+	pkg.File = filepath.Join(sf.dir, jobKey(pkg.ProductKey, pkg.DeviceName, data.Module)+"-"+data.Version+".bin")
+	part := pkg.File + partSuffix
+	if viaMQTT {
+		err = sf.downloadMQTT(ctx, pkg.ProductKey, pkg.DeviceName, data, part, rp.download)
+	} else {
+		err = sf.download(ctx, data.URL, part, data.Size, rp.download)
+	}
+	if err != nil {
+		return &Error{aiot.OtaProgressStepDownloadFailed, err}
+	}
+	if err = sf.transit(st, PhaseVerifying, ""); err != nil {
+		return err
+	}
+	if err = VerifyFile(part, data); err != nil {
+		os.Remove(part) // nolint: errcheck
+		return &Error{aiot.OtaProgressStepVerifyFailed, err}
+	}
+	if data.IsDiff == 1 { // 差分包,还原为完整固件
+		err = sf.applyDelta(pkg, part)
+		os.Remove(part) // nolint: errcheck
+		return err
+	}
+	return os.Rename(part, pkg.File)
+}
+
+func (sf *Engine) installerOf(pk, module string) Installer {
+	if f, ok := sf.flashers[pk]; ok {
+		return &flasherInstaller{sf, f}
+	}
+	if installer, ok := sf.installers[aiot.OtaModuleName(module)]; ok {
+		return installer
+	}
+	return sf.installer
+}
+
+func (sf *Engine) currentVersion(pk, dn, module string) (string, bool) {
+	if sf.version != nil {
+		return sf.version(pk, dn, module), true
+	}
+	return sf.c.OtaModuleVersion(pk, dn, module)
+}
+
+func (sf *Engine) progress(pk, dn, module string, step int, desc string) {
+	err := sf.c.OtaProgress(pk, dn, aiot.OtaProgressParams{Step: step, Desc: desc, Module: module})
+	if err != nil {
+		sf.c.Log.Warnf("ota: report progress %d of %s failed, %+v", step, jobKey(pk, dn, module), err)
+	}
+}
+
+// inform 上报升级后的版本,同时上报其它已注册模块的版本
+func (sf *Engine) inform(pk, dn, module, version string) {
+	err := sf.c.OtaInformModules(pk, dn, aiot.OtaInformParams{Version: version, Module: module})
+	if err != nil {
+		sf.c.Log.Warnf("ota: inform version %s of %s failed, %+v", version, jobKey(pk, dn, module), err)
+	}
+}
+
+// reporter 按间隔上报进度, 百分比映射到 [base, base+span] 区间
+type reporter struct {
+	mu          sync.Mutex
+	e           *Engine
+	pk, dn      string
+	module      string
+	desc        string
+	base, span  int
+	lastStep    int
+	lastReport  time.Time
+	reportCount int
+}
+
+func (sf *Engine) newReporter(pk, dn, module, desc string) *reporter {
+	return &reporter{e: sf, pk: pk, dn: dn, module: module, desc: desc, span: 100}
+}
+
+func (sf *reporter) download(written, total int64) {
+	if total <= 0 {
+		return
+	}
+	sf.report(int(written * 100 / total))
+}
+
+func (sf *reporter) report(percent int) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if percent > 100 {
+		percent = 100
+	}
+	step := sf.base + percent*sf.span/100
+	if step < 1 {
+		step = 1
+	} else if step > 100 {
+		step = 100
+	}
+	if step == sf.lastStep ||
+		(percent != 100 && sf.reportCount > 0 && time.Since(sf.lastReport) < sf.e.progressInterval) {
+		return
+	}
+	sf.lastStep, sf.lastReport = step, time.Now()
+	sf.reportCount++
+	sf.e.progress(sf.pk, sf.dn, sf.module, step, sf.desc)
+}
+
+func jobKey(pk, dn, module string) string {
+	if module == "" {
+		module = "default"
+	}
+	return strings.Join([]string{pk, dn, module}, ".")
+}
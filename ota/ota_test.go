@@ -0,0 +1,516 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+type message struct {
+	topic   string
+	payload []byte
+}
+
+type fakeConn struct {
+	mu        sync.Mutex
+	messages  []message
+	onPublish func(topic string, payload []byte)
+}
+
+func (sf *fakeConn) Publish(topic string, _ byte, payload interface{}) error {
+	var b []byte
+	switch v := payload.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	}
+	sf.mu.Lock()
+	sf.messages = append(sf.messages, message{topic, b})
+	sf.mu.Unlock()
+	if sf.onPublish != nil {
+		sf.onPublish(topic, b)
+	}
+	return nil
+}
+
+func (sf *fakeConn) Subscribe(string, aiot.ProcDownStream) error { return nil }
+func (sf *fakeConn) UnSubscribe(...string) error                 { return nil }
+func (sf *fakeConn) Close() error                                { return nil }
+
+// steps 上报的进度
+func (sf *fakeConn) steps() []int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	var steps []int
+	for _, m := range sf.messages {
+		if !strings.HasPrefix(m.topic, "/ota/device/progress/") {
+			continue
+		}
+		var req struct {
+			Params aiot.OtaProgressParams `json:"params"`
+		}
+		if json.Unmarshal(m.payload, &req) == nil {
+			steps = append(steps, req.Params.Step)
+		}
+	}
+	return steps
+}
+
+// versions 上报的版本
+func (sf *fakeConn) versions() []string {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	var versions []string
+	for _, m := range sf.messages {
+		if !strings.HasPrefix(m.topic, "/ota/device/inform/") {
+			continue
+		}
+		var req struct {
+			Params aiot.OtaInformParams `json:"params"`
+		}
+		if json.Unmarshal(m.payload, &req) == nil {
+			versions = append(versions, req.Params.Version)
+		}
+	}
+	return versions
+}
+
+var meta = infra.MetaTriad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"}
+
+func newFirmware(size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func firmwareData(url string, fw []byte) aiot.OtaFirmwareData {
+	m := md5.Sum(fw)
+	s := sha256.Sum256(fw)
+	return aiot.OtaFirmwareData{
+		Size:       int64(len(fw)),
+		Sign:       hex.EncodeToString(s[:]),
+		Version:    "1.1.0",
+		URL:        url,
+		SignMethod: "SHA256",
+		MD5:        hex.EncodeToString(m[:]),
+	}
+}
+
+func newServer(fw []byte, ranges *[]string) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "fw.bin", time.Time{}, bytes.NewReader(fw))
+	}))
+}
+
+func TestEngineUpgrade(t *testing.T) {
+	fw := newFirmware(100 * 1024)
+	var ranges []string
+	srv := newServer(fw, &ranges)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "ota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conn := &fakeConn{}
+	c := aiot.New(meta, conn)
+	var installed []byte
+	e := New(c, InstallerFunc(func(ctx context.Context, pkg *Package) error {
+		installed, err = ioutil.ReadFile(pkg.File)
+		return err
+	}), WithDir(dir), WithProgressInterval(0))
+
+	data := firmwareData(srv.URL, fw)
+	// 已下载部分,断点续传
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pk.dn.default-1.1.0.bin.part"), fw[:1000], 0644))
+
+	require.NoError(t, e.Upgrade(context.Background(), "pk", "dn", data))
+	require.Equal(t, fw, installed)
+	require.Equal(t, []string{"bytes=1000-"}, ranges)
+	require.Equal(t, []string{"1.1.0"}, conn.versions())
+	steps := conn.steps()
+	require.NotEmpty(t, steps)
+	require.Equal(t, 100, steps[len(steps)-1])
+	files, _ := filepath.Glob(filepath.Join(dir, "*.bin*"))
+	require.Empty(t, files)
+	st, err := e.State("pk", "dn", "")
+	require.NoError(t, err)
+	require.Equal(t, PhaseCommitted, st.Phase)
+}
+
+func TestEngineFailure(t *testing.T) {
+	fw := newFirmware(4096)
+	var ranges []string
+	srv := newServer(fw, &ranges)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "ota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("verify", func(t *testing.T) {
+		conn := &fakeConn{}
+		e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error { return nil }),
+			WithDir(dir))
+		data := firmwareData(srv.URL, fw)
+		data.Sign = strings.Repeat("0", 64)
+		err := e.Upgrade(context.Background(), "pk", "dn", data)
+		require.ErrorIs(t, err, ErrDigestMismatch)
+		steps := conn.steps()
+		require.Equal(t, aiot.OtaProgressStepVerifyFailed, steps[len(steps)-1])
+	})
+
+	t.Run("download", func(t *testing.T) {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		conn := &fakeConn{}
+		e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error { return nil }),
+			WithDir(dir))
+		err := e.Upgrade(context.Background(), "pk", "dn", firmwareData(closed.URL, fw))
+		require.Error(t, err)
+		require.Equal(t, []int{aiot.OtaProgressStepDownloadFailed}, conn.steps())
+	})
+
+	t.Run("program", func(t *testing.T) {
+		conn := &fakeConn{}
+		e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error {
+			return os.ErrPermission
+		}), WithDir(dir))
+		err := e.Upgrade(context.Background(), "pk", "dn", firmwareData(srv.URL, fw))
+		require.ErrorIs(t, err, os.ErrPermission)
+		steps := conn.steps()
+		require.Equal(t, aiot.OtaProgressStepProgramFailed, steps[len(steps)-1])
+		require.Empty(t, conn.versions())
+	})
+}
+
+func TestEngineStart(t *testing.T) {
+	fw := newFirmware(1024)
+	var ranges []string
+	srv := newServer(fw, &ranges)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "ota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// 安装过程中设备重启,留下待确认的升级
+	pkg := &Package{ProductKey: "pk", DeviceName: "dn", Data: firmwareData(srv.URL, fw), File: filepath.Join(dir, "fw.bin")}
+	statePath := filepath.Join(dir, "pk.dn.default"+stateSuffix)
+	require.NoError(t, saveState(statePath, &State{Phase: PhaseInstalling, Package: pkg}))
+
+	current := "1.1.0"
+	conn := &fakeConn{}
+	e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error { return nil }),
+		WithDir(dir), WithVersionFunc(func(pk, dn, module string) string { return current }))
+	require.NoError(t, e.Start())
+	require.Equal(t, []string{"1.1.0"}, conn.versions())
+	st, err := e.State("pk", "dn", "")
+	require.NoError(t, err)
+	require.Equal(t, PhaseCommitted, st.Phase)
+
+	// 版本未生效
+	require.NoError(t, saveState(statePath, &State{Phase: PhaseInstalling, Package: pkg}))
+	current = "1.0.0"
+	conn = &fakeConn{}
+	e = New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error { return nil }),
+		WithDir(dir), WithVersionFunc(func(pk, dn, module string) string { return current }))
+	require.NoError(t, e.Start())
+	require.Empty(t, conn.versions())
+	require.Equal(t, []int{aiot.OtaProgressStepProgramFailed}, conn.steps())
+	st, err = e.State("pk", "dn", "")
+	require.NoError(t, err)
+	require.Equal(t, PhaseIdle, st.Phase)
+	require.Contains(t, st.Desc, "not take effect")
+	require.NoError(t, e.Close())
+}
+
+func TestEngineModules(t *testing.T) {
+	fw := newFirmware(2048)
+	var ranges []string
+	srv := newServer(fw, &ranges)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "ota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conn := &fakeConn{}
+	c := aiot.New(meta, conn,
+		aiot.WithOtaModule("", func(pk, dn string) string { return "1.0.0" }),
+		aiot.WithOtaModule("mcu", func(pk, dn string) string { return "0.1.0" }))
+	require.Equal(t, []string{"default", "mcu"}, c.OtaModules())
+
+	// 上线后上报所有模块版本
+	require.NoError(t, c.Connect())
+	require.Equal(t, []string{"1.0.0", "0.1.0"}, conn.versions())
+
+	var modules []string
+	e := New(c, nil, WithDir(dir), WithModuleInstaller("mcu", InstallerFunc(func(_ context.Context, pkg *Package) error {
+		modules = append(modules, pkg.Data.Module)
+		return nil
+	})))
+
+	conn.messages = nil
+	data := firmwareData(srv.URL, fw)
+	data.Module = "mcu"
+	require.NoError(t, e.Upgrade(context.Background(), "pk", "dn", data))
+	require.Equal(t, []string{"mcu"}, modules)
+	require.Equal(t, []string{"1.0.0", "1.1.0"}, conn.versions())
+
+	// 无安装器的模块
+	conn.messages = nil
+	data.Module = "modem"
+	require.ErrorIs(t, e.Upgrade(context.Background(), "pk", "dn", data), ErrNoInstaller)
+	require.Equal(t, []int{aiot.OtaProgressStepUpgradeFailed}, conn.steps())
+}
+
+func TestEngineDelta(t *testing.T) {
+	patch, err := ioutil.ReadFile("bspatch/testdata/patch.bin")
+	require.NoError(t, err)
+	want, err := ioutil.ReadFile("bspatch/testdata/new.bin")
+	require.NoError(t, err)
+	var ranges []string
+	srv := newServer(patch, &ranges)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "ota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	data := firmwareData(srv.URL, patch)
+	data.IsDiff = 1
+	current := FileDeltaSource(func(*Package) string { return "bspatch/testdata/old.bin" })
+	sum := sha256.Sum256(want)
+	withDigest := func(sign string, size int64) aiot.OtaFirmwareData {
+		d := data
+		d.ExtData = map[string]interface{}{
+			ExtDataImageSign:       sign,
+			ExtDataImageSignMethod: SignMethodSHA256,
+			ExtDataImageSize:       float64(size),
+		}
+		return d
+	}
+
+	t.Run("no source", func(t *testing.T) {
+		conn := &fakeConn{}
+		e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error { return nil }),
+			WithDir(dir))
+		require.ErrorIs(t, e.Upgrade(context.Background(), "pk", "dn", data), ErrNoDeltaSource)
+		steps := conn.steps()
+		require.Equal(t, aiot.OtaProgressStepUpgradeFailed, steps[len(steps)-1])
+	})
+
+	t.Run("patched", func(t *testing.T) {
+		conn := &fakeConn{}
+		var installed []byte
+		e := New(aiot.New(meta, conn), InstallerFunc(func(_ context.Context, pkg *Package) (err error) {
+			installed, err = ioutil.ReadFile(pkg.File)
+			return err
+		}), WithDir(dir), WithDeltaSource(current))
+		require.NoError(t, e.Upgrade(context.Background(), "pk", "dn", withDigest(hex.EncodeToString(sum[:]), int64(len(want)))))
+		require.Equal(t, want, installed)
+		require.Equal(t, []string{"1.1.0"}, conn.versions())
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		conn := &fakeConn{}
+		e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error { return nil }),
+			WithDir(dir), WithDeltaSource(current))
+		require.ErrorIs(t, e.Upgrade(context.Background(), "pk", "dn", withDigest(strings.Repeat("0", 64), int64(len(want)))), ErrImageMismatch)
+		steps := conn.steps()
+		require.Equal(t, aiot.OtaProgressStepVerifyFailed, steps[len(steps)-1])
+	})
+
+	t.Run("size mismatch", func(t *testing.T) {
+		conn := &fakeConn{}
+		e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error { return nil }),
+			WithDir(dir), WithDeltaSource(current))
+		err := e.Upgrade(context.Background(), "pk", "dn", withDigest(hex.EncodeToString(sum[:]), int64(len(want))+1))
+		require.ErrorIs(t, err, ErrImageMismatch)
+		require.Contains(t, err.Error(), ErrSizeMismatch.Error())
+	})
+
+	t.Run("no digest", func(t *testing.T) {
+		conn := &fakeConn{}
+		e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error { return nil }),
+			WithDir(dir), WithDeltaSource(current))
+		require.ErrorIs(t, e.Upgrade(context.Background(), "pk", "dn", data), ErrNoImageDigest)
+		steps := conn.steps()
+		require.Equal(t, aiot.OtaProgressStepUpgradeFailed, steps[len(steps)-1])
+	})
+
+	t.Run("corrupt patch", func(t *testing.T) {
+		bad := newServer([]byte("not a bsdiff patch"), &ranges)
+		defer bad.Close()
+		conn := &fakeConn{}
+		e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error { return nil }),
+			WithDir(dir), WithDeltaSource(current))
+		d := firmwareData(bad.URL, []byte("not a bsdiff patch"))
+		d.IsDiff = 1
+		d.ExtData = withDigest(hex.EncodeToString(sum[:]), int64(len(want))).ExtData
+		require.Error(t, e.Upgrade(context.Background(), "pk", "dn", d))
+		steps := conn.steps()
+		require.Equal(t, aiot.OtaProgressStepUpgradeFailed, steps[len(steps)-1])
+	})
+
+	t.Run("wrong base image", func(t *testing.T) {
+		conn := &fakeConn{}
+		e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error { return nil }),
+			WithDir(dir), WithDeltaSource(FileDeltaSource(func(*Package) string { return "bspatch/testdata/new.bin" })))
+		require.ErrorIs(t, e.Upgrade(context.Background(), "pk", "dn", withDigest(hex.EncodeToString(sum[:]), 0)), ErrImageMismatch)
+	})
+}
+
+// fileDownloadReply 构造文件分片下载应答
+func fileDownloadReply(id uint, fw []byte, offset int64, size int) []byte {
+	if end := offset + int64(size); end < int64(len(fw)) {
+		fw = fw[:end]
+	}
+	block := fw[offset:]
+	head, _ := json.Marshal(aiot.FileDownloadReply{
+		ID:   id,
+		Code: infra.CodeSuccess,
+		Data: aiot.FileDownloadData{FileLength: int64(len(fw)), BSize: len(block), BOffset: offset},
+	})
+	b := make([]byte, 2, 2+len(head)+len(block)+2)
+	binary.BigEndian.PutUint16(b, uint16(len(head)))
+	b = append(append(b, head...), block...)
+	return append(b, byte(infra.CRC16IBM(block)>>8), byte(infra.CRC16IBM(block)))
+}
+
+func TestEngineMQTTDownload(t *testing.T) {
+	fw := newFirmware(10000)
+	dir, err := ioutil.TempDir("", "ota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c *aiot.Client
+	var mu sync.Mutex
+	var offsets []int64
+	corrupted := false
+	conn := &fakeConn{}
+	conn.onPublish = func(topic string, payload []byte) {
+		if topic != "/sys/pk/dn/thing/file/download" {
+			return
+		}
+		var req struct {
+			ID     uint                    `json:"id,string"`
+			Params aiot.FileDownloadParams `json:"params"`
+		}
+		require.NoError(t, json.Unmarshal(payload, &req))
+		require.Equal(t, int64(100), req.Params.FileInfo.StreamID)
+		mu.Lock()
+		offsets = append(offsets, req.Params.FileBlock.Offset)
+		rsp := fileDownloadReply(req.ID, fw, req.Params.FileBlock.Offset, req.Params.FileBlock.Size)
+		if !corrupted { // 第一个分片CRC错误
+			corrupted = true
+			rsp[len(rsp)-1]++
+		}
+		mu.Unlock()
+		go aiot.ProcThingFileDownloadReply(c, "/sys/pk/dn/thing/file/download_reply", rsp) // nolint: errcheck
+	}
+	c = aiot.New(meta, conn)
+
+	var installed []byte
+	e := New(c, InstallerFunc(func(_ context.Context, pkg *Package) (err error) {
+		installed, err = ioutil.ReadFile(pkg.File)
+		return err
+	}), WithDir(dir), WithBlockSize(4096), WithBlockTimeout(time.Second), WithProgressInterval(0))
+
+	data := firmwareData("", fw)
+	data.DProtocol = "mqtt"
+	data.StreamID, data.StreamFileID = 100, 1
+	// 已下载部分,断点续传
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pk.dn.default-1.1.0.bin.part"), fw[:1000], 0644))
+
+	require.NoError(t, e.Upgrade(context.Background(), "pk", "dn", data))
+	require.Equal(t, fw, installed)
+	require.Equal(t, []int64{1000, 1000, 5096, 9192}, offsets)
+	require.Equal(t, []string{"1.1.0"}, conn.versions())
+	steps := conn.steps()
+	require.Equal(t, 100, steps[len(steps)-1])
+}
+
+func TestEngineMQTTDownloadOffline(t *testing.T) {
+	fw := newFirmware(10000)
+	dir, err := ioutil.TempDir("", "ota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c *aiot.Client
+	var mu sync.Mutex
+	var offsets []int64
+	dropped := false
+	conn := &fakeConn{}
+	conn.onPublish = func(topic string, payload []byte) {
+		if topic != "/sys/pk/dn/thing/file/download" {
+			return
+		}
+		var req struct {
+			ID     uint                    `json:"id,string"`
+			Params aiot.FileDownloadParams `json:"params"`
+		}
+		json.Unmarshal(payload, &req) // nolint: errcheck
+		mu.Lock()
+		defer mu.Unlock()
+		offsets = append(offsets, req.Params.FileBlock.Offset)
+		if req.Params.FileBlock.Offset == 4096 && !dropped {
+			// 请求期间断线, 应答丢失, 应答超时后重新上线
+			dropped = true
+			c.SetDeviceStatus("pk", "dn", aiot.DevStatusAttached) // nolint: errcheck
+			time.AfterFunc(time.Millisecond*200, func() {
+				c.SetDeviceStatus("pk", "dn", aiot.DevStatusOnline) // nolint: errcheck
+			})
+			return
+		}
+		rsp := fileDownloadReply(req.ID, fw, req.Params.FileBlock.Offset, req.Params.FileBlock.Size)
+		go aiot.ProcThingFileDownloadReply(c, "/sys/pk/dn/thing/file/download_reply", rsp) // nolint: errcheck
+	}
+	c = aiot.New(meta, conn)
+
+	var installed []byte
+	e := New(c, InstallerFunc(func(_ context.Context, pkg *Package) (err error) {
+		installed, err = ioutil.ReadFile(pkg.File)
+		return err
+	}), WithDir(dir), WithBlockSize(4096), WithBlockTimeout(time.Millisecond*100), WithBlockRetry(0),
+		WithProgressInterval(0))
+
+	data := firmwareData("", fw)
+	data.DProtocol = "mqtt"
+	data.StreamID, data.StreamFileID = 100, 1
+	require.NoError(t, e.Upgrade(context.Background(), "pk", "dn", data))
+	require.Equal(t, fw, installed)
+	mu.Lock()
+	require.Equal(t, []int64{0, 4096, 4096, 8192}, offsets)
+	mu.Unlock()
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	aiot "github.com/things-go/aliyun-iot"
+)
+
+// 默认健康确认超时时间
+const DefaultConfirmTimeout = time.Minute * 5
+
+// 确认错误
+var (
+	ErrConfirmTimeout = errors.New("ota: health confirmation timeout")
+	ErrNotPending     = errors.New("ota: no upgrade pending confirmation")
+)
+
+// SlotInstaller A/B分区安装器
+// Install 将固件写入非活动分区并设置下次从该分区启动,由应用重启设备,
+// 重启后调用 Engine.Start 进行健康确认, 确认成功调用 Commit, 失败或超时调用 Rollback
+type SlotInstaller interface {
+	Installer
+	// Commit 确认新分区运行正常,将其标记为永久活动分区
+	Commit(ctx context.Context, pkg *Package) error
+	// Rollback 回滚到升级前的分区, 由应用决定是否重启设备
+	Rollback(ctx context.Context, pkg *Package) error
+}
+
+// HealthCheck 重启后新固件的健康确认, 返回nil表示运行正常
+type HealthCheck func(ctx context.Context, pkg *Package) error
+
+// WithHealthCheck 设置A/B分区升级重启后的健康确认及超时时间,
+// 未设置健康确认时,需在超时时间内调用 Engine.Confirm 确认, 否则自动回滚
+func WithHealthCheck(check HealthCheck, timeout time.Duration) Option {
+	return func(e *Engine) {
+		e.healthCheck = check
+		e.confirmTimeout = timeout
+	}
+}
+
+// Confirm 应用确认新固件运行正常
+func (sf *Engine) Confirm(pk, dn, module string) error {
+	sf.mu.Lock()
+	ch, ok := sf.confirms[jobKey(pk, dn, module)]
+	sf.mu.Unlock()
+	if !ok {
+		return ErrNotPending
+	}
+	select {
+	case ch <- nil:
+	default:
+	}
+	return nil
+}
+
+// confirm 重启后确认待确认的升级, 成功提交并上报版本, 失败或超时回滚并上报烧写失败
+func (sf *Engine) confirm(st *State) {
+	pkg := st.Package
+	key := jobKey(pkg.ProductKey, pkg.DeviceName, pkg.Data.Module)
+	slot, isSlot := sf.installerOf(pkg.ProductKey, pkg.Data.Module).(SlotInstaller)
+	if ver, ok := sf.currentVersion(pkg.ProductKey, pkg.DeviceName, pkg.Data.Module); ok && ver != pkg.Data.Version {
+		err := fmt.Errorf("version %s not take effect, current %s", pkg.Data.Version, ver)
+		if isSlot {
+			sf.rollback(st, slot, err)
+			return
+		}
+		if er := sf.transit(st, PhaseIdle, err.Error()); er != nil {
+			sf.c.Log.Warnf("ota: %+v", er)
+		}
+		sf.progress(pkg.ProductKey, pkg.DeviceName, pkg.Data.Module, aiot.OtaProgressStepProgramFailed, err.Error())
+		return
+	}
+	if !isSlot {
+		sf.commit(st, nil)
+		return
+	}
+	if st.Phase == PhaseInstalling { // 写入新分区时中断
+		sf.rollback(st, slot, errors.New("install interrupted"))
+		return
+	}
+
+	ch := make(chan error, 1)
+	sf.mu.Lock()
+	sf.confirms[key] = ch
+	sf.mu.Unlock()
+	defer func() {
+		sf.mu.Lock()
+		delete(sf.confirms, key)
+		sf.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(sf.ctx, sf.confirmTimeout)
+	defer cancel()
+	if sf.healthCheck != nil {
+		go func() {
+			err := sf.healthCheck(ctx, pkg)
+			select {
+			case ch <- err:
+			default:
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-ch:
+	case <-ctx.Done():
+		if sf.ctx.Err() != nil { // 引擎关闭,保持待确认状态
+			return
+		}
+		err = ErrConfirmTimeout
+	}
+	if err != nil {
+		sf.rollback(st, slot, err)
+		return
+	}
+	sf.commit(st, slot)
+}
+
+// commit 提交升级并上报新版本
+func (sf *Engine) commit(st *State, slot SlotInstaller) {
+	pkg := st.Package
+	if slot != nil {
+		if err := slot.Commit(sf.ctx, pkg); err != nil {
+			sf.rollback(st, slot, fmt.Errorf("commit, %v", err))
+			return
+		}
+	}
+	if err := sf.transit(st, PhaseCommitted, ""); err != nil {
+		sf.c.Log.Warnf("ota: %+v", err)
+	}
+	sf.inform(pkg.ProductKey, pkg.DeviceName, pkg.Data.Module, pkg.Data.Version)
+}
+
+// rollback 回滚升级并上报烧写失败
+func (sf *Engine) rollback(st *State, slot SlotInstaller, reason error) {
+	pkg := st.Package
+	desc := "rollback, " + reason.Error()
+	if err := slot.Rollback(sf.ctx, pkg); err != nil {
+		desc = fmt.Sprintf("%s, rollback failed, %v", desc, err)
+	}
+	if err := sf.transit(st, PhaseRolledBack, desc); err != nil {
+		sf.c.Log.Warnf("ota: %+v", err)
+	}
+	sf.progress(pkg.ProductKey, pkg.DeviceName, pkg.Data.Module, aiot.OtaProgressStepProgramFailed, desc)
+}
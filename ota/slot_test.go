@@ -0,0 +1,222 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	aiot "github.com/things-go/aliyun-iot"
+)
+
+type slotInstaller struct {
+	mu        sync.Mutex
+	installed int
+	committed int
+	rollback  int
+}
+
+func (sf *slotInstaller) Install(context.Context, *Package) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.installed++
+	return nil
+}
+
+func (sf *slotInstaller) Commit(context.Context, *Package) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.committed++
+	return nil
+}
+
+func (sf *slotInstaller) Rollback(context.Context, *Package) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.rollback++
+	return nil
+}
+
+// waitConfirmed 等待后台确认完成
+func waitConfirmed(t *testing.T, e *Engine) {
+	require.Eventually(t, func() bool {
+		st, err := e.State("pk", "dn", "")
+		return err == nil && st.Phase != PhasePendingConfirm
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestStateTransit(t *testing.T) {
+	st := &State{Phase: PhaseIdle}
+	require.True(t, st.CanTransit(PhaseDownloading))
+	require.False(t, st.CanTransit(PhaseInstalling))
+	st.Phase = PhasePendingConfirm
+	require.True(t, st.CanTransit(PhaseRolledBack))
+	require.False(t, st.CanTransit(PhaseDownloading))
+	require.True(t, st.CanTransit(PhaseIdle))
+}
+
+func TestEngineSlot(t *testing.T) {
+	fw := newFirmware(1024)
+	var ranges []string
+	srv := newServer(fw, &ranges)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "ota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	data := firmwareData(srv.URL, fw)
+	upgrade := func(t *testing.T) {
+		conn := &fakeConn{}
+		slot := &slotInstaller{}
+		e := New(aiot.New(meta, conn), slot, WithDir(dir))
+		require.NoError(t, e.Upgrade(context.Background(), "pk", "dn", data))
+		require.Equal(t, 1, slot.installed)
+		require.Empty(t, conn.versions())
+		st, err := e.State("pk", "dn", "")
+		require.NoError(t, err)
+		require.Equal(t, PhasePendingConfirm, st.Phase)
+
+		// 待确认时拒绝新的升级
+		require.ErrorIs(t, e.Upgrade(context.Background(), "pk", "dn", data), ErrPendingConfirm)
+	}
+
+	t.Run("health ok", func(t *testing.T) {
+		upgrade(t)
+		conn := &fakeConn{}
+		slot := &slotInstaller{}
+		e := New(aiot.New(meta, conn), slot, WithDir(dir),
+			WithHealthCheck(func(context.Context, *Package) error { return nil }, time.Second))
+		require.NoError(t, e.Start())
+		waitConfirmed(t, e)
+		require.NoError(t, e.Close())
+		require.Equal(t, 1, slot.committed)
+		require.Equal(t, []string{"1.1.0"}, conn.versions())
+		st, err := e.State("pk", "dn", "")
+		require.NoError(t, err)
+		require.Equal(t, PhaseCommitted, st.Phase)
+	})
+
+	t.Run("health failed", func(t *testing.T) {
+		upgrade(t)
+		conn := &fakeConn{}
+		slot := &slotInstaller{}
+		e := New(aiot.New(meta, conn), slot, WithDir(dir),
+			WithHealthCheck(func(context.Context, *Package) error { return errors.New("app crashed") }, time.Second))
+		require.NoError(t, e.Start())
+		waitConfirmed(t, e)
+		require.NoError(t, e.Close())
+		require.Equal(t, 1, slot.rollback)
+		require.Empty(t, conn.versions())
+		require.Equal(t, []int{aiot.OtaProgressStepProgramFailed}, conn.steps())
+		st, err := e.State("pk", "dn", "")
+		require.NoError(t, err)
+		require.Equal(t, PhaseRolledBack, st.Phase)
+		require.Contains(t, st.Desc, "app crashed")
+	})
+
+	t.Run("confirm timeout", func(t *testing.T) {
+		upgrade(t)
+		conn := &fakeConn{}
+		slot := &slotInstaller{}
+		e := New(aiot.New(meta, conn), slot, WithDir(dir), WithHealthCheck(nil, time.Millisecond*50))
+		require.NoError(t, e.Start())
+		waitConfirmed(t, e)
+		require.NoError(t, e.Close())
+		require.Equal(t, 1, slot.rollback)
+		st, err := e.State("pk", "dn", "")
+		require.NoError(t, err)
+		require.Equal(t, PhaseRolledBack, st.Phase)
+		require.Contains(t, st.Desc, ErrConfirmTimeout.Error())
+	})
+
+	t.Run("manual confirm", func(t *testing.T) {
+		upgrade(t)
+		conn := &fakeConn{}
+		slot := &slotInstaller{}
+		e := New(aiot.New(meta, conn), slot, WithDir(dir), WithHealthCheck(nil, time.Second))
+		require.ErrorIs(t, e.Confirm("pk", "dn", ""), ErrNotPending)
+		require.NoError(t, e.Start())
+		require.Eventually(t, func() bool { return e.Confirm("pk", "dn", "") == nil },
+			time.Second, time.Millisecond*10)
+		waitConfirmed(t, e)
+		require.NoError(t, e.Close())
+		require.Equal(t, 1, slot.committed)
+		require.Equal(t, []string{"1.1.0"}, conn.versions())
+	})
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	day := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	windows := []Window{
+		{Start: 2 * time.Hour, End: 4 * time.Hour},
+		{Start: 22 * time.Hour, End: time.Hour},
+	}
+	require.Zero(t, untilWindow(nil, day.Add(12*time.Hour)))
+	require.Zero(t, untilWindow(windows, day.Add(3*time.Hour)))
+	require.Zero(t, untilWindow(windows, day.Add(23*time.Hour)))
+	require.Zero(t, untilWindow(windows, day.Add(30*time.Minute)))
+	require.Equal(t, time.Hour, untilWindow(windows, day.Add(time.Hour)))
+	require.Equal(t, 10*time.Hour, untilWindow(windows, day.Add(12*time.Hour)))
+
+	// 等待到窗口内再安装
+	dir, err := ioutil.TempDir("", "ota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fw := newFirmware(1024)
+	var ranges []string
+	srv := newServer(fw, &ranges)
+	defer srv.Close()
+
+	conn := &fakeConn{}
+	e := New(aiot.New(meta, conn), InstallerFunc(func(context.Context, *Package) error { return nil }),
+		WithDir(dir), WithMaintenanceWindows(Window{Start: 2 * time.Hour, End: 4 * time.Hour}))
+	e.now = func() time.Time { return day.Add(time.Hour) }
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	require.ErrorIs(t, e.Upgrade(ctx, "pk", "dn", firmwareData(srv.URL, fw)), context.DeadlineExceeded)
+	require.Empty(t, conn.versions())
+	for _, step := range conn.steps() { // 等待中断不上报失败
+		require.True(t, step >= 0, step)
+	}
+	st, err := e.State("pk", "dn", "")
+	require.NoError(t, err)
+	require.Equal(t, PhaseScheduled, st.Phase)
+	require.FileExists(t, st.Package.File)
+	require.NoError(t, e.Close())
+
+	// 重启后继续等待, 进入窗口后安装
+	conn = &fakeConn{}
+	installed := make(chan []byte, 1)
+	e = New(aiot.New(meta, conn), InstallerFunc(func(_ context.Context, pkg *Package) error {
+		b, err := ioutil.ReadFile(pkg.File)
+		installed <- b
+		return err
+	}), WithDir(dir), WithMaintenanceWindows(Window{Start: 2 * time.Hour, End: 4 * time.Hour}))
+	e.now = func() time.Time { return day.Add(3 * time.Hour) }
+	require.NoError(t, e.Start())
+	select {
+	case b := <-installed:
+		require.Equal(t, fw, b)
+	case <-time.After(time.Second):
+		require.FailNow(t, "scheduled upgrade not resumed")
+	}
+	require.NoError(t, e.Close())
+	require.Equal(t, []string{"1.1.0"}, conn.versions())
+	st, err = e.State("pk", "dn", "")
+	require.NoError(t, err)
+	require.Equal(t, PhaseCommitted, st.Phase)
+	require.NoFileExists(t, st.Package.File)
+
+	require.NoError(t, e.Upgrade(context.Background(), "pk", "dn", firmwareData(srv.URL, fw)))
+	require.Equal(t, []string{"1.1.0", "1.1.0"}, conn.versions())
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Phase 升级阶段
+type Phase string
+
+// 升级阶段
+// idle → downloading → verifying → [scheduled →] installing → pending-confirm → committed/rolled-back
+// 设置了维护时间窗口且当前不在窗口内时进入scheduled, 重启后由 Engine.Start 继续等待并安装,
+// 非A/B分区安装器安装成功后直接进入committed, A/B分区安装中断将回滚
+const (
+	PhaseIdle           Phase = "idle"
+	PhaseDownloading    Phase = "downloading"
+	PhaseVerifying      Phase = "verifying"
+	PhaseScheduled      Phase = "scheduled"
+	PhaseInstalling     Phase = "installing"
+	PhasePendingConfirm Phase = "pending-confirm"
+	PhaseCommitted      Phase = "committed"
+	PhaseRolledBack     Phase = "rolled-back"
+)
+
+// 合法的阶段转换, 任意阶段失败或取消均可回到idle
+var transitions = map[Phase][]Phase{
+	PhaseIdle:           {PhaseDownloading},
+	PhaseDownloading:    {PhaseVerifying},
+	PhaseVerifying:      {PhaseScheduled, PhaseInstalling},
+	PhaseScheduled:      {PhaseInstalling},
+	PhaseInstalling:     {PhasePendingConfirm, PhaseCommitted, PhaseRolledBack},
+	PhasePendingConfirm: {PhaseCommitted, PhaseRolledBack},
+	PhaseCommitted:      {PhaseDownloading},
+	PhaseRolledBack:     {PhaseDownloading},
+}
+
+// State 持久化的升级状态, 每个设备的每个模块一个
+type State struct {
+	Phase   Phase    `json:"phase"`
+	Package *Package `json:"package"`
+	Desc    string   `json:"desc,omitempty"` // 失败或回滚原因
+	Updated int64    `json:"updated"`        // 更新时间, ms
+}
+
+// CanTransit 是否可以从当前阶段转换到to阶段
+func (sf *State) CanTransit(to Phase) bool {
+	if to == PhaseIdle {
+		return true
+	}
+	for _, p := range transitions[sf.Phase] {
+		if p == to {
+			return true
+		}
+	}
+	return false
+}
+
+// State 获取设备模块的升级状态,没有升级记录时为idle
+func (sf *Engine) State(pk, dn, module string) (*State, error) {
+	st, err := loadState(sf.statePath(pk, dn, module))
+	if os.IsNotExist(err) {
+		return &State{Phase: PhaseIdle}, nil
+	}
+	return st, err
+}
+
+// transit 转换到to阶段并持久化
+func (sf *Engine) transit(st *State, to Phase, desc string) error {
+	if !st.CanTransit(to) {
+		return fmt.Errorf("ota: invalid transition %s -> %s", st.Phase, to)
+	}
+	st.Phase, st.Desc, st.Updated = to, desc, time.Now().UnixNano()/int64(time.Millisecond)
+	pkg := st.Package
+	return saveState(sf.statePath(pkg.ProductKey, pkg.DeviceName, pkg.Data.Module), st)
+}
+
+func (sf *Engine) statePath(pk, dn, module string) string {
+	return filepath.Join(sf.dir, jobKey(pk, dn, module)+stateSuffix)
+}
+
+// saveState 原子地写入状态文件
+func saveState(name string, st *State) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmp) // nolint: errcheck
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func loadState(name string) (*State, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	st := &State{}
+	if err = json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+	if st.Package == nil {
+		return nil, fmt.Errorf("ota: state %s without package", name)
+	}
+	return st, nil
+}
+
+// loadStates 加载所有设备模块的升级状态
+func (sf *Engine) loadStates() ([]*State, error) {
+	files, err := filepath.Glob(filepath.Join(sf.dir, "*"+stateSuffix))
+	if err != nil {
+		return nil, err
+	}
+	states := make([]*State, 0, len(files))
+	for _, name := range files {
+		st, err := loadState(name)
+		if err != nil {
+			sf.c.Log.Warnf("ota: load state %s failed, %+v", name, err)
+			continue
+		}
+		states = append(states, st)
+	}
+	return states, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"context"
+)
+
+// DefaultSubDeviceConcurrency 默认网关同时升级的子设备数
+const DefaultSubDeviceConcurrency = 2
+
+// SubDeviceFlasher 子设备固件烧写器, 网关代理子设备下载并校验固件后,
+// 通过本地总线(串口, modbus, ble等)将固件烧写到子设备.
+// progress 上报烧写进度百分比 [0, 100], 引擎以子设备的productKey,deviceName上报升级进度及版本
+type SubDeviceFlasher interface {
+	Flash(ctx context.Context, pkg *Package, progress func(percent int)) error
+}
+
+// SubDeviceFlasherFunc 子设备固件烧写器函数适配
+type SubDeviceFlasherFunc func(ctx context.Context, pkg *Package, progress func(percent int)) error
+
+// Flash 实现 SubDeviceFlasher 接口
+func (f SubDeviceFlasherFunc) Flash(ctx context.Context, pkg *Package, progress func(percent int)) error {
+	return f(ctx, pkg, progress)
+}
+
+// WithSubDeviceFlasher 设置子设备产品的固件烧写器, 该产品下子设备的升级由网关代理,
+// 下载占升级进度的前一半, 烧写占后一半
+func WithSubDeviceFlasher(productKey string, f SubDeviceFlasher) Option {
+	return func(e *Engine) {
+		e.flashers[productKey] = f
+	}
+}
+
+// WithSubDeviceConcurrency 设置网关同时升级的子设备数, 默认 DefaultSubDeviceConcurrency
+func WithSubDeviceConcurrency(n int) Option {
+	return func(e *Engine) {
+		if n > 0 {
+			e.subDevConcurrency = n
+		}
+	}
+}
+
+// flasherInstaller 将子设备固件烧写器适配为安装器
+type flasherInstaller struct {
+	e *Engine
+	f SubDeviceFlasher
+}
+
+// Install 实现 Installer 接口
+func (sf *flasherInstaller) Install(ctx context.Context, pkg *Package) error {
+	rp := sf.e.newReporter(pkg.ProductKey, pkg.DeviceName, pkg.Data.Module, "flashing")
+	rp.base, rp.span = 50, 50
+	return sf.f.Flash(ctx, pkg, rp.report)
+}
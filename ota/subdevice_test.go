@@ -0,0 +1,93 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	aiot "github.com/things-go/aliyun-iot"
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+func TestEngineSubDevice(t *testing.T) {
+	fw := newFirmware(4096)
+	var ranges []string
+	srv := newServer(fw, &ranges)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "ota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conn := &fakeConn{}
+	c := aiot.New(meta, conn, aiot.WithEnableGateway())
+	for _, dn := range []string{"sub1", "sub2", "sub3"} {
+		require.NoError(t, c.AddSubDevice(infra.MetaTriad{ProductKey: "subpk", DeviceName: dn, DeviceSecret: "ds"}))
+		require.NoError(t, c.SetDeviceStatus("subpk", dn, aiot.DevStatusOnline))
+	}
+
+	var mu sync.Mutex
+	var running, maxRunning int
+	var flashed []string
+	e := New(c, nil, WithDir(dir), WithProgressInterval(0), WithSubDeviceConcurrency(2),
+		WithSubDeviceFlasher("subpk", SubDeviceFlasherFunc(func(_ context.Context, pkg *Package, progress func(int)) error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			b, err := ioutil.ReadFile(pkg.File)
+			require.NoError(t, err)
+			require.Equal(t, fw, b)
+			progress(50)
+			time.Sleep(time.Millisecond * 20)
+			progress(100)
+			mu.Lock()
+			running--
+			flashed = append(flashed, pkg.DeviceName)
+			mu.Unlock()
+			return nil
+		})))
+
+	data := firmwareData(srv.URL, fw)
+	for _, dn := range []string{"sub1", "sub2", "sub3"} {
+		require.NoError(t, e.OtaUpgrade(c, "subpk", dn, data))
+	}
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(flashed) == 3
+	}, time.Second*5, time.Millisecond*10)
+	require.NoError(t, e.Close())
+	require.ElementsMatch(t, []string{"sub1", "sub2", "sub3"}, flashed)
+	require.Equal(t, 2, maxRunning)
+
+	// 进度及版本以子设备上报
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	informs := 0
+	var steps []string
+	for _, m := range conn.messages {
+		if strings.HasPrefix(m.topic, "/ota/device/inform/subpk/sub1") {
+			informs++
+		}
+		if strings.HasPrefix(m.topic, "/ota/device/progress/subpk/sub1") {
+			steps = append(steps, string(m.payload))
+		}
+		require.False(t, strings.Contains(m.topic, "/pk/dn"), m.topic)
+	}
+	require.Equal(t, 1, informs)
+	require.True(t, strings.Contains(steps[len(steps)-1], `"step":"100"`))
+	require.True(t, strings.Contains(strings.Join(steps, ""), `"step":"50","desc":"downloading"`))
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	aiot "github.com/things-go/aliyun-iot"
+)
+
+// 签名方法
+const (
+	SignMethodMD5    = "MD5"
+	SignMethodSHA256 = "SHA256"
+)
+
+// 校验错误
+var (
+	ErrSizeMismatch          = errors.New("ota: firmware size mismatch")
+	ErrDigestMismatch        = errors.New("ota: firmware digest mismatch")
+	ErrUnsupportedSignMethod = errors.New("ota: unsupported sign method")
+	ErrNoDigestSign          = errors.New("ota: package without digest sign")
+	ErrDigestSignMismatch    = errors.New("ota: package digest sign mismatch")
+)
+
+// VerifyFile 校验固件文件的大小,md5及按signMethod的签名
+func VerifyFile(name string, data aiot.OtaFirmwareData) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Verify(f, data)
+}
+
+// Verify 校验固件的大小,md5及按signMethod的签名
+func Verify(r io.Reader, data aiot.OtaFirmwareData) error {
+	var signHash hash.Hash
+
+	switch strings.ToUpper(data.SignMethod) {
+	case SignMethodMD5, "":
+		signHash = md5.New()
+	case SignMethodSHA256:
+		signHash = sha256.New()
+	default:
+		return fmt.Errorf("%w %s", ErrUnsupportedSignMethod, data.SignMethod)
+	}
+	md5Hash := md5.New()
+	n, err := io.Copy(io.MultiWriter(signHash, md5Hash), r)
+	if err != nil {
+		return err
+	}
+	if data.Size > 0 && n != data.Size {
+		return ErrSizeMismatch
+	}
+	if data.MD5 != "" && !strings.EqualFold(data.MD5, hex.EncodeToString(md5Hash.Sum(nil))) {
+		return ErrDigestMismatch
+	}
+	if data.Sign != "" && !strings.EqualFold(data.Sign, hex.EncodeToString(signHash.Sum(nil))) {
+		return ErrDigestMismatch
+	}
+	return nil
+}
+
+// DigestMessage 升级包数字签名的原文, 单文件为固件的摘要sign,
+// 多文件为各文件的摘要fileSign按顺序拼接
+func DigestMessage(data aiot.OtaFirmwareData) []byte {
+	if len(data.Files) == 0 {
+		return []byte(data.Sign)
+	}
+	var b strings.Builder
+	for _, f := range data.Files {
+		b.WriteString(f.FileSign)
+	}
+	return []byte(b.String())
+}
+
+// ParsePublicKey 解析PEM格式的公钥, 支持RSA, ECDSA的PKIX公钥及证书
+func ParsePublicKey(pemBytes []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("ota: invalid pem public key")
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// VerifyDigestSign 使用公钥校验升级包的数字签名digestsign,
+// 签名为对 DigestMessage 的SHA256摘要的 RSA PKCS#1 v1.5 或 ECDSA(ASN.1) 签名, base64编码
+func VerifyDigestSign(pub crypto.PublicKey, data aiot.OtaFirmwareData) error {
+	if data.DigestSign == "" {
+		return ErrNoDigestSign
+	}
+	sig, err := base64.StdEncoding.DecodeString(data.DigestSign)
+	if err != nil {
+		return fmt.Errorf("%w, %v", ErrDigestSignMismatch, err)
+	}
+	hashed := sha256.Sum256(DigestMessage(data))
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig); err != nil {
+			return ErrDigestSignMismatch
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hashed[:], sig) {
+			return ErrDigestSignMismatch
+		}
+	default:
+		return fmt.Errorf("ota: unsupported public key %T", pub)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ota
+
+import (
+	"context"
+	"time"
+)
+
+// Window 每日的维护时间窗口, Start, End 为相对于本地时间零点的偏移,
+// End 小于 Start 表示跨越零点, 如 22:00 ~ 04:00
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// WithMaintenanceWindows 设置安装固件的维护时间窗口,下载校验完成后等待到窗口内再安装,
+// 未设置时立即安装
+func WithMaintenanceWindows(windows ...Window) Option {
+	return func(e *Engine) {
+		e.windows = windows
+	}
+}
+
+// contains 时间t是否在窗口内
+func (sf Window) contains(t time.Time) bool {
+	y, m, d := t.Date()
+	offset := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if sf.Start <= sf.End {
+		return offset >= sf.Start && offset < sf.End
+	}
+	return offset >= sf.Start || offset < sf.End
+}
+
+// next 距离下一个窗口开始的时间
+func (sf Window) next(t time.Time) time.Duration {
+	y, m, d := t.Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(sf.Start)
+	if !start.After(t) {
+		start = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()).Add(sf.Start)
+	}
+	return start.Sub(t)
+}
+
+// untilWindow 距离可以安装的时间, 0表示当前在窗口内或未设置窗口
+func untilWindow(windows []Window, t time.Time) time.Duration {
+	if len(windows) == 0 {
+		return 0
+	}
+	var wait time.Duration
+	for i, w := range windows {
+		if w.contains(t) {
+			return 0
+		}
+		if d := w.next(t); i == 0 || d < wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// waitWindow 等待到维护时间窗口内
+func (sf *Engine) waitWindow(ctx context.Context) error {
+	for {
+		wait := untilWindow(sf.windows, sf.now())
+		if wait <= 0 {
+			return nil
+		}
+		tm := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			tm.Stop()
+			return ctx.Err()
+		case <-tm.C:
+		}
+	}
+}
@@ -21,6 +21,30 @@ func WithDeviceToken(deviceToken string) Option {
 	}
 }
 
+// WithClientID 设置设备端标识clientId, 默认{productKey}.{deviceName}
+// NOTE: SecureModeNoPreRegistration时应使用免预注册动态注册返回的clientId
+func WithClientID(clientID string) Option {
+	return func(c *config) {
+		c.clientID = clientID
+	}
+}
+
+// WithSigner 设置签名器, 由签名器使用设备的DeviceSecret加签, 默认使用三元组的DeviceSecret
+func WithSigner(s infra.Signer) Option {
+	return func(c *config) {
+		c.signer = s
+	}
+}
+
+// WithX509 使用X.509证书认证, 安全模式固定为SecureModeTLSDirect,
+// 接入域名为x509.itls.cn-shanghai.aliyuncs.com或自定义域名, 不需要DeviceSecret.
+// 仅支持 Generate 的MQTT连接认证, 动态注册(GenerateRegister)及HTTP认证不支持证书
+func WithX509() Option {
+	return func(c *config) {
+		c.x509 = true
+	}
+}
+
 // WithPort 设置端口, 默认1883
 func WithPort(port uint16) Option {
 	return func(c *config) {
@@ -27,10 +27,13 @@ const (
 	hmacsha256 = "hmacsha256"
 	hmacsha1   = "hmacsha1"
 	hmacmd5    = "hmacmd5"
-	// itls domain
-	// itlsDomain = "x509.itls.cn-shanghai.aliyuncs.com"
+	// X.509证书认证的接入域名, 目前只有华东2(上海)
+	x509Domain = "x509.itls.cn-shanghai.aliyuncs.com"
 )
 
+// ErrX509Unsupported 该认证或注册方式不支持X.509证书
+var ErrX509Unsupported = errors.New("x509 not supported")
+
 // SecureMode 支持的安全模型
 const (
 	SecureModeNoPreRegistration = "-2" // 一型一密免预注册
@@ -40,6 +43,13 @@ const (
 	SecureModeITLSDNSID2        = "8" // ITLS, ID2方式
 )
 
+// AuthType 一型一密的认证类型
+const (
+	AuthTypeRegister = "register" // 预注册动态注册,获取DeviceSecret
+	AuthTypeRegNwl   = "regnwl"   // 免预注册动态注册,获取clientId和deviceToken
+	AuthTypeConnWl   = "connwl"   // 免预注册使用clientId和deviceToken登录
+)
+
 // Sign 签名后的信息
 type Sign struct {
 	Addr      string // broker addr
@@ -60,12 +70,15 @@ func (ms *Sign) ClientIDWithExt() string {
 type config struct {
 	secureMode  string            // 安全模式
 	deviceToken string            // only use on SecureModeNoPreRegistration
+	clientID    string            // 设备端标识, 默认{productKey}.{deviceName}
+	signer      infra.Signer      // 签名器, 默认使用triad的DeviceSecret
 	method      string            // 签名方法
 	enableDM    bool              // 使能物模型
 	extRRPC     bool              // 物模型下,支持扩展RRPC
 	port        uint16            // 端口,默认为1883
 	timestamp   int64             // 表示当前时间的毫秒值,可以不传递, 默认 fixedTimestamp
 	extParams   map[string]string // clientID扩展参数
+	x509        bool              // X.509证书认证
 }
 
 // Generate 根据MetaTriad和region生成签名
@@ -74,20 +87,22 @@ type config struct {
 // 默认使能物模型
 // 默认固定时间戳
 // 默认hmacsha256签名加密
-// TODO: itls x509方式
+// 安全模式为SecureModeNoPreRegistration时,需通过 WithClientID 和 WithDeviceToken
+// 设置动态注册返回的clientId和deviceToken, triad的DeviceSecret不使用;
+// 使用 WithSigner 设置签名器时, triad的DeviceSecret可以为空;
+// 使用 WithX509 时为X.509证书认证, 由设备证书完成认证, triad的DeviceSecret不使用,
+// 连接需使用 NewX509TLSConfig 等出示设备证书的tls配置
 func Generate(triad infra.MetaTriad, crd infra.CloudRegionDomain, opts ...Option) (*Sign, error) {
 	if crd.Region == infra.CloudRegionCustom && crd.CustomDomain == "" {
 		return nil, errors.New("invalid custom domain")
 	}
 	c := &config{
-		SecureModeTCPDirectPlain,
-		"",
-		hmacsha256,
-		true,
-		false,
-		1883,
-		fixedTimestamp,
-		map[string]string{
+		secureMode: SecureModeTCPDirectPlain,
+		method:     hmacsha256,
+		enableDM:   true,
+		port:       1883,
+		timestamp:  fixedTimestamp,
+		extParams: map[string]string{
 			"securemode": SecureModeTCPDirectPlain,
 			"signmethod": hmacsha256,
 			"gw":         "0",
@@ -108,12 +123,13 @@ func Generate(triad infra.MetaTriad, crd infra.CloudRegionDomain, opts ...Option
 		delete(c.extParams, "gw")
 		delete(c.extParams, "ext")
 	}
+	if c.x509 {
+		return c.generateX509(triad, crd)
+	}
 
 	var enableTLS bool // 使能tls
 	switch c.secureMode {
-	case SecureModeNoPreRegistration:
-		panic("feature not support")
-	case SecureModeTLSGuider, SecureModeTLSDirect, SecureModeITLSDNSID2:
+	case SecureModeNoPreRegistration, SecureModeTLSGuider, SecureModeTLSDirect, SecureModeITLSDNSID2:
 		enableTLS = true
 	default: // SecureModeTCPDirectPlain
 		c.secureMode = SecureModeTCPDirectPlain
@@ -121,31 +137,25 @@ func Generate(triad infra.MetaTriad, crd infra.CloudRegionDomain, opts ...Option
 	}
 	c.extParams["securemode"] = c.secureMode
 
-	schema := "tcp://"
-	if enableTLS {
-		schema = "tls://"
-	}
-
-	// setup HostName
-	hostname := triad.ProductKey + "."
-	if crd.Region == infra.CloudRegionCustom {
-		hostname += crd.CustomDomain
-	} else {
-		hostname += infra.MQTTCloudDomain[crd.Region]
-	}
-
-	addr := schema + net.JoinHostPort(hostname, strconv.Itoa(int(c.port)))
+	addr, hostname := c.broker(triad.ProductKey, crd, enableTLS)
 	username := triad.DeviceName + "&" + triad.ProductKey
+	clientID := c.clientID
+	if clientID == "" {
+		clientID = infra.ClientID(triad.ProductKey, triad.DeviceName)
+	}
 
 	if c.secureMode == SecureModeNoPreRegistration {
-		c.extParams["authType"] = "connwl"
+		if c.deviceToken == "" {
+			return nil, errors.New("device token required")
+		}
+		c.extParams["authType"] = AuthTypeConnWl
 		delete(c.extParams, "timestamp")
 		delete(c.extParams, "signmethod")
 		return &Sign{
 			addr,
 			hostname,
 			c.port,
-			infra.ClientID(triad.ProductKey, triad.DeviceName),
+			clientID,
 			encodeExtParam(c.extParams),
 			username,
 			c.deviceToken,
@@ -158,8 +168,16 @@ func Generate(triad infra.MetaTriad, crd infra.CloudRegionDomain, opts ...Option
 		c.method = hmacsha256
 	}
 	c.extParams["signmethod"] = c.method
-	// setup ClientID,Password
-	clientID, pwd := infra.CalcSign(c.method, triad, c.timestamp)
+	// setup Password
+	signer := c.signer
+	if signer == nil {
+		signer = infra.TriadSigner(triad)
+	}
+	pair := infra.MetaPair{ProductKey: triad.ProductKey, DeviceName: triad.DeviceName}
+	pwd, err := signer.Sign(c.method, pair, infra.SignContent(clientID, pair, c.timestamp))
+	if err != nil {
+		return nil, err
+	}
 	return &Sign{
 		addr,
 		hostname,
@@ -171,6 +189,114 @@ func Generate(triad infra.MetaTriad, crd infra.CloudRegionDomain, opts ...Option
 	}, nil
 }
 
+// generateX509 X.509证书认证, 安全模式为TLS直连, 不需要签名, password为空
+func (sf *config) generateX509(triad infra.MetaTriad, crd infra.CloudRegionDomain) (*Sign, error) {
+	var hostname string
+	switch crd.Region {
+	case infra.CloudRegionShangHai:
+		hostname = x509Domain
+	case infra.CloudRegionCustom:
+		hostname = crd.CustomDomain
+	default:
+		return nil, errors.New("x509 only support cn-shanghai or custom domain")
+	}
+	sf.secureMode = SecureModeTLSDirect
+	sf.extParams["securemode"] = SecureModeTLSDirect
+	delete(sf.extParams, "timestamp")
+	delete(sf.extParams, "signmethod")
+	clientID := sf.clientID
+	if clientID == "" {
+		clientID = infra.ClientID(triad.ProductKey, triad.DeviceName)
+	}
+	return &Sign{
+		"tls://" + net.JoinHostPort(hostname, strconv.Itoa(int(sf.port))),
+		hostname,
+		sf.port,
+		clientID,
+		encodeExtParam(sf.extParams),
+		triad.DeviceName + "&" + triad.ProductKey,
+		"",
+	}, nil
+}
+
+// GenerateRegister 根据MetaTetrad和region生成一型一密MQTT动态注册的签名, 需使用TLS连接.
+// 默认为预注册方式(securemode=2,authType=register), 连接后云端下发DeviceSecret到 /ext/register;
+// 使用 WithSecureMode(SecureModeNoPreRegistration) 时为免预注册方式(securemode=-2,authType=regnwl),
+// 云端下发clientId和deviceToken到 /ext/regnwl
+// 支持的选项: WithClientID, WithPort, WithSignMethod, WithSecureMode, WithSDKVersion, WithExtParamsKV,
+// 不支持 WithX509, 返回 ErrX509Unsupported
+func GenerateRegister(tetrad infra.MetaTetrad, crd infra.CloudRegionDomain, opts ...Option) (*Sign, error) {
+	if crd.Region == infra.CloudRegionCustom && crd.CustomDomain == "" {
+		return nil, errors.New("invalid custom domain")
+	}
+	if tetrad.ProductKey == "" || tetrad.ProductSecret == "" || tetrad.DeviceName == "" {
+		return nil, errors.New("invalid parameter")
+	}
+	c := &config{
+		secureMode: SecureModeTLSDirect,
+		method:     hmacsha256,
+		port:       1883,
+		extParams:  make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.x509 {
+		return nil, ErrX509Unsupported
+	}
+
+	authType := AuthTypeRegister
+	if c.secureMode == SecureModeNoPreRegistration {
+		authType = AuthTypeRegNwl
+	} else {
+		c.secureMode = SecureModeTLSDirect
+	}
+	switch c.method {
+	case hmacsha1, hmacmd5, hmacsha256:
+	default:
+		c.method = hmacsha256
+	}
+	random := infra.RandAlphabet(16)
+	c.extParams["securemode"] = c.secureMode
+	c.extParams["authType"] = authType
+	c.extParams["random"] = random
+	c.extParams["signmethod"] = c.method
+
+	addr, hostname := c.broker(tetrad.ProductKey, crd, true)
+	clientID := c.clientID
+	if clientID == "" {
+		clientID = infra.ClientID(tetrad.ProductKey, tetrad.DeviceName)
+	}
+	// deviceName{deviceName}productKey{productKey}random{random}
+	source := "deviceName" + tetrad.DeviceName + "productKey" + tetrad.ProductKey + "random" + random
+	return &Sign{
+		addr,
+		hostname,
+		c.port,
+		clientID,
+		encodeExtParam(c.extParams),
+		tetrad.DeviceName + "&" + tetrad.ProductKey,
+		infra.Hmac(c.method, tetrad.ProductSecret, source),
+	}, nil
+}
+
+// broker 返回broker地址及主机名
+func (sf *config) broker(productKey string, crd infra.CloudRegionDomain, enableTLS bool) (addr, hostname string) {
+	schema := "tcp://"
+	if enableTLS {
+		schema = "tls://"
+	}
+
+	// setup HostName
+	hostname = productKey + "."
+	if crd.Region == infra.CloudRegionCustom {
+		hostname += crd.CustomDomain
+	} else {
+		hostname += infra.MQTTCloudDomain[crd.Region]
+	}
+	return schema + net.JoinHostPort(hostname, strconv.Itoa(int(sf.port))), hostname
+}
+
 // encodeExtParam 根据extParams编码扩展字符串
 func encodeExtParam(extParams map[string]string) string {
 	if len(extParams) == 0 {
@@ -211,26 +337,45 @@ func NewTLSConfig(ca string) (*tls.Config, error) {
 	return TLSConfig(bs)
 }
 
-// TLSConfig tls config
-func TLSConfig(cacertPem []byte) (*tls.Config, error) {
+// NewX509TLSConfig 创建X.509证书认证的tls配置, 出示设备证书, ca为空时不加载CA证书,
+// ca, cert, key 如果有"base64://"前缀,直接解析后面的字符串,否则认为是文件名
+func NewX509TLSConfig(ca, cert, key string) (*tls.Config, error) {
+	var bs []byte
+	if ca != "" {
+		var err error
+		if bs, err = infra.LoadCrt(ca); err != nil {
+			return nil, err
+		}
+	}
+	crt, err := LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	return TLSConfig(bs, crt)
+}
+
+// LoadX509KeyPair 加载设备证书及私钥
+// 如果cert, key有"base64://"前缀,直接解析后面的字符串,否则认为是文件名
+func LoadX509KeyPair(cert, key string) (tls.Certificate, error) {
+	certPem, err := infra.LoadCrt(cert)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPem, err := infra.LoadCrt(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPem, keyPem)
+}
+
+// TLSConfig tls config, certs 为向服务端出示的设备证书, 用于X.509证书认证
+func TLSConfig(cacertPem []byte, certs ...tls.Certificate) (*tls.Config, error) {
 	// Import trusted certificates from CAfile.pem.
 	// Alternatively, manually add CA certificates to
 	// default openssl CA bundle.
 	certpool := x509.NewCertPool()
 	certpool.AppendCertsFromPEM(cacertPem)
 
-	//	// Import client certificate/key pair
-	//	cert, err := tls.X509KeyPair([]byte(cert_pem), []byte(key_pem))
-	//	if err != nil {
-	//		return nil, err
-	//	}
-
-	//	// Just to print out the client certificate..
-	//	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
-	//	if err != nil {
-	//		return nil, err
-	//	}
-
 	// Create tls.Config with desired tls properties
 	return &tls.Config{
 		// RootCAs = certs used to verify server cert.
@@ -245,8 +390,8 @@ func TLSConfig(cacertPem []byte) (*tls.Config, error) {
 		// match server. IP matches what is in cert etc.
 		InsecureSkipVerify: true,
 		// Certificates = list of certs client sends to server.
-		//		Certificates: []tls.Certificate{cert},
-		MinVersion: tls.VersionTLS12,
-		MaxVersion: tls.VersionTLS12,
+		Certificates: certs,
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tls.VersionTLS12,
 	}, nil
 }
@@ -1,7 +1,19 @@
 package sign
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -65,6 +77,138 @@ func TestMQTTSign(t *testing.T) {
 	})
 }
 
+func TestMQTTSignNoPreRegistration(t *testing.T) {
+	triad := infra.MetaTriad{ProductKey: testProductKey, DeviceName: testDeviceName}
+	crd := infra.CloudRegionDomain{Region: infra.CloudRegionShangHai}
+
+	_, err := Generate(triad, crd, WithSecureMode(SecureModeNoPreRegistration))
+	require.Error(t, err)
+
+	signout, err := Generate(triad, crd,
+		WithSecureMode(SecureModeNoPreRegistration),
+		WithClientID("cid"),
+		WithDeviceToken("token"),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "tls://"+testProductKey+".iot-as-mqtt.cn-shanghai.aliyuncs.com:1883", signout.Addr)
+	require.Equal(t, "cid", signout.ClientID)
+	require.Equal(t, "cid|authType=connwl,ext=0,gw=0,lan=Golang,securemode=-2|", signout.ClientIDWithExt())
+	require.Equal(t, testDeviceName+"&"+testProductKey, signout.UserName)
+	require.Equal(t, "token", signout.Password)
+}
+
+func TestMQTTSignClientID(t *testing.T) {
+	triad := infra.MetaTriad{ProductKey: testProductKey, DeviceName: testDeviceName, DeviceSecret: testDeviceSecret}
+	crd := infra.CloudRegionDomain{Region: infra.CloudRegionShangHai}
+
+	signout, err := Generate(triad, crd)
+	require.NoError(t, err)
+	clientID, pwd := infra.CalcSign(hmacsha256, triad, fixedTimestamp)
+	require.Equal(t, clientID, signout.ClientID)
+	require.Equal(t, pwd, signout.Password)
+
+	signout, err = Generate(triad, crd, WithClientID("cid"))
+	require.NoError(t, err)
+	require.Equal(t, "cid", signout.ClientID)
+	require.NotEqual(t, pwd, signout.Password)
+}
+
+func TestMQTTSignRegister(t *testing.T) {
+	tetrad := infra.MetaTetrad{ProductKey: testProductKey, ProductSecret: "ps", DeviceName: testDeviceName}
+	crd := infra.CloudRegionDomain{Region: infra.CloudRegionShangHai}
+
+	_, err := GenerateRegister(infra.MetaTetrad{ProductKey: testProductKey, DeviceName: testDeviceName}, crd)
+	require.Error(t, err)
+	// 动态注册不支持X.509证书
+	_, err = GenerateRegister(tetrad, crd, WithX509())
+	require.Equal(t, ErrX509Unsupported, err)
+
+	for _, tt := range []struct {
+		mode       string
+		secureMode string
+		authType   string
+	}{
+		{"", SecureModeTLSDirect, AuthTypeRegister},
+		{SecureModeNoPreRegistration, SecureModeNoPreRegistration, AuthTypeRegNwl},
+	} {
+		signout, err := GenerateRegister(tetrad, crd, WithSecureMode(tt.mode), WithSignMethod(hmacsha1))
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(signout.Addr, "tls://"))
+		require.Equal(t, testDeviceName+"&"+testProductKey, signout.UserName)
+
+		ext := signout.ClientIDWithExt()
+		i := strings.Index(ext, "random=")
+		require.True(t, i > 0)
+		random := ext[i+len("random=") : i+len("random=")+16]
+		require.Equal(t, infra.ClientID(testProductKey, testDeviceName)+
+			"|authType="+tt.authType+",random="+random+",securemode="+tt.secureMode+",signmethod=hmacsha1|", ext)
+		source := "deviceName" + testDeviceName + "productKey" + testProductKey + "random" + random
+		require.Equal(t, infra.Hmac(hmacsha1, "ps", source), signout.Password)
+	}
+}
+
+// testCertificate 生成自签名的设备证书及私钥
+func testCertificate(t *testing.T) (certPem, keyPem []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: testDeviceName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestMQTTSignX509(t *testing.T) {
+	triad := infra.MetaTriad{ProductKey: testProductKey, DeviceName: testDeviceName}
+	signout, err := Generate(triad, infra.CloudRegionDomain{Region: infra.CloudRegionShangHai}, WithX509(), WithSecureMode(SecureModeTCPDirectPlain))
+	require.NoError(t, err)
+	require.Equal(t, "tls://x509.itls.cn-shanghai.aliyuncs.com:1883", signout.Addr)
+	require.Equal(t, "x509.itls.cn-shanghai.aliyuncs.com", signout.HostName)
+	require.Equal(t, infra.ClientID(testProductKey, testDeviceName)+"|ext=0,gw=0,lan=Golang,securemode=2|", signout.ClientIDWithExt())
+	require.Equal(t, testDeviceName+"&"+testProductKey, signout.UserName)
+	require.Empty(t, signout.Password)
+
+	signout, err = Generate(triad, infra.CloudRegionDomain{Region: infra.CloudRegionCustom, CustomDomain: "iot.custom.com"}, WithX509(), WithPort(8883))
+	require.NoError(t, err)
+	require.Equal(t, "tls://iot.custom.com:8883", signout.Addr)
+
+	// 不使用签名, 忽略签名方法及时间戳
+	signout, err = Generate(triad, infra.CloudRegionDomain{Region: infra.CloudRegionShangHai}, WithX509(),
+		WithClientID("cid"), WithSignMethod(hmacsha1), WithTimestamp(), WithDeviceModel(false))
+	require.NoError(t, err)
+	require.Equal(t, "cid|lan=Golang,securemode=2,v=20|", signout.ClientIDWithExt())
+	require.Equal(t, testDeviceName+"&"+testProductKey, signout.UserName)
+	require.Empty(t, signout.Password)
+
+	_, err = Generate(triad, infra.CloudRegionDomain{Region: infra.CloudRegionJapan}, WithX509())
+	require.Error(t, err)
+}
+
+func TestX509TLSConfig(t *testing.T) {
+	certPem, keyPem := testCertificate(t)
+	certFile := filepath.Join(t.TempDir(), "device.crt")
+	require.NoError(t, ioutil.WriteFile(certFile, certPem, 0600))
+
+	cfg, err := NewX509TLSConfig("", certFile, "base64://"+base64.StdEncoding.EncodeToString(keyPem))
+	require.NoError(t, err)
+	require.Len(t, cfg.Certificates, 1)
+
+	_, err = NewX509TLSConfig("", certFile, certFile)
+	require.Error(t, err)
+
+	cfg, err = TLSConfig(nil)
+	require.NoError(t, err)
+	require.Empty(t, cfg.Certificates)
+}
+
 func Benchmark_encodeExtParam(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		encodeExtParam(map[string]string{
@@ -0,0 +1,168 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package signer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// 错误定义
+var (
+	ErrServerClosed      = errors.New("signer: server closed")
+	ErrUnsupportedMethod = errors.New("signer: unsupported sign method")
+)
+
+// Secrets 持有多个设备三元组的HMAC签名器, 用于签名守护进程
+func Secrets(triads ...infra.MetaTriad) infra.Signer {
+	secrets := make(map[infra.MetaPair]string, len(triads))
+	for _, t := range triads {
+		secrets[infra.MetaPair{ProductKey: t.ProductKey, DeviceName: t.DeviceName}] = t.DeviceSecret
+	}
+	return infra.SecretSigner(func(pair infra.MetaPair) (string, error) {
+		return secrets[pair], nil
+	})
+}
+
+// LoadSecrets 从JSON文件加载设备三元组列表, 格式为 []infra.MetaTriad
+func LoadSecrets(path string) (infra.Signer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var triads []infra.MetaTriad
+	if err = json.Unmarshal(b, &triads); err != nil {
+		return nil, err
+	}
+	return Secrets(triads...), nil
+}
+
+// Listen 监听Unix socket, 删除残留的socket文件, 并设置权限为0600只允许本用户访问
+func Listen(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		l.Close() // nolint: errcheck
+		return nil, err
+	}
+	return l, nil
+}
+
+// Server 签名守护进程, 使用持有DeviceSecret的签名器为客户端加签
+type Server struct {
+	s infra.Signer
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewServer 创建签名守护进程, s 为实际持有DeviceSecret的签名器, 如 Secrets
+func NewServer(s infra.Signer) *Server {
+	return &Server{
+		s:         s,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve 在l上提供服务, 直到 Close, 关闭后返回 ErrServerClosed
+func (sf *Server) Serve(l net.Listener) error {
+	sf.mu.Lock()
+	if sf.closed {
+		sf.mu.Unlock()
+		return ErrServerClosed
+	}
+	sf.listeners[l] = struct{}{}
+	sf.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			sf.mu.Lock()
+			closed := sf.closed
+			delete(sf.listeners, l)
+			sf.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		sf.mu.Lock()
+		if sf.closed {
+			sf.mu.Unlock()
+			conn.Close() // nolint: errcheck
+			return ErrServerClosed
+		}
+		sf.conns[conn] = struct{}{}
+		sf.wg.Add(1)
+		sf.mu.Unlock()
+		go sf.serveConn(conn)
+	}
+}
+
+func (sf *Server) serveConn(conn net.Conn) {
+	defer func() {
+		sf.mu.Lock()
+		delete(sf.conns, conn)
+		sf.mu.Unlock()
+		conn.Close() // nolint: errcheck
+		sf.wg.Done()
+	}()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	enc := json.NewEncoder(conn)
+	for {
+		var req Request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if err := enc.Encode(sf.sign(&req)); err != nil {
+			return
+		}
+	}
+}
+
+func (sf *Server) sign(req *Request) *Response {
+	switch req.Method {
+	case "hmacmd5", "hmacsha1", "hmacsha256":
+	default:
+		return &Response{Error: ErrUnsupportedMethod.Error()}
+	}
+	sign, err := sf.s.Sign(req.Method, infra.MetaPair{ProductKey: req.ProductKey, DeviceName: req.DeviceName}, req.Content)
+	if err != nil {
+		return &Response{Error: err.Error()}
+	}
+	return &Response{Sign: sign}
+}
+
+// Close 关闭所有监听及连接
+func (sf *Server) Close() error {
+	sf.mu.Lock()
+	sf.closed = true
+	for l := range sf.listeners {
+		l.Close() // nolint: errcheck
+	}
+	for conn := range sf.conns {
+		conn.Close() // nolint: errcheck
+	}
+	sf.mu.Unlock()
+	sf.wg.Wait()
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package signer 通过Unix socket委托本地签名守护进程加签的签名器, 设备的DeviceSecret只保存在守护进程中.
+// 协议为每行一个JSON的请求及应答, 一个连接上可以有多个请求:
+//
+//	-> {"method":"hmacsha256","productKey":"pk","deviceName":"dn","content":"clientId..."}
+//	<- {"sign":"9a2f..."} 或 {"error":"no device secret"}
+package signer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// DefaultTimeout 默认单次签名的超时时间
+const DefaultTimeout = 3 * time.Second
+
+// Request 签名请求
+type Request struct {
+	Method     string `json:"method"`
+	ProductKey string `json:"productKey"`
+	DeviceName string `json:"deviceName"`
+	Content    string `json:"content"`
+}
+
+// Response 签名应答
+type Response struct {
+	Sign  string `json:"sign,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Option 客户端选项
+type Option func(*Client)
+
+// WithTimeout 设置单次签名的超时时间,默认 DefaultTimeout
+func WithTimeout(t time.Duration) Option {
+	return func(c *Client) {
+		if t > 0 {
+			c.timeout = t
+		}
+	}
+}
+
+// Client 委托签名守护进程加签的签名器, 实现 infra.Signer 接口, 协程安全
+type Client struct {
+	path    string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+var _ infra.Signer = (*Client)(nil)
+
+// New 创建签名器, path为签名守护进程的Unix socket路径, 首次签名时才连接
+func New(path string, opts ...Option) *Client {
+	c := &Client{path: path, timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Sign 实现 infra.Signer 接口, 连接出错时重新连接并重试一次
+func (sf *Client) Sign(method string, pair infra.MetaPair, content string) (string, error) {
+	req, err := json.Marshal(&Request{method, pair.ProductKey, pair.DeviceName, content})
+	if err != nil {
+		return "", err
+	}
+	req = append(req, '\n')
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	reused := sf.conn != nil
+	rsp, err := sf.roundTrip(req)
+	if err != nil && reused {
+		rsp, err = sf.roundTrip(req)
+	}
+	if err != nil {
+		return "", err
+	}
+	if rsp.Error != "" {
+		return "", errors.New(rsp.Error)
+	}
+	return rsp.Sign, nil
+}
+
+func (sf *Client) roundTrip(req []byte) (*Response, error) {
+	if sf.conn == nil {
+		conn, err := net.DialTimeout("unix", sf.path, sf.timeout)
+		if err != nil {
+			return nil, err
+		}
+		sf.conn, sf.r = conn, bufio.NewReader(conn)
+	}
+	rsp := &Response{}
+	err := sf.conn.SetDeadline(time.Now().Add(sf.timeout))
+	if err == nil {
+		_, err = sf.conn.Write(req)
+	}
+	if err == nil {
+		var line []byte
+		if line, err = sf.r.ReadBytes('\n'); err == nil {
+			err = json.Unmarshal(line, rsp)
+		}
+	}
+	if err != nil {
+		sf.closeLocked()
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// Close 关闭与守护进程的连接
+func (sf *Client) Close() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.closeLocked()
+	return nil
+}
+
+func (sf *Client) closeLocked() {
+	if sf.conn != nil {
+		sf.conn.Close() // nolint: errcheck
+		sf.conn, sf.r = nil, nil
+	}
+}
@@ -0,0 +1,77 @@
+package signer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/sign"
+)
+
+var testTriad = infra.MetaTriad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"}
+
+func serve(t *testing.T, path string) *Server {
+	l, err := Listen(path)
+	require.NoError(t, err)
+	srv := NewServer(Secrets(testTriad))
+	go srv.Serve(l) // nolint: errcheck
+	return srv
+}
+
+func TestSigner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signer.sock")
+	srv := serve(t, path)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	c := New(path)
+	defer c.Close()
+	pair := infra.MetaPair{ProductKey: "pk", DeviceName: "dn"}
+	for _, method := range []string{"hmacmd5", "hmacsha1", "hmacsha256"} {
+		s, err := c.Sign(method, pair, "content")
+		require.NoError(t, err)
+		require.Equal(t, infra.Hmac(method, "ds", "content"), s)
+	}
+
+	_, err = c.Sign("hmacsha256", infra.MetaPair{ProductKey: "pk", DeviceName: "other"}, "content")
+	require.EqualError(t, err, infra.ErrNoSecret.Error())
+	_, err = c.Sign("sha256", pair, "content")
+	require.EqualError(t, err, ErrUnsupportedMethod.Error())
+
+	// 守护进程重启后重新连接
+	require.NoError(t, srv.Close())
+	srv = serve(t, path)
+	defer srv.Close()
+	s, err := c.Sign("hmacsha256", pair, "content")
+	require.NoError(t, err)
+	require.Equal(t, infra.Hmac("hmacsha256", "ds", "content"), s)
+
+	// 与使用DeviceSecret的签名一致
+	crd := infra.CloudRegionDomain{Region: infra.CloudRegionShangHai}
+	want, err := sign.Generate(testTriad, crd)
+	require.NoError(t, err)
+	got, err := sign.Generate(infra.MetaTriad{ProductKey: "pk", DeviceName: "dn"}, crd, sign.WithSigner(c))
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+func TestSignerUnavailable(t *testing.T) {
+	c := New(filepath.Join(t.TempDir(), "missing.sock"))
+	_, err := c.Sign("hmacsha256", infra.MetaPair{ProductKey: "pk", DeviceName: "dn"}, "content")
+	require.Error(t, err)
+}
+
+func TestLoadSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`[{"productKey":"pk","deviceName":"dn","deviceSecret":"ds"}]`), 0600))
+	s, err := LoadSecrets(path)
+	require.NoError(t, err)
+	v, err := s.Sign("hmacsha256", infra.MetaPair{ProductKey: "pk", DeviceName: "dn"}, "content")
+	require.NoError(t, err)
+	require.Equal(t, infra.Hmac("hmacsha256", "ds", "content"), v)
+}
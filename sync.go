@@ -57,6 +57,9 @@ func (sf *Client) putPending(id uint) *Token {
 // signalPending 指定缓存id收到回复,并发出同步通知
 func (sf *Client) signalPending(msg Message) {
 	key := strconv.FormatUint(uint64(msg.ID), 10)
+	if sf.replayCache != nil {
+		sf.replayCache.Delete(key)
+	}
 	if v, ok := sf.msgCache.Get(key); ok {
 		sf.msgCache.Delete(key)
 		select {
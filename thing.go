@@ -25,6 +25,7 @@ type Callback interface {
 	ThingModelDownRaw(c *Client, productKey, deviceName string, payload []byte) error
 	// event
 	ThingEventPropertyPostReply(c *Client, err error, productKey, deviceName string) error
+	// 自定义模块的eventID为 {functionBlockId}:{tsl.event.identifier}
 	ThingEventPostReply(c *Client, err error, eventID, productKey, deviceName string) error
 	ThingEventPropertyPackPostReply(c *Client, err error, productKey, deviceName string) error
 	ThingEventPropertyHistoryPostReply(c *Client, err error, productKey, deviceName string) error
@@ -54,6 +55,7 @@ type Callback interface {
 	// 设置设备属性, 需用户自行做回复
 	ThingServicePropertySet(c *Client, productKey, deviceName string, payload []byte) error
 	// 设备服务调用,需用户自行做回复
+	// 自定义模块的srvID为 {functionBlockId}:{tsl.service.identifier}(未注册该模块处理函数时)
 	ThingServiceRequest(c *Client, srvID, productKey, deviceName string, payload []byte) error
 
 	// ntp
@@ -70,7 +72,7 @@ type Callback interface {
 
 // GwCallback 网关事件接口
 type GwCallback interface {
-	// 520错误已做自动登陆回复
+	// 子设备错误回复, 子设备会话或离线错误时已更新子设备状态并自动重新登陆子设备, 见 WithSubDevRelogin
 	ExtErrorResponse(c *Client, err error, productKey, deviceName string) error
 	ThingTopoGetReply(c *Client, err error, params []infra.MetaPair) error
 	ThingListFoundReply(c *Client, err error) error
@@ -18,7 +18,7 @@ import (
 // request:  /sys/{productKey}/{deviceName}/thing/event/property/post
 // response: /sys/{productKey}/{deviceName}/thing/event/property/post_reply
 func (sf *Client) ThingEventPropertyPost(pk, dn string, params interface{}) (*Token, error) {
-	if sf.hasRawModel {
+	if sf.hasRawModel && sf.codec == nil {
 		return nil, ErrNotSupportFeature
 	}
 	if !sf.IsActive(pk, dn) {
@@ -29,6 +29,7 @@ func (sf *Client) ThingEventPropertyPost(pk, dn string, params interface{}) (*To
 }
 
 // ThingEventPost 设备事件上报
+// eventID: 自定义模块的事件为 {functionBlockId}:{tsl.event.identifier}, 见 ThingFunctionBlockEventPost
 // request:  /sys/{productKey}/{deviceName}/thing/event/{tsl.event.identifier}/post
 // response: /sys/{productKey}/{deviceName}/thing/event/{tsl.event.identifier}/post_reply
 func (sf *Client) ThingEventPost(pk, dn, eventID string, params interface{}) (*Token, error) {
@@ -0,0 +1,77 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package aiot
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/uri"
+)
+
+// @see https://help.aliyun.com/document_detail/73727.html
+// 自定义模块(功能块)下的属性,事件,服务的标识符格式为 {functionBlockId}:{identifier},
+// 默认模块的标识符无前缀.
+
+// FunctionBlockServiceHandler 自定义模块的服务调用处理,需用户自行做回复
+// srvID: 模块内的服务标识符,不含模块前缀
+// 回复的topic: /sys/{productKey}/{deviceName}/thing/service/{functionBlockId}:{srvID}_reply
+type FunctionBlockServiceHandler func(c *Client, productKey, deviceName, srvID string, payload []byte) error
+
+// ServiceRequest 服务调用请求
+type ServiceRequest struct {
+	ID      uint            `json:"id,string"`
+	Version string          `json:"version"`
+	Params  json.RawMessage `json:"params"`
+	Method  string          `json:"method"`
+	// 自定义模块ID, 默认模块为空
+	FunctionBlockID string `json:"functionBlockId,omitempty"`
+}
+
+// ThingFunctionBlockPropertyPost 自定义模块上报属性数据,属性标识符将自动加上模块前缀
+// request:  /sys/{productKey}/{deviceName}/thing/event/property/post
+// response: /sys/{productKey}/{deviceName}/thing/event/property/post_reply
+func (sf *Client) ThingFunctionBlockPropertyPost(pk, dn, functionBlockID string,
+	params map[string]interface{}) (*Token, error) {
+	if functionBlockID == "" {
+		return sf.ThingEventPropertyPost(pk, dn, params)
+	}
+	ps := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		ps[uri.FunctionBlockIdentifier(functionBlockID, k)] = v
+	}
+	return sf.ThingEventPropertyPost(pk, dn, ps)
+}
+
+// ThingFunctionBlockEventPost 自定义模块事件上报
+// request:  /sys/{productKey}/{deviceName}/thing/event/{functionBlockId}:{tsl.event.identifier}/post
+// response: /sys/{productKey}/{deviceName}/thing/event/{functionBlockId}:{tsl.event.identifier}/post_reply
+func (sf *Client) ThingFunctionBlockEventPost(pk, dn, functionBlockID, eventID string,
+	params interface{}) (*Token, error) {
+	return sf.ThingEventPost(pk, dn, uri.FunctionBlockIdentifier(functionBlockID, eventID), params)
+}
+
+// functionBlockOfService 获取服务调用所属的模块及模块内的服务标识符
+// 优先使用topic中的模块前缀, 其次为请求中的functionBlockId字段
+func functionBlockOfService(serviceID string, payload []byte) (functionBlockID, srvID string) {
+	functionBlockID, srvID = uri.SplitFunctionBlock(serviceID)
+	if functionBlockID != "" {
+		return functionBlockID, srvID
+	}
+	req := &ServiceRequest{}
+	if err := json.Unmarshal(payload, req); err != nil {
+		return "", srvID
+	}
+	if req.FunctionBlockID == "" {
+		// method: thing.service.{functionBlockId}:{identifier}
+		var method string
+		if _, err := fmt.Sscanf(req.Method, infra.MethodServiceFormat, &method); err == nil {
+			functionBlockID, _ = uri.SplitFunctionBlock(method)
+		}
+		return functionBlockID, srvID
+	}
+	return req.FunctionBlockID, srvID
+}
@@ -0,0 +1,114 @@
+package aiot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+// serviceCall 记录服务调用的处理方
+type serviceCall struct {
+	by    string // 处理方, 模块ID或 "callback"
+	srvID string
+	pk    string
+	dn    string
+}
+
+// serviceCb 记录交由 Callback 处理的服务调用
+type serviceCb struct {
+	NopCb
+	calls *[]serviceCall
+}
+
+func (sf serviceCb) ThingServiceRequest(_ *Client, srvID, pk, dn string, _ []byte) error {
+	*sf.calls = append(*sf.calls, serviceCall{"callback", srvID, pk, dn})
+	return nil
+}
+
+func functionBlockHandler(calls *[]serviceCall, functionBlockID string) FunctionBlockServiceHandler {
+	return func(_ *Client, pk, dn, srvID string, _ []byte) error {
+		*calls = append(*calls, serviceCall{functionBlockID, srvID, pk, dn})
+		return nil
+	}
+}
+
+// newServiceClient 创建只处理下行服务调用的客户端
+func newServiceClient(opts ...Option) *Client {
+	return New(infra.MetaTriad{ProductKey: "pk", DeviceName: "dn", DeviceSecret: "ds"}, nil, opts...)
+}
+
+func TestDispatchServiceRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		payload string
+		want    serviceCall
+	}{
+		{
+			"topic prefix",
+			"/sys/pk/dn/thing/service/block:reboot",
+			`{"id":"1","method":"thing.service.block:reboot","params":{}}`,
+			serviceCall{"block", "reboot", "pk", "dn"},
+		},
+		{
+			"payload functionBlockId",
+			"/sys/pk/dn/thing/service/reboot",
+			`{"id":"1","method":"thing.service.reboot","params":{},"functionBlockId":"block"}`,
+			serviceCall{"block", "reboot", "pk", "dn"},
+		},
+		{
+			"method prefix",
+			"/sys/pk/dn/thing/service/reboot",
+			`{"id":"1","method":"thing.service.block:reboot","params":{}}`,
+			serviceCall{"block", "reboot", "pk", "dn"},
+		},
+		{
+			"default module",
+			"/sys/pk/dn/thing/service/reboot",
+			`{"id":"1","method":"thing.service.reboot","params":{}}`,
+			serviceCall{"callback", "reboot", "pk", "dn"},
+		},
+		{
+			"unregistered module",
+			"/sys/pk/dn/thing/service/other:reboot",
+			`{"id":"1","method":"thing.service.other:reboot","params":{}}`,
+			serviceCall{"callback", "other:reboot", "pk", "dn"},
+		},
+		{
+			"invalid payload",
+			"/sys/pk/dn/thing/service/reboot",
+			`invalid`,
+			serviceCall{"callback", "reboot", "pk", "dn"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []serviceCall
+			c := newServiceClient(
+				WithCallback(serviceCb{calls: &calls}),
+				WithFunctionBlockHandler("block", functionBlockHandler(&calls, "block")),
+			)
+			require.NoError(t, ProcThingServiceRequest(c, tt.topic, []byte(tt.payload)))
+			require.Equal(t, []serviceCall{tt.want}, calls)
+		})
+	}
+}
+
+func TestDispatchServiceRequestFallback(t *testing.T) {
+	var calls []serviceCall
+	c := newServiceClient(WithCallback(serviceCb{calls: &calls}))
+	require.NoError(t, ProcThingServiceRequest(c, "/sys/pk/dn/thing/service/block:reboot",
+		[]byte(`{"id":"1","method":"thing.service.block:reboot","params":{}}`)))
+	require.Equal(t, []serviceCall{{"callback", "block:reboot", "pk", "dn"}}, calls)
+
+	// property/set 不经过模块分发
+	calls = nil
+	c = newServiceClient(
+		WithCallback(serviceCb{calls: &calls}),
+		WithFunctionBlockHandler("block", functionBlockHandler(&calls, "block")),
+	)
+	require.NoError(t, ProcThingServiceRequest(c, "/sys/pk/dn/thing/service/property/set", []byte(`{}`)))
+	require.Empty(t, calls)
+}
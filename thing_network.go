@@ -29,45 +29,63 @@ type TopoAddParams struct {
 // request:   /sys/{productKey}/{deviceName}/thing/topo/add
 // response:  /sys/{productKey}/{deviceName}/thing/topo/add_reply
 func (sf *Client) thingTopoAdd(pk, dn string) (*Token, error) {
+	return sf.thingTopoBatchAdd([]infra.MetaPair{
+		{ProductKey: pk, DeviceName: dn},
+	})
+}
+
+// thingTopoBatchAdd 批量添加设备拓扑关系,所有子设备必需持有secret
+// request:   /sys/{productKey}/{deviceName}/thing/topo/add
+// response:  /sys/{productKey}/{deviceName}/thing/topo/add_reply
+func (sf *Client) thingTopoBatchAdd(pairs []infra.MetaPair) (*Token, error) {
 	if !sf.isGateway {
 		return nil, ErrNotSupportFeature
 	}
-	ds, err := sf.DeviceSecret(pk, dn)
-	if err != nil {
-		return nil, err
+	if len(pairs) == 0 {
+		return nil, ErrInvalidParameter
 	}
 
 	timestamp := infra.Millisecond(time.Now())
-	clientID, signs := infra.CalcSign("hmacsha256",
-		infra.MetaTriad{
-			ProductKey:   pk,
-			DeviceName:   dn,
-			DeviceSecret: ds,
-		}, timestamp)
-	_uri := sf.URIGateway(uri.SysPrefix, uri.ThingTopoAdd)
-	return sf.SendRequest(_uri, infra.MethodTopoAdd, []TopoAddParams{
-		{
-			pk,
-			dn,
+	params := make([]TopoAddParams, 0, len(pairs))
+	for _, pair := range pairs {
+		clientID, signs, err := sf.calcSign(pair.ProductKey, pair.DeviceName, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, TopoAddParams{
+			pair.ProductKey,
+			pair.DeviceName,
 			clientID,
 			timestamp,
 			"hmacsha256",
 			signs,
-		},
-	})
+		})
+	}
+	_uri := sf.URIGateway(uri.SysPrefix, uri.ThingTopoAdd)
+	return sf.SendRequest(_uri, infra.MethodTopoAdd, params)
 }
 
 // thingTopoDelete 删除网关与子设备的拓扑关系
 // request： /sys/{productKey}/{deviceName}/thing/topo/delete
 // response：/sys/{productKey}/{deviceName}/thing/topo/delete_reply
 func (sf *Client) thingTopoDelete(pk, dn string) (*Token, error) {
+	return sf.thingTopoBatchDelete([]infra.MetaPair{
+		{ProductKey: pk, DeviceName: dn},
+	})
+}
+
+// thingTopoBatchDelete 批量删除网关与子设备的拓扑关系
+// request： /sys/{productKey}/{deviceName}/thing/topo/delete
+// response：/sys/{productKey}/{deviceName}/thing/topo/delete_reply
+func (sf *Client) thingTopoBatchDelete(pairs []infra.MetaPair) (*Token, error) {
 	if !sf.isGateway {
 		return nil, ErrNotSupportFeature
 	}
+	if len(pairs) == 0 {
+		return nil, ErrInvalidParameter
+	}
 	_uri := sf.URIGateway(uri.SysPrefix, uri.ThingTopoDelete)
-	return sf.SendRequest(_uri, infra.MethodTopoDelete, []infra.MetaPair{
-		{ProductKey: pk, DeviceName: dn},
-	})
+	return sf.SendRequest(_uri, infra.MethodTopoDelete, pairs)
 }
 
 // ThingTopoGet 获取该网关和子设备的拓扑关系
@@ -0,0 +1,139 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package aiot
+
+import (
+	"encoding/json"
+
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/uri"
+)
+
+// ProductRegisterProxied 子设备一型一密动态注册的单个子设备参数
+type ProductRegisterProxied struct {
+	ProductKey string `json:"productKey"`
+	DeviceName string `json:"deviceName"`
+	Random     string `json:"random"`
+	Sign       string `json:"sign"`
+	SignMethod string `json:"signMethod"` // 支持hmacmd5、hmacsha1、hmacsha256
+}
+
+// ProductRegisterParams 子设备一型一密动态注册参数域
+type ProductRegisterParams struct {
+	Proxieds []ProductRegisterProxied `json:"proxieds"`
+}
+
+// ProductRegisterError 注册失败的子设备信息
+type ProductRegisterError struct {
+	ProductKey string `json:"productKey"`
+	DeviceName string `json:"deviceName"`
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+}
+
+// ProductRegisterData 子设备一型一密动态注册应答数据域
+type ProductRegisterData struct {
+	ErrorInfos []ProductRegisterError `json:"errorInfos"`
+	Successes  []SubRegisterData      `json:"successes"`
+}
+
+// ProductRegisterResponse 子设备一型一密动态注册应答
+type ProductRegisterResponse struct {
+	ID      uint                `json:"id,string"`
+	Code    int                 `json:"code"`
+	Data    ProductRegisterData `json:"data"`
+	Message string              `json:"message,omitempty"`
+}
+
+// SetProductSecret 设置子设备产品的ProductSecret,
+// 设置后该产品的子设备可使用一型一密动态注册,无需在平台预注册设备名称
+func (sf *Client) SetProductSecret(pk, ps string) {
+	sf.psMu.Lock()
+	defer sf.psMu.Unlock()
+	if ps == "" {
+		delete(sf.productSecrets, pk)
+		return
+	}
+	if sf.productSecrets == nil {
+		sf.productSecrets = make(map[string]string)
+	}
+	sf.productSecrets[pk] = ps
+}
+
+// ProductSecret 获得子设备产品的ProductSecret
+func (sf *Client) ProductSecret(pk string) (string, error) {
+	sf.psMu.RLock()
+	defer sf.psMu.RUnlock()
+	if ps, ok := sf.productSecrets[pk]; ok {
+		return ps, nil
+	}
+	return "", ErrNotFound
+}
+
+// thingProxyProductRegister 子设备一型一密动态注册
+// 网关使用子设备产品的ProductSecret为子设备发起动态注册,设备名称无需在平台预注册,
+// 返回成功注册的子设备的设备证书
+// request:   /sys/{productKey}/{deviceName}/thing/proxy/provisioning/product_register
+// response:  /sys/{productKey}/{deviceName}/thing/proxy/provisioning/product_register_reply
+func (sf *Client) thingProxyProductRegister(pk, dn string) (*Token, error) {
+	return sf.thingProxyBatchProductRegister([]infra.MetaPair{
+		{ProductKey: pk, DeviceName: dn},
+	})
+}
+
+// thingProxyBatchProductRegister 子设备一型一密批量动态注册,所有子设备的产品必需已设置ProductSecret
+// request:   /sys/{productKey}/{deviceName}/thing/proxy/provisioning/product_register
+// response:  /sys/{productKey}/{deviceName}/thing/proxy/provisioning/product_register_reply
+func (sf *Client) thingProxyBatchProductRegister(pairs []infra.MetaPair) (*Token, error) {
+	if !sf.isGateway {
+		return nil, ErrNotSupportFeature
+	}
+	if len(pairs) == 0 {
+		return nil, ErrInvalidParameter
+	}
+
+	params := ProductRegisterParams{make([]ProductRegisterProxied, 0, len(pairs))}
+	for _, pair := range pairs {
+		ps, err := sf.ProductSecret(pair.ProductKey)
+		if err != nil {
+			return nil, err
+		}
+		random := infra.RandAlphabet(16)
+		// deviceName{deviceName}productKey{productKey}random{random}
+		source := "deviceName" + pair.DeviceName + "productKey" + pair.ProductKey + "random" + random
+		params.Proxieds = append(params.Proxieds, ProductRegisterProxied{
+			pair.ProductKey,
+			pair.DeviceName,
+			random,
+			infra.Hmac("hmacsha256", ps, source),
+			"hmacsha256",
+		})
+	}
+	_uri := sf.URIGateway(uri.SysPrefix, uri.ThingProxyProductRegister)
+	return sf.SendRequest(_uri, infra.MethodProxyProductRegister, params)
+}
+
+// ProcThingProxyProductRegisterReply 处理子设备一型一密动态注册回复
+// request:   /sys/{productKey}/{deviceName}/thing/proxy/provisioning/product_register
+// response:  /sys/{productKey}/{deviceName}/thing/proxy/provisioning/product_register_reply
+// subscribe: /sys/{productKey}/{deviceName}/thing/proxy/provisioning/product_register_reply
+func ProcThingProxyProductRegisterReply(c *Client, rawURI string, payload []byte) error {
+	uris := uri.Spilt(rawURI)
+	if len(uris) < 7 {
+		return ErrInvalidURI
+	}
+	rsp := &ProductRegisterResponse{}
+	err := json.Unmarshal(payload, rsp)
+	if err != nil {
+		return err
+	}
+
+	if rsp.Code != infra.CodeSuccess {
+		err = infra.NewCodeError(rsp.Code, rsp.Message)
+	}
+	c.signalPending(Message{rsp.ID, rsp.Data, err})
+	c.Log.Debugf("thing.proxy.provisioning.product_register.reply @%d", rsp.ID)
+	return nil
+}
@@ -0,0 +1,93 @@
+package aiot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/aliyun-iot/infra"
+)
+
+const testProxyRegisterURI = "/sys/gwpk/gwdn/thing/proxy/provisioning/product_register_reply"
+
+func TestProcThingProxyProductRegisterReply(t *testing.T) {
+	c, _ := newGateway()
+	require.Equal(t, ErrInvalidURI, ProcThingProxyProductRegisterReply(c, "/sys/gwpk/gwdn/thing", nil))
+	require.Error(t, ProcThingProxyProductRegisterReply(c, testProxyRegisterURI, []byte("{")))
+
+	token := c.putPending(1)
+	require.NoError(t, ProcThingProxyProductRegisterReply(c, testProxyRegisterURI,
+		[]byte(`{"id":"1","code":200,"data":{"successes":[{"productKey":"pk","deviceName":"a","deviceSecret":"ds"}],
+		"errorInfos":[{"productKey":"pk","deviceName":"b","code":6288,"message":"failed"}]}}`)))
+	msg, err := token.Wait(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, ProductRegisterData{
+		ErrorInfos: []ProductRegisterError{{ProductKey: "pk", DeviceName: "b", Code: 6288, Message: "failed"}},
+		Successes:  []SubRegisterData{{ProductKey: "pk", DeviceName: "a", DeviceSecret: "ds"}},
+	}, msg.Data)
+
+	token = c.putPending(2)
+	require.NoError(t, ProcThingProxyProductRegisterReply(c, testProxyRegisterURI,
+		[]byte(`{"id":"2","code":400,"message":"request error"}`)))
+	_, err = token.Wait(time.Second)
+	var codeErr *infra.CodeError
+	require.ErrorAs(t, err, &codeErr)
+	require.Equal(t, infra.CodeRequestError, codeErr.Code())
+}
+
+func TestLinkThingProxyBatchProductRegister(t *testing.T) {
+	c, cloud := newGateway(WithProductSecret("pk", testProductSecret), WithProductSecret("bad", "x"))
+	for _, meta := range []infra.MetaTriad{{ProductKey: "pk", DeviceName: "a"}, {ProductKey: "bad", DeviceName: "b"}} {
+		require.NoError(t, c.AddSubDevice(meta))
+	}
+
+	regs, err := c.LinkThingProxyBatchProductRegister([]infra.MetaPair{
+		{ProductKey: "pk", DeviceName: "a"}, {ProductKey: "bad", DeviceName: "b"},
+	}, time.Second)
+	require.Equal(t, []SubRegisterData{{ProductKey: "pk", DeviceName: "a", DeviceSecret: "ds"}}, regs)
+	var codeErr *infra.CodeError
+	require.ErrorAs(t, err, &codeErr)
+	require.Equal(t, infra.CodeRequestError, codeErr.Code())
+	require.Equal(t, 1, cloud.count("/thing/proxy/provisioning/product_register"))
+
+	// 成功的子设备获得设备证书, 失败的不变
+	ds, err := c.DeviceSecret("pk", "a")
+	require.NoError(t, err)
+	require.Equal(t, "ds", ds)
+	st, _ := c.DeviceStatus("pk", "a")
+	require.Equal(t, DevStatusRegistered, st)
+	st, _ = c.DeviceStatus("bad", "b")
+	require.Equal(t, DevStatusUnauthorized, st)
+
+	// 未设置ProductSecret
+	_, err = c.LinkThingProxyBatchProductRegister([]infra.MetaPair{{ProductKey: "none", DeviceName: "a"}}, time.Second)
+	require.Equal(t, ErrNotFound, err)
+	_, err = c.LinkThingProxyBatchProductRegister(nil, time.Second)
+	require.Equal(t, ErrInvalidParameter, err)
+
+	_, err = New(testGateway, cloud).LinkThingProxyProductRegister("pk", "a", time.Second)
+	require.Equal(t, ErrNotSupportFeature, err)
+}
+
+func TestSubDeviceRegister(t *testing.T) {
+	c, cloud := newGateway(WithProductSecret("pk", testProductSecret))
+	cloud.omit = func(p infra.MetaPair) bool { return p.DeviceName == "x" }
+	for _, meta := range []infra.MetaTriad{
+		{ProductKey: "pk", DeviceName: "a"}, {ProductKey: "pk", DeviceName: "x"},
+		{ProductKey: "sub", DeviceName: "a"}, {ProductKey: "sub", DeviceName: "x"},
+	} {
+		require.NoError(t, c.AddSubDevice(meta))
+	}
+
+	// 一型一密动态注册
+	require.NoError(t, c.SubDeviceRegister("pk", "a", time.Second))
+	require.Equal(t, ErrNotRegistered, c.SubDeviceRegister("pk", "x", time.Second))
+	require.Equal(t, 2, cloud.count("/thing/proxy/provisioning/product_register"))
+
+	// 子设备动态注册
+	require.NoError(t, c.SubDeviceRegister("sub", "a", time.Second))
+	require.Equal(t, ErrNotRegistered, c.SubDeviceRegister("sub", "x", time.Second))
+	require.Equal(t, 2, cloud.count("/thing/sub/register"))
+	require.False(t, c.HasDeviceSecret("sub", "x"))
+}
@@ -23,6 +23,7 @@ func (sf *Client) ThingModelUpRaw(pk, dn string, payload interface{}) error {
 }
 
 // ProcThingModelUpRawReply 处理透传上行的应答
+// 设置了透传编解码器时,解码后分发到对应的属性上报或事件上报的应答
 // request: /sys/{productKey}/{deviceName}/thing/model/up_raw
 // response: /sys/{productKey}/{deviceName}/thing/model/up_raw_reply
 // subscribe: /sys/{productKey}/{deviceName}/thing/model/up_raw_reply
@@ -31,12 +32,16 @@ func ProcThingModelUpRawReply(c *Client, rawURI string, payload []byte) error {
 	if len(uris) < 6 {
 		return ErrInvalidURI
 	}
-	c.Log.Debugf("thing.model.up.raw.reply")
 	pk, dn := uris[1], uris[2]
+	if c.codec != nil {
+		return procModelUpRawReplyWithCodec(c, pk, dn, payload)
+	}
+	c.Log.Debugf("thing.model.up.raw.reply")
 	return c.cb.ThingModelUpRawReply(c, pk, dn, payload)
 }
 
 // ProcThingModelDownRaw 处理透传下行数据
+// 设置了透传编解码器时,解码后转为属性设置或服务调用处理
 // 下行
 // request: /sys/{productKey}/{deviceName}/thing/model/down_raw
 // response: /sys/{productKey}/{deviceName}/thing/model/down_raw_reply
@@ -46,7 +51,10 @@ func ProcThingModelDownRaw(c *Client, rawURI string, payload []byte) error {
 	if len(uris) < 6 {
 		return ErrInvalidURI
 	}
-	c.Log.Debugf("thing.model.down.raw")
 	pk, dn := uris[1], uris[2]
+	if c.codec != nil {
+		return procModelDownRawWithCodec(c, pk, dn, payload)
+	}
+	c.Log.Debugf("thing.model.down.raw")
 	return c.cb.ThingModelDownRaw(c, pk, dn, payload)
 }
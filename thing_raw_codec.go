@@ -0,0 +1,164 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package aiot
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/uri"
+)
+
+// @see https://help.aliyun.com/document_detail/68703.html
+
+// RequestRawData 请求, params域为 json.RawMessage
+type RequestRawData struct {
+	ID      uint            `json:"id,string"`
+	Version string          `json:"version"`
+	Params  json.RawMessage `json:"params"`
+	Method  string          `json:"method"`
+}
+
+// Codec 透传数据编解码器,与云端的数据解析脚本互为逆过程.
+// 设置后,物模型的属性上报,事件上报,属性设置,服务调用及其应答将透明地经由透传主题收发.
+type Codec interface {
+	// EncodeRequest 编码上行的Alink请求(属性上报,事件上报),对应云端脚本的rawDataToProtocol
+	EncodeRequest(productKey, deviceName string, req *Request) ([]byte, error)
+	// DecodeResponse 解码上行请求的透传应答,返回对应请求的method,对应云端脚本的protocolToRawData
+	DecodeResponse(productKey, deviceName string, raw []byte) (string, *ResponseRawData, error)
+	// DecodeRequest 解码下行的透传请求(属性设置,服务调用),对应云端脚本的protocolToRawData
+	DecodeRequest(productKey, deviceName string, raw []byte) (*RequestRawData, error)
+	// EncodeResponse 编码下行请求的应答,method为对应请求的method,对应云端脚本的rawDataToProtocol,
+	// 不支持该method时返回 ErrCodecUnknownMethod, 应答将按Alink格式发送
+	EncodeResponse(productKey, deviceName, method string, rsp *Response) ([]byte, error)
+}
+
+// ThingModelDownRawReply 回复透传下行数据
+// request:  /sys/{productKey}/{deviceName}/thing/model/down_raw
+// response: /sys/{productKey}/{deviceName}/thing/model/down_raw_reply
+func (sf *Client) ThingModelDownRawReply(pk, dn string, payload interface{}) error {
+	if !sf.hasRawModel {
+		return ErrNotSupportFeature
+	}
+	sf.Log.Debugf("thing.model.down.raw.reply")
+	_uri := uri.URI(uri.SysPrefix, uri.ThingModelDownRawReply, pk, dn)
+	return sf.Publish(_uri, 1, payload)
+}
+
+// isModelUpMethod 是否为需经透传编码的物模型上行method
+// thing.event.property.post, thing.event.{tsl.event.identifier}.post
+func isModelUpMethod(method string) bool {
+	if method == infra.MethodEventPropertyPost {
+		return true
+	}
+	return method != infra.MethodEventPropertyPackPost &&
+		method != infra.MethodEventPropertyHistoryPost &&
+		strings.HasPrefix(method, "thing.event.") &&
+		strings.HasSuffix(method, ".post")
+}
+
+// requestWithCodec 使用编码器编码物模型上行请求并发往透传主题
+// 返回false表示不是物模型请求,需按Alink格式发送
+func (sf *Client) requestWithCodec(_uri string, req *Request) (bool, error) {
+	if !isModelUpMethod(req.Method) {
+		return false, nil
+	}
+	pk, dn, ok := sysPair(_uri)
+	if !ok {
+		return false, nil
+	}
+	raw, err := sf.codec.EncodeRequest(pk, dn, req)
+	if err != nil {
+		return true, err
+	}
+	return true, sf.Publish(uri.URI(uri.SysPrefix, uri.ThingModelUpRaw, pk, dn), 1, raw)
+}
+
+// responseWithCodec 使用编码器编码物模型下行请求的应答并发往透传主题
+// 返回false表示不是物模型服务的应答或编码器不支持该method,需按Alink格式发送
+// response: /sys/{productKey}/{deviceName}/thing/service/[{tsl.service.identifier}_reply,property/set_reply]
+func (sf *Client) responseWithCodec(_uri string, rsp *Response) (bool, error) {
+	pk, dn, ok := sysPair(_uri)
+	if !ok {
+		return false, nil
+	}
+	uris := uri.Spilt(_uri)
+	if len(uris) < 6 || uris[3] != "thing" || uris[4] != "service" {
+		return false, nil
+	}
+	name := strings.Join(uris[5:], uri.Sep)
+	if !strings.HasSuffix(name, "_"+uri.ReplySuffix) {
+		return false, nil
+	}
+	name = strings.TrimSuffix(name, "_"+uri.ReplySuffix)
+	method := "thing.service." + strings.ReplaceAll(name, uri.Sep, ".")
+	raw, err := sf.codec.EncodeResponse(pk, dn, method, rsp)
+	if errors.Is(err, ErrCodecUnknownMethod) {
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	return true, sf.ThingModelDownRawReply(pk, dn, raw)
+}
+
+// procModelUpRawReplyWithCodec 解码透传上行应答,并分发到对应的 Callback
+func procModelUpRawReplyWithCodec(c *Client, pk, dn string, payload []byte) error {
+	method, rsp, err := c.codec.DecodeResponse(pk, dn, payload)
+	if err != nil {
+		return err
+	}
+	if rsp.Code != infra.CodeSuccess {
+		err = infra.NewCodeError(rsp.Code, rsp.Message)
+	}
+	c.signalPending(Message{rsp.ID, nil, err})
+	c.Log.Debugf("thing.model.up.raw.reply %s @%d", method, rsp.ID)
+	switch {
+	case method == infra.MethodEventPropertyPost:
+		return c.cb.ThingEventPropertyPostReply(c, err, pk, dn)
+	case isModelUpMethod(method):
+		eventID := strings.TrimSuffix(strings.TrimPrefix(method, "thing.event."), ".post")
+		return c.cb.ThingEventPostReply(c, err, eventID, pk, dn)
+	}
+	return c.cb.ThingModelUpRawReply(c, pk, dn, payload)
+}
+
+// procModelDownRawWithCodec 解码透传下行请求,并转为属性设置或服务调用处理
+func procModelDownRawWithCodec(c *Client, pk, dn string, payload []byte) error {
+	req, err := c.codec.DecodeRequest(pk, dn, payload)
+	if err != nil {
+		return err
+	}
+	if req.Version == "" {
+		req.Version = c.version
+	}
+	c.Log.Debugf("thing.model.down.raw %s @%d", req.Method, req.ID)
+	if req.Method == infra.MethodServicePropertySet {
+		py, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		return c.cb.ThingServicePropertySet(c, pk, dn, py)
+	}
+	if strings.HasPrefix(req.Method, "thing.service.") {
+		py, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		return c.dispatchServiceRequest(pk, dn, strings.TrimPrefix(req.Method, "thing.service."), py)
+	}
+	return c.cb.ThingModelDownRaw(c, pk, dn, payload)
+}
+
+// sysPair 从 /sys/{productKey}/{deviceName}/... 中获取productKey,deviceName
+func sysPair(_uri string) (pk, dn string, ok bool) {
+	uris := uri.Spilt(_uri)
+	if len(uris) < 3 || uris[0] != "sys" {
+		return "", "", false
+	}
+	return uris[1], uris[2], true
+}
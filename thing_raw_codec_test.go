@@ -0,0 +1,50 @@
+package aiot
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/aliyun-iot/infra"
+	"github.com/things-go/aliyun-iot/uri"
+)
+
+// rebootCodec 仅支持 thing.service.reboot 应答的编解码器
+type rebootCodec struct{}
+
+func (rebootCodec) EncodeRequest(string, string, *Request) ([]byte, error) {
+	return nil, ErrCodecUnknownMethod
+}
+
+func (rebootCodec) DecodeResponse(string, string, []byte) (string, *ResponseRawData, error) {
+	return "", nil, ErrCodecUnknownMethod
+}
+
+func (rebootCodec) DecodeRequest(string, string, []byte) (*RequestRawData, error) {
+	return nil, ErrCodecUnknownMethod
+}
+
+func (rebootCodec) EncodeResponse(_, _, method string, _ *Response) ([]byte, error) {
+	if method != "thing.service.reboot" {
+		return nil, ErrCodecUnknownMethod
+	}
+	return []byte{0x21}, nil
+}
+
+func TestResponseWithCodec(t *testing.T) {
+	cloud := &fakeCloud{}
+	c := New(testGateway, cloud, WithCodec(rebootCodec{}))
+
+	require.NoError(t, c.ThingServiceResponse("pk", "dn", "reboot", Response{ID: 1, Code: infra.CodeSuccess}))
+	require.Equal(t, [][]byte{{0x21}}, cloud.published(uri.URI(uri.SysPrefix, uri.ThingModelDownRawReply, "pk", "dn")))
+
+	// 编码器不支持的method按Alink格式应答
+	require.NoError(t, c.ThingServiceResponse("pk", "dn", "upgrade", Response{ID: 2, Code: infra.CodeSuccess, Data: "{}"}))
+	payloads := cloud.published(uri.URI(uri.SysPrefix, uri.ThingServiceResponse, "pk", "dn", "upgrade"))
+	require.Len(t, payloads, 1)
+	var rsp Response
+	require.NoError(t, json.Unmarshal(payloads[0], &rsp))
+	require.Equal(t, uint(2), rsp.ID)
+	require.Len(t, cloud.published(uri.URI(uri.SysPrefix, uri.ThingModelDownRawReply, "pk", "dn")), 1)
+}
@@ -8,6 +8,7 @@ import "github.com/things-go/aliyun-iot/uri"
 
 // ProcThingServiceRequest 处理设备服务调用(异步)
 // 下行
+// 自定义模块的服务调用,如果注册了该模块的处理函数,将由模块的处理函数处理,否则由 Callback 处理
 // request:   /sys/{productKey}/{deviceName}/thing/service/[{tsl.service.identifier},property/set]
 // response:  /sys/{productKey}/{deviceName}/thing/service/[{tsl.service.identifier}_reply,property/set_reply
 // subscribe: /sys/{productKey}/{deviceName}/thing/service/[+,#]
@@ -24,5 +25,30 @@ func ProcThingServiceRequest(c *Client, rawURI string, payload []byte) error {
 		return c.cb.ThingServicePropertySet(c, pk, dn, payload)
 	}
 	c.Log.Debugf("thing.service.%s", serviceID)
-	return c.cb.ThingServiceRequest(c, serviceID, pk, dn, payload)
+	return c.dispatchServiceRequest(pk, dn, serviceID, payload)
+}
+
+// dispatchServiceRequest 分发服务调用到自定义模块的处理函数或 Callback
+func (sf *Client) dispatchServiceRequest(pk, dn, serviceID string, payload []byte) error {
+	if len(sf.functionBlocks) > 0 {
+		functionBlockID, srvID := functionBlockOfService(serviceID, payload)
+		if h, ok := sf.functionBlocks[functionBlockID]; ok && functionBlockID != "" {
+			return h(sf, pk, dn, srvID, payload)
+		}
+	}
+	return sf.cb.ThingServiceRequest(sf, serviceID, pk, dn, payload)
+}
+
+// ThingServicePropertySetResponse 属性设置的回复
+// response: /sys/{productKey}/{deviceName}/thing/service/property/set_reply
+func (sf *Client) ThingServicePropertySetResponse(pk, dn string, rsp Response) error {
+	_uri := uri.URI(uri.SysPrefix, uri.ThingServicePropertySetReply, pk, dn)
+	return sf.Response(_uri, rsp)
+}
+
+// ThingServiceResponse 服务调用的回复, srvID可以是带模块前缀的标识符
+// response: /sys/{productKey}/{deviceName}/thing/service/{tsl.service.identifier}_reply
+func (sf *Client) ThingServiceResponse(pk, dn, srvID string, rsp Response) error {
+	_uri := uri.URI(uri.SysPrefix, uri.ThingServiceResponse, pk, dn, srvID)
+	return sf.Response(_uri, rsp)
 }
@@ -91,7 +91,7 @@ func ProcThingDelete(c *Client, rawURI string, payload []byte) error {
 		return err
 	}
 
-	c.Delete(pk, dn)
+	c.Delete(pk, dn) // nolint: errcheck
 	_uri := uri.ReplyWithRequestURI(rawURI)
 	err := c.Response(_uri, Response{ID: req.ID, Code: infra.CodeSuccess, Data: "{}"})
 	if err != nil {
@@ -34,13 +34,23 @@ type SubRegisterResponse struct {
 // request:   /sys/{productKey}/{deviceName}/thing/sub/register
 // response:  /sys/{productKey}/{deviceName}/thing/sub/register_reply
 func (sf *Client) thingSubRegister(pk, dn string) (*Token, error) {
+	return sf.thingSubBatchRegister([]infra.MetaPair{
+		{ProductKey: pk, DeviceName: dn},
+	})
+}
+
+// thingSubBatchRegister 子设备批量动态注册
+// request:   /sys/{productKey}/{deviceName}/thing/sub/register
+// response:  /sys/{productKey}/{deviceName}/thing/sub/register_reply
+func (sf *Client) thingSubBatchRegister(pairs []infra.MetaPair) (*Token, error) {
 	if !sf.isGateway {
 		return nil, ErrNotSupportFeature
 	}
+	if len(pairs) == 0 {
+		return nil, ErrInvalidParameter
+	}
 	_uri := sf.URIGateway(uri.SysPrefix, uri.ThingSubRegister)
-	return sf.SendRequest(_uri, infra.MethodSubDevRegister, []infra.MetaPair{
-		{ProductKey: pk, DeviceName: dn},
-	})
+	return sf.SendRequest(_uri, infra.MethodSubDevRegister, pairs)
 }
 
 // ProcThingSubRegisterReply 处理子设备动态注册回复
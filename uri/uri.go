@@ -12,6 +12,8 @@ import (
 // 分隔符定义
 const (
 	Sep = "/"
+	// FunctionBlockSep 自定义模块(功能块)标识符分隔符, {functionBlockId}:{identifier}
+	FunctionBlockSep = ":"
 )
 
 // URI 前缀定义
@@ -46,6 +48,18 @@ const (
 	OtaDeviceProcessPrefix   = "/ota/device/progress/%s/%s"
 	ThingOtaFirmwareGet      = "thing/ota/firmware/get"
 	ThingOtaFirmwareGetReply = "thing/ota/firmware/get_reply"
+	ThingFileDownload        = "thing/file/download"
+	ThingFileDownloadReply   = "thing/file/download_reply"
+)
+
+// 文件上传 uri定义
+const (
+	ThingFileUploadInit        = "thing/file/upload/mqtt/init"
+	ThingFileUploadInitReply   = "thing/file/upload/mqtt/init_reply"
+	ThingFileUploadSend        = "thing/file/upload/mqtt/send"
+	ThingFileUploadSendReply   = "thing/file/upload/mqtt/send_reply"
+	ThingFileUploadCancel      = "thing/file/upload/mqtt/cancel"
+	ThingFileUploadCancelReply = "thing/file/upload/mqtt/cancel_reply"
 )
 
 // 设备URI 定义
@@ -117,6 +131,9 @@ const (
 	// 子设备动态注册
 	ThingSubRegister      = "thing/sub/register"
 	ThingSubRegisterReply = "thing/sub/register_reply"
+	// 子设备一型一密动态注册(免预注册)
+	ThingProxyProductRegister      = "thing/proxy/provisioning/product_register"
+	ThingProxyProductRegisterReply = "thing/proxy/provisioning/product_register_reply"
 
 	// 子设备登录
 	CombineLogin            = "combine/login"
@@ -183,6 +200,24 @@ func Spilt(uri string) []string {
 	return strings.Split(strings.TrimLeft(uri, Sep), Sep)
 }
 
+// FunctionBlockIdentifier 生成自定义模块下的标识符 {functionBlockId}:{identifier}
+// functionBlockID为空表示默认模块,直接返回identifier
+func FunctionBlockIdentifier(functionBlockID, identifier string) string {
+	if functionBlockID == "" {
+		return identifier
+	}
+	return functionBlockID + FunctionBlockSep + identifier
+}
+
+// SplitFunctionBlock 拆分标识符为自定义模块ID和模块内的标识符
+// 默认模块的标识符不含分隔符,此时functionBlockID为空
+func SplitFunctionBlock(identifier string) (functionBlockID, id string) {
+	if i := strings.Index(identifier, FunctionBlockSep); i >= 0 {
+		return identifier[:i], identifier[i+1:]
+	}
+	return "", identifier
+}
+
 // ExtRRPC 生成Ext RRPC URI
 func ExtRRPC(messageID, _uri string) string {
 	return fmt.Sprintf(ExtRRPCPrefix, messageID) + _uri
@@ -132,3 +132,19 @@ func TestExtRRPC(t *testing.T) {
 	require.Equal(t, "/ext/rrpc/+//a/b/c", ExtRRPC("+", "/a/b/c"))
 	require.Equal(t, "/ext/rrpc/+//a/b/c", ExtRRPCWildcardOne("/a/b/c"))
 }
+
+func TestFunctionBlock(t *testing.T) {
+	require.Equal(t, "event", FunctionBlockIdentifier("", "event"))
+	require.Equal(t, "block:event", FunctionBlockIdentifier("block", "event"))
+	require.Equal(t,
+		fmt.Sprintf(SysPrefix+ThingEventPost, "pk", "dn", "block:event"),
+		URI(SysPrefix, ThingEventPost, "pk", "dn", FunctionBlockIdentifier("block", "event")))
+
+	blockID, id := SplitFunctionBlock("block:event")
+	require.Equal(t, "block", blockID)
+	require.Equal(t, "event", id)
+
+	blockID, id = SplitFunctionBlock("event")
+	require.Equal(t, "", blockID)
+	require.Equal(t, "event", id)
+}